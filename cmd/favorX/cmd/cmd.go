@@ -7,7 +7,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/FavorLabs/favorX/pkg/accounting"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
 	"github.com/gauss-project/aurorafs/pkg/boson"
 	"github.com/gauss-project/aurorafs/pkg/logging"
 	"github.com/sirupsen/logrus"
@@ -51,9 +54,14 @@ const (
 	optionNameGatewayMode           = "gateway-mode"
 	optionNameTrafficContractAddr   = "traffic-contract-addr"
 	optionNameTrafficEnable         = "traffic-enable"
+	optionNameNoChain               = "no-chain"
+	optionNamePaymentThreshold      = "payment-threshold"
+	optionNamePaymentTolerance      = "payment-tolerance"
+	optionNamePeerLimits            = "peer-limits"
 	optionNameBinMaxPeers           = "bin-max-peers"
 	optionNameLightMaxPeers         = "light-max-peers"
 	optionNameAllowPrivateCIDRs     = "allow-private-cidrs"
+	optionNameForwardAnyAddress     = "netrelay-forward-any-address"
 	optionNameRestrictedAPI         = "restricted"
 	optionNameTokenEncryptionKey    = "token-encryption-key"
 	optionNameAdminPasswordHash     = "admin-password"
@@ -62,6 +70,24 @@ const (
 	optionNameEnableApiTls          = "enable-api-tls"
 	optionNameTlsCRT                = "tls-crt-file"
 	optionNameTlsKey                = "tls-key-file"
+	optionNameRetentionMaxAge       = "retention-max-age"
+	optionNameRetentionMaxSize      = "retention-max-size"
+	optionNameRetentionKeepPinned   = "retention-keep-pinned"
+	optionNameRetentionKeepRegister = "retention-keep-registered"
+	optionNameRetentionInterval     = "retention-interval"
+	optionNameAutoCashThreshold     = "autocash-threshold"
+	optionNameAutoCashMaxGasPrice   = "autocash-max-gas-price"
+	optionNameAutoCashMaxTxs        = "autocash-max-txs"
+	optionNameAutoCashInterval      = "autocash-interval"
+	optionNameAutoCashDryRun        = "autocash-dry-run"
+	optionNameTxGasPriceStrategy    = "tx-gas-price-strategy"
+	optionNameTxGasPrice            = "tx-gas-price"
+	optionNameTxGasPricePercentile  = "tx-gas-price-percentile"
+	optionNameTxGasPriceBlocks      = "tx-gas-price-blocks"
+	optionNameTxMaxGasPrice         = "tx-max-gas-price"
+	optionNameTxReplaceAfter        = "tx-replace-after"
+	optionNameTxGasBumpPercent      = "tx-gas-bump-percent"
+	optionNameRegisterInterval      = "register-interval"
 )
 
 func init() {
@@ -123,6 +149,8 @@ func newCommand(opts ...option) (c *command, err error) {
 
 	c.initVersionCmd()
 	c.initDBCmd()
+	c.initForwardCmd()
+	c.initDevChainCmd()
 
 	if err := c.initConfigurateOptionsCmd(); err != nil {
 		return nil, err
@@ -225,12 +253,17 @@ func (c *command) setAllFlags(cmd *cobra.Command) {
 	cmd.Flags().StringSlice(optionNameResolverEndpoints, []string{}, "ENS compatible API endpoint for a TLD and with contract address, can be repeated,the default endpoint with chain-endpoint, format [tld:]contract-addr[@url]")
 	cmd.Flags().Bool(optionNameGatewayMode, false, "disable a set of sensitive features in the api")
 	cmd.Flags().Bool(optionNameBootnodeMode, false, "cause the node to always accept incoming connections")
-	// cmd.Flags().String(optionNameTrafficContractAddr, "", "link to traffic contract")
-	// cmd.Flags().Bool(optionNameTrafficEnable, false, "enable traffic")
+	cmd.Flags().String(optionNameTrafficContractAddr, "", "link to traffic contract")
+	cmd.Flags().Bool(optionNameTrafficEnable, false, "settle traffic with cheques cashed at the traffic contract")
+	cmd.Flags().Uint64(optionNamePaymentThreshold, accounting.DefaultPaymentThreshold, "traffic owed to a peer that is paid")
+	cmd.Flags().Uint64(optionNamePaymentTolerance, accounting.DefaultPaymentTolerance, "traffic a peer may owe before it is disconnected")
+	cmd.Flags().StringSlice(optionNamePeerLimits, []string{}, "payment threshold and tolerance of a peer, format overlay:threshold:tolerance, either may be empty to keep the default")
+	cmd.Flags().Bool(optionNameNoChain, false, "allow to run without chain endpoint or oracle contract, sources are then only found through chunkinfo and nothing can be registered")
 	cmd.Flags().Bool(optionNameFullNode, true, "full node")
 	cmd.Flags().Int(optionNameLightMaxPeers, 100, "connected light node max limit")
 	cmd.Flags().Int(optionNameBinMaxPeers, 20, "kademlia every k bucket connected peers max limit")
 	cmd.Flags().Bool(optionNameAllowPrivateCIDRs, false, "allow to advertise private CIDRs to the public network")
+	cmd.Flags().Bool(optionNameForwardAnyAddress, false, "allow netrelay port forwards to listen on addresses other than loopback ones")
 	cmd.Flags().Bool(optionNameRestrictedAPI, false, "enable permission check on the http APIs")
 	cmd.Flags().String(optionNameTokenEncryptionKey, "", "admin username to get the security token")
 	cmd.Flags().String(optionNameAdminPasswordHash, "", "bcrypt hash of the admin password to get the security token")
@@ -238,6 +271,24 @@ func (c *command) setAllFlags(cmd *cobra.Command) {
 	cmd.Flags().Bool(optionNameEnableApiTls, false, "enable https to api/debug api")
 	cmd.Flags().String(optionNameTlsKey, "", "https private key file path")
 	cmd.Flags().String(optionNameTlsCRT, "", "https certificate file path")
+	cmd.Flags().Duration(optionNameRetentionMaxAge, 0, "remove local files older than this duration, 0 disables the rule")
+	cmd.Flags().Uint64(optionNameRetentionMaxSize, 0, "remove the oldest local files while their total size in bytes exceeds this value, 0 disables the rule")
+	cmd.Flags().Bool(optionNameRetentionKeepPinned, true, "never remove pinned files by retention rules, when disabled retention unpins the files it removes")
+	cmd.Flags().Bool(optionNameRetentionKeepRegister, true, "never remove files registered on the oracle by retention rules")
+	cmd.Flags().Duration(optionNameRetentionInterval, time.Hour, "interval between retention sweeps, 0 disables the background sweeper")
+	cmd.Flags().Uint64(optionNameAutoCashThreshold, 0, "cash received cheques automatically once their uncashed value reaches this value, 0 disables automatic cashing")
+	cmd.Flags().Uint64(optionNameAutoCashMaxGasPrice, 0, "highest gas price in wei cheques are cashed at automatically, 0 for no ceiling")
+	cmd.Flags().Int(optionNameAutoCashMaxTxs, 0, "cashing transactions sent automatically per autocash interval at most, 0 for no limit")
+	cmd.Flags().Duration(optionNameAutoCashInterval, time.Hour, "interval the autocash transaction limit applies to")
+	cmd.Flags().Bool(optionNameAutoCashDryRun, false, "log the cheques that would be cashed automatically without cashing them")
+	cmd.Flags().String(optionNameTxGasPriceStrategy, string(txmgr.Oracle), "gas price of the transactions of the node: fixed, oracle (suggested by the chain node) or percentile (of recent blocks)")
+	cmd.Flags().Uint64(optionNameTxGasPrice, 0, "gas price in wei of the fixed strategy")
+	cmd.Flags().Int(optionNameTxGasPricePercentile, 60, "percentile of the gas prices of recent blocks of the percentile strategy")
+	cmd.Flags().Int(optionNameTxGasPriceBlocks, 20, "number of recent blocks of the percentile strategy")
+	cmd.Flags().Uint64(optionNameTxMaxGasPrice, 0, "highest gas price in wei of the transactions of the node, 0 for no limit")
+	cmd.Flags().Duration(optionNameTxReplaceAfter, 5*time.Minute, "replace transactions pending longer than this with a higher gas price, 0 disables replacement")
+	cmd.Flags().Int(optionNameTxGasBumpPercent, 20, "percentage a replacement raises the gas price by, at least 10")
+	cmd.Flags().Duration(optionNameRegisterInterval, time.Second, "pause between the oracle transactions of a batch file registration")
 }
 
 func newLogger(cmd *cobra.Command, verbosity string) (logging.Logger, error) {
@@ -0,0 +1,109 @@
+//go:build devchain
+// +build devchain
+
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/FavorLabs/favorX/pkg/devchain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+const (
+	optionNameDevChainListen      = "listen"
+	optionNameDevChainFund        = "fund"
+	optionNameDevChainOracleCode  = "oracle-contract-code"
+	optionNameDevChainTrafficCode = "traffic-contract-code"
+)
+
+func (c *command) initDevChainCmd() {
+	cmd := &cobra.Command{
+		Use:   "dev-chain",
+		Short: "Run a go-ethereum dev chain for development",
+		Long: `Run a go-ethereum dev chain for development
+
+Runs an in-process go-ethereum node in developer mode, deploys the oracle
+and traffic contracts from the given compiled bytecode and funds the given
+accounts. Nodes use it with --chain-endpoint, --oracle-contract-addr and
+--traffic-contract-addr. Every transaction is mined as soon as it is sent,
+the chain is discarded when the command is interrupted.
+
+The command is only built with the devchain tag.`,
+		Example: `
+$> favorX dev-chain --fund 0x5c...a1 --oracle-contract-code Oracle.bin --traffic-contract-code Traffic.bin
+chain endpoint: http://127.0.0.1:8545`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var accounts []common.Address
+			for _, a := range c.config.GetStringSlice(optionNameDevChainFund) {
+				if !common.IsHexAddress(a) {
+					return fmt.Errorf("invalid account %q", a)
+				}
+				accounts = append(accounts, common.HexToAddress(a))
+			}
+			oracleCode, err := readContractCode(c.config.GetString(optionNameDevChainOracleCode))
+			if err != nil {
+				return fmt.Errorf("oracle contract: %w", err)
+			}
+			trafficCode, err := readContractCode(c.config.GetString(optionNameDevChainTrafficCode))
+			if err != nil {
+				return fmt.Errorf("traffic contract: %w", err)
+			}
+
+			chain, err := devchain.New(c.config.GetString(optionNameDevChainListen), devchain.Options{
+				Accounts:    accounts,
+				OracleCode:  oracleCode,
+				TrafficCode: trafficCode,
+			})
+			if err != nil {
+				return err
+			}
+			cmd.Printf("chain endpoint: %s\n", chain.Endpoint())
+			cmd.Printf("chain id: %s\n", chain.ChainID())
+			if len(oracleCode) > 0 {
+				cmd.Printf("oracle contract: %s\n", chain.OracleAddress())
+			}
+			if len(trafficCode) > 0 {
+				cmd.Printf("traffic contract: %s\n", chain.TrafficAddress())
+			}
+			for _, a := range accounts {
+				cmd.Printf("funded account: %s\n", a)
+			}
+
+			interruptChannel := make(chan os.Signal, 1)
+			signal.Notify(interruptChannel, syscall.SIGINT, syscall.SIGTERM)
+			<-interruptChannel
+
+			return chain.Close()
+		},
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.config.BindPFlags(cmd.Flags())
+		},
+	}
+
+	cmd.Flags().String(optionNameDevChainListen, "127.0.0.1:8545", "json-rpc listen address")
+	cmd.Flags().StringSlice(optionNameDevChainFund, []string{}, "ethereum addresses to fund with ether and traffic")
+	cmd.Flags().String(optionNameDevChainOracleCode, "", "file with the compiled oracle contract bytecode to deploy")
+	cmd.Flags().String(optionNameDevChainTrafficCode, "", "file with the compiled traffic contract bytecode to deploy")
+
+	c.root.AddCommand(cmd)
+}
+
+// readContractCode reads the hex encoded bytecode of a contract, as solc
+// --bin writes it. No file means no bytecode.
+func readContractCode(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+}
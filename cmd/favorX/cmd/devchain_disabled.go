@@ -0,0 +1,8 @@
+//go:build !devchain
+// +build !devchain
+
+package cmd
+
+// initDevChainCmd adds nothing, the dev-chain command is only built with the
+// devchain tag.
+func (c *command) initDevChainCmd() {}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/spf13/cobra"
+)
+
+const (
+	optionNameForwardListen   = "listen"
+	optionNameForwardAPI      = "api"
+	optionNameForwardAPIToken = "api-token"
+
+	// forwardLease is the lease of the forward, it is renewed at a third of
+	// it so a single failed renewal does not close the forward.
+	forwardLease = 30 * time.Second
+)
+
+func (c *command) initForwardCmd() {
+	cmd := &cobra.Command{
+		Use:   "forward <target overlay> <service>",
+		Short: "Forward a local port to a tcp service of another node",
+		Long: `Forward a local port to a tcp service of another node
+
+Asks the running node to listen on a local address and to tunnel every
+accepted connection through the netrelay protocol to the named service
+exposed by the target node. The forward is held with a lease the command
+renews, it is closed when the command is interrupted and expires when the
+command dies.`,
+		Example: `
+$> favorX forward 5c...a1 postgres --listen 127.0.0.1:5432
+forwarding 127.0.0.1:5432 to postgres of 5c...a1`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return cmd.Help()
+			}
+			target, err := boson.ParseHexAddress(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid target overlay: %w", err)
+			}
+
+			api := strings.TrimSuffix(c.config.GetString(optionNameForwardAPI), "/")
+			token := c.config.GetString(optionNameForwardAPIToken)
+			do := func(method, path string, body interface{}, v interface{}) error {
+				b, err := json.Marshal(body)
+				if err != nil {
+					return err
+				}
+				req, err := http.NewRequestWithContext(cmd.Context(), method, api+path, bytes.NewReader(b))
+				if err != nil {
+					return err
+				}
+				req.Header.Set("Content-Type", "application/json")
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode >= http.StatusBadRequest {
+					var e struct {
+						Message string `json:"message"`
+					}
+					_ = json.NewDecoder(resp.Body).Decode(&e)
+					return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, e.Message)
+				}
+				if v == nil {
+					return nil
+				}
+				return json.NewDecoder(resp.Body).Decode(v)
+			}
+
+			var f struct {
+				ID     string `json:"id"`
+				Listen string `json:"listen"`
+			}
+			err = do(http.MethodPost, "/netrelay/forwards", map[string]interface{}{
+				"listen":  c.config.GetString(optionNameForwardListen),
+				"target":  target,
+				"service": args[1],
+				"lease":   int64(forwardLease / time.Second),
+			}, &f)
+			if err != nil {
+				return err
+			}
+			cmd.Printf("forwarding %s to %s of %s\n", f.Listen, args[1], target)
+
+			interruptChannel := make(chan os.Signal, 1)
+			signal.Notify(interruptChannel, syscall.SIGINT, syscall.SIGTERM)
+			ticker := time.NewTicker(forwardLease / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-interruptChannel:
+					return do(http.MethodDelete, "/netrelay/forwards/"+f.ID, nil, nil)
+				case <-ticker.C:
+					if err := do(http.MethodPut, "/netrelay/forwards/"+f.ID, nil, nil); err != nil {
+						cmd.PrintErrf("renew forward: %v\n", err)
+					}
+				}
+			}
+		},
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return c.config.BindPFlags(cmd.Flags())
+		},
+	}
+
+	cmd.Flags().String(optionNameForwardListen, "127.0.0.1:0", "local address to listen on")
+	cmd.Flags().String(optionNameForwardAPI, "http://localhost:1633", "api endpoint of the node")
+	cmd.Flags().String(optionNameForwardAPIToken, "", "api token if the api is restricted")
+
+	c.root.AddCommand(cmd)
+}
@@ -132,9 +132,14 @@ func (c *command) initStartCmd() (err error) {
 				GatewayMode:            c.config.GetBool(optionNameGatewayMode),
 				TrafficEnable:          c.config.GetBool(optionNameTrafficEnable),
 				TrafficContractAddr:    c.config.GetString(optionNameTrafficContractAddr),
+				NoChain:                c.config.GetBool(optionNameNoChain),
+				PaymentThreshold:       c.config.GetUint64(optionNamePaymentThreshold),
+				PaymentTolerance:       c.config.GetUint64(optionNamePaymentTolerance),
+				PeerLimits:             c.config.GetStringSlice(optionNamePeerLimits),
 				KadBinMaxPeers:         c.config.GetInt(optionNameBinMaxPeers),
 				LightNodeMaxPeers:      c.config.GetInt(optionNameLightMaxPeers),
 				AllowPrivateCIDRs:      c.config.GetBool(optionNameAllowPrivateCIDRs),
+				ForwardAnyAddress:      c.config.GetBool(optionNameForwardAnyAddress),
 				Restricted:             c.config.GetBool(optionNameRestrictedAPI),
 				TokenEncryptionKey:     c.config.GetString(optionNameTokenEncryptionKey),
 				AdminPasswordHash:      c.config.GetString(optionNameAdminPasswordHash),
@@ -143,6 +148,24 @@ func (c *command) initStartCmd() (err error) {
 				EnableApiTLS:           c.config.GetBool(optionNameEnableApiTls),
 				TlsCrtFile:             c.config.GetString(optionNameTlsCRT),
 				TlsKeyFile:             c.config.GetString(optionNameTlsKey),
+				RetentionMaxAge:        c.config.GetDuration(optionNameRetentionMaxAge),
+				RetentionMaxSize:       c.config.GetUint64(optionNameRetentionMaxSize),
+				RetentionKeepPinned:    c.config.GetBool(optionNameRetentionKeepPinned),
+				RetentionKeepRegister:  c.config.GetBool(optionNameRetentionKeepRegister),
+				RetentionInterval:      c.config.GetDuration(optionNameRetentionInterval),
+				AutoCashThreshold:      c.config.GetUint64(optionNameAutoCashThreshold),
+				AutoCashMaxGasPrice:    c.config.GetUint64(optionNameAutoCashMaxGasPrice),
+				AutoCashMaxTxs:         c.config.GetInt(optionNameAutoCashMaxTxs),
+				AutoCashInterval:       c.config.GetDuration(optionNameAutoCashInterval),
+				AutoCashDryRun:         c.config.GetBool(optionNameAutoCashDryRun),
+				TxGasPriceStrategy:     c.config.GetString(optionNameTxGasPriceStrategy),
+				TxGasPrice:             c.config.GetUint64(optionNameTxGasPrice),
+				TxGasPricePercentile:   c.config.GetInt(optionNameTxGasPricePercentile),
+				TxGasPriceBlocks:       c.config.GetInt(optionNameTxGasPriceBlocks),
+				TxMaxGasPrice:          c.config.GetUint64(optionNameTxMaxGasPrice),
+				TxReplaceAfter:         c.config.GetDuration(optionNameTxReplaceAfter),
+				TxGasBumpPercent:       c.config.GetInt(optionNameTxGasBumpPercent),
+				RegisterInterval:       c.config.GetDuration(optionNameRegisterInterval),
 			})
 			if err != nil {
 				return err
@@ -0,0 +1,251 @@
+// Package accounting does the per peer accounting of the traffic of the
+// node on top of the aurorafs accounting.
+//
+// Traffic retrieved from a peer is owed to it and paid through the
+// settlement once it reaches the payment threshold of the peer. A peer that
+// owes more traffic than its payment tolerance is disconnected. Both limits
+// default to the options of the node and can be set per peer, e.g. to
+// tolerate more debt of trusted peers. The aurorafs accounting applies the
+// same limits to all peers, so the peers of each distinct limits are kept
+// by an aurorafs accounting of their own.
+package accounting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/gauss-project/aurorafs/pkg/accounting"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/cheque"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	// DefaultPaymentThreshold is the traffic owed to a peer that is paid.
+	DefaultPaymentThreshold = 256 * 4 * 4
+	// DefaultPaymentTolerance is the traffic a peer may owe before it is
+	// disconnected.
+	DefaultPaymentTolerance = DefaultPaymentThreshold * 2 * 32
+)
+
+var (
+	// ErrDisconnectThresholdExceeded denotes a peer has exceeded the disconnect threshold.
+	ErrDisconnectThresholdExceeded = accounting.ErrDisconnectThresholdExceeded
+	// ErrLowAvailableExceeded denotes the node can not pay for the traffic.
+	ErrLowAvailableExceeded = accounting.ErrLowAvailableExceeded
+	// ErrInvalidPeerLimits is returned for malformed per peer limits.
+	ErrInvalidPeerLimits = errors.New("invalid peer limits")
+	// ErrPeerNotFound is returned for peers without accounting.
+	ErrPeerNotFound = errors.New("peer not found")
+)
+
+var _ accounting.Interface = (*Accounting)(nil)
+
+// Limits are the payment threshold and tolerance of a peer.
+type Limits struct {
+	PaymentThreshold *big.Int `json:"paymentThreshold"`
+	PaymentTolerance *big.Int `json:"paymentTolerance"`
+}
+
+// Options are the limits of all peers and the overrides of some, keyed by
+// overlay. Unset limits of an override fall back to the defaults.
+type Options struct {
+	PaymentThreshold *big.Int
+	PaymentTolerance *big.Int
+	PeerLimits       map[string]Limits
+}
+
+// ParsePeerLimits parses per peer limits given as
+// overlay:threshold:tolerance. Either limit may be left empty to keep the
+// default.
+func ParsePeerLimits(specs []string) (map[string]Limits, error) {
+	limits := make(map[string]Limits, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPeerLimits, spec)
+		}
+		peer, err := boson.ParseHexAddress(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidPeerLimits, spec, err)
+		}
+		var l Limits
+		for i, v := range []**big.Int{&l.PaymentThreshold, &l.PaymentTolerance} {
+			if parts[i+1] == "" {
+				continue
+			}
+			n, ok := new(big.Int).SetString(parts[i+1], 10)
+			if !ok || n.Sign() < 0 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidPeerLimits, spec)
+			}
+			*v = n
+		}
+		limits[peer.String()] = l
+	}
+	return limits, nil
+}
+
+// Balance is the accounting of a peer.
+type Balance struct {
+	Peer boson.Address `json:"peer"`
+	// Debt is the traffic retrieved from the peer and not paid yet.
+	Debt *big.Int `json:"debt"`
+	// Credit is the traffic transferred to the peer and not paid yet.
+	Credit *big.Int `json:"credit"`
+	Limits
+}
+
+// Accounting routes the accounting of every peer to the aurorafs
+// accounting of its limits.
+type Accounting struct {
+	limits     Limits
+	peerLimits map[string]Limits
+	settlement settlement.Interface
+	metrics    metrics
+	// accountings are keyed by limits, see limitsKey
+	accountings map[string]*accounting.Accounting
+	mu          sync.Mutex
+	peers       map[string]*accounting.Accounting
+}
+
+func limitsKey(l Limits) string {
+	return l.PaymentThreshold.String() + ":" + l.PaymentTolerance.String()
+}
+
+// NewAccounting creates a new Accounting instance with the provided options.
+func NewAccounting(o Options, logger logging.Logger, store storage.StateStorer, settlement settlement.Interface) *Accounting {
+	a := &Accounting{
+		limits: Limits{
+			PaymentThreshold: new(big.Int).Set(o.PaymentThreshold),
+			PaymentTolerance: new(big.Int).Set(o.PaymentTolerance),
+		},
+		peerLimits:  o.PeerLimits,
+		settlement:  settlement,
+		metrics:     newMetrics(),
+		accountings: make(map[string]*accounting.Accounting),
+		peers:       make(map[string]*accounting.Accounting),
+	}
+	add := func(l Limits) {
+		if _, ok := a.accountings[limitsKey(l)]; !ok {
+			a.accountings[limitsKey(l)] = accounting.NewAccounting(l.PaymentTolerance, l.PaymentThreshold, logger, store, settlement)
+		}
+	}
+	add(a.limits)
+	for _, l := range o.PeerLimits {
+		add(a.override(l))
+	}
+	return a
+}
+
+// override returns the default limits overridden by the limits set in o.
+func (a *Accounting) override(o Limits) Limits {
+	l := a.limits
+	if o.PaymentThreshold != nil {
+		l.PaymentThreshold = o.PaymentThreshold
+	}
+	if o.PaymentTolerance != nil {
+		l.PaymentTolerance = o.PaymentTolerance
+	}
+	return l
+}
+
+// Limits returns the limits of peer.
+func (a *Accounting) Limits(peer boson.Address) Limits {
+	if o, ok := a.peerLimits[peer.String()]; ok {
+		return a.override(o)
+	}
+	return a.limits
+}
+
+// peerAccounting returns the accounting of peer and remembers the peer.
+func (a *Accounting) peerAccounting(peer boson.Address) *accounting.Accounting {
+	acc := a.accountings[limitsKey(a.Limits(peer))]
+	a.mu.Lock()
+	a.peers[peer.String()] = acc
+	a.mu.Unlock()
+	return acc
+}
+
+// Reserve reserves a portion of the balance for peer and attempts settlements if necessary.
+func (a *Accounting) Reserve(peer boson.Address, traffic uint64) error {
+	err := a.peerAccounting(peer).Reserve(peer, traffic)
+	if errors.Is(err, ErrLowAvailableExceeded) {
+		a.metrics.AccountingBlocksCount.Inc()
+	}
+	return err
+}
+
+// Credit increases the amount of credit we have with the given peer
+// (and decreases existing debt).
+func (a *Accounting) Credit(ctx context.Context, peer boson.Address, traffic uint64) error {
+	if err := a.peerAccounting(peer).Credit(ctx, peer, traffic); err != nil {
+		return err
+	}
+	a.metrics.TotalCreditedAmount.Add(float64(traffic))
+	a.metrics.CreditEventsCount.Inc()
+	return nil
+}
+
+// Debit increases the amount of debt we have with the given peer (and decreases
+// existing credit).
+func (a *Accounting) Debit(peer boson.Address, traffic uint64) error {
+	err := a.peerAccounting(peer).Debit(peer, traffic)
+	if errors.Is(err, ErrDisconnectThresholdExceeded) {
+		a.metrics.AccountingDisconnectsCount.Inc()
+	}
+	if err != nil {
+		return err
+	}
+	a.metrics.TotalDebitedAmount.Add(float64(traffic))
+	a.metrics.DebitEventsCount.Inc()
+	return nil
+}
+
+// Balance returns the debt, credit and limits of peer. Peers the node did
+// not account any traffic with since it started are not found.
+func (a *Accounting) Balance(peer boson.Address) (*Balance, error) {
+	a.mu.Lock()
+	_, ok := a.peers[peer.String()]
+	a.mu.Unlock()
+	if !ok {
+		return nil, ErrPeerNotFound
+	}
+	debt, err := a.settlement.RetrieveTraffic(peer)
+	if err != nil {
+		if errors.Is(err, cheque.ErrNoCheque) {
+			return nil, ErrPeerNotFound
+		}
+		return nil, err
+	}
+	credit, err := a.settlement.TransferTraffic(peer)
+	if err != nil {
+		if errors.Is(err, cheque.ErrNoCheque) {
+			return nil, ErrPeerNotFound
+		}
+		return nil, err
+	}
+	return &Balance{
+		Peer:   peer,
+		Debt:   debt,
+		Credit: credit,
+		Limits: a.Limits(peer),
+	}, nil
+}
+
+// NotifyPayment decreases the debt with peer by the paid traffic.
+func (a *Accounting) NotifyPayment(peer boson.Address, traffic *big.Int) error {
+	return a.peerAccounting(peer).NotifyPayment(peer, traffic)
+}
+
+// AsyncNotifyPayment calls NotifyPayment without waiting for it, to be used
+// as the notify payment function of the settlement, which notifies while it
+// holds its locks.
+func (a *Accounting) AsyncNotifyPayment(peer boson.Address, traffic *big.Int) error {
+	return a.peerAccounting(peer).AsyncNotifyPayment(peer, traffic)
+}
@@ -0,0 +1,130 @@
+package accounting_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/accounting"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/mock"
+	statestore "github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+func TestParsePeerLimits(t *testing.T) {
+	limits, err := accounting.ParsePeerLimits([]string{"ca1e:100:2000", "be1e::5000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := limits["ca1e"]; l.PaymentThreshold.Int64() != 100 || l.PaymentTolerance.Int64() != 2000 {
+		t.Fatalf("got limits %+v", l)
+	}
+	if l := limits["be1e"]; l.PaymentThreshold != nil || l.PaymentTolerance.Int64() != 5000 {
+		t.Fatalf("got limits %+v", l)
+	}
+
+	for _, spec := range []string{"ca1e:100", "zz:1:2", "ca1e:x:2", "ca1e:1:-2"} {
+		if _, err := accounting.ParsePeerLimits([]string{spec}); !errors.Is(err, accounting.ErrInvalidPeerLimits) {
+			t.Fatalf("%s: got error %v, want %v", spec, err, accounting.ErrInvalidPeerLimits)
+		}
+	}
+}
+
+func TestPeerLimits(t *testing.T) {
+	trusted := boson.MustParseHexAddress("ca1e")
+	other := boson.MustParseHexAddress("be1e")
+	transferred := map[string]*big.Int{
+		trusted.String(): big.NewInt(1500),
+		other.String():   big.NewInt(1500),
+	}
+	// the settlement keeps the traffic retrieved and not paid yet
+	var mu sync.Mutex
+	retrieved := big.NewInt(0)
+	paid := make(chan *big.Int, 1)
+	settlement := mock.NewSettlement(
+		mock.WithRetrieveTraffic(func(boson.Address) (*big.Int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return new(big.Int).Set(retrieved), nil
+		}),
+		mock.WithTransferTraffic(func(peer boson.Address) (*big.Int, error) {
+			return transferred[peer.String()], nil
+		}),
+		mock.WithPutTransferTraffic(func(boson.Address, *big.Int) error { return nil }),
+		mock.WithPutRetrieveTraffic(func(_ boson.Address, traffic *big.Int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			retrieved.Add(retrieved, traffic)
+			return nil
+		}),
+		mock.WithPay(func(_ context.Context, peer boson.Address, threshold *big.Int) error {
+			if !peer.Equal(trusted) {
+				t.Errorf("paid peer %s", peer)
+			}
+			paid <- threshold
+			return nil
+		}),
+	)
+
+	limits, err := accounting.ParsePeerLimits([]string{"ca1e:200:2000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := accounting.NewAccounting(accounting.Options{
+		PaymentThreshold: big.NewInt(100),
+		PaymentTolerance: big.NewInt(1000),
+		PeerLimits:       limits,
+	}, logging.New(io.Discard, 0), statestore.NewStateStore(), settlement)
+
+	// the trusted peer may owe more than the default tolerance
+	if err := acc.Debit(trusted, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.Debit(other, 10); !errors.Is(err, accounting.ErrDisconnectThresholdExceeded) {
+		t.Fatalf("got error %v, want %v", err, accounting.ErrDisconnectThresholdExceeded)
+	}
+
+	// the trusted peer is paid at its own threshold
+	if err := acc.Credit(context.Background(), trusted, 150); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case threshold := <-paid:
+		t.Fatalf("paid at threshold %v below it", threshold)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := acc.Credit(context.Background(), trusted, 50); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case threshold := <-paid:
+		if threshold.Int64() != 200 {
+			t.Fatalf("paid at threshold %v, want 200", threshold)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer not paid")
+	}
+
+	balance, err := acc.Balance(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Debt.Int64() != 200 || balance.Credit.Int64() != 1500 || balance.PaymentThreshold.Int64() != 200 || balance.PaymentTolerance.Int64() != 2000 {
+		t.Fatalf("got balance %+v", balance)
+	}
+	// peers without accounting are not added by asking for their balance
+	unknown := boson.MustParseHexAddress("de1e")
+	for i := 0; i < 2; i++ {
+		if _, err := acc.Balance(unknown); !errors.Is(err, accounting.ErrPeerNotFound) {
+			t.Fatalf("got error %v, want %v", err, accounting.ErrPeerNotFound)
+		}
+	}
+	if l := acc.Limits(other); l.PaymentThreshold.Int64() != 100 || l.PaymentTolerance.Int64() != 1000 {
+		t.Fatalf("got limits %+v", l)
+	}
+}
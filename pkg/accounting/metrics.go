@@ -0,0 +1,66 @@
+package accounting
+
+import (
+	m "github.com/gauss-project/aurorafs/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metrics struct {
+	// all metrics fields must be exported
+	// to be able to return them by Metrics()
+	// using reflection
+	TotalDebitedAmount         prometheus.Counter
+	TotalCreditedAmount        prometheus.Counter
+	DebitEventsCount           prometheus.Counter
+	CreditEventsCount          prometheus.Counter
+	AccountingDisconnectsCount prometheus.Counter
+	AccountingBlocksCount      prometheus.Counter
+}
+
+func newMetrics() metrics {
+	subsystem := "accounting"
+
+	return metrics{
+		TotalDebitedAmount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "total_debited_amount",
+			Help:      "Amount of traffic debited to peers (potential income of the node)",
+		}),
+		TotalCreditedAmount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "total_credited_amount",
+			Help:      "Amount of traffic credited to peers (potential cost of the node)",
+		}),
+		DebitEventsCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "debit_events_count",
+			Help:      "Number of occurrences of traffic debit events towards peers",
+		}),
+		CreditEventsCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "credit_events_count",
+			Help:      "Number of occurrences of traffic credit events towards peers",
+		}),
+		AccountingDisconnectsCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "accounting_disconnects_count",
+			Help:      "Number of occurrences of peers disconnected based on payment tolerances",
+		}),
+		AccountingBlocksCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: m.Namespace,
+			Subsystem: subsystem,
+			Name:      "accounting_blocks_count",
+			Help:      "Number of occurrences of refusing traffic the available balance can not pay for",
+		}),
+	}
+}
+
+// Metrics returns the prometheus Collector for the accounting service.
+func (a *Accounting) Metrics() []prometheus.Collector {
+	return m.PrometheusCollectorsFromFields(a.metrics)
+}
@@ -0,0 +1,195 @@
+// Package act implements access control for encrypted content. The reference
+// of the content is encrypted with a random access key, which in turn is
+// encrypted for the publisher and every grantee with a key derived from the
+// ECDH shared secret of the publisher and the grantee.
+package act
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/encryption"
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	// ErrAccessDenied is returned when the key has no entry in the manifest.
+	ErrAccessDenied = errors.New("act: access denied")
+	// ErrNotPublisher is returned when a manifest is changed by someone else
+	// than its publisher.
+	ErrNotPublisher = errors.New("act: not the publisher")
+	// ErrInvalidManifest is returned for malformed manifests.
+	ErrInvalidManifest = errors.New("act: invalid manifest")
+)
+
+var (
+	lookupSalt = []byte{0}
+	keySalt    = []byte{1}
+)
+
+// Manifest is the access manifest stored next to the encrypted content.
+type Manifest struct {
+	Publisher string            `json:"publisher"`
+	Reference string            `json:"reference"`
+	Entries   map[string]string `json:"entries"`
+}
+
+// Controller creates and opens access manifests with a private key.
+type Controller struct {
+	key *ecdsa.PrivateKey
+	dh  crypto.DH
+}
+
+// New returns a Controller acting with the given private key.
+func New(key *ecdsa.PrivateKey) *Controller {
+	return &Controller{
+		key: key,
+		dh:  crypto.NewDH(key),
+	}
+}
+
+// PublicKey returns the compressed hex encoded public key of the controller.
+func (c *Controller) PublicKey() string {
+	return EncodePublicKey(&c.key.PublicKey)
+}
+
+// Grant creates a manifest giving the controller and the grantees access to
+// the reference. Every call uses a fresh access key, so a manifest created
+// without a former grantee can not be opened by it.
+func (c *Controller) Grant(ref boson.Address, grantees []*ecdsa.PublicKey) (*Manifest, error) {
+	accessKey := encryption.GenerateRandomKey(encryption.KeyLength)
+
+	encRef, err := newCipher(accessKey).Encrypt(ref.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("encrypt reference: %w", err)
+	}
+
+	m := &Manifest{
+		Publisher: c.PublicKey(),
+		Reference: hex.EncodeToString(encRef),
+		Entries:   make(map[string]string, len(grantees)+1),
+	}
+
+	for _, pub := range append([]*ecdsa.PublicKey{&c.key.PublicKey}, grantees...) {
+		lookup, akdk, err := c.keys(pub)
+		if err != nil {
+			return nil, err
+		}
+		encKey, err := newCipher(akdk).Encrypt(accessKey)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt access key: %w", err)
+		}
+		m.Entries[hex.EncodeToString(lookup)] = hex.EncodeToString(encKey)
+	}
+
+	return m, nil
+}
+
+// Open returns the content reference of the manifest if the controller is
+// one of its grantees.
+func (c *Controller) Open(m *Manifest) (boson.Address, error) {
+	publisher, err := DecodePublicKey(m.Publisher)
+	if err != nil {
+		return boson.ZeroAddress, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+
+	lookup, akdk, err := c.keys(publisher)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	return OpenWithKeys(m, lookup, akdk)
+}
+
+// OpenWithKeys returns the content reference of the manifest for the
+// grantee whose lookup key and access key decryption key are given, see
+// GranteeKeys.
+func OpenWithKeys(m *Manifest, lookup, akdk []byte) (boson.Address, error) {
+	entry, ok := m.Entries[hex.EncodeToString(lookup)]
+	if !ok {
+		return boson.ZeroAddress, ErrAccessDenied
+	}
+
+	encKey, err := hex.DecodeString(entry)
+	if err != nil {
+		return boson.ZeroAddress, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	accessKey, err := newCipher(akdk).Decrypt(encKey)
+	if err != nil {
+		return boson.ZeroAddress, fmt.Errorf("decrypt access key: %w", err)
+	}
+
+	encRef, err := hex.DecodeString(m.Reference)
+	if err != nil {
+		return boson.ZeroAddress, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	ref, err := newCipher(accessKey).Decrypt(encRef)
+	if err != nil {
+		return boson.ZeroAddress, fmt.Errorf("decrypt reference: %w", err)
+	}
+
+	return boson.NewAddress(ref), nil
+}
+
+// Update opens a manifest published by the controller and grants access to
+// the new set of grantees under a fresh access key.
+func (c *Controller) Update(m *Manifest, grantees []*ecdsa.PublicKey) (*Manifest, error) {
+	if m.Publisher != c.PublicKey() {
+		return nil, ErrNotPublisher
+	}
+	ref, err := c.Open(m)
+	if err != nil {
+		return nil, err
+	}
+	return c.Grant(ref, grantees)
+}
+
+// GranteeKeys derives the lookup key and the access key decryption key
+// the holder of key opens the manifests of publisher with. Grantees derive
+// them on their side, so their private key never leaves them.
+func GranteeKeys(key *ecdsa.PrivateKey, publisher *ecdsa.PublicKey) (lookup, akdk []byte, err error) {
+	return sharedKeys(crypto.NewDH(key), publisher)
+}
+
+// keys derives the lookup key and the access key decryption key shared
+// between the controller and pub.
+func (c *Controller) keys(pub *ecdsa.PublicKey) (lookup, akdk []byte, err error) {
+	return sharedKeys(c.dh, pub)
+}
+
+func sharedKeys(dh crypto.DH, pub *ecdsa.PublicKey) (lookup, akdk []byte, err error) {
+	lookup, err = dh.SharedKey(pub, lookupSalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("derive lookup key: %w", err)
+	}
+	akdk, err = dh.SharedKey(pub, keySalt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("derive access key decryption key: %w", err)
+	}
+	return lookup, akdk, nil
+}
+
+func newCipher(key []byte) encryption.Interface {
+	return encryption.New(key, 0, 0, sha3.NewLegacyKeccak256)
+}
+
+// EncodePublicKey returns the compressed hex encoding of pub.
+func EncodePublicKey(pub *ecdsa.PublicKey) string {
+	return hex.EncodeToString(crypto.EncodeSecp256k1PublicKey(pub))
+}
+
+// DecodePublicKey parses a compressed or uncompressed hex encoded public key.
+func DecodePublicKey(s string) (*ecdsa.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 33 {
+		return ethcrypto.DecompressPubkey(b)
+	}
+	return ethcrypto.UnmarshalPubkey(b)
+}
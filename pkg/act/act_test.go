@@ -0,0 +1,103 @@
+package act_test
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/act"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	k, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestGrantOpen(t *testing.T) {
+	publisher := act.New(newKey(t))
+	aliceKey, bobKey := newKey(t), newKey(t)
+	alice, bob := act.New(aliceKey), act.New(bobKey)
+
+	ref := boson.NewAddress(make([]byte, 64))
+	ref.Bytes()[0] = 1
+
+	m, err := publisher.Grant(ref, []*ecdsa.PublicKey{&aliceKey.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]*act.Controller{"publisher": publisher, "grantee": alice} {
+		got, err := c.Open(m)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !got.Equal(ref) {
+			t.Fatalf("%s: got %s, want %s", name, got, ref)
+		}
+	}
+
+	if _, err := bob.Open(m); !errors.Is(err, act.ErrAccessDenied) {
+		t.Fatalf("got %v, want %v", err, act.ErrAccessDenied)
+	}
+
+	// a grantee opens the manifest with the keys derived on its side
+	publisherKey, err := act.DecodePublicKey(m.Publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup, akdk, err := act.GranteeKeys(aliceKey, publisherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := act.OpenWithKeys(m, lookup, akdk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(ref) {
+		t.Fatalf("got %s, want %s", got, ref)
+	}
+	lookup, akdk, err = act.GranteeKeys(bobKey, publisherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := act.OpenWithKeys(m, lookup, akdk); !errors.Is(err, act.ErrAccessDenied) {
+		t.Fatalf("got %v, want %v", err, act.ErrAccessDenied)
+	}
+
+	// revoke alice, grant bob
+	updated, err := publisher.Update(m, []*ecdsa.PublicKey{&bobKey.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Open(updated); !errors.Is(err, act.ErrAccessDenied) {
+		t.Fatalf("got %v, want %v", err, act.ErrAccessDenied)
+	}
+	got, err = bob.Open(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(ref) {
+		t.Fatalf("got %s, want %s", got, ref)
+	}
+
+	if _, err := alice.Update(updated, nil); !errors.Is(err, act.ErrNotPublisher) {
+		t.Fatalf("got %v, want %v", err, act.ErrNotPublisher)
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	k := newKey(t)
+	pub, err := act.DecodePublicKey(act.EncodePublicKey(&k.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.X.Cmp(k.X) != 0 || pub.Y.Cmp(k.Y) != 0 {
+		t.Fatal("decoded public key does not match")
+	}
+}
@@ -0,0 +1,474 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/act"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/encryption"
+	"github.com/gauss-project/aurorafs/pkg/file/joiner"
+	"github.com/gauss-project/aurorafs/pkg/file/loadsave"
+	"github.com/gauss-project/aurorafs/pkg/file/pipeline"
+	"github.com/gauss-project/aurorafs/pkg/file/pipeline/builder"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/manifest"
+	"github.com/gauss-project/aurorafs/pkg/sctx"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+const actGranteesKeyPrefix = "act-grantees-"
+
+// maxAccessManifestSize bounds the size of an access manifest read from the store.
+const maxAccessManifestSize = 4 * 1024 * 1024
+
+var (
+	errActNotEncrypted = errors.New("act requires an encrypted reference")
+	errActKey          = errors.New("missing or invalid act keys")
+	errInvalidGrantee  = errors.New("invalid grantee")
+)
+
+// actGrantees is the grantee list of an access manifest published by this node.
+type actGrantees struct {
+	Reference boson.Address `json:"reference"`
+	Grantees  []string      `json:"grantees"`
+}
+
+type actGranteesPatchRequest struct {
+	Add    []string `json:"add"`
+	Revoke []string `json:"revoke"`
+}
+
+type actCreateRequest struct {
+	Reference boson.Address `json:"reference"`
+	Grantees  []string      `json:"grantees"`
+}
+
+func actGranteesKey(ref boson.Address) string {
+	return actGranteesKeyPrefix + ref.String()
+}
+
+func requestAct(r *http.Request) bool {
+	return strings.ToLower(r.Header.Get(AuroraActHeader)) == StringTrue
+}
+
+// requestActGrantees returns the comma separated public keys of the grantees header.
+func requestActGrantees(r *http.Request) []string {
+	var grantees []string
+	for _, g := range strings.Split(r.Header.Get(AuroraActGranteesHeader), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			grantees = append(grantees, g)
+		}
+	}
+	return grantees
+}
+
+func parseGrantees(list []string) ([]*ecdsa.PublicKey, error) {
+	keys := make([]*ecdsa.PublicKey, 0, len(list))
+	for _, g := range list {
+		pub, err := act.DecodePublicKey(g)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", errInvalidGrantee, g, err)
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+// storeAccessManifest uploads the manifest and registers its chunks so
+// grantees can retrieve it like any other root.
+func (s *server) storeAccessManifest(ctx context.Context, m *act.Manifest) (boson.Address, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+
+	pipe := builder.NewPipelineBuilder(ctx, s.storer, storage.ModePutUpload, false)
+	ref, err := builder.FeedPipeline(ctx, pipe, bytes.NewReader(data))
+	if err != nil {
+		return boson.ZeroAddress, fmt.Errorf("store access manifest: %w", err)
+	}
+	if err := s.registerRoot(ctx, ref); err != nil {
+		return boson.ZeroAddress, err
+	}
+	return ref, nil
+}
+
+// registerRoot records the data chunks of a root stored by this node.
+func (s *server) registerRoot(ctx context.Context, ref boson.Address) error {
+	dataChunks, _, err := s.traversal.GetChunkHashes(ctx, ref, nil)
+	if err != nil {
+		return fmt.Errorf("get chunk hashes: %w", err)
+	}
+	for _, li := range dataChunks {
+		for _, b := range li {
+			if err := s.chunkInfo.OnChunkRetrieved(boson.NewAddress(b), ref, s.overlay); err != nil {
+				return fmt.Errorf("chunk transfer data: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// reencrypt stores the content of ref again under fresh encryption keys and
+// returns the new reference, so the former reference opens nothing new.
+// Collections are rebuilt file by file with their metadata.
+func (s *server) reencrypt(ctx context.Context, ref boson.Address) (boson.Address, error) {
+	factory := func() pipeline.Interface {
+		return builder.NewPipelineBuilder(ctx, s.storer, storage.ModePutUpload, true)
+	}
+	copyFile := func(addr boson.Address) (boson.Address, error) {
+		j, _, err := joiner.New(ctx, s.storer, storage.ModeGetRequest, addr)
+		if err != nil {
+			return boson.ZeroAddress, err
+		}
+		return builder.FeedPipeline(ctx, factory(), j)
+	}
+
+	m, err := manifest.NewDefaultManifestReference(ref, loadsave.NewReadonly(s.storer, storage.ModeGetRequest))
+	if errors.Is(err, manifest.ErrInvalidManifestType) {
+		return copyFile(ref)
+	}
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+
+	copied, err := manifest.NewDefaultManifest(loadsave.New(s.storer, factory), true)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	root, err := m.Lookup(ctx, manifest.RootPath)
+	switch {
+	case err == nil:
+		err = copied.Add(ctx, manifest.RootPath, manifest.NewEntry(boson.ZeroAddress, root.Metadata()))
+		if err != nil {
+			return boson.ZeroAddress, err
+		}
+	case !errors.Is(err, manifest.ErrNotFound):
+		return boson.ZeroAddress, err
+	}
+	err = m.IterateDirectories(ctx, []byte{}, -1, func(nodeType int, path, prefix, hash []byte, metadata map[string]string) error {
+		if nodeType != int(manifest.File) {
+			return nil
+		}
+		file, err := copyFile(boson.NewAddress(hash))
+		if err != nil {
+			return fmt.Errorf("copy %s%s: %w", path, prefix, err)
+		}
+		return copied.Add(ctx, string(path)+string(prefix), manifest.NewEntry(file, metadata))
+	})
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	return copied.Store(ctx)
+}
+
+// replaceRevoked hands the pin and retention record of the content revoked
+// from former grantees over to its re-encrypted copy and removes it.
+func (s *server) replaceRevoked(ctx context.Context, old, ref boson.Address) error {
+	if err := s.retention.Record(ref, time.Now()); err != nil {
+		s.logger.Debugf("act: record retention of %s: %v", ref, err)
+	}
+	pinned, err := s.pinning.HasPin(old)
+	if err != nil {
+		return fmt.Errorf("check pin: %w", err)
+	}
+	if pinned {
+		meta, err := s.pinMeta.Get(old)
+		if err != nil {
+			return fmt.Errorf("pin metadata: %w", err)
+		}
+		if err := s.createPin(ctx, ref, false, meta.Name); err != nil {
+			return fmt.Errorf("pin: %w", err)
+		}
+	}
+
+	s.rootsMu.Lock()
+	defer s.rootsMu.Unlock()
+	if _, err := s.deleteFile(ctx, newChunkRefs(), old, true); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// loadAccessManifest reads an access manifest, retrieving it from the
+// network if it is not held locally.
+func (s *server) loadAccessManifest(ctx context.Context, ref boson.Address) (*act.Manifest, error) {
+	ctx = sctx.SetRootHash(ctx, ref)
+	if !s.chunkInfo.Init(ctx, nil, ref) {
+		return nil, storage.ErrNotFound
+	}
+
+	j, _, err := joiner.New(ctx, s.storer, storage.ModeGetRequest, ref)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(j, maxAccessManifestSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var m act.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", act.ErrInvalidManifest, err)
+	}
+	return &m, nil
+}
+
+// grantAccess publishes an access manifest for the encrypted reference and
+// remembers its grantees.
+func (s *server) grantAccess(ctx context.Context, ref boson.Address, keys []*ecdsa.PublicKey) (boson.Address, error) {
+	if len(ref.Bytes()) != encryption.ReferenceSize {
+		return boson.ZeroAddress, errActNotEncrypted
+	}
+
+	m, err := s.act.Grant(ref, keys)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	encoded := make([]string, 0, len(keys))
+	for _, k := range keys {
+		encoded = append(encoded, act.EncodePublicKey(k))
+	}
+	return s.publishAccessManifest(ctx, m, encoded)
+}
+
+func (s *server) publishAccessManifest(ctx context.Context, m *act.Manifest, grantees []string) (boson.Address, error) {
+	actRef, err := s.storeAccessManifest(ctx, m)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	err = s.stateStore.Put(actGranteesKey(actRef), actGrantees{Reference: actRef, Grantees: grantees})
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	return actRef, nil
+}
+
+// openAct resolves an access manifest reference to the content reference.
+// The requester gives the lookup key and the access key decryption key it
+// derived for the publisher with the act key headers, the publisher too.
+// The key of the node is never used, so reading through the API does not
+// make the client a grantee. An expected publisher can be given with the
+// publisher header.
+func (s *server) openAct(r *http.Request, ref boson.Address) (boson.Address, error) {
+	lookup, err1 := hex.DecodeString(r.Header.Get(AuroraActLookupKeyHeader))
+	akdk, err2 := hex.DecodeString(r.Header.Get(AuroraActAccessKeyHeader))
+	if err1 != nil || err2 != nil || len(lookup) == 0 || len(akdk) == 0 {
+		return boson.ZeroAddress, errActKey
+	}
+	m, err := s.loadAccessManifest(r.Context(), ref)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	if publisher := r.Header.Get(AuroraActPublisherHeader); publisher != "" {
+		pub, err := act.DecodePublicKey(publisher)
+		if err != nil || act.EncodePublicKey(pub) != m.Publisher {
+			return boson.ZeroAddress, act.ErrAccessDenied
+		}
+	}
+	return act.OpenWithKeys(m, lookup, akdk)
+}
+
+// grantErrorResponse answers a request whose grant of access failed.
+func grantErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errActNotEncrypted):
+		jsonhttp.BadRequest(w, errActNotEncrypted.Error())
+	case errors.Is(err, errInvalidGrantee):
+		jsonhttp.BadRequest(w, "invalid grantees")
+	default:
+		jsonhttp.InternalServerError(w, "grant access failed")
+	}
+}
+
+func (s *server) actCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req actCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("act create: decode request: %v", err)
+		s.logger.Error("act create: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	var actRef boson.Address
+	keys, err := parseGrantees(req.Grantees)
+	if err == nil {
+		s.rootsMu.RLock()
+		actRef, err = s.grantAccess(r.Context(), req.Reference, keys)
+		s.rootsMu.RUnlock()
+	}
+	if err != nil {
+		s.logger.Debugf("act create: grant access to %s: %v", req.Reference, err)
+		s.logger.Error("act create: grant access")
+		grantErrorResponse(w, err)
+		return
+	}
+
+	jsonhttp.Created(w, auroraUploadResponse{Reference: actRef})
+}
+
+func (s *server) actGranteesGetHandler(w http.ResponseWriter, r *http.Request) {
+	ref, err := boson.ParseHexAddress(mux.Vars(r)["address"])
+	if err != nil {
+		jsonhttp.BadRequest(w, "invalid address")
+		return
+	}
+
+	var g actGrantees
+	err = s.stateStore.Get(actGranteesKey(ref), &g)
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonhttp.NotFound(w, "not published by this node")
+		return
+	}
+	if err != nil {
+		s.logger.Debugf("act grantees: get %s: %v", ref, err)
+		s.logger.Error("act grantees: get grantees")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	jsonhttp.OK(w, g)
+}
+
+// actGranteesPatchHandler adds and revokes grantees of an access manifest
+// published by this node. The content is granted under a new access key and
+// the reference of the updated manifest is returned. When grantees are
+// revoked the content is re-encrypted first and the former copy is removed,
+// so the former manifest no longer leads to it.
+func (s *server) actGranteesPatchHandler(w http.ResponseWriter, r *http.Request) {
+	ref, err := boson.ParseHexAddress(mux.Vars(r)["address"])
+	if err != nil {
+		jsonhttp.BadRequest(w, "invalid address")
+		return
+	}
+
+	var req actGranteesPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("act grantees patch: decode request: %v", err)
+		s.logger.Error("act grantees patch: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	var current actGrantees
+	err = s.stateStore.Get(actGranteesKey(ref), &current)
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonhttp.NotFound(w, "not published by this node")
+		return
+	}
+	if err != nil {
+		s.logger.Debugf("act grantees patch: get %s: %v", ref, err)
+		s.logger.Error("act grantees patch: get grantees")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	// normalise the keys so revocations match regardless of their encoding
+	set := make(map[string]struct{})
+	var grantees []string
+	add := func(list []string) error {
+		keys, err := parseGrantees(list)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			enc := act.EncodePublicKey(k)
+			if _, ok := set[enc]; !ok {
+				set[enc] = struct{}{}
+				grantees = append(grantees, enc)
+			}
+		}
+		return nil
+	}
+	revoked, err := parseGrantees(req.Revoke)
+	if err == nil {
+		err = add(current.Grantees)
+	}
+	former := len(grantees)
+	if err == nil {
+		err = add(req.Add)
+	}
+	if err != nil {
+		s.logger.Debugf("act grantees patch: %v", err)
+		s.logger.Error("act grantees patch: invalid grantees")
+		jsonhttp.BadRequest(w, "invalid grantees")
+		return
+	}
+	for _, k := range revoked {
+		delete(set, act.EncodePublicKey(k))
+	}
+	remaining := make([]string, 0, len(set))
+	revoking := false
+	for i, g := range grantees {
+		if _, ok := set[g]; ok {
+			remaining = append(remaining, g)
+		} else if i < former {
+			revoking = true
+		}
+	}
+
+	ctx := r.Context()
+	m, err := s.loadAccessManifest(ctx, ref)
+	if err != nil {
+		s.logger.Debugf("act grantees patch: load manifest %s: %v", ref, err)
+		s.logger.Error("act grantees patch: load manifest")
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+	if m.Publisher != s.act.PublicKey() {
+		jsonhttp.Forbidden(w, "not the publisher")
+		return
+	}
+	content, err := s.act.Open(m)
+	if err != nil {
+		s.logger.Debugf("act grantees patch: open manifest %s: %v", ref, err)
+		s.logger.Error("act grantees patch: open manifest")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	keys, _ := parseGrantees(remaining)
+	s.rootsMu.RLock()
+	granted := content
+	if revoking {
+		granted, err = s.reencrypt(ctx, content)
+		if err == nil {
+			err = s.registerRoot(ctx, granted)
+		}
+	}
+	var actRef boson.Address
+	if err == nil {
+		actRef, err = s.grantAccess(ctx, granted, keys)
+	}
+	s.rootsMu.RUnlock()
+	if err != nil {
+		s.logger.Debugf("act grantees patch: grant access to %s: %v", ref, err)
+		s.logger.Error("act grantees patch: grant access")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if err := s.stateStore.Delete(actGranteesKey(ref)); err != nil {
+		s.logger.Debugf("act grantees patch: forget %s: %v", ref, err)
+	}
+	if revoking {
+		if err := s.replaceRevoked(ctx, content, granted); err != nil {
+			s.logger.Debugf("act grantees patch: replace revoked content %s: %v", content, err)
+			s.logger.Error("act grantees patch: replace revoked content")
+			jsonhttp.InternalServerError(w, "revoked content could not be removed")
+			return
+		}
+	}
+
+	jsonhttp.OK(w, actGrantees{Reference: actRef, Grantees: remaining})
+}
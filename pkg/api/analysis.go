@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/encryption"
+	"github.com/gauss-project/aurorafs/pkg/file/joiner"
+	"github.com/gauss-project/aurorafs/pkg/file/loadsave"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/manifest"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gauss-project/aurorafs/pkg/tracing"
+	"github.com/gauss-project/manifest/mantaray"
+	"github.com/gorilla/mux"
+)
+
+// fileAnalysis is the chunk level breakdown of a file or manifest.
+type fileAnalysis struct {
+	Reference          boson.Address `json:"reference"`
+	Encrypted          bool          `json:"encrypted"`
+	Files              int           `json:"files"`
+	Size               int64         `json:"size"`
+	Chunks             int           `json:"chunks"`
+	DataChunks         int           `json:"dataChunks"`
+	IntermediateChunks int           `json:"intermediateChunks"`
+	ManifestChunks     int           `json:"manifestChunks"`
+	EstimatedChunks    int64         `json:"estimatedChunks"`
+	Duplicates         int           `json:"duplicates"`
+	DedupHits          int           `json:"dedupHits"`
+	Overhead           float64       `json:"overhead"`
+}
+
+// analyseReference breaks the chunks of the file or manifest held in the
+// localstore down into data, intermediate and manifest chunks. Nothing is
+// requested from the network.
+func (s *server) analyseReference(ctx context.Context, ref boson.Address) (*fileAnalysis, error) {
+	analysis := &fileAnalysis{
+		Reference: ref,
+		Encrypted: len(ref.Bytes()) == encryption.ReferenceSize,
+	}
+
+	// visits counts how often every chunk is referenced within the root
+	visits := make(map[string]int)
+	if err := s.localTraversal.Traverse(ctx, ref, func(addr boson.Address) error {
+		visits[addr.String()]++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	fileChunks := make(map[string]struct{})
+	analyseFile := func(addr boson.Address) error {
+		j, span, err := joiner.New(ctx, s.LocalStore, storage.ModeGetRequest, addr)
+		if err != nil {
+			return err
+		}
+		data := int((span + boson.ChunkSize - 1) / boson.ChunkSize)
+		if data == 0 {
+			data = 1
+		}
+		var chunks int
+		if err := j.IterateChunkAddresses(func(a boson.Address) error {
+			fileChunks[a.String()] = struct{}{}
+			chunks++
+			return nil
+		}); err != nil {
+			return err
+		}
+		analysis.Files++
+		analysis.Size += span
+		analysis.DataChunks += data
+		analysis.IntermediateChunks += chunks - data
+		analysis.EstimatedChunks += calculateNumberOfChunks(span, analysis.Encrypted)
+		return nil
+	}
+
+	ls := loadsave.NewReadonly(s.LocalStore, storage.ModeGetRequest)
+	m, err := manifest.NewDefaultManifestReference(ref, ls)
+	switch {
+	case errors.Is(err, manifest.ErrInvalidManifestType):
+		err = analyseFile(ref)
+	case err != nil:
+		return nil, err
+	default:
+		err = m.IterateDirectories(ctx, []byte{}, -1, func(nodeType int, _, _, hash []byte, _ map[string]string) error {
+			if nodeType != int(manifest.File) {
+				return nil
+			}
+			return analyseFile(boson.NewAddress(hash))
+		})
+		// as in the traversal, a root failing to load as a manifest is a
+		// plain file
+		if errors.Is(err, mantaray.ErrTooShort) || errors.Is(err, mantaray.ErrInvalidVersionHash) {
+			err = analyseFile(ref)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("analyse files: %w", err)
+	}
+
+	for addr, n := range visits {
+		analysis.Chunks++
+		analysis.Duplicates += n - 1
+		if _, ok := fileChunks[addr]; !ok {
+			analysis.ManifestChunks++
+		}
+	}
+
+	// chunkinfo leaves the chunks shared with other roots out of the
+	// pyramid of the root, these are stored only once
+	if pyramid := s.chunkInfo.GetChunkPyramid(ref); pyramid != nil {
+		own := make(map[string]struct{}, len(pyramid))
+		for _, chunk := range pyramid {
+			own[chunk.Cid.String()] = struct{}{}
+		}
+		shared := make([]boson.Address, 0)
+		for addr := range visits {
+			if _, ok := own[addr]; !ok && addr != ref.String() {
+				shared = append(shared, boson.MustParseHexAddress(addr))
+			}
+		}
+		has, err := s.LocalStore.HasMulti(ctx, storage.ModeHasChunk, shared...)
+		if err != nil {
+			return nil, fmt.Errorf("check shared chunks: %w", err)
+		}
+		for _, yes := range has {
+			if yes {
+				analysis.DedupHits++
+			}
+		}
+	}
+
+	if analysis.DataChunks > 0 {
+		analysis.Overhead = float64(analysis.IntermediateChunks+analysis.ManifestChunks) / float64(analysis.DataChunks)
+	}
+	return analysis, nil
+}
+
+// analysisHandler reports how many chunks a file or manifest held by the
+// node consists of, how many of them it shares with other local files and
+// how much of it is spent on intermediate and manifest chunks.
+func (s *server) analysisHandler(w http.ResponseWriter, r *http.Request) {
+	logger := tracing.NewLoggerWithTraceID(r.Context(), s.logger)
+
+	nameOrHex := mux.Vars(r)["address"]
+	ref, err := s.resolveNameOrAddress(nameOrHex)
+	if err != nil {
+		logger.Debugf("analysis: parse address %s: %v", nameOrHex, err)
+		logger.Error("analysis: parse address")
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+
+	analysis, err := s.analyseReference(r.Context(), ref)
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonhttp.NotFound(w, "file not held locally")
+		return
+	}
+	if err != nil {
+		logger.Debugf("analysis: %s: %v", ref, err)
+		logger.Error("analysis: analyse file")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, analysis)
+}
@@ -0,0 +1,89 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/file/pipeline/builder"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+type fileAnalysis struct {
+	Reference          boson.Address `json:"reference"`
+	Encrypted          bool          `json:"encrypted"`
+	Files              int           `json:"files"`
+	Size               int64         `json:"size"`
+	Chunks             int           `json:"chunks"`
+	DataChunks         int           `json:"dataChunks"`
+	IntermediateChunks int           `json:"intermediateChunks"`
+	ManifestChunks     int           `json:"manifestChunks"`
+	EstimatedChunks    int64         `json:"estimatedChunks"`
+	Duplicates         int           `json:"duplicates"`
+	DedupHits          int           `json:"dedupHits"`
+	Overhead           float64       `json:"overhead"`
+}
+
+// storeFile stores size random bytes as a file in store and returns its
+// reference.
+func storeFile(t *testing.T, store storage.Storer, size int) boson.Address {
+	t.Helper()
+	ctx := context.Background()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	pipe := builder.NewPipelineBuilder(ctx, store, storage.ModePutUpload, false)
+	ref, err := builder.FeedPipeline(ctx, pipe, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestAnalysis(t *testing.T) {
+	store := newStorer()
+	ref := storeFile(t, store, 2*boson.ChunkSize+100)
+	client := newTestServer(t, testServerOptions{Storer: store})
+
+	// three data chunks under one intermediate chunk
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/analysis/"+ref.String(), http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(fileAnalysis{
+			Reference:          ref,
+			Files:              1,
+			Size:               2*boson.ChunkSize + 100,
+			Chunks:             4,
+			DataChunks:         3,
+			IntermediateChunks: 1,
+			EstimatedChunks:    4,
+			Overhead:           float64(1) / 3,
+		}),
+	)
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/analysis/"+test.RandomAddress().String(), http.StatusNotFound,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "file not held locally",
+			Code:    http.StatusNotFound,
+		}),
+	)
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/analysis/not-an-address", http.StatusNotFound)
+}
+
+func TestAnalysisRestricted(t *testing.T) {
+	store := newStorer()
+	ref := storeFile(t, store, 100)
+	client := newTestServer(t, testServerOptions{Storer: store, Restricted: true})
+	resource := "/v1/analysis/" + ref.String()
+
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusOK,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+}
@@ -14,20 +14,38 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/FavorLabs/favorX/pkg/accounting"
+	"github.com/FavorLabs/favorX/pkg/act"
+	"github.com/FavorLabs/favorX/pkg/autocash"
+	"github.com/FavorLabs/favorX/pkg/batchreg"
+	"github.com/FavorLabs/favorX/pkg/groupauth"
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/FavorLabs/favorX/pkg/groupcrypt"
+	"github.com/FavorLabs/favorX/pkg/groupfeed"
+	"github.com/FavorLabs/favorX/pkg/grouplog"
+	"github.com/FavorLabs/favorX/pkg/groupstream"
+	"github.com/FavorLabs/favorX/pkg/netrelay"
+	"github.com/FavorLabs/favorX/pkg/pingrtt"
+	"github.com/FavorLabs/favorX/pkg/pinmeta"
+	"github.com/FavorLabs/favorX/pkg/pinsvc"
+	"github.com/FavorLabs/favorX/pkg/retention"
+	"github.com/FavorLabs/favorX/pkg/traffichistory"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gauss-project/aurorafs/pkg/auth"
 	"github.com/gauss-project/aurorafs/pkg/boson"
 	"github.com/gauss-project/aurorafs/pkg/chunkinfo"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
 	"github.com/gauss-project/aurorafs/pkg/file/pipeline"
 	"github.com/gauss-project/aurorafs/pkg/file/pipeline/builder"
 	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
 	"github.com/gauss-project/aurorafs/pkg/logging"
 	m "github.com/gauss-project/aurorafs/pkg/metrics"
 	"github.com/gauss-project/aurorafs/pkg/multicast"
-	"github.com/gauss-project/aurorafs/pkg/netrelay"
 	"github.com/gauss-project/aurorafs/pkg/pinning"
 	"github.com/gauss-project/aurorafs/pkg/resolver"
 	"github.com/gauss-project/aurorafs/pkg/routetab"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
 	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
 	"github.com/gauss-project/aurorafs/pkg/settlement/traffic"
 	"github.com/gauss-project/aurorafs/pkg/storage"
@@ -46,6 +64,13 @@ const (
 	AuroraFeedIndexNextHeader  = "Aurora-Feed-Index-Next"
 	AuroraCollectionHeader     = "Aurora-Collection"
 	AuroraCollectionNameHeader = "Aurora-Collection-Name"
+	AuroraActHeader            = "Aurora-Act"
+	AuroraActGranteesHeader    = "Aurora-Act-Grantees"
+	AuroraActPublisherHeader   = "Aurora-Act-Publisher"
+	AuroraActLookupKeyHeader   = "Aurora-Act-Lookup-Key"
+	AuroraActAccessKeyHeader   = "Aurora-Act-Access-Key"
+	AuroraGroupTokenHeader     = "Aurora-Group-Token"
+	AuroraGroupTimeoutHeader   = "Aurora-Group-Timeout"
 )
 
 // The size of buffer used for prefetching content with Langos.
@@ -95,6 +120,7 @@ type authenticator interface {
 type server struct {
 	auth        authenticator
 	storer      storage.Storer
+	stateStore  storage.StateStorer
 	resolver    resolver.Interface
 	overlay     boson.Address
 	chunkInfo   chunkinfo.Interface
@@ -119,6 +145,33 @@ type server struct {
 	route           routetab.RouteTab
 	kad             topology.Driver
 	snapshotPeers   []boson.Address
+	retention       *retention.Store
+	registerJobs    *batchreg.Registry
+	rootsMu         sync.RWMutex
+	rootsDeleted    uint64
+	localTraversal  traversal.Traverser
+	act             *act.Controller
+	pinMeta         *pinmeta.Store
+	pinCheckMu      sync.Mutex
+	pinCheck        *pinCheckStatus
+	pinService      *pinsvc.Store
+	pinServiceWake  chan struct{}
+	pinServiceMu    sync.Mutex
+	groupRPC        *rpc.Client
+	groupLog        *grouplog.Log
+	groupLogMu      sync.Mutex
+	groupConsumers  map[string]*groupConsumer
+	groupIngestMu   sync.Mutex
+	signer          crypto.Signer
+	groupAuth       *groupauth.Store
+	groupVerifier   *groupauth.Verifier
+	groupPeer       *groupcrypt.Peer
+	groupKeys       sync.Map
+	groupSessions   sync.Map
+	groupConf       *groupconf.Store
+	groupStreams    *groupstream.Sessions
+	groupCountersMu sync.Mutex
+	groupCounters   map[string]map[string]*groupPeerCounters
 }
 
 type Options struct {
@@ -129,6 +182,17 @@ type Options struct {
 	Restricted         bool
 	DebugApiAddr       string
 	RPCWSAddr          string
+	Retention          retention.Policy
+	RetentionInterval  time.Duration
+	NetworkID          uint64
+	TrafficHistory     *traffichistory.History
+	Accounting         *accounting.Accounting
+	AutoCash           *autocash.Cashier
+	Transactions       *txmgr.Manager
+	RegisterInterval   time.Duration
+	LocalStore         storage.Storer
+	GroupFeed          *groupfeed.Hub
+	PingRTT            *pingrtt.Recorder
 }
 type TransactionResponse struct {
 	Hash     common.Hash
@@ -142,17 +206,19 @@ const (
 )
 
 // New will create a and initialize a new API service.
-func New(storer storage.Storer, resolver resolver.Interface, addr boson.Address, chunkInfo chunkinfo.Interface,
+func New(storer storage.Storer, stateStore storage.StateStorer, resolver resolver.Interface, addr boson.Address, signer crypto.Signer, chunkInfo chunkinfo.Interface,
 	traversalService traversal.Traverser, pinning pinning.Interface, auth authenticator, logger logging.Logger,
 	tracer *tracing.Tracer, traffic traffic.ApiInterface, commonChain chain.Common, oracleChain chain.Resolver,
 	netRelay netrelay.NetRelay, multicast multicast.GroupInterface, kad topology.Driver, route routetab.RouteTab, o Options) Service {
 	s := &server{
 		auth:            auth,
 		storer:          storer,
+		stateStore:      stateStore,
 		resolver:        resolver,
 		overlay:         addr,
 		chunkInfo:       chunkInfo,
 		traversal:       traversalService,
+		localTraversal:  traversal.New(o.LocalStore),
 		pinning:         pinning,
 		Options:         o,
 		logger:          logger,
@@ -167,11 +233,44 @@ func New(storer storage.Storer, resolver resolver.Interface, addr boson.Address,
 		transactionChan: make(chan TransactionResponse, 10),
 		multicast:       multicast,
 		netRelay:        netRelay,
+		retention:       retention.NewStore(stateStore),
+		act:             act.New(signer.PrivateKey()),
+		pinMeta:         pinmeta.NewStore(stateStore),
+		pinService:      pinsvc.NewStore(stateStore),
+		pinServiceWake:  make(chan struct{}, 1),
+		groupLog:        grouplog.New(stateStore),
+		groupConsumers:  make(map[string]*groupConsumer),
+		groupCounters:   make(map[string]map[string]*groupPeerCounters),
+		signer:          signer,
+		groupAuth:       groupauth.NewStore(stateStore),
+		groupVerifier:   groupauth.NewVerifier(o.NetworkID),
+		groupPeer:       groupcrypt.NewPeer(signer.PrivateKey()),
+		groupConf:       groupconf.NewStore(stateStore),
+		groupStreams: groupstream.NewSessions(groupstream.Options{
+			Idle:            groupStreamSessionIdle,
+			MaxSize:         groupStreamMaxSize,
+			MaxSessions:     groupStreamMaxSessions,
+			MaxPeerSessions: groupStreamMaxPeerSessions,
+		}),
 	}
 
+	s.registerJobs = batchreg.New(logger, oracleChain, addr, func(root boson.Address) {
+		s.auroraChainSate.Store(root.String(), true)
+	}, batchreg.Options{Interval: o.RegisterInterval, Lock: s.lockRegister})
+
 	BufferSizeMul = o.BufferSizeMul
 	s.setupRouting()
 	s.transactionReceiptUpdate()
+	s.retentionSweeper()
+	s.pinServiceWorker()
+	s.setupGroupRPC()
+	// the rpc api of the multicast service is taken above, everything sent
+	// from here on is signed for private groups
+	s.multicast = memberGroups{GroupInterface: prunedGroups{GroupInterface: s.multicast, s: s}, s: s}
+	if o.GroupFeed != nil {
+		o.GroupFeed.SetFilter(s.filterGroupEvent)
+	}
+	s.groupLogWorker()
 
 	return s
 }
@@ -180,6 +279,10 @@ func New(storer storage.Storer, resolver resolver.Interface, addr boson.Address,
 func (s *server) Close() error {
 	s.logger.Info("api shutting down")
 	close(s.quit)
+	_ = s.registerJobs.Close()
+	if s.groupRPC != nil {
+		s.groupRPC.Close()
+	}
 
 	done := make(chan struct{})
 	go func() {
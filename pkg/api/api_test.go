@@ -0,0 +1,516 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/api"
+	"github.com/FavorLabs/favorX/pkg/auth"
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/FavorLabs/favorX/pkg/groupfeed"
+	"github.com/FavorLabs/favorX/pkg/netrelay"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/chunkinfo"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	"github.com/gauss-project/aurorafs/pkg/multicast/pb"
+	"github.com/gauss-project/aurorafs/pkg/pinning"
+	resolvermock "github.com/gauss-project/aurorafs/pkg/resolver/mock"
+	routetabmock "github.com/gauss-project/aurorafs/pkg/routetab/mock"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic"
+	statestore "github.com/gauss-project/aurorafs/pkg/statestore/mock"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	storagemock "github.com/gauss-project/aurorafs/pkg/storage/mock"
+	"github.com/gauss-project/aurorafs/pkg/subscribe"
+	"github.com/gauss-project/aurorafs/pkg/topology"
+	"github.com/gauss-project/aurorafs/pkg/traversal"
+	"resenje.org/web"
+)
+
+// testAuthKey is the encryption key of the tokens the test servers accept.
+const testAuthKey = "test"
+
+type testServerOptions struct {
+	Storer      storage.Storer
+	LocalStore  storage.Storer
+	StateStorer storage.StateStorer
+	ChunkInfo   chunkinfo.Interface
+	Pinning     pinning.Interface
+	Traffic     traffic.ApiInterface
+	OracleChain chain.Resolver
+	NetRelay    netrelay.NetRelay
+	Multicast   multicast.GroupInterface
+	Kad         topology.Driver
+	Restricted  bool
+	Options     api.Options
+}
+
+// newTestServer starts the api with o, filling what is not given with
+// mocks, and returns a client sending requests to it.
+func newTestServer(t *testing.T, o testServerOptions) *http.Client {
+	t.Helper()
+	client, _ := startTestServer(t, o)
+	return client
+}
+
+// startTestServer is newTestServer also returning the address the api
+// listens on, to which websockets are dialed.
+func startTestServer(t *testing.T, o testServerOptions) (*http.Client, string) {
+	t.Helper()
+	logger := logging.New(io.Discard, 0)
+	if o.Storer == nil {
+		o.Storer = newStorer()
+	}
+	if o.LocalStore == nil {
+		o.LocalStore = o.Storer
+	}
+	if o.StateStorer == nil {
+		o.StateStorer = statestore.NewStateStore()
+	}
+	if o.ChunkInfo == nil {
+		o.ChunkInfo = newChunkInfo()
+	}
+	if o.Pinning == nil {
+		o.Pinning = newPinning()
+	}
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := auth.New(testAuthKey, "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Options.WsPingPeriod == 0 {
+		o.Options.WsPingPeriod = time.Minute
+	}
+	o.Options.Restricted = o.Restricted
+	o.Options.LocalStore = o.LocalStore
+	route := routetabmock.NewMockRouteTable()
+
+	s := api.New(o.Storer, o.StateStorer, resolvermock.NewResolver(), boson.MustParseHexAddress("01"), crypto.NewDefaultSigner(key),
+		o.ChunkInfo, traversal.New(o.Storer), o.Pinning, authenticator, logger, nil, o.Traffic, nil, o.OracleChain,
+		o.NetRelay, o.Multicast, o.Kad, &route, o.Options)
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		_ = s.Close()
+	})
+
+	return &http.Client{
+		Transport: web.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			u, err := url.Parse(ts.URL + r.URL.String())
+			if err != nil {
+				return nil, err
+			}
+			r.URL = u
+			return ts.Client().Transport.RoundTrip(r)
+		}),
+	}, ts.Listener.Addr().String()
+}
+
+// authToken returns the bearer token of role accepted by the test servers.
+func authToken(t *testing.T, role string) string {
+	t.Helper()
+	a, err := auth.New(testAuthKey, "", logging.New(io.Discard, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := a.GenerateKey(role, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + key
+}
+
+// storer is the storage mock, which lacks HasMulti.
+type storer struct {
+	*storagemock.MockStorer
+}
+
+func newStorer() *storer {
+	return &storer{MockStorer: storagemock.NewStorer()}
+}
+
+func (s *storer) HasMulti(ctx context.Context, mode storage.ModeHas, addrs ...boson.Address) ([]bool, error) {
+	has := make([]bool, len(addrs))
+	for i, addr := range addrs {
+		yes, err := s.Has(ctx, mode, addr)
+		if err != nil {
+			return nil, err
+		}
+		has[i] = yes
+	}
+	return has, nil
+}
+
+// chunkInfo lists the roots given pyramids and removes them on DelFile.
+// Methods not needed by the tests are not implemented.
+type chunkInfo struct {
+	chunkinfo.Interface
+	mu       sync.Mutex
+	roots    []boson.Address
+	pyramids map[string][]*chunkinfo.PyramidCidNum
+}
+
+func newChunkInfo() *chunkInfo {
+	return &chunkInfo{pyramids: make(map[string][]*chunkinfo.PyramidCidNum)}
+}
+
+// putRoot adds root with the chunks of its pyramid.
+func (ci *chunkInfo) putRoot(root boson.Address, chunks ...boson.Address) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.roots = append(ci.roots, root)
+	pyramid := []*chunkinfo.PyramidCidNum{{Cid: root, Number: 1}}
+	for _, chunk := range chunks {
+		pyramid = append(pyramid, &chunkinfo.PyramidCidNum{Cid: chunk, Number: 1})
+	}
+	ci.pyramids[root.String()] = pyramid
+}
+
+// Init reports the sources of every root as known, the chunks are taken
+// from the localstore.
+func (ci *chunkInfo) Init(context.Context, []byte, boson.Address) bool {
+	return true
+}
+
+func (ci *chunkInfo) GetFileList(boson.Address) ([]map[string]interface{}, []boson.Address) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return nil, append([]boson.Address(nil), ci.roots...)
+}
+
+func (ci *chunkInfo) GetChunkPyramid(root boson.Address) []*chunkinfo.PyramidCidNum {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.pyramids[root.String()]
+}
+
+func (ci *chunkInfo) DelFile(root boson.Address, del func() error) error {
+	if err := del(); err != nil {
+		return err
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	for i, r := range ci.roots {
+		if r.Equal(root) {
+			ci.roots = append(ci.roots[:i], ci.roots[i+1:]...)
+			break
+		}
+	}
+	delete(ci.pyramids, root.String())
+	return nil
+}
+
+// pins tracks the pinned roots like the pinning service, without pinning
+// their chunks.
+type pins struct {
+	mu   sync.Mutex
+	refs []boson.Address
+}
+
+func newPinning() *pins {
+	return &pins{}
+}
+
+func (p *pins) CreatePin(_ context.Context, ref boson.Address, _ bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.refs {
+		if r.Equal(ref) {
+			return nil
+		}
+	}
+	p.refs = append(p.refs, ref)
+	return nil
+}
+
+func (p *pins) DeletePin(_ context.Context, ref boson.Address) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, r := range p.refs {
+		if r.Equal(ref) {
+			p.refs = append(p.refs[:i], p.refs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (p *pins) HasPin(ref boson.Address) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.refs {
+		if r.Equal(ref) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *pins) Pins() ([]boson.Address, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]boson.Address(nil), p.refs...), nil
+}
+
+// groups mimics the multicast service. Messages received in a group are
+// delivered by publishing them on subPub like the service does, what is sent
+// and replied is recorded.
+type groups struct {
+	multicast.GroupInterface
+	subPub subscribe.SubPub
+
+	mu          sync.Mutex
+	joined      []*model.GroupInfo
+	peers       map[string]*multicast.GroupPeers
+	multicasted []*pb.MulticastMsg
+	replies     chan groupReplyCall
+	sessions    map[string]chan []byte
+	lastSession int
+	// sendReceive answers the requests sent by the node, without it the
+	// targets are not found
+	sendReceive func(ctx context.Context, data []byte, gid, dest boson.Address) ([]byte, error)
+}
+
+// groupReplyCall is a reply sent through the rpc api of the service.
+type groupReplyCall struct {
+	SessionID string
+	Data      []byte
+}
+
+func newGroups() *groups {
+	return &groups{
+		subPub:   subscribe.NewSubPub(),
+		peers:    make(map[string]*multicast.GroupPeers),
+		replies:  make(chan groupReplyCall, 16),
+		sessions: make(map[string]chan []byte),
+	}
+}
+
+// newGroupFeed returns a group feed hub subscribing to g.
+func newGroupFeed(t *testing.T, g *groups) *groupfeed.Hub {
+	t.Helper()
+	h, err := groupfeed.New(logging.New(io.Discard, 0), g.API(), g.subPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+// join adds gid to the groups of the node unless joined before, with peers
+// connected.
+func (g *groups) join(gid boson.Address, connected ...boson.Address) {
+	if !g.isJoined(gid) {
+		_ = g.AddGroup([]model.ConfigNodeGroup{{Name: gid.String()}})
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.peers[gid.String()].Connected = connected
+}
+
+// isJoined reports whether gid is among the groups of the node.
+func (g *groups) isJoined(gid boson.Address) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.peers[gid.String()]
+	return ok
+}
+
+// sent returns the multicast messages sent so far.
+func (g *groups) sent() []*pb.MulticastMsg {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*pb.MulticastMsg(nil), g.multicasted...)
+}
+
+// receive publishes a message received from peer in gid. Messages with a
+// session id are requests.
+func (g *groups) receive(gid, from boson.Address, sessionID string, data []byte) {
+	_ = g.subPub.Publish("group", "groupMessage", gid.String(), multicast.GroupMessage{
+		SessionID: rpc.ID(sessionID),
+		GID:       gid,
+		Data:      data,
+		From:      from,
+	})
+}
+
+// request delivers data as a request of from in gid and returns the reply
+// of the node.
+func (g *groups) request(ctx context.Context, gid, from boson.Address, data []byte) ([]byte, error) {
+	g.mu.Lock()
+	g.lastSession++
+	session := "session-" + strconv.Itoa(g.lastSession)
+	c := make(chan []byte, 1)
+	g.sessions[session] = c
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.sessions, session)
+		g.mu.Unlock()
+	}()
+
+	g.receive(gid, from, session, data)
+	select {
+	case out := <-c:
+		return out, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// link passes the requests sent by the node to target, which receives them
+// from overlay.
+func (g *groups) link(target *groups, overlay boson.Address) {
+	g.sendReceive = func(ctx context.Context, data []byte, gid, _ boson.Address) ([]byte, error) {
+		return target.request(ctx, gid, overlay, data)
+	}
+}
+
+// receiveMulticast publishes a multicast message of origin received in gid.
+func (g *groups) receiveMulticast(gid, origin boson.Address, data []byte) {
+	_ = g.subPub.Publish("group", "multicastMsg", gid.String(), multicast.Message{
+		CreateTime: time.Now().UnixMilli(),
+		GID:        gid,
+		Origin:     origin,
+		Data:       data,
+		From:       origin,
+	})
+}
+
+func (g *groups) Multicast(info *pb.MulticastMsg, _ ...boson.Address) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.multicasted = append(g.multicasted, info)
+	return nil
+}
+
+func (g *groups) AddGroup(configs []model.ConfigNodeGroup) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, c := range configs {
+		gid := groupconf.GroupID(c.Name)
+		if _, ok := g.peers[gid.String()]; ok {
+			for _, info := range g.joined {
+				if info.GroupID.Equal(gid) {
+					info.Option = c
+				}
+			}
+			continue
+		}
+		g.joined = append(g.joined, &model.GroupInfo{GroupID: gid, Option: c})
+		g.peers[gid.String()] = &multicast.GroupPeers{}
+	}
+	return nil
+}
+
+func (g *groups) RemoveGroup(gid boson.Address, _ model.GType) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, info := range g.joined {
+		if info.GroupID.Equal(gid) {
+			g.joined = append(g.joined[:i], g.joined[i+1:]...)
+			break
+		}
+	}
+	delete(g.peers, gid.String())
+	return nil
+}
+
+func (g *groups) Snapshot() *model.KadParams {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	params := &model.KadParams{Timestamp: time.Now()}
+	for _, info := range g.joined {
+		c := *info
+		params.Groups = append(params.Groups, &c)
+	}
+	return params
+}
+
+func (g *groups) GetGroupPeers(groupName string) (*multicast.GroupPeers, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	peers, ok := g.peers[groupconf.GroupID(groupName).String()]
+	if !ok {
+		return nil, errors.New("group not found")
+	}
+	return &multicast.GroupPeers{
+		Connected: append([]boson.Address(nil), peers.Connected...),
+		Keep:      append([]boson.Address(nil), peers.Keep...),
+	}, nil
+}
+
+func (g *groups) SendReceive(ctx context.Context, data []byte, gid, dest boson.Address) ([]byte, error) {
+	if g.sendReceive == nil {
+		return nil, errors.New("target not in the group")
+	}
+	return g.sendReceive(ctx, data, gid, dest)
+}
+
+func (g *groups) API() rpc.API {
+	return rpc.API{
+		Namespace: "group",
+		Service:   &groupsAPI{g: g},
+	}
+}
+
+// groupsAPI is the rpc api of groups.
+type groupsAPI struct {
+	g *groups
+}
+
+func (a *groupsAPI) subscribe(ctx context.Context, kind, name string) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	return sub, a.g.subPub.Subscribe(subscribe.NewNotifier(notifier, sub), "group", kind, groupconf.GroupID(name).String())
+}
+
+func (a *groupsAPI) Message(ctx context.Context, name string) (*rpc.Subscription, error) {
+	return a.subscribe(ctx, "groupMessage", name)
+}
+
+func (a *groupsAPI) Multicast(ctx context.Context, name string) (*rpc.Subscription, error) {
+	return a.subscribe(ctx, "multicastMsg", name)
+}
+
+func (a *groupsAPI) Peers(ctx context.Context, name string) (*rpc.Subscription, error) {
+	return a.subscribe(ctx, "groupPeers", name)
+}
+
+// Reply answers a request, the replies to sessions nobody waits for are
+// recorded.
+func (a *groupsAPI) Reply(sessionID string, data []byte) error {
+	a.g.mu.Lock()
+	c, ok := a.g.sessions[sessionID]
+	a.g.mu.Unlock()
+	if ok {
+		select {
+		case c <- data:
+		default:
+		}
+		return nil
+	}
+	select {
+	case a.g.replies <- groupReplyCall{SessionID: sessionID, Data: data}:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/FavorLabs/favorX/pkg/autocash"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+)
+
+const (
+	defaultAutoCashAttemptsLimit = 100
+	maxAutoCashAttemptsLimit     = 1000
+)
+
+// autoCashAvailable responds with not found and returns false if the node
+// cashes no cheques.
+func (s *server) autoCashAvailable(w http.ResponseWriter) bool {
+	if s.AutoCash == nil {
+		jsonhttp.NotFound(w, "automatic cashing not available")
+		return false
+	}
+	return true
+}
+
+func (s *server) autoCashPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.autoCashAvailable(w) {
+		return
+	}
+	jsonhttp.OK(w, s.AutoCash.Policy())
+}
+
+func (s *server) autoCashPolicyUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.autoCashAvailable(w) {
+		return
+	}
+	var p autocash.Policy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		s.logger.Debugf("autocash policy: decode request: %v", err)
+		s.logger.Error("autocash policy: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+	if err := s.AutoCash.SetPolicy(p); err != nil {
+		if errors.Is(err, autocash.ErrInvalidPolicy) {
+			jsonhttp.BadRequest(w, "invalid policy")
+			return
+		}
+		s.logger.Debugf("autocash policy: save: %v", err)
+		s.logger.Error("autocash policy: save")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, p)
+}
+
+func (s *server) autoCashAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.autoCashAvailable(w) {
+		return
+	}
+	limit := defaultAutoCashAttemptsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAutoCashAttemptsLimit {
+			jsonhttp.BadRequest(w, "invalid limit")
+			return
+		}
+	}
+
+	attempts, err := s.AutoCash.Attempts(limit)
+	if err != nil {
+		s.logger.Debugf("autocash attempts: %v", err)
+		s.logger.Error("autocash attempts: list")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, attempts)
+}
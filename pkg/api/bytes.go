@@ -32,7 +32,7 @@ func (s *server) bytesUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if strings.ToLower(r.Header.Get(AuroraPinHeader)) == StringTrue {
-		if err := s.pinning.CreatePin(ctx, address, false); err != nil {
+		if err := s.createPin(ctx, address, false, ""); err != nil {
 			logger.Debugf("bytes upload: creation of pin for %q failed: %v", address, err)
 			logger.Error("bytes upload: creation of pin failed")
 			jsonhttp.InternalServerError(w, nil)
@@ -1,14 +1,26 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 
+	"github.com/FavorLabs/favorX/pkg/chainoracle"
+	"github.com/FavorLabs/favorX/pkg/chaintraffic"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
 	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
 )
 
+// purposeChainAPI is the purpose transactions sent through the chain api
+// are recorded with by the transaction manager.
+const purposeChainAPI = "chain-api"
+
 type AllRequest struct {
 	Id      int           `json:"id"`
 	JsonRpc string        `json:"jsonrpc"`
@@ -41,11 +53,19 @@ func (s *server) chainHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := &chain.AllRequest{
-		Method: allRequest.Method,
-		Params: allRequest.Params,
+	var resp *chain.AllResponse
+	if allRequest.Method == "eth_sendTransaction" {
+		// sent by the transaction manager, so the nonce does not collide
+		// with the transactions of the node
+		var hash common.Hash
+		hash, err = s.sendTransaction(r.Context(), allRequest.Params)
+		resp = &chain.AllResponse{Result: hash}
+	} else {
+		resp, err = s.commonChain.All(r.Context(), &chain.AllRequest{
+			Method: allRequest.Method,
+			Params: allRequest.Params,
+		})
 	}
-	resp, err := s.commonChain.All(r.Context(), req)
 	if err != nil {
 		s.logger.Debugf("api: all chain handler: : %v", err)
 		s.logger.Errorf("api: all chain handler: ")
@@ -60,7 +80,79 @@ func (s *server) chainHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// sendTransactionRequest is the transaction of eth_sendTransaction. The
+// nonce, if given, is ignored, the transaction manager assigns it.
+type sendTransactionRequest struct {
+	From     *common.Address `json:"from"`
+	To       *common.Address `json:"to"`
+	Data     hexutil.Bytes   `json:"data"`
+	Gas      *hexutil.Uint64 `json:"gas"`
+	GasLimit *hexutil.Uint64 `json:"gasLimit"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+}
+
+// sendTransaction sends the transaction of the eth_sendTransaction params
+// through the transaction manager.
+func (s *server) sendTransaction(ctx context.Context, params []interface{}) (common.Hash, error) {
+	if len(params) != 1 {
+		return common.Hash{}, errors.New("eth_sendTransaction takes one transaction")
+	}
+	b, err := json.Marshal(params[0])
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var tx sendTransactionRequest
+	if err := json.Unmarshal(b, &tx); err != nil {
+		return common.Hash{}, fmt.Errorf("invalid transaction: %w", err)
+	}
+	if tx.From != nil {
+		address, err := s.signer.EthereumAddress()
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if *tx.From != address {
+			return common.Hash{}, errors.New("incorrect source address for sending transactions")
+		}
+	}
+	req := &chain.TxRequest{
+		To:   tx.To,
+		Data: tx.Data,
+	}
+	switch {
+	case tx.Gas != nil:
+		req.GasLimit = uint64(*tx.Gas)
+	case tx.GasLimit != nil:
+		req.GasLimit = uint64(*tx.GasLimit)
+	}
+	if tx.GasPrice != nil {
+		req.GasPrice = tx.GasPrice.ToInt()
+	}
+	if tx.Value != nil {
+		req.Value = tx.Value.ToInt()
+	}
+	return s.Transactions.Send(txmgr.WithPurpose(ctx, purposeChainAPI, ""), req)
+}
+
+// chainTransactionHandler returns the newest pending transaction of the
+// node, empty if none is pending.
 func (s *server) chainTransactionHandler(w http.ResponseWriter, r *http.Request) {
-	tx := s.commonChain.GetTransaction()
+	pending, err := s.Transactions.Records(txmgr.StatusPending)
+	if err != nil {
+		s.logger.Debugf("api: chain transaction: %v", err)
+		s.logger.Error("api: chain transaction")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	tx := &chain.TxInfo{}
+	if len(pending) > 0 {
+		tx.Value, tx.TxHash = pending[0].Subject, pending[0].Hash.String()
+		switch pending[0].Purpose {
+		case chainoracle.PurposeRegister, chainoracle.PurposeRemove:
+			tx.Type = chain.ORACLE
+		case chaintraffic.PurposeCashCheque:
+			tx.Type = chain.TRAFFIC
+		}
+	}
 	jsonhttp.OK(w, tx)
 }
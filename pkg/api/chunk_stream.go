@@ -0,0 +1,411 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/cac"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/netstore"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxChunkBatch is the maximum number of chunks accepted or returned by
+	// a single batch request or websocket message.
+	maxChunkBatch = 1024
+
+	// chunkStreamWriteDeadline bounds every write on the upload websocket.
+	chunkStreamWriteDeadline = 10 * time.Second
+
+	// chunkStreamReadLimit is the largest message accepted on the upload
+	// websocket: a full batch of chunks with their length prefixes.
+	chunkStreamReadLimit = maxChunkBatch * (boson.ChunkWithSpanSize + 2)
+
+	// AuroraChunkAddressHeader carries the address of a chunk in a batch download part.
+	AuroraChunkAddressHeader = "Aurora-Chunk-Address"
+	// AuroraChunkErrorHeader carries the reason a chunk of a batch download is missing.
+	AuroraChunkErrorHeader = "Aurora-Chunk-Error"
+)
+
+var errChunkBatchTooLarge = fmt.Errorf("batch exceeds %d chunks", maxChunkBatch)
+
+type chunkBatchResult struct {
+	Reference boson.Address `json:"reference"`
+	Exists    bool          `json:"exists"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type chunkBatchResponse struct {
+	Chunks []chunkBatchResult `json:"chunks"`
+}
+
+// putChunkBatch stores all valid span+data chunks with a single storer.Put
+// call. Invalid chunks are reported in their result entry and skipped.
+func (s *server) putChunkBatch(ctx context.Context, mode storage.ModePut, pin bool, data [][]byte) ([]chunkBatchResult, error) {
+	results := make([]chunkBatchResult, len(data))
+	chunks := make([]boson.Chunk, 0, len(data))
+	index := make([]int, 0, len(data))
+
+	for i, d := range data {
+		if len(d) < boson.SpanSize {
+			results[i].Error = "data length"
+			continue
+		}
+		chunk, err := cac.NewWithDataSpan(d)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Reference = chunk.Address()
+		chunks = append(chunks, chunk)
+		index = append(index, i)
+	}
+
+	if len(chunks) == 0 {
+		return results, nil
+	}
+
+	exist, err := s.storer.Put(ctx, mode, chunks...)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range index {
+		if j < len(exist) {
+			results[i].Exists = exist[j]
+		}
+		if pin {
+			if err := s.createPin(ctx, chunks[j].Address(), false, ""); err != nil {
+				s.logger.Debugf("chunk batch: creation of pin for %q failed: %v", chunks[j].Address(), err)
+				results[i].Error = "pin chunk"
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// chunkBatchUploadHandler stores the chunks sent as the parts of a
+// multipart request, each part holding the span and data of one chunk.
+func (s *server) chunkBatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get(contentTypeHeader)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		s.logger.Debugf("chunk batch upload: parse content type header %q: %v", contentType, err)
+		s.logger.Error("chunk batch upload: invalid content type")
+		jsonhttp.BadRequest(w, invalidContentType)
+		return
+	}
+
+	var data [][]byte
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if jsonhttp.HandleBodyReadError(err, w) {
+				return
+			}
+			s.logger.Debugf("chunk batch upload: read part: %v", err)
+			s.logger.Error("chunk batch upload: read part")
+			jsonhttp.BadRequest(w, "invalid multipart body")
+			return
+		}
+		if len(data) == maxChunkBatch {
+			jsonhttp.BadRequest(w, errChunkBatchTooLarge.Error())
+			return
+		}
+		d, err := io.ReadAll(io.LimitReader(part, boson.ChunkWithSpanSize+1))
+		if err != nil {
+			if jsonhttp.HandleBodyReadError(err, w) {
+				return
+			}
+			s.logger.Debugf("chunk batch upload: read chunk data: %v", err)
+			s.logger.Error("chunk batch upload: read chunk data")
+			jsonhttp.InternalServerError(w, "cannot read chunk data")
+			return
+		}
+		data = append(data, d)
+	}
+
+	if len(data) == 0 {
+		jsonhttp.BadRequest(w, "no chunks")
+		return
+	}
+
+	pin := strings.ToLower(r.Header.Get(AuroraPinHeader)) == StringTrue
+	results, err := s.putChunkBatch(r.Context(), requestModePut(r), pin, data)
+	if err != nil {
+		s.logger.Debugf("chunk batch upload: chunk write error: %v", err)
+		s.logger.Error("chunk batch upload: chunk write error")
+		jsonhttp.InternalServerError(w, "chunk write error")
+		return
+	}
+
+	jsonhttp.Created(w, chunkBatchResponse{Chunks: results})
+}
+
+// chunkBatchGetHandler sends the requested chunks back as a
+// multipart/mixed response, in the order of the address query parameters.
+// Chunks that can not be retrieved are sent as empty parts carrying the
+// error header, including the chunks whose recovery was initiated.
+func (s *server) chunkBatchGetHandler(w http.ResponseWriter, r *http.Request) {
+	var addrs []boson.Address
+	for _, v := range r.URL.Query()["address"] {
+		for _, a := range strings.Split(v, ",") {
+			if a == "" {
+				continue
+			}
+			if len(addrs) == maxChunkBatch {
+				jsonhttp.BadRequest(w, errChunkBatchTooLarge.Error())
+				return
+			}
+			addr, err := s.resolveNameOrAddress(a)
+			if err != nil {
+				s.logger.Debugf("chunk batch: parse chunk address %s: %v", a, err)
+				s.logger.Error("chunk batch: parse chunk address error")
+				jsonhttp.BadRequest(w, "invalid address")
+				return
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		jsonhttp.BadRequest(w, "no addresses")
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set(contentTypeHeader, "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	// every chunk is written as soon as it is fetched, so no more than one
+	// chunk of the batch is held in memory
+	ctx := r.Context()
+	for _, addr := range addrs {
+		header := textproto.MIMEHeader{}
+		header.Set(AuroraChunkAddressHeader, addr.String())
+
+		var data []byte
+		chunk, err := s.storer.Get(ctx, storage.ModeGetRequest, addr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Tracef("chunk batch: get chunk %s: %v", addr, err)
+		}
+		switch {
+		case errors.Is(err, netstore.ErrRecoveryAttempt):
+			header.Set(AuroraChunkErrorHeader, "chunk recovery initiated")
+		case errors.Is(err, storage.ErrNotFound):
+			header.Set(AuroraChunkErrorHeader, "chunk not found")
+		case err != nil:
+			header.Set(AuroraChunkErrorHeader, "chunk read error")
+		default:
+			header.Set(contentTypeHeader, "binary/octet-stream")
+			data = chunk.Data()
+		}
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			s.logger.Debugf("chunk batch: write part: %v", err)
+			return
+		}
+		if _, err := part.Write(data); err != nil {
+			s.logger.Debugf("chunk batch: write part: %v", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		s.logger.Debugf("chunk batch: close multipart writer: %v", err)
+	}
+}
+
+// chunkUploadStreamHandler upgrades the connection to a websocket on which
+// every binary message carries up to maxChunkBatch chunks, each prefixed by
+// its length as a big endian uint16. The chunks of a message are stored
+// with a single Put and the message is answered with a chunkBatchResponse.
+func (s *server) chunkUploadStreamHandler(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  boson.ChunkWithSpanSize,
+		WriteBufferSize: boson.ChunkWithSpanSize,
+		CheckOrigin:     s.checkOrigin,
+	}
+
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Debugf("chunk upload stream: upgrade: %v", err)
+		s.logger.Error("chunk upload stream: upgrade")
+		jsonhttp.BadRequest(w, nil)
+		return
+	}
+
+	pin := strings.ToLower(r.Header.Get(AuroraPinHeader)) == StringTrue
+	mode := requestModePut(r)
+
+	s.wsWg.Add(1)
+	go s.handleUploadStream(c, mode, pin)
+}
+
+func (s *server) handleUploadStream(conn *websocket.Conn, mode storage.ModePut, pin bool) {
+	defer s.wsWg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		gone = make(chan struct{})
+		err  error
+	)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	conn.SetReadLimit(chunkStreamReadLimit)
+	conn.SetCloseHandler(func(code int, text string) error {
+		s.logger.Debugf("chunk upload stream: client gone. code %d message %s", code, text)
+		close(gone)
+		return nil
+	})
+
+	sendErrorClose := func(code int, errmsg string) {
+		err := conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, errmsg),
+			time.Now().Add(chunkStreamWriteDeadline),
+		)
+		if err != nil {
+			s.logger.Errorf("chunk upload stream: failed sending close msg: %v", err)
+		}
+	}
+
+	// the client is expected to answer pings, reads time out after two
+	// missed pongs
+	readTimeout := 2 * s.WsPingPeriod
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	go func() {
+		ticker := time.NewTicker(s.WsPingPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.quit:
+				sendErrorClose(websocket.CloseGoingAway, "node shutting down")
+				cancel()
+				return
+			case <-gone:
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(chunkStreamWriteDeadline))
+				if err != nil {
+					s.logger.Debugf("chunk upload stream: ping: %v", err)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-gone:
+			return
+		default:
+		}
+
+		err = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if err != nil {
+			s.logger.Debugf("chunk upload stream: set read deadline: %v", err)
+			return
+		}
+
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				s.logger.Debugf("chunk upload stream: read message: %v", err)
+			}
+			return
+		}
+
+		if mt != websocket.BinaryMessage {
+			s.logger.Debug("chunk upload stream: unexpected message received from client", mt)
+			sendErrorClose(websocket.CloseUnsupportedData, "invalid message")
+			return
+		}
+
+		data, err := splitChunkFrames(msg)
+		if err != nil {
+			s.logger.Debugf("chunk upload stream: %v", err)
+			sendErrorClose(websocket.CloseInvalidFramePayloadData, err.Error())
+			return
+		}
+
+		results, err := s.putChunkBatch(ctx, mode, pin, data)
+		if err != nil {
+			s.logger.Debugf("chunk upload stream: chunk write error: %v", err)
+			s.logger.Error("chunk upload stream: chunk write error")
+			sendErrorClose(websocket.CloseInternalServerErr, "chunk write error")
+			return
+		}
+
+		if err := conn.SetWriteDeadline(time.Now().Add(chunkStreamWriteDeadline)); err != nil {
+			s.logger.Debugf("chunk upload stream: set write deadline: %v", err)
+			return
+		}
+		if err := conn.WriteJSON(chunkBatchResponse{Chunks: results}); err != nil {
+			s.logger.Debugf("chunk upload stream: write response: %v", err)
+			return
+		}
+	}
+}
+
+// splitChunkFrames splits a websocket message into the length prefixed
+// chunks it carries.
+func splitChunkFrames(msg []byte) ([][]byte, error) {
+	var data [][]byte
+	for len(msg) > 0 {
+		if len(data) == maxChunkBatch {
+			return nil, errChunkBatchTooLarge
+		}
+		if len(msg) < 2 {
+			return nil, errors.New("truncated chunk length")
+		}
+		n := int(binary.BigEndian.Uint16(msg))
+		msg = msg[2:]
+		if n > len(msg) || n > boson.ChunkWithSpanSize {
+			return nil, errors.New("invalid chunk length")
+		}
+		data = append(data, msg[:n])
+		msg = msg[n:]
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no chunks")
+	}
+	return data, nil
+}
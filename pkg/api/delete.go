@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/chunkinfo"
+	"github.com/gauss-project/aurorafs/pkg/sctx"
+	"github.com/gauss-project/aurorafs/pkg/shed/driver"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+var (
+	errFilePinned = errors.New("file is pinned")
+	// errUploadDeleted is returned when a deletion during an upload removed
+	// chunks the upload deduplicated against.
+	errUploadDeleted = errors.New("chunks of the upload were deleted meanwhile")
+)
+
+// deletePreview describes what removing a root from the localstore does.
+type deletePreview struct {
+	Reference boson.Address `json:"reference"`
+	Pinned    bool          `json:"pinned"`
+	Chunks    int           `json:"chunks"`
+	Shared    int           `json:"shared"`
+	Missing   int           `json:"missing"`
+	FreedSize uint64        `json:"freedSize"`
+}
+
+type deletePlan struct {
+	deletePreview
+	chunks []chunkinfo.PyramidCidNum
+}
+
+// chunkRefs counts the local roots whose chunkinfo pyramid lists every
+// chunk, so removing several roots reads every pyramid only once.
+// chunkinfo leaves the chunks it knows to be shared out of a pyramid; the
+// counts catch the sharing with roots it has not loaded yet.
+type chunkRefs struct {
+	roots   map[string]map[string]struct{}
+	count   map[string]int
+	unknown map[string]struct{}
+}
+
+func newChunkRefs() *chunkRefs {
+	return &chunkRefs{
+		roots:   make(map[string]map[string]struct{}),
+		count:   make(map[string]int),
+		unknown: make(map[string]struct{}),
+	}
+}
+
+// syncChunkRefs adds the local roots not counted yet to refs. Roots whose
+// pyramid chunkinfo can not tell are kept as unknown.
+func (s *server) syncChunkRefs(refs *chunkRefs) {
+	_, roots := s.chunkInfo.GetFileList(s.overlay)
+	for _, root := range roots {
+		if _, ok := refs.roots[root.String()]; ok {
+			continue
+		}
+		pyramid := s.chunkInfo.GetChunkPyramid(root)
+		if pyramid == nil {
+			refs.unknown[root.String()] = struct{}{}
+			continue
+		}
+		delete(refs.unknown, root.String())
+		chunks := make(map[string]struct{}, len(pyramid))
+		for _, chunk := range pyramid {
+			chunks[chunk.Cid.String()] = struct{}{}
+			refs.count[chunk.Cid.String()]++
+		}
+		refs.roots[root.String()] = chunks
+	}
+}
+
+// others returns how many roots other than root reference the chunk.
+func (refs *chunkRefs) others(root boson.Address, chunk string) int {
+	n := refs.count[chunk]
+	if _, ok := refs.roots[root.String()][chunk]; ok {
+		n--
+	}
+	return n
+}
+
+// remove drops root with its chunks from refs.
+func (refs *chunkRefs) remove(root boson.Address) {
+	for c := range refs.roots[root.String()] {
+		if refs.count[c]--; refs.count[c] <= 0 {
+			delete(refs.count, c)
+		}
+	}
+	delete(refs.roots, root.String())
+	delete(refs.unknown, root.String())
+}
+
+// check fails if the chunks of a root other than except are unknown, as
+// they might be among the chunks to remove.
+func (refs *chunkRefs) check(except boson.Address) error {
+	for root := range refs.unknown {
+		if root != except.String() {
+			return fmt.Errorf("chunks of %s are unknown", root)
+		}
+	}
+	return nil
+}
+
+// planDelete works out which chunks of the root can be removed without
+// breaking any other root held by the node. It fails only if chunks are
+// left to remove and another root might reference them.
+func (s *server) planDelete(ctx context.Context, refs *chunkRefs, hash boson.Address) (*deletePlan, error) {
+	pinned, err := s.pinning.HasPin(hash)
+	if err != nil {
+		return nil, fmt.Errorf("check pin: %w", err)
+	}
+
+	s.syncChunkRefs(refs)
+	pyramid := s.chunkInfo.GetChunkPyramid(hash)
+
+	plan := &deletePlan{
+		deletePreview: deletePreview{
+			Reference: hash,
+			Pinned:    pinned,
+			Chunks:    len(pyramid),
+		},
+		chunks: make([]chunkinfo.PyramidCidNum, 0, len(pyramid)),
+	}
+
+	addrs := make([]boson.Address, 0, len(pyramid)+1)
+	for _, chunk := range pyramid {
+		if chunk.Cid.Equal(hash) {
+			continue
+		}
+		if refs.others(hash, chunk.Cid.String()) > 0 {
+			plan.Shared++
+			continue
+		}
+		plan.chunks = append(plan.chunks, *chunk)
+		addrs = append(addrs, chunk.Cid)
+	}
+	if len(plan.chunks) > 0 {
+		if err := refs.check(hash); err != nil {
+			return nil, err
+		}
+	}
+	addrs = append(addrs, hash)
+
+	// the pyramid of chunkinfo already leaves the chunks other roots
+	// reference out, the full pyramid of the root tells how many are kept
+	if full, err := s.fullPyramid(ctx, hash); err != nil {
+		s.logger.Debugf("delete: full pyramid of %s: %v", hash, err)
+	} else {
+		removed := make(map[string]struct{}, len(addrs))
+		for _, addr := range addrs {
+			removed[addr.String()] = struct{}{}
+		}
+		plan.Chunks, plan.Shared = len(full), 0
+		for chunk := range full {
+			if _, ok := removed[chunk]; !ok {
+				plan.Shared++
+			}
+		}
+	}
+
+	has, err := s.storer.HasMulti(ctx, storage.ModeHasChunk, addrs...)
+	if err != nil {
+		return nil, fmt.Errorf("check chunks: %w", err)
+	}
+	for _, yes := range has {
+		if yes {
+			plan.FreedSize += boson.ChunkWithSpanSize
+		} else {
+			plan.Missing++
+		}
+	}
+
+	return plan, nil
+}
+
+// fullPyramid returns every chunk of the root held in the localstore, the
+// intermediate and manifest chunks as well as the data chunks.
+func (s *server) fullPyramid(ctx context.Context, hash boson.Address) (map[string]struct{}, error) {
+	pyramid, err := s.localTraversal.GetPyramid(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	hashes, _, err := s.localTraversal.GetChunkHashes(ctx, hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[string]struct{}, len(pyramid))
+	for chunk := range pyramid {
+		chunks[chunk] = struct{}{}
+	}
+	for _, file := range hashes {
+		for _, chunk := range file {
+			chunks[boson.NewAddress(chunk).String()] = struct{}{}
+		}
+	}
+	return chunks, nil
+}
+
+// uploadStarted returns the number of roots deleted so far, which an
+// upload about to store chunks passes to commitUpload.
+func (s *server) uploadStarted() uint64 {
+	s.rootsMu.RLock()
+	defer s.rootsMu.RUnlock()
+	return s.rootsDeleted
+}
+
+// commitUpload registers ref, the root of an upload started when started
+// roots were deleted, and records it for the retention. Uploads only hold
+// rootsMu here, so a deletion while the upload stored its chunks may have
+// removed the ones it deduplicated against; the root is then registered
+// only if the localstore still holds all its chunks.
+func (s *server) commitUpload(ctx context.Context, ref boson.Address, started uint64) error {
+	s.rootsMu.RLock()
+	defer s.rootsMu.RUnlock()
+	if s.rootsDeleted != started {
+		chunks, err := s.fullPyramid(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUploadDeleted, err)
+		}
+		addrs := make([]boson.Address, 0, len(chunks))
+		for chunk := range chunks {
+			addrs = append(addrs, boson.MustParseHexAddress(chunk))
+		}
+		has, err := s.storer.HasMulti(ctx, storage.ModeHasChunk, addrs...)
+		if err != nil {
+			return fmt.Errorf("check chunks: %w", err)
+		}
+		for _, yes := range has {
+			if !yes {
+				return errUploadDeleted
+			}
+		}
+	}
+	if err := s.registerRoot(ctx, ref); err != nil {
+		return err
+	}
+	if err := s.retention.Record(ref, time.Now()); err != nil {
+		s.logger.Debugf("upload: record retention of %s: %v", ref, err)
+	}
+	return nil
+}
+
+// previewDelete returns what removing the root would do.
+func (s *server) previewDelete(ctx context.Context, hash boson.Address) (*deletePreview, error) {
+	s.rootsMu.Lock()
+	defer s.rootsMu.Unlock()
+	plan, err := s.planDelete(ctx, newChunkRefs(), hash)
+	if err != nil {
+		return nil, err
+	}
+	return &plan.deletePreview, nil
+}
+
+// deleteFile removes the chunks of the given root that no other local root
+// references and drops the root from chunkinfo. Pinned roots are refused
+// with errFilePinned unless force is set, in which case their chunks are
+// unpinned first and pinned again if the removal fails. The caller holds
+// rootsMu, so no upload registers a root sharing chunks meanwhile. refs may
+// be shared by calls removing several roots.
+func (s *server) deleteFile(ctx context.Context, refs *chunkRefs, hash boson.Address, force bool) (*deletePreview, error) {
+	plan, err := s.planDelete(ctx, refs, hash)
+	if err != nil {
+		return nil, err
+	}
+	pinned := plan.Pinned
+	if pinned {
+		if !force {
+			return nil, errFilePinned
+		}
+		// pinned chunks can not be removed, the pin metadata is only
+		// dropped once the file is gone
+		if err := s.pinning.DeletePin(ctx, hash); err != nil {
+			return nil, fmt.Errorf("unpin: %w", err)
+		}
+	}
+
+	ctx = sctx.SetRootHash(ctx, hash)
+
+	del := func() error {
+		for _, chunk := range plan.chunks {
+			for i := 0; i < chunk.Number; i++ {
+				err = s.storer.Set(ctx, storage.ModeSetRemove, chunk.Cid)
+				if err != nil {
+					if errors.Is(err, driver.ErrNotFound) {
+						continue
+					}
+
+					return err
+				}
+			}
+		}
+
+		err = s.storer.Set(ctx, storage.ModeSetRemove, hash)
+		if err != nil {
+			if !errors.Is(err, driver.ErrNotFound) {
+				return err
+			}
+		}
+
+		return nil
+	}
+	err = s.chunkInfo.DelFile(hash, del)
+	if err != nil {
+		if pinned {
+			if err := s.pinning.CreatePin(ctx, hash, true); err != nil {
+				s.logger.Errorf("delete: restore pin of %s: %v", hash, err)
+			}
+		}
+		return nil, err
+	}
+	refs.remove(hash)
+	s.rootsDeleted++
+	if pinned {
+		s.forgetPin(hash)
+	}
+
+	if err := s.retention.Forget(hash); err != nil {
+		s.logger.Debugf("delete: forget retention record of %s: %v", hash, err)
+	}
+
+	return &plan.deletePreview, nil
+}
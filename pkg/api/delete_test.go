@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	testingc "github.com/gauss-project/aurorafs/pkg/storage/testing"
+)
+
+type deletePreview struct {
+	Reference boson.Address `json:"reference"`
+	Pinned    bool          `json:"pinned"`
+	Chunks    int           `json:"chunks"`
+	Shared    int           `json:"shared"`
+	Missing   int           `json:"missing"`
+	FreedSize uint64        `json:"freedSize"`
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	chunks := testingc.GenerateTestRandomChunks(4)
+	root, own, shared, other := chunks[0], chunks[1], chunks[2], chunks[3]
+	store := newStorer()
+	if _, err := store.Put(ctx, storage.ModePutUpload, chunks...); err != nil {
+		t.Fatal(err)
+	}
+	ci := newChunkInfo()
+	ci.putRoot(root.Address(), own.Address(), shared.Address())
+	ci.putRoot(other.Address(), shared.Address())
+	pins := newPinning()
+	if err := pins.CreatePin(ctx, root.Address(), true); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestServer(t, testServerOptions{
+		Storer:     store,
+		LocalStore: newStorer(),
+		ChunkInfo:  ci,
+		Pinning:    pins,
+	})
+	resource := "/v1/file/" + root.Address().String()
+
+	// the chunk the other root references is kept
+	want := deletePreview{
+		Reference: root.Address(),
+		Pinned:    true,
+		Chunks:    3,
+		Shared:    1,
+		FreedSize: 2 * boson.ChunkWithSpanSize,
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		jsonhttptest.Request(t, client, http.MethodDelete, resource+"?dryRun=true", http.StatusOK,
+			jsonhttptest.WithExpectedJSONResponse(want),
+		)
+		if has, _ := store.Has(ctx, storage.ModeHasChunk, own.Address()); !has {
+			t.Fatal("dry run removed a chunk")
+		}
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		jsonhttptest.Request(t, client, http.MethodDelete, resource, http.StatusConflict,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Message: "file is pinned, unpin it or delete with force",
+				Code:    http.StatusConflict,
+			}),
+		)
+		if has, _ := store.Has(ctx, storage.ModeHasChunk, own.Address()); !has {
+			t.Fatal("refused deletion removed a chunk")
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		jsonhttptest.Request(t, client, http.MethodDelete, resource+"?force=true", http.StatusOK,
+			jsonhttptest.WithExpectedJSONResponse(want),
+		)
+		for _, c := range []struct {
+			chunk boson.Chunk
+			want  bool
+		}{
+			{root, false},
+			{own, false},
+			{shared, true},
+			{other, true},
+		} {
+			if has, _ := store.Has(ctx, storage.ModeHasChunk, c.chunk.Address()); has != c.want {
+				t.Fatalf("chunk %s: got stored %t, want %t", c.chunk.Address(), has, c.want)
+			}
+		}
+		if pinned, _ := pins.HasPin(root.Address()); pinned {
+			t.Fatal("deleted root is still pinned")
+		}
+	})
+}
+
+func TestDeleteRestricted(t *testing.T) {
+	ctx := context.Background()
+	root := testingc.GenerateTestRandomChunk()
+	store := newStorer()
+	if _, err := store.Put(ctx, storage.ModePutUpload, root); err != nil {
+		t.Fatal(err)
+	}
+	ci := newChunkInfo()
+	ci.putRoot(root.Address())
+	client := newTestServer(t, testServerOptions{
+		Storer:     store,
+		LocalStore: newStorer(),
+		ChunkInfo:  ci,
+		Restricted: true,
+	})
+	resource := "/v1/file/" + root.Address().String()
+
+	jsonhttptest.Request(t, client, http.MethodDelete, resource, http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodDelete, resource, http.StatusForbidden,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+	jsonhttptest.Request(t, client, http.MethodDelete, resource, http.StatusOK,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "creator")),
+	)
+	if has, _ := store.Has(ctx, storage.ModeHasChunk, root.Address()); has {
+		t.Fatal("root not removed")
+	}
+}
@@ -15,15 +15,11 @@ import (
 	"strings"
 
 	"github.com/gauss-project/aurorafs/pkg/boson"
-	"github.com/gauss-project/aurorafs/pkg/chunkinfo"
 	"github.com/gauss-project/aurorafs/pkg/file"
 	"github.com/gauss-project/aurorafs/pkg/file/loadsave"
 	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
 	"github.com/gauss-project/aurorafs/pkg/logging"
 	"github.com/gauss-project/aurorafs/pkg/manifest"
-	"github.com/gauss-project/aurorafs/pkg/sctx"
-	"github.com/gauss-project/aurorafs/pkg/shed/driver"
-	"github.com/gauss-project/aurorafs/pkg/storage"
 	"github.com/gauss-project/aurorafs/pkg/tracing"
 	"github.com/gorilla/mux"
 )
@@ -60,6 +56,7 @@ func (s *server) dirUploadHandler(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
+	started := s.uploadStarted()
 	p := requestPipelineFn(s.storer, r)
 	factory := requestPipelineFactory(ctx, s.storer, r)
 	reference, err := storeDir(
@@ -80,28 +77,15 @@ func (s *server) dirUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dataChunks, _, err := s.traversal.GetChunkHashes(ctx, reference, nil)
-	if err != nil {
-		logger.Debugf("dir upload dir: get chunk hashes err: %v", err)
-		logger.Errorf("dir upload dir: get chunk hashes err")
-		jsonhttp.InternalServerError(w, "could not get chunk hashes")
+	if err := s.commitUpload(ctx, reference, started); err != nil {
+		logger.Debugf("dir upload dir: register dir: %v", err)
+		logger.Errorf("dir upload dir: register dir")
+		jsonhttp.InternalServerError(w, "chunk transfer data error")
 		return
 	}
 
-	for _, li := range dataChunks {
-		for _, b := range li {
-			err := s.chunkInfo.OnChunkRetrieved(boson.NewAddress(b), reference, s.overlay)
-			if err != nil {
-				logger.Errorf("dir upload dir: chunk transfer data err: %v", err)
-				logger.Errorf("dir upload dir: chunk transfer data err")
-				jsonhttp.InternalServerError(w, "chunk transfer data error")
-				return
-			}
-		}
-	}
-
 	if strings.ToLower(r.Header.Get(AuroraPinHeader)) == StringTrue {
-		if err := s.pinning.CreatePin(r.Context(), reference, false); err != nil {
+		if err := s.createPin(r.Context(), reference, false, r.Header.Get(AuroraCollectionNameHeader)); err != nil {
 			logger.Debugf("dir upload dir: creation of pin for %q failed: %v", reference, err)
 			logger.Error("dir upload dir: creation of pin failed")
 			jsonhttp.InternalServerError(w, nil)
@@ -109,6 +93,21 @@ func (s *server) dirUploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if requestAct(r) {
+		keys, err := parseGrantees(requestActGrantees(r))
+		var actRef boson.Address
+		if err == nil {
+			actRef, err = s.grantAccess(ctx, reference, keys)
+		}
+		if err != nil {
+			logger.Debugf("dir upload dir: grant access to %q: %v", reference, err)
+			logger.Error("dir upload dir: grant access failed")
+			grantErrorResponse(w, err)
+			return
+		}
+		reference = actRef
+	}
+
 	jsonhttp.Created(w, auroraUploadResponse{
 		Reference: reference,
 	})
@@ -339,50 +338,30 @@ func (s *server) auroraDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r = r.WithContext(sctx.SetRootHash(r.Context(), hash))
-
-	del := func() error {
-		pyramid := s.chunkInfo.GetChunkPyramid(hash)
-		chunkHashes := make([]chunkinfo.PyramidCidNum, len(pyramid))
-
-		for i, chunk := range pyramid {
-			chunkHashes[i] = *chunk
-		}
-
-		var err error
-
-		for _, chunk := range chunkHashes {
-			if chunk.Cid.Equal(hash) {
-				continue
-			}
-
-			for i := 0; i < chunk.Number; i++ {
-				err = s.storer.Set(r.Context(), storage.ModeSetRemove, chunk.Cid)
-				if err != nil {
-					if errors.Is(err, driver.ErrNotFound) {
-						continue
-					}
-
-					return err
-				}
-			}
-		}
-
-		err = s.storer.Set(r.Context(), storage.ModeSetRemove, hash)
+	if strings.ToLower(r.URL.Query().Get("dryRun")) == StringTrue {
+		preview, err := s.previewDelete(r.Context(), hash)
 		if err != nil {
-			if !errors.Is(err, driver.ErrNotFound) {
-				return err
-			}
+			s.logger.Errorf("dir delete: plan: %v", err)
+			jsonhttp.InternalServerError(w, "dir deleting occur error")
+			return
 		}
+		jsonhttp.OK(w, preview)
+		return
+	}
 
-		return nil
+	force := strings.ToLower(r.URL.Query().Get("force")) == StringTrue
+	s.rootsMu.Lock()
+	preview, err := s.deleteFile(r.Context(), newChunkRefs(), hash, force)
+	s.rootsMu.Unlock()
+	if errors.Is(err, errFilePinned) {
+		jsonhttp.Conflict(w, "file is pinned, unpin it or delete with force")
+		return
 	}
-	err = s.chunkInfo.DelFile(hash, del)
 	if err != nil {
-		s.logger.Errorf("dir delete: remove file: %w", err)
+		s.logger.Errorf("dir delete: remove file: %v", err)
 		jsonhttp.InternalServerError(w, "dir deleting occur error")
 		return
 	}
 
-	jsonhttp.OK(w, nil)
+	jsonhttp.OK(w, preview)
 }
@@ -18,6 +18,7 @@ import (
 
 	"github.com/gauss-project/aurorafs/pkg/chunkinfo"
 
+	"github.com/FavorLabs/favorX/pkg/act"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethersphere/langos"
 	"github.com/gauss-project/aurorafs/pkg/aurora"
@@ -48,6 +49,10 @@ func (s *server) auroraUploadHandler(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.BadRequest(w, invalidContentType)
 		return
 	}
+	if requestAct(r) {
+		// access control works on the keys embedded in encrypted references
+		r.Header.Set(AuroraEncryptHeader, StringTrue)
+	}
 	isDir := r.Header.Get(AuroraCollectionHeader)
 	if strings.ToLower(isDir) == StringTrue || mediaType == multiPartFormData {
 		s.dirUploadHandler(w, r)
@@ -83,6 +88,7 @@ func (s *server) fileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	dirName = r.Header.Get(AuroraCollectionNameHeader)
 	reader = r.Body
 
+	started := s.uploadStarted()
 	p := requestPipelineFn(s.storer, r)
 
 	// first store the file and get its reference
@@ -169,27 +175,14 @@ func (s *server) fileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Debugf("Manifest Reference: %s", manifestReference.String())
 
-	dataChunks, _, err := s.traversal.GetChunkHashes(ctx, manifestReference, nil)
-	if err != nil {
-		logger.Debugf("upload file: get chunk hashes of file %q: %v", fileName, err)
-		logger.Errorf("upload file: get chunk hashes of file %q", fileName)
-		jsonhttp.InternalServerError(w, "could not get chunk hashes")
+	if err := s.commitUpload(ctx, manifestReference, started); err != nil {
+		logger.Debugf("upload file: register file %q: %v", fileName, err)
+		logger.Errorf("upload file: register file %q", fileName)
+		jsonhttp.InternalServerError(w, "chunk transfer data error")
 		return
 	}
-
-	for _, li := range dataChunks {
-		for _, b := range li {
-			err := s.chunkInfo.OnChunkRetrieved(boson.NewAddress(b), manifestReference, s.overlay)
-			if err != nil {
-				logger.Debugf("upload file: chunk transfer data err: %v", err)
-				logger.Errorf("upload file: chunk transfer data err")
-				jsonhttp.InternalServerError(w, "chunk transfer data error")
-				return
-			}
-		}
-	}
 	if strings.ToLower(r.Header.Get(AuroraPinHeader)) == StringTrue {
-		if err := s.pinning.CreatePin(ctx, manifestReference, false); err != nil {
+		if err := s.createPin(ctx, manifestReference, false, realIndexFilename); err != nil {
 			logger.Debugf("upload file: creation of pin for %q failed: %v", manifestReference, err)
 			logger.Error("upload file: creation of pin failed")
 			jsonhttp.InternalServerError(w, nil)
@@ -197,6 +190,21 @@ func (s *server) fileUploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if requestAct(r) {
+		keys, err := parseGrantees(requestActGrantees(r))
+		var actRef boson.Address
+		if err == nil {
+			actRef, err = s.grantAccess(ctx, manifestReference, keys)
+		}
+		if err != nil {
+			logger.Debugf("upload file: grant access to %q: %v", manifestReference, err)
+			logger.Error("upload file: grant access failed")
+			grantErrorResponse(w, err)
+			return
+		}
+		manifestReference = actRef
+	}
+
 	w.Header().Set("ETag", fmt.Sprintf("%q", manifestReference.String()))
 	jsonhttp.Created(w, auroraUploadResponse{
 		Reference: manifestReference,
@@ -228,6 +236,24 @@ func (s *server) auroraDownloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if requestAct(r) {
+		address, err = s.openAct(r, address)
+		if err != nil {
+			logger.Debugf("download: open access manifest %s: %v", nameOrHex, err)
+			logger.Error("download: open access manifest")
+			if errors.Is(err, act.ErrAccessDenied) {
+				jsonhttp.Forbidden(w, "access denied")
+				return
+			}
+			if errors.Is(err, errActKey) {
+				jsonhttp.BadRequest(w, errActKey.Error())
+				return
+			}
+			jsonhttp.NotFound(w, nil)
+			return
+		}
+	}
+
 	r = r.WithContext(sctx.SetRootHash(r.Context(), address))
 	if !s.chunkInfo.Init(r.Context(), nil, address) {
 		logger.Debugf("download: chunkInfo init %s: %v", nameOrHex, err)
@@ -676,24 +702,22 @@ func (s *server) manifestViewHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) fileRegister(w http.ResponseWriter, r *http.Request) {
-	apiName := "fileRegister"
 	logger := tracing.NewLoggerWithTraceID(r.Context(), s.logger)
 	nameOrHex := mux.Vars(r)["address"]
 	address, err := s.resolveNameOrAddress(nameOrHex)
-	defer s.tranProcess.Delete(apiName + address.String())
-
 	if err != nil {
 		logger.Debugf("file fileRegister: parse address %s: %v", nameOrHex, err)
 		logger.Errorf("file fileRegister: parse address")
 		jsonhttp.NotFound(w, nil)
 		return
 	}
-	if _, ok := s.tranProcess.Load(apiName + address.String()); ok {
+	unlock, ok := s.lockRegister(address)
+	if !ok {
 		logger.Errorf("parse address %s under processing", nameOrHex)
 		jsonhttp.InternalServerError(w, fmt.Sprintf("parse address %s under processing", nameOrHex))
 		return
 	}
-	s.tranProcess.Store(apiName+address.String(), "-")
+	defer unlock()
 	overlays := s.oracleChain.GetNodesFromCid(address.Bytes())
 	for _, v := range overlays {
 		if s.overlay.Equal(v) {
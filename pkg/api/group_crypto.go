@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupauth"
+	"github.com/FavorLabs/favorX/pkg/groupcrypt"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/encryption"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+// groupSessionTimeout is how long the public key of the sender of an
+// encrypted request is kept to encrypt the reply.
+const groupSessionTimeout = time.Minute
+
+var (
+	errGroupNoKey       = errors.New("group key not available")
+	errGroupKeyMismatch = errors.New("public key does not match overlay")
+	errGroupPlainReply  = errors.New("reply to encrypted request not encrypted")
+	errGroupKeyLength   = errors.New("group key of invalid length")
+)
+
+// overlayKey reports whether pub is the public key of overlay.
+func (s *server) overlayKey(overlay boson.Address, pub *ecdsa.PublicKey) bool {
+	addr, err := crypto.NewOverlayAddress(*pub, s.NetworkID)
+	return err == nil && addr.Equal(overlay)
+}
+
+// groupPublicKey returns the public key of the target overlay, asking the
+// target in the group gid if it is not known yet.
+func (s *server) groupPublicKey(ctx context.Context, gid, target boson.Address) (*ecdsa.PublicKey, error) {
+	if pub, ok := s.groupKeys.Load(target.String()); ok {
+		return pub.(*ecdsa.PublicKey), nil
+	}
+	out, err := s.multicast.SendReceive(ctx, groupcrypt.KeyRequest(), gid, target)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := groupcrypt.DecodePublicKey(out)
+	if err != nil {
+		return nil, err
+	}
+	if !s.overlayKey(target, pub) {
+		return nil, errGroupKeyMismatch
+	}
+	s.groupKeys.Store(target.String(), pub)
+	return pub, nil
+}
+
+// sealGroupData encrypts data multicast to the private group gid.
+func (s *server) sealGroupData(gid boson.Address, data []byte) ([]byte, error) {
+	g, err := s.groupAuth.Group(gid)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && g.Key == nil) {
+		return nil, errGroupNoKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groupcrypt.SealGroup(g.Key, data)
+}
+
+// openGroupData decrypts a message of the group gid sent by from.
+func (s *server) openGroupData(gid, from boson.Address, data []byte) ([]byte, *ecdsa.PublicKey, error) {
+	mode, err := groupcrypt.Mode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mode == groupcrypt.ModeGroup {
+		g, err := s.groupAuth.Group(gid)
+		if errors.Is(err, storage.ErrNotFound) || (err == nil && g.Key == nil) {
+			return nil, nil, errGroupNoKey
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		plaintext, err := groupcrypt.OpenGroup(g.Key, data)
+		return plaintext, nil, err
+	}
+
+	plaintext, pub, err := s.groupPeer.Open(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.overlayKey(from, pub) {
+		return nil, nil, errGroupKeyMismatch
+	}
+	s.groupKeys.Store(from.String(), pub)
+	return plaintext, pub, nil
+}
+
+// openGroupEvent answers public key requests, stores group keys passed to
+// this node and decrypts encrypted events. It reports whether the event is
+// delivered to the subscribers.
+func (s *server) openGroupEvent(gid boson.Address, ev *groupEvent) bool {
+	switch {
+	case groupcrypt.IsKeyRequest(ev.Data):
+		if ev.Type == groupEventRequest {
+			go s.replyGroupSession(ev.SessionID, s.groupPeer.PublicKey())
+		}
+		return false
+	case groupauth.IsKeyMessage(ev.Data):
+		if err := s.handleGroupKey(gid, *ev); err != nil {
+			s.logger.Debugf("group crypt: %s key from %s: %v", gid, ev.From, err)
+		}
+		return false
+	case !groupcrypt.IsSealed(ev.Data):
+		return true
+	}
+
+	plaintext, pub, err := s.openGroupData(gid, ev.From, ev.Data)
+	if err != nil {
+		s.logger.Debugf("group crypt: %s message from %s dropped: %v", gid, ev.From, err)
+		return false
+	}
+	ev.Data = plaintext
+	ev.Encrypted = true
+	if ev.Type == groupEventRequest && pub != nil {
+		s.groupSessions.Store(ev.SessionID, pub)
+		time.AfterFunc(groupSessionTimeout, func() {
+			s.groupSessions.Delete(ev.SessionID)
+		})
+	}
+	return true
+}
+
+// sealGroupReply encrypts the reply to an encrypted request for its sender.
+func (s *server) sealGroupReply(sessionID string, data []byte) ([]byte, error) {
+	pub, ok := s.groupSessions.LoadAndDelete(sessionID)
+	if !ok {
+		return data, nil
+	}
+	return s.groupPeer.Seal(pub.(*ecdsa.PublicKey), data)
+}
+
+// sealGroupKey returns the key message passing the key of g to the member
+// overlay with the public key pub.
+func (s *server) sealGroupKey(g groupauth.Group, overlay boson.Address, pub []byte) ([]byte, error) {
+	key, err := groupcrypt.DecodePublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if !s.overlayKey(overlay, key) {
+		return nil, errGroupKeyMismatch
+	}
+	sealed, err := s.groupPeer.Seal(key, g.Key)
+	if err != nil {
+		return nil, err
+	}
+	return groupauth.KeyMessage(sealed), nil
+}
+
+// handleGroupKey stores the group key a member passed to this node.
+func (s *server) handleGroupKey(gid boson.Address, ev groupEvent) error {
+	g, err := s.groupAuth.Group(gid)
+	if err != nil {
+		return err
+	}
+	if g.Key != nil {
+		return nil
+	}
+	sealed, err := groupauth.ParseKeyMessage(ev.Data)
+	if err != nil {
+		return err
+	}
+	key, pub, err := s.groupPeer.Open(sealed)
+	if err != nil {
+		return err
+	}
+	if !s.overlayKey(ev.From, pub) {
+		return errGroupKeyMismatch
+	}
+	if len(key) != encryption.KeyLength {
+		return errGroupKeyLength
+	}
+	g.Key = key
+	return s.groupAuth.PutGroup(g)
+}
+
+// sealPeerData encrypts data for the public key of target, which is
+// returned to open the reply with.
+func (s *server) sealPeerData(ctx context.Context, gid, target boson.Address, data []byte) ([]byte, *ecdsa.PublicKey, error) {
+	pub, err := s.groupPublicKey(ctx, gid, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("public key of %s: %w", target, err)
+	}
+	sealed, err := s.groupPeer.Seal(pub, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sealed, pub, nil
+}
+
+// openPeerReply decrypts the reply to an encrypted request, which must be
+// sealed by the target with the public key pub.
+func (s *server) openPeerReply(data []byte, pub *ecdsa.PublicKey) ([]byte, error) {
+	if !groupcrypt.IsSealed(data) {
+		return nil, errGroupPlainReply
+	}
+	plaintext, sender, err := s.groupPeer.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(crypto.EncodeSecp256k1PublicKey(sender), crypto.EncodeSecp256k1PublicKey(pub)) {
+		return nil, errGroupKeyMismatch
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupcrypt"
+	"github.com/FavorLabs/favorX/pkg/grouplog"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/cac"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/pb"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// groupSyncInterval is how often durable groups are synced from their
+	// members.
+	groupSyncInterval = time.Minute
+	// groupSyncPeers is the number of members a group is synced from.
+	groupSyncPeers = 3
+	// groupSyncPage is the maximum number of envelopes per sync response.
+	groupSyncPage = 32
+	// groupSyncTimeout bounds a single sync request.
+	groupSyncTimeout = 30 * time.Second
+
+	defaultGroupMessagesLimit = 100
+	maxGroupMessagesLimit     = 1000
+)
+
+var (
+	errDurableUnsupported   = errors.New("durable groups not supported")
+	errGroupFeedUnsupported = errors.New("group subscriptions not supported")
+)
+
+// groupConsumer is the running consumer of a durable group.
+type groupConsumer struct {
+	cancel context.CancelFunc
+}
+
+type groupMessage struct {
+	grouplog.Entry
+	Data      []byte `json:"data"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+type groupMessagesResponse struct {
+	Messages []groupMessage `json:"messages"`
+	Cursor   uint64         `json:"cursor"`
+	More     bool           `json:"more"`
+}
+
+// groupLogWorker keeps a consumer running for every durable group and syncs
+// their logs from other members, so messages sent while this node was
+// offline are delivered once it is back. Private groups are announced to
+// their members on the same schedule.
+func (s *server) groupLogWorker() {
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ticker := time.NewTicker(groupSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			gids, err := s.groupLog.Durable()
+			if err != nil {
+				s.logger.Errorf("group log: list durable groups: %v", err)
+			}
+			for _, gid := range gids {
+				if err := s.startGroupConsumer(gid); err != nil {
+					s.logger.Debugf("group log: consume %s: %v", gid, err)
+					continue
+				}
+				s.syncGroup(ctx, gid)
+			}
+			s.announcePrivateGroups()
+
+			select {
+			case <-s.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// startGroupConsumer subscribes to a durable group to log its messages and
+// answer the sync requests of other members.
+func (s *server) startGroupConsumer(gid boson.Address) error {
+	if s.groupRPC == nil {
+		return errDurableUnsupported
+	}
+
+	s.groupLogMu.Lock()
+	defer s.groupLogMu.Unlock()
+
+	if _, ok := s.groupConsumers[gid.String()]; ok {
+		return nil
+	}
+	sub, err := s.subscribeGroup(gid)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &groupConsumer{cancel: cancel}
+	s.groupConsumers[gid.String()] = c
+
+	go func() {
+		defer func() {
+			cancel()
+			sub.Unsubscribe()
+			s.groupLogMu.Lock()
+			if s.groupConsumers[gid.String()] == c {
+				delete(s.groupConsumers, gid.String())
+			}
+			s.groupLogMu.Unlock()
+		}()
+
+		for {
+			select {
+			case <-s.quit:
+				return
+			case <-ctx.Done():
+				return
+			case fev, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				if ev, ok := newGroupEvent(fev); ok {
+					s.consumeGroupEvent(ctx, gid, ev)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *server) stopGroupConsumer(gid boson.Address) {
+	s.groupLogMu.Lock()
+	defer s.groupLogMu.Unlock()
+
+	if c, ok := s.groupConsumers[gid.String()]; ok {
+		c.cancel()
+		delete(s.groupConsumers, gid.String())
+	}
+}
+
+func (s *server) consumeGroupEvent(ctx context.Context, gid boson.Address, ev groupEvent) {
+	switch {
+	case ev.Type == groupEventMulticast && grouplog.IsEnvelope(ev.Data):
+		if _, _, err := s.ingestEnvelope(ctx, gid, ev.Data, storage.ModePutRequest); err != nil {
+			s.logger.Debugf("group log: %s ingest message from %s: %v", gid, ev.From, err)
+		}
+	case ev.Type == groupEventRequest && grouplog.IsSyncRequest(ev.Data):
+		if err := s.answerGroupSync(ctx, gid, ev); err != nil {
+			s.logger.Debugf("group log: %s answer sync of %s: %v", gid, ev.From, err)
+		}
+	}
+}
+
+// ingestEnvelope stores and pins the chunk of an encoded envelope and logs
+// it. Envelopes logged before are skipped.
+func (s *server) ingestEnvelope(ctx context.Context, gid boson.Address, raw []byte, mode storage.ModePut) (grouplog.Entry, bool, error) {
+	env, err := grouplog.UnmarshalEnvelope(raw)
+	if err != nil {
+		return grouplog.Entry{}, false, err
+	}
+	if !env.GID.Equal(gid) {
+		return grouplog.Entry{}, false, fmt.Errorf("envelope of group %s", env.GID)
+	}
+	ch, err := cac.New(raw)
+	if err != nil {
+		return grouplog.Entry{}, false, err
+	}
+
+	s.groupIngestMu.Lock()
+	defer s.groupIngestMu.Unlock()
+
+	has, err := s.groupLog.Has(gid, ch.Address())
+	if err != nil || has {
+		return grouplog.Entry{}, false, err
+	}
+	if _, err := s.storer.Put(ctx, mode, ch); err != nil {
+		return grouplog.Entry{}, false, err
+	}
+	if err := s.storer.Set(ctx, storage.ModeSetPin, ch.Address()); err != nil {
+		return grouplog.Entry{}, false, err
+	}
+	return s.groupLog.Append(env, ch.Address())
+}
+
+// publishDurable logs a message sent by this node and multicasts it to the
+// group.
+func (s *server) publishDurable(ctx context.Context, gid boson.Address, data []byte) (grouplog.Entry, error) {
+	seq, err := s.groupLog.NextSeq(gid)
+	if err != nil {
+		return grouplog.Entry{}, err
+	}
+	raw, err := grouplog.Envelope{
+		GID:     gid,
+		Origin:  s.overlay,
+		Seq:     seq,
+		Created: time.Now().UnixMilli(),
+		Data:    data,
+	}.Marshal()
+	if err != nil {
+		return grouplog.Entry{}, err
+	}
+
+	entry, _, err := s.ingestEnvelope(ctx, gid, raw, storage.ModePutUpload)
+	if err != nil {
+		return grouplog.Entry{}, err
+	}
+	err = s.multicast.Multicast(&pb.MulticastMsg{
+		Gid:  gid.Bytes(),
+		Data: raw,
+	})
+	if err != nil {
+		// the message is logged and reaches the other members by sync
+		s.logger.Debugf("group log: %s multicast: %v", gid, err)
+	}
+	return entry, nil
+}
+
+func (s *server) loadEnvelope(ctx context.Context, ref boson.Address) ([]byte, error) {
+	ch, err := s.storer.Get(ctx, storage.ModeGetLookup, ref)
+	if err != nil {
+		return nil, err
+	}
+	return ch.Data()[boson.SpanSize:], nil
+}
+
+func (s *server) answerGroupSync(ctx context.Context, gid boson.Address, ev groupEvent) error {
+	req, err := grouplog.UnmarshalSyncRequest(ev.Data)
+	if err != nil {
+		return err
+	}
+	if req.Limit <= 0 || req.Limit > groupSyncPage {
+		req.Limit = groupSyncPage
+	}
+
+	entries, more, err := s.groupLog.Since(gid, req.Since, req.Limit)
+	if err != nil {
+		return err
+	}
+	resp := grouplog.SyncResponse{
+		Envelopes: make([][]byte, 0, len(entries)),
+		Cursor:    req.Since,
+		More:      more,
+	}
+	for _, e := range entries {
+		raw, err := s.loadEnvelope(ctx, e.Reference)
+		if err != nil {
+			return err
+		}
+		resp.Envelopes = append(resp.Envelopes, raw)
+		resp.Cursor = e.Cursor
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.groupRPC.CallContext(ctx, nil, "group_reply", ev.SessionID, data)
+}
+
+// syncGroup pulls the envelopes a few members logged since the last sync.
+func (s *server) syncGroup(ctx context.Context, gid boson.Address) {
+	peers, err := s.multicast.GetGroupPeers(gid.String())
+	if err != nil {
+		s.logger.Debugf("group log: %s peers: %v", gid, err)
+		return
+	}
+
+	seen := make(map[string]struct{})
+	for _, peer := range append(peers.Connected, peers.Keep...) {
+		if len(seen) == groupSyncPeers {
+			return
+		}
+		if _, ok := seen[peer.String()]; ok {
+			continue
+		}
+		seen[peer.String()] = struct{}{}
+
+		if err := s.syncGroupFrom(ctx, gid, peer); err != nil {
+			s.logger.Debugf("group log: %s sync from %s: %v", gid, peer, err)
+		}
+	}
+}
+
+func (s *server) syncGroupFrom(ctx context.Context, gid, peer boson.Address) error {
+	cursor, err := s.groupLog.PeerCursor(gid, peer)
+	if err != nil {
+		return err
+	}
+
+	for {
+		req, err := grouplog.SyncRequest{Since: cursor, Limit: groupSyncPage}.Marshal()
+		if err != nil {
+			return err
+		}
+		rctx, cancel := context.WithTimeout(ctx, groupSyncTimeout)
+		out, err := s.multicast.SendReceive(rctx, req, gid, peer)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		var resp grouplog.SyncResponse
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return err
+		}
+		for _, raw := range resp.Envelopes {
+			if _, _, err := s.ingestEnvelope(ctx, gid, raw, storage.ModePutRequest); err != nil {
+				return err
+			}
+		}
+		if resp.Cursor <= cursor {
+			return nil
+		}
+		cursor = resp.Cursor
+		if err := s.groupLog.SetPeerCursor(gid, peer, cursor); err != nil {
+			return err
+		}
+		if !resp.More {
+			return nil
+		}
+	}
+}
+
+// enableDurable turns on the durable mode of a joined group.
+func (s *server) enableDurable(gid boson.Address) error {
+	if s.groupRPC == nil {
+		return errDurableUnsupported
+	}
+	if err := s.groupLog.SetDurable(gid); err != nil {
+		return err
+	}
+	if err := s.startGroupConsumer(gid); err != nil {
+		return err
+	}
+	go s.syncGroup(context.Background(), gid)
+	return nil
+}
+
+// disableDurable drops the log of a group and unpins its messages.
+func (s *server) disableDurable(ctx context.Context, gid boson.Address) error {
+	durable, err := s.groupLog.IsDurable(gid)
+	if err != nil || !durable {
+		return err
+	}
+	s.stopGroupConsumer(gid)
+
+	refs, err := s.groupLog.Remove(gid)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := s.storer.Set(ctx, storage.ModeSetUnpin, ref); err != nil {
+			s.logger.Debugf("group log: %s unpin %s: %v", gid, ref, err)
+		}
+	}
+	return nil
+}
+
+// groupEventPayload unwraps and decrypts the envelopes of durable groups for
+// websocket subscribers. It returns false for the internal sync traffic and
+// for envelopes that can not be decrypted.
+func (s *server) groupEventPayload(ev *groupEvent) bool {
+	if grouplog.IsSyncRequest(ev.Data) {
+		return false
+	}
+	if grouplog.IsEnvelope(ev.Data) {
+		env, err := grouplog.UnmarshalEnvelope(ev.Data)
+		if err != nil {
+			return false
+		}
+		ev.From = env.Origin
+		ev.Data = env.Data
+		ev.Timestamp = env.Created
+		if groupcrypt.IsSealed(ev.Data) {
+			ev.Data, _, err = s.openGroupData(ev.GID, ev.From, ev.Data)
+			if err != nil {
+				s.logger.Debugf("group log: %s decrypt message of %s: %v", ev.GID, ev.From, err)
+				return false
+			}
+			ev.Encrypted = true
+		}
+	}
+	return true
+}
+
+// groupMessagesHandler returns the messages of a durable group logged after
+// the since cursor.
+func (s *server) groupMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	str := mux.Vars(r)["gid"]
+	gid, err := boson.ParseHexAddress(str)
+	if err != nil {
+		gid = multicast.GenerateGID(str)
+	}
+
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			jsonhttp.BadRequest(w, "invalid since")
+			return
+		}
+	}
+	limit := defaultGroupMessagesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxGroupMessagesLimit {
+			jsonhttp.BadRequest(w, "invalid limit")
+			return
+		}
+	}
+
+	durable, err := s.groupLog.IsDurable(gid)
+	if err != nil {
+		s.logger.Debugf("group messages: %s: %v", gid, err)
+		s.logger.Error("group messages: check durable")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if !durable {
+		jsonhttp.NotFound(w, "group is not durable")
+		return
+	}
+
+	entries, more, err := s.groupLog.Since(gid, since, limit)
+	if err != nil {
+		s.logger.Debugf("group messages: %s: %v", gid, err)
+		s.logger.Error("group messages: read log")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	resp := groupMessagesResponse{
+		Messages: make([]groupMessage, 0, len(entries)),
+		Cursor:   since,
+		More:     more,
+	}
+	for _, e := range entries {
+		resp.Cursor = e.Cursor
+		raw, err := s.loadEnvelope(r.Context(), e.Reference)
+		if err == nil {
+			var env grouplog.Envelope
+			env, err = grouplog.UnmarshalEnvelope(raw)
+			raw = env.Data
+		}
+		if err != nil {
+			s.logger.Debugf("group messages: %s load %s: %v", gid, e.Reference, err)
+			s.logger.Error("group messages: load message")
+			jsonhttp.InternalServerError(w, nil)
+			return
+		}
+		msg := groupMessage{Entry: e, Data: raw}
+		if groupcrypt.IsSealed(raw) {
+			// encrypted messages can not be read until a member passed
+			// the group key to this node
+			msg.Data, _, err = s.openGroupData(gid, e.Origin, raw)
+			if err != nil {
+				s.logger.Debugf("group messages: %s decrypt %s: %v", gid, e.Reference, err)
+				continue
+			}
+			msg.Encrypted = true
+		}
+		resp.Messages = append(resp.Messages, msg)
+	}
+
+	jsonhttp.OK(w, resp)
+}
@@ -0,0 +1,168 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/api"
+	"github.com/FavorLabs/favorX/pkg/grouplog"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+type groupMessagesResponse struct {
+	Messages []struct {
+		grouplog.Entry
+		Data []byte `json:"data"`
+	} `json:"messages"`
+	Cursor uint64 `json:"cursor"`
+	More   bool   `json:"more"`
+}
+
+// groupJoinBody is the least configuration a group is joined with.
+var groupJoinBody = jsonhttptest.WithJSONRequestBody(map[string]int{"keep-connected-peers": 1})
+
+func TestGroupDurable(t *testing.T) {
+	store := newStorer()
+	g := newGroups()
+	client := newTestServer(t, testServerOptions{
+		Storer:    store,
+		Multicast: g,
+		Options:   api.Options{GroupFeed: newGroupFeed(t, g)},
+	})
+	gid, peer := multicast.GenerateGID("news"), test.RandomAddress()
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news", http.StatusNotFound,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "group is not durable",
+			Code:    http.StatusNotFound,
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/join/news?durable=true", http.StatusOK, groupJoinBody)
+
+	// a message sent by this node is logged, pinned and multicast
+	var sent grouplog.Entry
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/multicast/news", http.StatusOK,
+		jsonhttptest.WithRequestBody(strings.NewReader("hello")),
+		jsonhttptest.WithUnmarshalJSONResponse(&sent),
+	)
+	if sent.Cursor != 1 || sent.Seq != 1 {
+		t.Fatalf("got entry %+v", sent)
+	}
+	if mode := store.GetModeSet(sent.Reference); mode != storage.ModeSetPin {
+		t.Fatalf("got mode %v of the sent message", mode)
+	}
+	if msgs := g.sent(); len(msgs) != 1 || !grouplog.IsEnvelope(msgs[0].Data) {
+		t.Fatalf("got multicast %+v", msgs)
+	}
+
+	// an envelope multicast by a member is logged by the consumer
+	raw, err := grouplog.Envelope{GID: gid, Origin: peer, Seq: 1, Created: time.Now().UnixMilli(), Data: []byte("hi")}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.receiveMulticast(gid, peer, raw)
+	var resp groupMessagesResponse
+	for deadline := time.Now().Add(5 * time.Second); len(resp.Messages) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("received message not logged")
+		}
+		jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news", http.StatusOK,
+			jsonhttptest.WithUnmarshalJSONResponse(&resp),
+		)
+	}
+	if m := resp.Messages[1]; !m.Origin.Equal(peer) || string(m.Data) != "hi" || resp.Cursor != 2 || resp.More {
+		t.Fatalf("got messages %+v", resp)
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news?since=1", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&resp),
+	)
+	if len(resp.Messages) != 1 || string(resp.Messages[0].Data) != "hi" {
+		t.Fatalf("got messages %+v", resp)
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news?limit=1", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&resp),
+	)
+	if len(resp.Messages) != 1 || resp.Cursor != 1 || !resp.More {
+		t.Fatalf("got messages %+v", resp)
+	}
+
+	// members syncing from this node are sent the logged envelopes
+	req, err := grouplog.SyncRequest{Since: 1}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.receive(gid, peer, "7", req)
+	select {
+	case r := <-g.replies:
+		var sync grouplog.SyncResponse
+		if err := json.Unmarshal(r.Data, &sync); err != nil {
+			t.Fatal(err)
+		}
+		if r.SessionID != "7" || len(sync.Envelopes) != 1 || sync.Cursor != 2 || sync.More {
+			t.Fatalf("got sync response %+v", sync)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync request not answered")
+	}
+
+	// leaving drops the log and unpins the messages
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/group/join/news", http.StatusOK)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news", http.StatusNotFound)
+	if mode := store.GetModeSet(sent.Reference); mode != storage.ModeSetUnpin {
+		t.Fatalf("got mode %v of the sent message", mode)
+	}
+}
+
+func TestGroupDurableBadRequest(t *testing.T) {
+	g := newGroups()
+	client := newTestServer(t, testServerOptions{
+		Multicast: g,
+		Options:   api.Options{GroupFeed: newGroupFeed(t, g)},
+	})
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/join/news?durable=true", http.StatusOK, groupJoinBody)
+
+	for _, tc := range []struct {
+		query, message string
+	}{
+		{"since=first", "invalid since"},
+		{"limit=0", "invalid limit"},
+		{"limit=1001", "invalid limit"},
+	} {
+		jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news?"+tc.query, http.StatusBadRequest,
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Message: tc.message,
+				Code:    http.StatusBadRequest,
+			}),
+		)
+	}
+}
+
+func TestGroupDurableUnsupported(t *testing.T) {
+	g := newGroups()
+	// without the rpc api of the service nothing can be consumed
+	client := newTestServer(t, testServerOptions{Multicast: struct{ multicast.GroupInterface }{g}})
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/join/news?durable=true", http.StatusInternalServerError, groupJoinBody)
+	if g.isJoined(multicast.GenerateGID("news")) {
+		t.Fatal("failed join not undone")
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/messages/news", http.StatusNotFound)
+}
+
+func TestGroupDurableRestricted(t *testing.T) {
+	client := newTestServer(t, testServerOptions{Restricted: true})
+	resource := "/v1/group/messages/" + boson.MustParseHexAddress("02").String()
+
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusNotFound,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+}
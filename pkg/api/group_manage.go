@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	"github.com/gauss-project/aurorafs/pkg/multicast/pb"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	topmodel "github.com/gauss-project/aurorafs/pkg/topology/model"
+	"github.com/gorilla/mux"
+)
+
+const (
+	groupTypeJoin    = "join"
+	groupTypeObserve = "observe"
+)
+
+type groupInfo struct {
+	GID       boson.Address         `json:"gid"`
+	Type      string                `json:"type"`
+	Option    model.ConfigNodeGroup `json:"option"`
+	Connected int                   `json:"connected"`
+	Durable   bool                  `json:"durable"`
+	Private   bool                  `json:"private"`
+	Saved     bool                  `json:"saved"`
+}
+
+type groupListResponse struct {
+	Groups []groupInfo `json:"groups"`
+}
+
+// groupPatchRequest changes the settings of a group, fields left out are
+// kept. The keys match those of the join and observe requests.
+type groupPatchRequest struct {
+	KeepConnectedPeers *int             `json:"keep-connected-peers"`
+	KeepPingPeers      *int             `json:"keep-ping-peers"`
+	Nodes              *[]boson.Address `json:"nodes"`
+}
+
+// prunedGroups hides the nodes removed from a group by a patch from the
+// peers of the group. The multicast service keeps every node it was given
+// once, so the removed nodes are left out of the peers it reports and are
+// skipped when multicasting. Nodes that are given again are shown again.
+// The removed nodes are saved with the group configuration.
+type prunedGroups struct {
+	multicast.GroupInterface
+	s *server
+}
+
+// removedNodes returns the nodes removed from the group gid.
+func (s *server) removedNodes(gid boson.Address) []boson.Address {
+	removed, err := s.groupConf.Removed(gid)
+	if err != nil {
+		s.logger.Errorf("group manage: %s removed nodes: %v", gid, err)
+	}
+	return removed
+}
+
+func prunePeers(peers, removed []boson.Address) []boson.Address {
+	var kept []boson.Address
+	for _, peer := range peers {
+		if !peer.MemberOf(removed) {
+			kept = append(kept, peer)
+		}
+	}
+	return kept
+}
+
+func (g prunedGroups) Snapshot() *model.KadParams {
+	params := g.GroupInterface.Snapshot()
+	for _, info := range params.Groups {
+		removed := g.s.removedNodes(info.GroupID)
+		if len(removed) == 0 {
+			continue
+		}
+		info.KeepPeers = prunePeers(info.KeepPeers, removed)
+		info.KnowPeers = prunePeers(info.KnowPeers, removed)
+		if c := info.ConnectedInfo; c != nil {
+			var peers []*topmodel.PeerInfo
+			for _, peer := range c.ConnectedPeers {
+				if peer == nil || !peer.Address.MemberOf(removed) {
+					peers = append(peers, peer)
+				}
+			}
+			c.ConnectedPeers = peers
+			c.Connected = len(peers)
+		}
+	}
+	return params
+}
+
+func (g prunedGroups) GetGroupPeers(groupName string) (*multicast.GroupPeers, error) {
+	peers, err := g.GroupInterface.GetGroupPeers(groupName)
+	if err != nil {
+		return nil, err
+	}
+	if removed := g.s.removedNodes(groupconf.GroupID(groupName)); len(removed) > 0 {
+		peers.Connected = prunePeers(peers.Connected, removed)
+		peers.Keep = prunePeers(peers.Keep, removed)
+	}
+	return peers, nil
+}
+
+func (g prunedGroups) GetOptimumPeer(groupName string) (boson.Address, error) {
+	peers, err := g.GetGroupPeers(groupName)
+	if err != nil {
+		return boson.ZeroAddress, err
+	}
+	if len(peers.Connected) > 0 {
+		return peers.Connected[0], nil
+	}
+	if len(peers.Keep) > 0 {
+		return peers.Keep[0], nil
+	}
+	return boson.ZeroAddress, nil
+}
+
+func (g prunedGroups) Multicast(info *pb.MulticastMsg, skip ...boson.Address) error {
+	skip = append(skip, g.s.removedNodes(boson.NewAddress(info.Gid))...)
+	return g.GroupInterface.Multicast(info, skip...)
+}
+
+// groupInfos returns the joined and observed groups.
+func (s *server) groupInfos() ([]groupInfo, error) {
+	var infos []groupInfo
+	for _, g := range s.multicast.Snapshot().Groups {
+		var typ string
+		switch g.Option.GType {
+		case model.GTypeJoin:
+			typ = groupTypeJoin
+		case model.GTypeObserve:
+			typ = groupTypeObserve
+		default:
+			continue
+		}
+
+		info := groupInfo{GID: g.GroupID, Type: typ, Option: g.Option}
+		if g.ConnectedInfo != nil {
+			info.Connected = g.ConnectedInfo.Connected
+		}
+		var err error
+		if info.Durable, err = s.groupLog.IsDurable(g.GroupID); err != nil {
+			return nil, err
+		}
+		if _, err := s.groupAuth.Group(g.GroupID); err == nil {
+			info.Private = true
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		if _, err := s.groupConf.Get(g.GroupID); err == nil {
+			info.Saved = true
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *server) groupInfo(gid boson.Address) (groupInfo, bool, error) {
+	infos, err := s.groupInfos()
+	if err != nil {
+		return groupInfo{}, false, err
+	}
+	for _, info := range infos {
+		if info.GID.Equal(gid) {
+			return info, true, nil
+		}
+	}
+	return groupInfo{}, false, nil
+}
+
+// groupListHandler lists the groups this node joined or observes.
+func (s *server) groupListHandler(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.groupInfos()
+	if err != nil {
+		s.logger.Debugf("group list: %v", err)
+		s.logger.Error("group list: list groups")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if infos == nil {
+		infos = make([]groupInfo, 0)
+	}
+	jsonhttp.OK(w, groupListResponse{Groups: infos})
+}
+
+func (s *server) groupGetHandler(w http.ResponseWriter, r *http.Request) {
+	gid := groupconf.GroupID(mux.Vars(r)["gid"])
+	info, ok, err := s.groupInfo(gid)
+	if err != nil {
+		s.logger.Debugf("group get: %s: %v", gid, err)
+		s.logger.Error("group get: get group")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if !ok {
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+	jsonhttp.OK(w, info)
+}
+
+// groupPatchHandler changes the peers kept in a joined or observed group
+// without leaving it. The changed settings are saved and applied again on
+// restart.
+func (s *server) groupPatchHandler(w http.ResponseWriter, r *http.Request) {
+	gid := groupconf.GroupID(mux.Vars(r)["gid"])
+
+	var req groupPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("group patch: decode request: %v", err)
+		s.logger.Error("group patch: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	info, ok, err := s.groupInfo(gid)
+	if err != nil {
+		s.logger.Debugf("group patch: %s: %v", gid, err)
+		s.logger.Error("group patch: get group")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if !ok {
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+
+	option := info.Option
+	if req.KeepConnectedPeers != nil {
+		option.KeepConnectedPeers = *req.KeepConnectedPeers
+	}
+	if req.KeepPingPeers != nil {
+		option.KeepPingPeers = *req.KeepPingPeers
+	}
+	var removed []boson.Address
+	if req.Nodes != nil {
+		for _, node := range option.Nodes {
+			if !node.MemberOf(*req.Nodes) {
+				removed = append(removed, node)
+			}
+		}
+		option.Nodes = *req.Nodes
+	}
+	if option.KeepPingPeers < 1 && option.KeepConnectedPeers < 1 {
+		jsonhttp.BadRequest(w, fmt.Errorf("keep_ping_peers or keep_connected_peers must > 0"))
+		return
+	}
+
+	if err := s.multicast.AddGroup([]model.ConfigNodeGroup{option}); err != nil {
+		s.logger.Debugf("group patch: %s update: %v", gid, err)
+		s.logger.Error("group patch: update group")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if err := s.groupConf.SetRemoved(gid, option.Nodes, removed); err != nil {
+		s.logger.Debugf("group patch: %s save removed nodes: %v", gid, err)
+		s.logger.Error("group patch: save removed nodes")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if err := s.groupConf.Put(option); err != nil {
+		s.logger.Debugf("group patch: %s save: %v", gid, err)
+		s.logger.Error("group patch: save group")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	info, _, err = s.groupInfo(gid)
+	if err != nil {
+		s.logger.Debugf("group patch: %s: %v", gid, err)
+		s.logger.Error("group patch: get group")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, info)
+}
@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	statestore "github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+type groupInfo struct {
+	GID    boson.Address         `json:"gid"`
+	Type   string                `json:"type"`
+	Option model.ConfigNodeGroup `json:"option"`
+	Saved  bool                  `json:"saved"`
+}
+
+// connectedPeers returns the connected peers of group shown by the api.
+func connectedPeers(t *testing.T, client *http.Client, group string) []boson.Address {
+	t.Helper()
+	var peers multicast.GroupPeers
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/peers/"+group, http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&peers),
+	)
+	return peers.Connected
+}
+
+func TestGroupPatch(t *testing.T) {
+	state := statestore.NewStateStore()
+	g := newGroups()
+	client := newTestServer(t, testServerOptions{StateStorer: state, Multicast: g})
+	a, b := test.RandomAddress(), test.RandomAddress()
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/join/chat", http.StatusOK,
+		jsonhttptest.WithJSONRequestBody(model.ConfigNodeGroup{KeepConnectedPeers: 1, Nodes: []boson.Address{a, b}}),
+	)
+	g.join(multicast.GenerateGID("chat"), a, b)
+
+	var info groupInfo
+	jsonhttptest.Request(t, client, http.MethodPatch, "/v1/group/chat", http.StatusOK,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{"nodes": []boson.Address{a}, "keep-ping-peers": 2}),
+		jsonhttptest.WithUnmarshalJSONResponse(&info),
+	)
+	if o := info.Option; len(o.Nodes) != 1 || !o.Nodes[0].Equal(a) || o.KeepConnectedPeers != 1 || o.KeepPingPeers != 2 || !info.Saved {
+		t.Fatalf("got group %+v", info)
+	}
+	// the service still connects the removed node
+	if peers := connectedPeers(t, client, "chat"); len(peers) != 1 || !peers[0].Equal(a) {
+		t.Fatalf("got connected peers %v", peers)
+	}
+
+	// the removed nodes are saved with the group
+	restarted := newTestServer(t, testServerOptions{StateStorer: state, Multicast: g})
+	if peers := connectedPeers(t, restarted, "chat"); len(peers) != 1 || !peers[0].Equal(a) {
+		t.Fatalf("got connected peers %v after restart", peers)
+	}
+
+	jsonhttptest.Request(t, restarted, http.MethodPatch, "/v1/group/chat", http.StatusOK,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{"nodes": []boson.Address{a, b}}),
+	)
+	if peers := connectedPeers(t, restarted, "chat"); len(peers) != 2 {
+		t.Fatalf("got connected peers %v", peers)
+	}
+}
+
+func TestGroupPatchBadRequest(t *testing.T) {
+	g := newGroups()
+	client := newTestServer(t, testServerOptions{Multicast: g})
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/join/chat", http.StatusOK, groupJoinBody)
+
+	jsonhttptest.Request(t, client, http.MethodPatch, "/v1/group/chat", http.StatusBadRequest,
+		jsonhttptest.WithRequestBody(nil),
+	)
+	jsonhttptest.Request(t, client, http.MethodPatch, "/v1/group/chat", http.StatusBadRequest,
+		jsonhttptest.WithJSONRequestBody(map[string]int{"keep-connected-peers": 0}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPatch, "/v1/group/other", http.StatusNotFound,
+		jsonhttptest.WithJSONRequestBody(map[string]int{"keep-connected-peers": 1}),
+	)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/other", http.StatusNotFound)
+}
+
+func TestGroupPatchRestricted(t *testing.T) {
+	g := newGroups()
+	client := newTestServer(t, testServerOptions{Multicast: g, Restricted: true})
+	g.join(multicast.GenerateGID("chat"))
+	body := jsonhttptest.WithJSONRequestBody(map[string]int{"keep-connected-peers": 1})
+
+	// consumers see the groups, only maintainers change them
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/chat", http.StatusOK,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+	jsonhttptest.Request(t, client, http.MethodPatch, "/v1/group/chat", http.StatusForbidden, body,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+	jsonhttptest.Request(t, client, http.MethodPatch, "/v1/group/chat", http.StatusOK, body,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "maintainer")),
+	)
+}
@@ -0,0 +1,451 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupauth"
+	"github.com/FavorLabs/favorX/pkg/groupcrypt"
+	"github.com/FavorLabs/favorX/pkg/groupfeed"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/pb"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errGroupTokenMismatch = errors.New("token of another group")
+	errGroupTokenRequired = errors.New("unknown group, public groups are joined by name and private groups with a token")
+	errGroupRefused       = errors.New("request refused by the target")
+
+	errGroupTargetUnreachable = errors.New("target unreachable")
+	errGroupTargetNotFound    = errors.New("target not found")
+)
+
+type groupTokenRequest struct {
+	Members []boson.Address `json:"members"`
+	Expires int64           `json:"expires"`
+}
+
+type groupTokenResponse struct {
+	GID   boson.Address `json:"gid"`
+	Token string        `json:"token"`
+}
+
+type groupMemberStatus struct {
+	Address boson.Address `json:"address"`
+	Member  bool          `json:"member"`
+	Expires int64         `json:"expires,omitempty"`
+}
+
+type groupPeersResponse struct {
+	*multicast.GroupPeers
+	Private bool                `json:"private"`
+	Members []groupMemberStatus `json:"members,omitempty"`
+}
+
+// groupTokenHandler issues a token for the private group name owned by this
+// node.
+func (s *server) groupTokenHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req groupTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.logger.Debugf("group token: decode request: %v", err)
+			s.logger.Error("group token: decode request")
+			jsonhttp.BadRequest(w, "invalid request")
+			return
+		}
+	}
+	var expires time.Time
+	if req.Expires != 0 {
+		expires = time.Unix(req.Expires, 0)
+	}
+
+	token, err := groupauth.Issue(s.signer, name, req.Members, expires)
+	if err != nil {
+		s.logger.Debugf("group token: issue: %v", err)
+		s.logger.Error("group token: issue")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	g, err := s.groupAuth.Group(token.GID)
+	if errors.Is(err, storage.ErrNotFound) {
+		g, err = groupauth.Group{GID: token.GID, Name: name, Owner: token.Owner}, nil
+	}
+	if err == nil && g.Key == nil {
+		g.Key = groupcrypt.NewGroupKey()
+		err = s.groupAuth.PutGroup(g)
+	}
+	if err != nil {
+		s.logger.Debugf("group token: save group: %v", err)
+		s.logger.Error("group token: save group")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	encoded, err := token.Encode()
+	if err != nil {
+		s.logger.Debugf("group token: encode: %v", err)
+		s.logger.Error("group token: encode")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	jsonhttp.OK(w, groupTokenResponse{GID: token.GID, Token: encoded})
+}
+
+// groupJoinToken returns the token to join the group gid with. Private
+// groups require the token header, the owner of a group joins with a token
+// issued to itself. Public groups return no token.
+func (s *server) groupJoinToken(r *http.Request, gid boson.Address) (*groupauth.Token, error) {
+	if v := r.Header.Get(AuroraGroupTokenHeader); v != "" {
+		token, err := groupauth.Decode(v)
+		if err != nil {
+			return nil, err
+		}
+		if !token.GID.Equal(gid) {
+			return nil, errGroupTokenMismatch
+		}
+		if err := token.Verify(s.overlay, time.Now()); err != nil {
+			return nil, err
+		}
+		return &token, nil
+	}
+
+	g, err := s.groupAuth.Group(gid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.signer.EthereumAddress()
+	if err != nil {
+		return nil, err
+	}
+	if g.Owner != owner {
+		return nil, groupauth.ErrInvalidToken
+	}
+	token, err := groupauth.Issue(s.signer, g.Name, []boson.Address{s.overlay}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// joinPrivate records the token a private group was joined with and
+// announces this node to the members.
+func (s *server) joinPrivate(token groupauth.Token) error {
+	g, err := s.groupAuth.Group(token.GID)
+	if errors.Is(err, storage.ErrNotFound) {
+		g = groupauth.Group{GID: token.GID, Name: token.Name, Owner: token.Owner}
+	} else if err != nil {
+		return err
+	}
+	g.Token = &token
+	if err := s.groupAuth.PutGroup(g); err != nil {
+		return err
+	}
+	if _, err := s.groupAuth.AddMember(s.overlay, token); err != nil {
+		return err
+	}
+	if err := s.startGroupConsumer(token.GID); err != nil {
+		return err
+	}
+	return s.announceMembership(g)
+}
+
+// leavePrivate forgets the token and the members of a private group.
+func (s *server) leavePrivate(gid boson.Address) error {
+	owner, err := s.signer.EthereumAddress()
+	if err != nil {
+		return err
+	}
+	return s.groupAuth.Leave(gid, owner)
+}
+
+// announceMembership multicasts the hello of this node to the group g, the
+// members answer with the group key as long as this node misses it.
+func (s *server) announceMembership(g groupauth.Group) error {
+	hello, err := s.groupHello(g)
+	if err != nil {
+		return err
+	}
+	return s.multicast.Multicast(&pb.MulticastMsg{
+		Gid:  g.GID.Bytes(),
+		Data: hello,
+	})
+}
+
+func (s *server) groupHello(g groupauth.Group) ([]byte, error) {
+	return groupauth.Hello{
+		Token:     *g.Token,
+		PublicKey: s.groupPeer.PublicKey(),
+		NeedKey:   g.Key == nil,
+	}.Marshal()
+}
+
+// announcePrivateGroups repeats the announcement of every joined private
+// group, members that were offline before learn about this node this way.
+func (s *server) announcePrivateGroups() {
+	groups, err := s.groupAuth.Groups()
+	if err != nil {
+		s.logger.Errorf("group auth: list private groups: %v", err)
+		return
+	}
+	for _, g := range groups {
+		if g.Token == nil {
+			continue
+		}
+		if err := s.startGroupConsumer(g.GID); err != nil {
+			s.logger.Debugf("group auth: consume %s: %v", g.GID, err)
+			continue
+		}
+		if err := s.announceMembership(g); err != nil {
+			s.logger.Debugf("group auth: announce to %s: %v", g.GID, err)
+		}
+	}
+}
+
+// filterGroupEvent is the filter of the group feed hub of the node. Every
+// event is checked for private groups, opened, counted and passed through
+// the stream sessions once, before it reaches the subscribers.
+func (s *server) filterGroupEvent(gid boson.Address, fev *groupfeed.Event) bool {
+	ev, ok := newGroupEvent(*fev)
+	if !ok || !s.acceptGroupEvent(gid, &ev) || !s.openGroupEvent(gid, &ev) {
+		return false
+	}
+	s.countGroupMessage(gid, ev.From, false)
+	if !s.streamGroupEvent(gid, &ev) {
+		return false
+	}
+	if m := fev.Message; m != nil {
+		opened := *m
+		opened.Data = ev.Data
+		opened.SessionID = rpc.ID(ev.SessionID)
+		fev.Message = &opened
+	}
+	if m := fev.Multicast; m != nil {
+		opened := *m
+		opened.Data = ev.Data
+		fev.Multicast = &opened
+	}
+	fev.Encrypted = ev.Encrypted
+	fev.ContentType = ev.ContentType
+	return true
+}
+
+// acceptGroupEvent drops the messages of private groups that are not signed
+// by their sender, stale or replayed, or sent by overlays that are not
+// members, requests among them are refused. The data of accepted messages is
+// replaced by the signed data. Announcements of members are consumed here.
+func (s *server) acceptGroupEvent(gid boson.Address, ev *groupEvent) bool {
+	g, err := s.groupAuth.Group(gid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		s.logger.Errorf("group auth: %s: %v", gid, err)
+		return false
+	}
+
+	data, err := s.groupVerifier.Open(gid, ev.From, ev.Data, time.Now())
+	if err != nil {
+		s.logger.Debugf("group auth: %s message of %s dropped: %v", gid, ev.From, err)
+		s.refuseGroupEvent(*ev)
+		return false
+	}
+	ev.Data = data
+
+	if groupauth.IsHello(ev.Data) {
+		s.handleGroupHello(g, *ev)
+		return false
+	}
+	if ev.From.Equal(s.overlay) {
+		return true
+	}
+	member, err := s.groupAuth.IsMember(gid, ev.From, time.Now())
+	if err != nil {
+		s.logger.Errorf("group auth: %s member %s: %v", gid, ev.From, err)
+		return false
+	}
+	if !member {
+		s.logger.Debugf("group auth: %s message from non-member %s dropped", gid, ev.From)
+		s.refuseGroupEvent(*ev)
+	}
+	return member
+}
+
+// refuseGroupEvent answers a dropped request, so the sender learns that it
+// was refused instead of waiting for the reply.
+func (s *server) refuseGroupEvent(ev groupEvent) {
+	if ev.Type != groupEventRequest {
+		return
+	}
+	go s.replyGroupSession(ev.SessionID, groupauth.Refusal())
+}
+
+// memberGroups signs the messages this node sends to private groups, the
+// members accept them only with the signature of their sender. Refused
+// requests fail with errGroupRefused.
+type memberGroups struct {
+	multicast.GroupInterface
+	s *server
+}
+
+func (g memberGroups) sign(gid boson.Address, data []byte) ([]byte, error) {
+	if _, err := g.s.groupAuth.Group(gid); errors.Is(err, storage.ErrNotFound) {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return groupauth.Sign(g.s.signer, gid, data, time.Now())
+}
+
+func (g memberGroups) Multicast(info *pb.MulticastMsg, skip ...boson.Address) (err error) {
+	info.Data, err = g.sign(boson.NewAddress(info.Gid), info.Data)
+	if err != nil {
+		return err
+	}
+	return g.GroupInterface.Multicast(info, skip...)
+}
+
+func (g memberGroups) Send(ctx context.Context, data []byte, gid, dest boson.Address) error {
+	data, err := g.sign(gid, data)
+	if err != nil {
+		return err
+	}
+	return groupTargetError(g.GroupInterface.Send(ctx, data, gid, dest))
+}
+
+func (g memberGroups) SendReceive(ctx context.Context, data []byte, gid, dest boson.Address) ([]byte, error) {
+	data, err := g.sign(gid, data)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.GroupInterface.SendReceive(ctx, data, gid, dest)
+	if err != nil {
+		return nil, groupTargetError(err)
+	}
+	if groupauth.IsRefusal(out) {
+		return nil, errGroupRefused
+	}
+	return out, nil
+}
+
+// groupTargetError wraps the errors of the multicast service about the
+// target, which only come as text, in the matching sentinel.
+func groupTargetError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "p2p stream create failed"):
+		return fmt.Errorf("%w: %s", errGroupTargetUnreachable, msg)
+	case msg == "target not in the group", msg == "target not subscribe the group message":
+		return fmt.Errorf("%w: %s", errGroupTargetNotFound, msg)
+	}
+	return err
+}
+
+// handleGroupHello records an announced member. New members announcing
+// themselves to the whole group are answered with the own announcement, so
+// they learn about this node too. Members asking for the group key get it
+// sealed for their public key.
+func (s *server) handleGroupHello(g groupauth.Group, ev groupEvent) {
+	hello, err := groupauth.ParseHello(ev.Data)
+	if err == nil && !hello.Token.GID.Equal(g.GID) {
+		err = errGroupTokenMismatch
+	}
+	if err == nil {
+		err = hello.Token.Verify(ev.From, time.Now())
+	}
+	if err != nil {
+		s.logger.Debugf("group auth: %s invalid announcement of %s: %v", g.GID, ev.From, err)
+		return
+	}
+
+	added, err := s.groupAuth.AddMember(ev.From, hello.Token)
+	if err != nil {
+		s.logger.Errorf("group auth: %s add member %s: %v", g.GID, ev.From, err)
+		return
+	}
+	if ev.Type != groupEventMulticast || g.Token == nil || ev.From.Equal(s.overlay) {
+		return
+	}
+
+	var messages [][]byte
+	if added {
+		answer, err := s.groupHello(g)
+		if err != nil {
+			return
+		}
+		messages = append(messages, answer)
+	}
+	if hello.NeedKey && g.Key != nil {
+		key, err := s.sealGroupKey(g, ev.From, hello.PublicKey)
+		if err != nil {
+			s.logger.Debugf("group auth: %s seal key for %s: %v", g.GID, ev.From, err)
+		} else {
+			messages = append(messages, key)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), groupSyncTimeout)
+		defer cancel()
+		for _, msg := range messages {
+			if err := s.multicast.Send(ctx, msg, g.GID, ev.From); err != nil {
+				s.logger.Debugf("group auth: %s answer announcement of %s: %v", g.GID, ev.From, err)
+				return
+			}
+		}
+	}()
+}
+
+// groupPeersMembers adds the member status of every peer of a private
+// group to the peers response.
+func (s *server) groupPeersMembers(gid boson.Address, resp *groupPeersResponse) error {
+	if _, err := s.groupAuth.Group(gid); errors.Is(err, storage.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Private = true
+
+	now := time.Now()
+	seen := make(map[string]struct{})
+	for _, peer := range append(resp.Connected, resp.Keep...) {
+		if _, ok := seen[peer.String()]; ok {
+			continue
+		}
+		seen[peer.String()] = struct{}{}
+
+		status := groupMemberStatus{Address: peer}
+		m, err := s.groupAuth.Member(gid, peer)
+		switch {
+		case err == nil:
+			status.Member = m.Active(now)
+			status.Expires = m.Expires
+		case !errors.Is(err, storage.ErrNotFound):
+			return err
+		}
+		resp.Members = append(resp.Members, status)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/FavorLabs/favorX/pkg/groupstream"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// groupStreamTimeout is the time a streamed request may take without
+	// the Aurora-Group-Timeout header.
+	groupStreamTimeout = 2 * time.Minute
+	// groupStreamPullWait is how long a pull waits for the response. It is
+	// below the time the multicast service waits for a reply.
+	groupStreamPullWait = 20 * time.Second
+	// groupStreamSessionIdle is how long the target keeps a stream that is
+	// not used. It is above the time a pull waits.
+	groupStreamSessionIdle = time.Minute
+	// groupStreamMaxSize limits the size of a streamed request and of the
+	// parts of the response not pulled yet.
+	groupStreamMaxSize = 64 * 1024 * 1024
+	// groupStreamMaxSessions limits the streams the node is the target of,
+	// groupStreamMaxPeerSessions those of a single peer.
+	groupStreamMaxSessions     = 256
+	groupStreamMaxPeerSessions = 16
+
+	// groupStreamSessionPrefix marks the session ids of streamed requests
+	// delivered to websocket subscribers.
+	groupStreamSessionPrefix = "stream:"
+)
+
+var (
+	errGroupStreamRemote = errors.New("remote handler failed")
+	errGroupStreamReply  = errors.New("invalid reply")
+)
+
+// groupSendError responds to a failed group request with the status that
+// matches the failure.
+func (s *server) groupSendError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		jsonhttp.GatewayTimeout(w, "timeout waiting for the target")
+	case errors.Is(err, errGroupStreamRemote):
+		jsonhttp.BadGateway(w, msg)
+	case errors.Is(err, errGroupRefused):
+		jsonhttp.Forbidden(w, msg)
+	case errors.Is(err, errGroupTargetUnreachable):
+		jsonhttp.ServiceUnavailable(w, "target unreachable")
+	case errors.Is(err, errGroupTargetNotFound):
+		jsonhttp.NotFound(w, msg)
+	case errors.Is(err, groupstream.ErrTooManySessions):
+		jsonhttp.ServiceUnavailable(w, msg)
+	default:
+		jsonhttp.InternalServerError(w, msg)
+	}
+}
+
+// groupRequestTimeout returns the timeout of the Aurora-Group-Timeout
+// header in seconds, or def without the header.
+func groupRequestTimeout(r *http.Request, def time.Duration) (time.Duration, error) {
+	v := r.Header.Get(AuroraGroupTimeoutHeader)
+	if v == "" {
+		return def, nil
+	}
+	sec, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || sec == 0 {
+		return 0, fmt.Errorf("invalid %s header", AuroraGroupTimeoutHeader)
+	}
+	return time.Duration(sec) * time.Second, nil
+}
+
+// groupStreamCall sends frame f and returns the reply of the target.
+func (s *server) groupStreamCall(ctx context.Context, gid, target boson.Address, f groupstream.Frame) (groupstream.Reply, error) {
+	data, err := f.Marshal()
+	if err != nil {
+		return groupstream.Reply{}, err
+	}
+	out, err := s.multicast.SendReceive(ctx, data, gid, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return groupstream.Reply{}, ctx.Err()
+		}
+		return groupstream.Reply{}, err
+	}
+	s.countGroupMessage(gid, target, true)
+	reply, err := groupstream.ParseReply(out)
+	if err != nil {
+		return groupstream.Reply{}, errGroupStreamReply
+	}
+	if reply.Status == groupstream.StatusError {
+		return reply, fmt.Errorf("%w: %s", errGroupStreamRemote, reply.Error)
+	}
+	return reply, nil
+}
+
+// groupStreamHandler sends the request body to the target in frames and
+// streams back the response of its handler. The content type is passed
+// through in both directions.
+func (s *server) groupStreamHandler(w http.ResponseWriter, r *http.Request) {
+	gid := groupconf.GroupID(mux.Vars(r)["gid"])
+	target, err := boson.ParseHexAddress(mux.Vars(r)["target"])
+	if err != nil {
+		jsonhttp.BadRequest(w, "invalid target address")
+		return
+	}
+	timeout, err := groupRequestTimeout(r, groupStreamTimeout)
+	if err != nil {
+		jsonhttp.BadRequest(w, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+	stream := hex.EncodeToString(id)
+
+	// send the request
+	buf := make([]byte, groupstream.FrameSize)
+	var seq uint64
+	for {
+		n, err := io.ReadFull(r.Body, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.logger.Debugf("group stream: read request: %v", err)
+			s.logger.Error("group stream: read request")
+			jsonhttp.BadRequest(w, "read request")
+			return
+		}
+		f := groupstream.Frame{Stream: stream, Seq: seq, Kind: groupstream.KindData, Data: buf[:n]}
+		if err != nil {
+			f.Kind = groupstream.KindEnd
+		}
+		if seq == 0 {
+			f.ContentType = r.Header.Get(contentTypeHeader)
+		}
+		if _, err := s.groupStreamCall(ctx, gid, target, f); err != nil {
+			s.logger.Debugf("group stream: send %s frame %d: %v", stream, seq, err)
+			s.groupSendError(w, err)
+			return
+		}
+		seq++
+		if f.Kind == groupstream.KindEnd {
+			break
+		}
+	}
+
+	// pull the response, the headers are written with the first frame
+	flusher, _ := w.(http.Flusher)
+	seq = 0
+	for {
+		reply, err := s.groupStreamCall(ctx, gid, target, groupstream.Frame{Stream: stream, Seq: seq, Kind: groupstream.KindPull})
+		if err != nil {
+			s.logger.Debugf("group stream: pull %s frame %d: %v", stream, seq, err)
+			if seq == 0 {
+				s.groupStreamCancel(gid, target, stream)
+				s.groupSendError(w, err)
+			}
+			return
+		}
+		if reply.Status == groupstream.StatusPending {
+			continue
+		}
+
+		if seq == 0 {
+			contentType := reply.ContentType
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			w.Header().Set(contentTypeHeader, contentType)
+			w.WriteHeader(http.StatusOK)
+		}
+		if _, err := w.Write(reply.Data); err != nil {
+			s.logger.Debugf("group stream: write response: %v", err)
+			s.groupStreamCancel(gid, target, stream)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if reply.Status == groupstream.StatusDone {
+			return
+		}
+		seq++
+	}
+}
+
+// groupStreamCancel drops the stream at the target.
+func (s *server) groupStreamCancel(gid, target boson.Address, stream string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), groupSyncTimeout)
+		defer cancel()
+		_, _ = s.groupStreamCall(ctx, gid, target, groupstream.Frame{Stream: stream, Kind: groupstream.KindCancel})
+	}()
+}
+
+// streamGroupEvent handles the frames of streams this node is the target
+// of. Complete requests are delivered as a single request event, whose
+// session id refers to the stream. It reports whether the event is
+// delivered to the subscribers.
+func (s *server) streamGroupEvent(gid boson.Address, ev *groupEvent) bool {
+	if !groupstream.IsFrame(ev.Data) {
+		return true
+	}
+	if ev.Type != groupEventRequest {
+		return false
+	}
+	session := ev.SessionID
+	reply := func(r groupstream.Reply) {
+		data, err := r.Marshal()
+		if err != nil {
+			return
+		}
+		s.replyGroupSession(session, data)
+	}
+
+	f, err := groupstream.ParseFrame(ev.Data)
+	if err != nil {
+		go reply(groupstream.ErrorReply(err))
+		return false
+	}
+	id := ev.From.String() + "/" + f.Stream
+
+	switch f.Kind {
+	case groupstream.KindData, groupstream.KindEnd:
+		sess, err := s.groupStreams.Open(ev.From.String(), id)
+		if err != nil {
+			s.logger.Debugf("group stream: %s stream of %s: %v", gid, ev.From, err)
+			go reply(groupstream.ErrorReply(err))
+			return false
+		}
+		complete, err := sess.Append(f)
+		if err != nil {
+			s.logger.Debugf("group stream: %s frame %d of %s: %v", gid, f.Seq, ev.From, err)
+			s.groupStreams.Remove(id)
+			go reply(groupstream.ErrorReply(err))
+			return false
+		}
+		go reply(groupstream.Reply{Status: groupstream.StatusOK})
+		if !complete {
+			return false
+		}
+		ev.Data, ev.ContentType = sess.Request()
+		ev.SessionID = groupStreamSessionPrefix + id
+		return true
+	case groupstream.KindPull:
+		sess, ok := s.groupStreams.Get(id)
+		if !ok {
+			go reply(groupstream.ErrorReply(errors.New("unknown stream")))
+			return false
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), groupStreamPullWait)
+			defer cancel()
+			r := sess.Pull(ctx, f.Seq)
+			if r.Status == groupstream.StatusDone || r.Status == groupstream.StatusError {
+				s.groupStreams.Remove(id)
+			}
+			reply(r)
+		}()
+	case groupstream.KindCancel:
+		s.groupStreams.Remove(id)
+		go reply(groupstream.Reply{Status: groupstream.StatusOK})
+	default:
+		go reply(groupstream.ErrorReply(groupstream.ErrInvalidFrame))
+	}
+	return false
+}
+
+// respondGroupStream passes the reply of a websocket subscriber to a
+// streamed request. Replies with more set are parts of the response, which
+// ends with the first reply without it. It reports whether the session was
+// one of a stream.
+func (s *server) respondGroupStream(reply groupReply) bool {
+	id := strings.TrimPrefix(reply.SessionID, groupStreamSessionPrefix)
+	if id == reply.SessionID {
+		return false
+	}
+	if sess, ok := s.groupStreams.Get(id); ok {
+		if reply.Error != "" {
+			sess.Close(reply.Error)
+			return true
+		}
+		if err := sess.Write(reply.Data, reply.ContentType); err != nil {
+			sess.Close(err.Error())
+			return true
+		}
+		if !reply.More {
+			sess.Close("")
+		}
+	}
+	return true
+}
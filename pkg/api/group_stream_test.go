@@ -0,0 +1,164 @@
+package api_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/api"
+	"github.com/FavorLabs/favorX/pkg/groupstream"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gorilla/websocket"
+)
+
+type groupStreamEvent struct {
+	groupSubscribeEvent
+	ContentType string `json:"contentType"`
+}
+
+// startGroupStream starts a caller linked to a target node, whose
+// subscriber to the group gid is returned.
+func startGroupStream(t *testing.T, gid string) (*http.Client, *websocket.Conn) {
+	t.Helper()
+	target := newGroups()
+	_, addr := startTestServer(t, testServerOptions{
+		Multicast: target,
+		Options:   api.Options{GroupFeed: newGroupFeed(t, target)},
+	})
+	conn, status := dialWebsocket(t, addr, "/v1/group/subscribe/"+gid, nil)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d", status)
+	}
+
+	caller := newGroups()
+	caller.link(target, test.RandomAddress())
+	return newTestServer(t, testServerOptions{Multicast: caller}), conn
+}
+
+func readGroupStreamEvent(t *testing.T, conn *websocket.Conn) groupStreamEvent {
+	t.Helper()
+	var ev groupStreamEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestGroupStream(t *testing.T) {
+	client, conn := startGroupStream(t, "chat")
+	target := test.RandomAddress()
+
+	// the request takes more than one frame
+	body := bytes.Repeat([]byte("a"), groupstream.FrameSize+10)
+	done := make(chan *http.Response, 1)
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, "/v1/group/stream/chat/"+target.String(), bytes.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			done <- nil
+			return
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+
+	ev := readGroupStreamEvent(t, conn)
+	if ev.Type != "request" || ev.ContentType != "text/plain" || !bytes.Equal(ev.Data, body) {
+		t.Fatalf("got event %s of %d bytes, content type %q", ev.Type, len(ev.Data), ev.ContentType)
+	}
+	for _, reply := range []map[string]interface{}{
+		{"sessionId": ev.SessionID, "data": []byte("hello "), "contentType": "text/plain", "more": true},
+		{"sessionId": ev.SessionID, "data": []byte("world")},
+	} {
+		if err := conn.WriteJSON(reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := <-done
+	if resp == nil {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/plain" || string(got) != "hello world" {
+		t.Fatalf("got status %d, content type %q, body %q", resp.StatusCode, resp.Header.Get("Content-Type"), got)
+	}
+}
+
+func TestGroupStreamRemoteError(t *testing.T) {
+	client, conn := startGroupStream(t, "chat")
+	target := test.RandomAddress()
+
+	go func() {
+		var ev groupStreamEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			return
+		}
+		_ = conn.WriteJSON(map[string]interface{}{"sessionId": ev.SessionID, "error": "boom"})
+	}()
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/stream/chat/"+target.String(), http.StatusBadGateway,
+		jsonhttptest.WithRequestBody(bytes.NewReader([]byte("ping"))),
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "remote handler failed: boom",
+			Code:    http.StatusBadGateway,
+		}),
+	)
+}
+
+func TestGroupStreamTimeout(t *testing.T) {
+	// the request is delivered, but never answered
+	client, _ := startGroupStream(t, "chat")
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/stream/chat/"+test.RandomAddress().String(), http.StatusGatewayTimeout,
+		jsonhttptest.WithRequestHeader(api.AuroraGroupTimeoutHeader, "1"),
+		jsonhttptest.WithRequestBody(bytes.NewReader([]byte("ping"))),
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "timeout waiting for the target",
+			Code:    http.StatusGatewayTimeout,
+		}),
+	)
+}
+
+func TestGroupStreamBadRequest(t *testing.T) {
+	client := newTestServer(t, testServerOptions{Multicast: newGroups()})
+	resource := "/v1/group/stream/chat/" + test.RandomAddress().String()
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/stream/chat/target", http.StatusBadRequest,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "invalid target address",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPost, resource, http.StatusBadRequest,
+		jsonhttptest.WithRequestHeader(api.AuroraGroupTimeoutHeader, "0"),
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "invalid " + api.AuroraGroupTimeoutHeader + " header",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPost, resource, http.StatusNotFound,
+		jsonhttptest.WithRequestBody(bytes.NewReader([]byte("ping"))),
+	)
+}
+
+func TestGroupStreamRestricted(t *testing.T) {
+	client := newTestServer(t, testServerOptions{Multicast: newGroups(), Restricted: true})
+	resource := "/v1/group/stream/chat/" + test.RandomAddress().String()
+
+	jsonhttptest.Request(t, client, http.MethodPost, resource, http.StatusForbidden)
+	// the target is not in the group
+	jsonhttptest.Request(t, client, http.MethodPost, resource, http.StatusNotFound,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupfeed"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	groupEventMulticast = "multicast"
+	groupEventNotify    = "notify"
+	groupEventRequest   = "request"
+
+	// groupEventBuffer is the number of events queued for a subscriber
+	// before further events are dropped.
+	groupEventBuffer = 64
+
+	groupStreamWriteDeadline = 4 * time.Second
+
+	groupReplyAttempts   = 3
+	groupReplyRetryDelay = 50 * time.Millisecond
+)
+
+// groupEvent is a message received in a group as written to the
+// subscribers of /group/subscribe/{gid}. Request events carry the session
+// id the reply has to be sent with. Encrypted events are delivered
+// decrypted. Dropped is the number of events the subscriber lost before
+// this one.
+type groupEvent struct {
+	Type        string        `json:"type"`
+	GID         boson.Address `json:"gid"`
+	From        boson.Address `json:"from"`
+	SessionID   string        `json:"sessionId,omitempty"`
+	Data        []byte        `json:"data"`
+	Timestamp   int64         `json:"timestamp"`
+	Encrypted   bool          `json:"encrypted,omitempty"`
+	ContentType string        `json:"contentType,omitempty"`
+	Dropped     int           `json:"dropped,omitempty"`
+}
+
+// groupReply answers a request event. Content type, error and more are
+// passed to the callers of streamed requests only, more marks a part of the
+// response that is followed by further replies.
+type groupReply struct {
+	SessionID   string `json:"sessionId"`
+	Data        []byte `json:"data"`
+	ContentType string `json:"contentType,omitempty"`
+	Error       string `json:"error,omitempty"`
+	More        bool   `json:"more,omitempty"`
+}
+
+// setupGroupRPC attaches an in-process client to the group namespace of the
+// multicast service, through which replies are sent and the peers of a
+// group are followed.
+func (s *server) setupGroupRPC() {
+	g, ok := s.multicast.(interface{ API() rpc.API })
+	if !ok {
+		return
+	}
+	srv := rpc.NewServer()
+	api := g.API()
+	if err := srv.RegisterName(api.Namespace, api.Service); err != nil {
+		s.logger.Errorf("group subscribe: register rpc api: %v", err)
+		return
+	}
+	s.groupRPC = rpc.DialInProc(srv)
+}
+
+// subscribeGroup subscribes to the messages of gid with the group feed hub
+// of the node, whose filter opened them already.
+func (s *server) subscribeGroup(gid boson.Address) (*groupfeed.Subscription, error) {
+	if s.GroupFeed == nil {
+		return nil, errGroupFeedUnsupported
+	}
+	return s.GroupFeed.Subscribe(gid)
+}
+
+// newGroupEvent converts an event of the group feed hub.
+func newGroupEvent(fev groupfeed.Event) (groupEvent, bool) {
+	switch {
+	case fev.Message != nil:
+		m := fev.Message
+		ev := groupEvent{
+			Type:        groupEventNotify,
+			GID:         m.GID,
+			From:        m.From,
+			SessionID:   string(m.SessionID),
+			Data:        m.Data,
+			Timestamp:   time.Now().UnixMilli(),
+			Encrypted:   fev.Encrypted,
+			ContentType: fev.ContentType,
+		}
+		if m.SessionID != "" {
+			ev.Type = groupEventRequest
+		}
+		return ev, true
+	case fev.Multicast != nil:
+		m := fev.Multicast
+		return groupEvent{
+			Type:      groupEventMulticast,
+			GID:       m.GID,
+			From:      m.Origin,
+			Data:      m.Data,
+			Timestamp: m.CreateTime,
+			Encrypted: fev.Encrypted,
+		}, true
+	}
+	return groupEvent{}, false
+}
+
+// replyGroupSession answers a request event from within the node. The
+// multicast service registers the session right after publishing the
+// event, so a reply sent at once is retried a few times.
+func (s *server) replyGroupSession(sessionID string, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), groupSyncTimeout)
+	defer cancel()
+
+	var err error
+	for i := 0; i < groupReplyAttempts; i++ {
+		if err = s.groupRPC.CallContext(ctx, nil, "group_reply", sessionID, data); err == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(groupReplyRetryDelay):
+		}
+	}
+	s.logger.Debugf("group subscribe: reply to session %s: %v", sessionID, err)
+}
+
+// groupSubscribeHandler streams the multicast, notify and request messages
+// received in a joined group over a websocket. Request messages are
+// answered by writing a groupReply with their session id.
+func (s *server) groupSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	str := mux.Vars(r)["gid"]
+	gid, err := boson.ParseHexAddress(str)
+	if err != nil {
+		gid = multicast.GenerateGID(str)
+	}
+
+	if s.groupRPC == nil || s.GroupFeed == nil {
+		jsonhttp.NotImplemented(w, "group subscriptions not supported")
+		return
+	}
+
+	sub, err := s.subscribeGroup(gid)
+	if err != nil {
+		s.logger.Debugf("group subscribe: subscribe %s: %v", gid, err)
+		s.logger.Error("group subscribe: subscribe")
+		jsonhttp.BadRequest(w, err.Error())
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+	}
+
+	// the websocket is counted before the client sees the upgrade, so a
+	// shutdown right after waits for it
+	s.wsWg.Add(1)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.wsWg.Done()
+		sub.Unsubscribe()
+		s.logger.Debugf("group subscribe: upgrade: %v", err)
+		s.logger.Error("group subscribe: upgrade")
+		jsonhttp.BadRequest(w, nil)
+		return
+	}
+
+	go s.handleGroupSubscription(conn, sub)
+}
+
+func (s *server) handleGroupSubscription(conn *websocket.Conn, sub *groupfeed.Subscription) {
+	defer s.wsWg.Done()
+	defer sub.Unsubscribe()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// replies are read in their own goroutine, a failed read means the
+	// client is gone
+	go func() {
+		defer cancel()
+		readTimeout := 2 * s.WsPingPeriod
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(readTimeout))
+		})
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				s.logger.Debugf("group subscribe: read: %v", err)
+				return
+			}
+			var reply groupReply
+			if err := json.Unmarshal(data, &reply); err != nil || reply.SessionID == "" {
+				s.logger.Debugf("group subscribe: invalid reply: %v", err)
+				continue
+			}
+			if s.respondGroupStream(reply) {
+				continue
+			}
+			data, err = s.sealGroupReply(reply.SessionID, reply.Data)
+			if err != nil {
+				s.logger.Debugf("group subscribe: seal reply to session %s: %v", reply.SessionID, err)
+				continue
+			}
+			err = s.groupRPC.CallContext(ctx, nil, "group_reply", reply.SessionID, data)
+			if err != nil {
+				s.logger.Debugf("group subscribe: reply to session %s: %v", reply.SessionID, err)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.WsPingPeriod)
+	defer ticker.Stop()
+
+	// the events lost by the hub are reported with the next written event
+	dropped := 0
+	for {
+		select {
+		case <-s.quit:
+			err := conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "node shutting down"),
+				time.Now().Add(groupStreamWriteDeadline),
+			)
+			if err != nil {
+				s.logger.Debugf("group subscribe: failed sending close msg: %v", err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		case fev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			dropped += fev.Dropped
+			ev, ok := newGroupEvent(fev)
+			if !ok || !s.groupEventPayload(&ev) {
+				continue
+			}
+			ev.Dropped, dropped = dropped, 0
+			_ = conn.SetWriteDeadline(time.Now().Add(groupStreamWriteDeadline))
+			if err := conn.WriteJSON(ev); err != nil {
+				s.logger.Debugf("group subscribe: write: %v", err)
+				return
+			}
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(groupStreamWriteDeadline))
+			if err != nil {
+				s.logger.Debugf("group subscribe: ping: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/api"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gorilla/websocket"
+)
+
+type groupSubscribeEvent struct {
+	Type      string        `json:"type"`
+	GID       boson.Address `json:"gid"`
+	From      boson.Address `json:"from"`
+	SessionID string        `json:"sessionId"`
+	Data      []byte        `json:"data"`
+}
+
+// dialWebsocket opens a websocket to path of the api listening on addr and
+// returns the status of a refused handshake.
+func dialWebsocket(t *testing.T, addr, path string, header http.Header) (*websocket.Conn, int) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws://"+addr+path, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, http.StatusSwitchingProtocols
+}
+
+func readGroupEvent(t *testing.T, conn *websocket.Conn) groupSubscribeEvent {
+	t.Helper()
+	var ev groupSubscribeEvent
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestGroupSubscribe(t *testing.T) {
+	g := newGroups()
+	gid, peer := test.RandomAddress(), test.RandomAddress()
+	_, addr := startTestServer(t, testServerOptions{
+		Multicast: g,
+		Options:   api.Options{GroupFeed: newGroupFeed(t, g)},
+	})
+
+	conn, status := dialWebsocket(t, addr, "/v1/group/subscribe/"+gid.String(), nil)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d", status)
+	}
+
+	g.receiveMulticast(gid, peer, []byte("hello"))
+	if ev := readGroupEvent(t, conn); ev.Type != "multicast" || !ev.From.Equal(peer) || string(ev.Data) != "hello" {
+		t.Fatalf("got event %+v", ev)
+	}
+	g.receive(gid, peer, "", []byte("note"))
+	if ev := readGroupEvent(t, conn); ev.Type != "notify" || !ev.GID.Equal(gid) || string(ev.Data) != "note" {
+		t.Fatalf("got event %+v", ev)
+	}
+
+	// the reply written to the websocket is passed to the service
+	g.receive(gid, peer, "1", []byte("ping"))
+	if ev := readGroupEvent(t, conn); ev.Type != "request" || ev.SessionID != "1" || string(ev.Data) != "ping" {
+		t.Fatalf("got event %+v", ev)
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"sessionId": "1", "data": []byte("pong")}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-g.replies:
+		if r.SessionID != "1" || string(r.Data) != "pong" {
+			t.Fatalf("got reply %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+}
+
+func TestGroupSubscribeUnsupported(t *testing.T) {
+	client := newTestServer(t, testServerOptions{})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/subscribe/"+test.RandomAddress().String(), http.StatusNotImplemented,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "group subscriptions not supported",
+			Code:    http.StatusNotImplemented,
+		}),
+	)
+}
+
+func TestGroupSubscribeRestricted(t *testing.T) {
+	g := newGroups()
+	_, addr := startTestServer(t, testServerOptions{
+		Multicast:  g,
+		Restricted: true,
+		Options:    api.Options{GroupFeed: newGroupFeed(t, g)},
+	})
+	path := "/v1/group/subscribe/" + test.RandomAddress().String()
+
+	if _, status := dialWebsocket(t, addr, path, nil); status != http.StatusForbidden {
+		t.Fatalf("got status %d without token", status)
+	}
+	if _, status := dialWebsocket(t, addr, path, http.Header{"Authorization": {authToken(t, "consumer")}}); status != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d for consumer", status)
+	}
+}
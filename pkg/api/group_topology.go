@@ -0,0 +1,386 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	groupPeerConnected = "connected"
+	groupPeerKept      = "kept"
+	groupPeerKnown     = "known"
+
+	groupPeerJoin   = "join"
+	groupPeerLeave  = "leave"
+	groupPeerChange = "change"
+
+	// groupCounterMaxPeers limits the peers messages are counted for per
+	// group, the least recently active one makes room for a new one.
+	groupCounterMaxPeers = 256
+)
+
+var errGroupNotFound = errors.New("group not found")
+
+// groupPeerCounters counts the messages exchanged with a peer of a group
+// since the node started or joined the group. Received messages are counted
+// while the group is subscribed to, once they are accepted.
+type groupPeerCounters struct {
+	Received    uint64 `json:"received"`
+	Sent        uint64 `json:"sent"`
+	LastMessage int64  `json:"lastMessage,omitempty"`
+}
+
+// groupPeerTopology describes how a peer of a group is reached. Peers that
+// are not neighbors are reached through the routes of the route table.
+// Latency is the round trip time of the last ping in milliseconds,
+// connectedFor the seconds of the current connection.
+type groupPeerTopology struct {
+	Address      boson.Address     `json:"address"`
+	Status       string            `json:"status"`
+	Neighbor     bool              `json:"neighbor"`
+	Routes       [][]boson.Address `json:"routes,omitempty"`
+	Latency      float64           `json:"latency"`
+	Direction    string            `json:"direction,omitempty"`
+	ConnectedFor float64           `json:"connectedFor"`
+	LastSeen     int64             `json:"lastSeen,omitempty"`
+	groupPeerCounters
+}
+
+type groupTopologyResponse struct {
+	GID   boson.Address       `json:"gid"`
+	Peers []groupPeerTopology `json:"peers"`
+}
+
+// groupPeerEvent reports a change of the connected and kept peers of a
+// group.
+type groupPeerEvent struct {
+	Type      string        `json:"type"`
+	GID       boson.Address `json:"gid"`
+	Peer      boson.Address `json:"peer"`
+	Status    string        `json:"status"`
+	Timestamp int64         `json:"timestamp"`
+}
+
+// countGroupMessage counts a message received from or sent to peer.
+func (s *server) countGroupMessage(gid, peer boson.Address, sent bool) {
+	s.groupCountersMu.Lock()
+	defer s.groupCountersMu.Unlock()
+
+	peers, ok := s.groupCounters[gid.String()]
+	if !ok {
+		peers = make(map[string]*groupPeerCounters)
+		s.groupCounters[gid.String()] = peers
+	}
+	c, ok := peers[peer.String()]
+	if !ok {
+		if len(peers) >= groupCounterMaxPeers {
+			var oldest string
+			for k, v := range peers {
+				if oldest == "" || v.LastMessage < peers[oldest].LastMessage {
+					oldest = k
+				}
+			}
+			delete(peers, oldest)
+		}
+		c = &groupPeerCounters{}
+		peers[peer.String()] = c
+	}
+	if sent {
+		c.Sent++
+	} else {
+		c.Received++
+	}
+	c.LastMessage = time.Now().UnixMilli()
+}
+
+func (s *server) groupPeerCounters(gid, peer boson.Address) groupPeerCounters {
+	s.groupCountersMu.Lock()
+	defer s.groupCountersMu.Unlock()
+
+	if c, ok := s.groupCounters[gid.String()][peer.String()]; ok {
+		return *c
+	}
+	return groupPeerCounters{}
+}
+
+// clearGroupCounters forgets the counters of a group that was left.
+func (s *server) clearGroupCounters(gid boson.Address) {
+	s.groupCountersMu.Lock()
+	defer s.groupCountersMu.Unlock()
+	delete(s.groupCounters, gid.String())
+}
+
+// groupJoined reports whether the node joined or observes gid.
+func (s *server) groupJoined(gid boson.Address) bool {
+	for _, g := range s.multicast.Snapshot().Groups {
+		if g.GroupID.Equal(gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupPeerStatuses returns the status of the connected and kept peers.
+func groupPeerStatuses(peers *multicast.GroupPeers) map[string]string {
+	statuses := make(map[string]string)
+	if peers == nil {
+		return statuses
+	}
+	for _, p := range peers.Keep {
+		statuses[p.String()] = groupPeerKept
+	}
+	for _, p := range peers.Connected {
+		statuses[p.String()] = groupPeerConnected
+	}
+	return statuses
+}
+
+// groupTopology returns the peers of gid this node knows of.
+func (s *server) groupTopology(ctx context.Context, gid boson.Address) ([]groupPeerTopology, error) {
+	if !s.groupJoined(gid) {
+		return nil, errGroupNotFound
+	}
+	peers, err := s.multicast.GetGroupPeers(gid.String())
+	if err != nil {
+		return nil, err
+	}
+	statuses := groupPeerStatuses(peers)
+	for _, g := range s.multicast.Snapshot().Groups {
+		if !g.GroupID.Equal(gid) {
+			continue
+		}
+		for _, p := range g.KnowPeers {
+			if _, ok := statuses[p.String()]; !ok {
+				statuses[p.String()] = groupPeerKnown
+			}
+		}
+	}
+
+	list := make([]groupPeerTopology, 0, len(statuses))
+	for addr, status := range statuses {
+		peer, err := boson.ParseHexAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		t := groupPeerTopology{
+			Address:           peer,
+			Status:            status,
+			Neighbor:          s.route.IsNeighbor(peer),
+			groupPeerCounters: s.groupPeerCounters(gid, peer),
+		}
+		if s.PingRTT != nil {
+			if rtt, ok := s.PingRTT.Last(peer); ok {
+				t.Latency = float64(rtt) / float64(time.Millisecond)
+			}
+		}
+		if ss := s.kad.SnapshotAddr(peer); ss != nil {
+			t.Direction = string(ss.SessionConnectionDirection)
+			t.LastSeen = ss.LastSeenTimestamp
+			if t.Neighbor {
+				t.ConnectedFor = ss.SessionConnectionDuration.Seconds()
+			}
+		}
+		if !t.Neighbor {
+			paths, err := s.route.GetRoute(ctx, peer)
+			if err == nil {
+				for _, p := range paths {
+					t.Routes = append(t.Routes, p.Items)
+				}
+			}
+		}
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Status != list[j].Status {
+			return list[i].Status < list[j].Status
+		}
+		return list[i].Address.String() < list[j].Address.String()
+	})
+	return list, nil
+}
+
+// groupTopologyHandler shows for each peer of a group how it is reached,
+// its latency and connection time and the messages exchanged with it.
+func (s *server) groupTopologyHandler(w http.ResponseWriter, r *http.Request) {
+	str := mux.Vars(r)["gid"]
+	gid, err := boson.ParseHexAddress(str)
+	if err != nil {
+		gid = multicast.GenerateGID(str)
+	}
+
+	peers, err := s.groupTopology(r.Context(), gid)
+	if errors.Is(err, errGroupNotFound) {
+		jsonhttp.NotFound(w, err.Error())
+		return
+	}
+	if err != nil {
+		s.logger.Debugf("group topology: %s: %v", gid, err)
+		s.logger.Error("group topology: get peers")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, groupTopologyResponse{GID: gid, Peers: peers})
+}
+
+// groupTopologySubscribeHandler streams the changes of the connected and
+// kept peers of a group over a websocket. The peers at the time of the
+// subscription are sent first as join events.
+func (s *server) groupTopologySubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	str := mux.Vars(r)["gid"]
+	gid, err := boson.ParseHexAddress(str)
+	if err != nil {
+		gid = multicast.GenerateGID(str)
+	}
+
+	if s.groupRPC == nil {
+		jsonhttp.NotImplemented(w, "group subscriptions not supported")
+		return
+	}
+	if !s.groupJoined(gid) {
+		jsonhttp.NotFound(w, errGroupNotFound.Error())
+		return
+	}
+
+	updates := make(chan *multicast.GroupPeers, groupEventBuffer)
+	sub, err := s.groupRPC.Subscribe(context.Background(), "group", updates, "peers", gid.String())
+	if err != nil {
+		s.logger.Debugf("group topology: subscribe %s: %v", gid, err)
+		s.logger.Error("group topology: subscribe")
+		jsonhttp.BadRequest(w, err.Error())
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+	}
+
+	// the websocket is counted before the client sees the upgrade, so a
+	// shutdown right after waits for it
+	s.wsWg.Add(1)
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.wsWg.Done()
+		sub.Unsubscribe()
+		s.logger.Debugf("group topology: upgrade: %v", err)
+		s.logger.Error("group topology: upgrade")
+		jsonhttp.BadRequest(w, nil)
+		return
+	}
+
+	go func() {
+		defer s.wsWg.Done()
+		defer sub.Unsubscribe()
+		s.handleGroupTopology(conn, gid, sub.Err(), updates)
+	}()
+}
+
+func (s *server) handleGroupTopology(conn *websocket.Conn, gid boson.Address, subErr <-chan error, updates chan *multicast.GroupPeers) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// nothing is read from the client but control messages, a failed read
+	// means the client is gone
+	go func() {
+		defer cancel()
+		readTimeout := 2 * s.WsPingPeriod
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(readTimeout))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(s.WsPingPeriod)
+	defer ticker.Stop()
+
+	last := make(map[string]string)
+	for {
+		select {
+		case <-s.quit:
+			err := conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "node shutting down"),
+				time.Now().Add(groupStreamWriteDeadline),
+			)
+			if err != nil {
+				s.logger.Debugf("group topology: failed sending close msg: %v", err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		case err := <-subErr:
+			s.logger.Debugf("group topology: %s subscription: %v", gid, err)
+			return
+		case peers := <-updates:
+			if removed := s.removedNodes(gid); len(removed) > 0 {
+				peers.Connected = prunePeers(peers.Connected, removed)
+				peers.Keep = prunePeers(peers.Keep, removed)
+			}
+			now := groupPeerStatuses(peers)
+			events := groupPeerEvents(gid, last, now, time.Now().UnixMilli())
+			last = now
+			_ = conn.SetWriteDeadline(time.Now().Add(groupStreamWriteDeadline))
+			for _, ev := range events {
+				if err := conn.WriteJSON(ev); err != nil {
+					s.logger.Debugf("group topology: write: %v", err)
+					return
+				}
+			}
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(groupStreamWriteDeadline))
+			if err != nil {
+				s.logger.Debugf("group topology: ping: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// groupPeerEvents returns the events that turn the peer statuses last into
+// now, ordered by peer.
+func groupPeerEvents(gid boson.Address, last, now map[string]string, timestamp int64) []groupPeerEvent {
+	var events []groupPeerEvent
+	add := func(typ, addr, status string) {
+		peer, err := boson.ParseHexAddress(addr)
+		if err != nil {
+			return
+		}
+		events = append(events, groupPeerEvent{Type: typ, GID: gid, Peer: peer, Status: status, Timestamp: timestamp})
+	}
+	for addr, status := range now {
+		switch prev, ok := last[addr]; {
+		case !ok:
+			add(groupPeerJoin, addr, status)
+		case prev != status:
+			add(groupPeerChange, addr, status)
+		}
+	}
+	for addr := range last {
+		if _, ok := now[addr]; !ok {
+			add(groupPeerLeave, addr, "")
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Peer.String() < events[j].Peer.String()
+	})
+	return events
+}
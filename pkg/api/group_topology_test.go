@@ -0,0 +1,174 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/topology"
+	"github.com/gauss-project/aurorafs/pkg/topology/model"
+	"github.com/gorilla/websocket"
+)
+
+type groupTopologyResponse struct {
+	GID   boson.Address `json:"gid"`
+	Peers []struct {
+		Address   boson.Address `json:"address"`
+		Status    string        `json:"status"`
+		Neighbor  bool          `json:"neighbor"`
+		Direction string        `json:"direction"`
+		LastSeen  int64         `json:"lastSeen"`
+		Sent      uint64        `json:"sent"`
+		Received  uint64        `json:"received"`
+	} `json:"peers"`
+}
+
+type groupPeerEvent struct {
+	Type   string        `json:"type"`
+	GID    boson.Address `json:"gid"`
+	Peer   boson.Address `json:"peer"`
+	Status string        `json:"status"`
+}
+
+// kad returns the snapshots of the peers it was given, the other methods of
+// the driver are not implemented.
+type kad struct {
+	topology.Driver
+	snapshots map[string]*model.Snapshot
+}
+
+func (k *kad) SnapshotAddr(addr boson.Address) *model.Snapshot {
+	return k.snapshots[addr.String()]
+}
+
+func readGroupPeerEvent(t *testing.T, conn *websocket.Conn) groupPeerEvent {
+	t.Helper()
+	var ev groupPeerEvent
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestGroupTopology(t *testing.T) {
+	g := newGroups()
+	gid := multicast.GenerateGID("chat")
+	connected, kept := boson.MustParseHexAddress("01"), boson.MustParseHexAddress("02")
+	g.join(gid, connected)
+	g.mu.Lock()
+	g.peers[gid.String()].Keep = []boson.Address{kept}
+	g.mu.Unlock()
+	g.sendReceive = func(_ context.Context, data []byte, _, _ boson.Address) ([]byte, error) {
+		return data, nil
+	}
+	client := newTestServer(t, testServerOptions{
+		Multicast: g,
+		Kad: &kad{snapshots: map[string]*model.Snapshot{
+			connected.String(): {LastSeenTimestamp: 42, SessionConnectionDirection: model.PeerConnectionDirectionOutbound},
+		}},
+	})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/topology/other", http.StatusNotFound,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "group not found",
+			Code:    http.StatusNotFound,
+		}),
+	)
+
+	// the messages sent to a peer are counted
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/group/send/chat/"+connected.String(), http.StatusOK,
+		jsonhttptest.WithRequestBody(strings.NewReader("ping")),
+	)
+
+	var resp groupTopologyResponse
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/topology/chat", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&resp),
+	)
+	if !resp.GID.Equal(gid) || len(resp.Peers) != 2 {
+		t.Fatalf("got topology %+v", resp)
+	}
+	if p := resp.Peers[0]; !p.Address.Equal(connected) || p.Status != "connected" || p.Direction != "outbound" || p.LastSeen != 42 || p.Sent != 1 {
+		t.Fatalf("got peer %+v", p)
+	}
+	if p := resp.Peers[1]; !p.Address.Equal(kept) || p.Status != "kept" || p.Direction != "" || p.Sent != 0 {
+		t.Fatalf("got peer %+v", p)
+	}
+}
+
+func TestGroupTopologySubscribe(t *testing.T) {
+	g := newGroups()
+	gid := multicast.GenerateGID("chat")
+	first, second := boson.MustParseHexAddress("01"), boson.MustParseHexAddress("02")
+	g.join(gid)
+	_, addr := startTestServer(t, testServerOptions{Multicast: g})
+
+	if _, status := dialWebsocket(t, addr, "/v1/group/topology/other/subscribe", nil); status != http.StatusNotFound {
+		t.Fatalf("got status %d for a group not joined", status)
+	}
+	conn, status := dialWebsocket(t, addr, "/v1/group/topology/chat/subscribe", nil)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d", status)
+	}
+
+	publish := func(peers *multicast.GroupPeers) {
+		if err := g.subPub.Publish("group", "groupPeers", gid.String(), peers); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(&multicast.GroupPeers{Connected: []boson.Address{first}, Keep: []boson.Address{second}})
+	if ev := readGroupPeerEvent(t, conn); ev.Type != "join" || !ev.GID.Equal(gid) || !ev.Peer.Equal(first) || ev.Status != "connected" {
+		t.Fatalf("got event %+v", ev)
+	}
+	if ev := readGroupPeerEvent(t, conn); ev.Type != "join" || !ev.Peer.Equal(second) || ev.Status != "kept" {
+		t.Fatalf("got event %+v", ev)
+	}
+
+	publish(&multicast.GroupPeers{Connected: []boson.Address{second}})
+	if ev := readGroupPeerEvent(t, conn); ev.Type != "leave" || !ev.Peer.Equal(first) {
+		t.Fatalf("got event %+v", ev)
+	}
+	if ev := readGroupPeerEvent(t, conn); ev.Type != "change" || !ev.Peer.Equal(second) || ev.Status != "connected" {
+		t.Fatalf("got event %+v", ev)
+	}
+}
+
+func TestGroupTopologySubscribeUnsupported(t *testing.T) {
+	client := newTestServer(t, testServerOptions{})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/topology/chat/subscribe", http.StatusNotImplemented,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "group subscriptions not supported",
+			Code:    http.StatusNotImplemented,
+		}),
+	)
+}
+
+func TestGroupTopologyRestricted(t *testing.T) {
+	g := newGroups()
+	g.join(multicast.GenerateGID("chat"))
+	client, addr := startTestServer(t, testServerOptions{Multicast: g, Kad: &kad{}, Restricted: true})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/topology/chat", http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/topology/chat", http.StatusOK,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/group/topology/"+test.RandomAddress().String(), http.StatusNotFound,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+
+	path := "/v1/group/topology/chat/subscribe"
+	if _, status := dialWebsocket(t, addr, path, nil); status != http.StatusForbidden {
+		t.Fatalf("got status %d without token", status)
+	}
+	if _, status := dialWebsocket(t, addr, path, http.Header{"Authorization": {authToken(t, "consumer")}}); status != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d for consumer", status)
+	}
+}
@@ -17,6 +17,10 @@ type metrics struct {
 	ResponseDuration   prometheus.Histogram
 	PingRequestCount   prometheus.Counter
 	ResponseCodeCounts *prometheus.CounterVec
+
+	RelayRequestCount *prometheus.CounterVec
+	RelayBytes        *prometheus.CounterVec
+	RelayDuration     *prometheus.HistogramVec
 }
 
 func newMetrics() metrics {
@@ -45,6 +49,34 @@ func newMetrics() metrics {
 			},
 			[]string{"code", "method"},
 		),
+		RelayRequestCount: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: m.Namespace,
+				Subsystem: subsystem,
+				Name:      "relay_request_count",
+				Help:      "Number of relayed requests grouped by group, domain and status code.",
+			},
+			[]string{"group", "domain", "code"},
+		),
+		RelayBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: m.Namespace,
+				Subsystem: subsystem,
+				Name:      "relay_bytes",
+				Help:      "Bytes relayed grouped by group, domain and direction.",
+			},
+			[]string{"group", "domain", "direction"},
+		),
+		RelayDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: m.Namespace,
+				Subsystem: subsystem,
+				Name:      "relay_duration_seconds",
+				Help:      "Histogram of relayed request durations grouped by group and domain.",
+				Buckets:   []float64{0.01, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			},
+			[]string{"group", "domain"},
+		),
 	}
 }
 
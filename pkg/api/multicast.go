@@ -1,52 +1,142 @@
 package api
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/FavorLabs/favorX/pkg/grouplog"
 	"github.com/gauss-project/aurorafs/pkg/boson"
 	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
 	"github.com/gauss-project/aurorafs/pkg/multicast"
 	"github.com/gauss-project/aurorafs/pkg/multicast/model"
 	"github.com/gauss-project/aurorafs/pkg/multicast/pb"
+	"github.com/gauss-project/aurorafs/pkg/storage"
 	"github.com/gorilla/mux"
 )
 
-func (s *server) addGroup(w http.ResponseWriter, r *http.Request, gType model.GType) {
+func (s *server) addGroup(w http.ResponseWriter, r *http.Request, gType model.GType) bool {
 	gid := mux.Vars(r)["gid"]
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		jsonhttp.InternalServerError(w, err)
-		return
+		return false
 	}
 	var req model.ConfigNodeGroup
 	if len(body) == 0 {
 		jsonhttp.BadRequest(w, fmt.Errorf("missing parameters"))
-		return
+		return false
 	}
 	err = json.Unmarshal(body, &req)
 	if err != nil {
 		jsonhttp.InternalServerError(w, err)
-		return
+		return false
 	}
 	if req.KeepPingPeers < 1 && req.KeepConnectedPeers < 1 {
 		jsonhttp.BadRequest(w, fmt.Errorf("keep_ping_peers or keep_connected_peers must > 0"))
-		return
+		return false
 	}
 	req.Name = gid
 	req.GType = gType
 	err = s.multicast.AddGroup([]model.ConfigNodeGroup{req})
 	if err != nil {
 		jsonhttp.InternalServerError(w, err)
+		return false
+	}
+	if err := s.groupConf.SetRemoved(groupconf.GroupID(gid), req.Nodes, nil); err != nil {
+		s.logger.Errorf("multicast add group: save removed nodes: %v", err)
+		jsonhttp.InternalServerError(w, err)
+		return false
+	}
+	if err := s.groupConf.Put(req); err != nil {
+		s.logger.Errorf("multicast add group: save: %v", err)
+		jsonhttp.InternalServerError(w, err)
+		return false
+	}
+	return true
+}
+
+// groupJoinHandler joins a group. With durable=true the messages of the
+// group are logged and synced from the other members.
+//
+// Private groups are joined with the token of the Aurora-Group-Token header.
+// Public groups are joined by name. A group id is accepted only with a
+// token, for private groups known to this node or for groups joined before,
+// so a private group is never joined as a public one by mistake. A join
+// that fails halfway is undone.
+func (s *server) groupJoinHandler(w http.ResponseWriter, r *http.Request) {
+	str := mux.Vars(r)["gid"]
+	gid, err := boson.ParseHexAddress(str)
+	byName := err != nil
+	if byName {
+		gid = multicast.GenerateGID(str)
+	}
+
+	token, err := s.groupJoinToken(r, gid)
+	if err != nil {
+		s.logger.Debugf("multicast join group: token: %v", err)
+		jsonhttp.Forbidden(w, err.Error())
+		return
+	}
+
+	_, err = s.groupConf.Get(gid)
+	joined := err == nil
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		s.logger.Errorf("multicast join group: saved group: %v", err)
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+	if token == nil && !byName && !joined {
+		if _, err := s.groupAuth.Group(gid); errors.Is(err, storage.ErrNotFound) {
+			jsonhttp.Forbidden(w, errGroupTokenRequired.Error())
+			return
+		}
+	}
+
+	if !s.addGroup(w, r, model.GTypeJoin) {
 		return
 	}
+	if token != nil {
+		if err := s.joinPrivate(*token); err != nil {
+			s.logger.Errorf("multicast join group: join private: %v", err)
+			if !joined {
+				s.undoJoin(gid)
+			}
+			jsonhttp.InternalServerError(w, err)
+			return
+		}
+	}
+	if strings.ToLower(r.URL.Query().Get("durable")) == StringTrue {
+		if err := s.enableDurable(gid); err != nil {
+			s.logger.Errorf("multicast join group: enable durable: %v", err)
+			if !joined {
+				s.undoJoin(gid)
+			}
+			jsonhttp.InternalServerError(w, err)
+			return
+		}
+	}
 	jsonhttp.OK(w, nil)
 }
 
-func (s *server) groupJoinHandler(w http.ResponseWriter, r *http.Request) {
-	s.addGroup(w, r, model.GTypeJoin)
+// undoJoin leaves a group whose join failed.
+func (s *server) undoJoin(gid boson.Address) {
+	if err := s.multicast.RemoveGroup(gid, model.GTypeJoin); err != nil {
+		s.logger.Errorf("multicast join group: undo: remove group: %v", err)
+	}
+	if err := s.groupConf.Delete(gid); err != nil {
+		s.logger.Errorf("multicast join group: undo: delete saved group: %v", err)
+	}
+	if err := s.leavePrivate(gid); err != nil {
+		s.logger.Errorf("multicast join group: undo: leave private: %v", err)
+	}
+	s.stopGroupConsumer(gid)
 }
 
 func (s *server) groupLeaveHandler(w http.ResponseWriter, r *http.Request) {
@@ -61,9 +151,23 @@ func (s *server) groupLeaveHandler(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.InternalServerError(w, err)
 		return
 	}
+	if err := s.groupConf.Delete(gid); err != nil {
+		s.logger.Errorf("multicast leave group: delete saved group: %v", err)
+	}
+	if err := s.disableDurable(r.Context(), gid); err != nil {
+		s.logger.Errorf("multicast leave group: disable durable: %v", err)
+	}
+	if err := s.leavePrivate(gid); err != nil {
+		s.logger.Errorf("multicast leave group: leave private: %v", err)
+	}
+	s.stopGroupConsumer(gid)
+	s.clearGroupCounters(gid)
 	jsonhttp.OK(w, nil)
 }
 
+// multicastMsg sends the body to all members of a group. With the
+// Aurora-Encrypt header the body is encrypted with the key of the private
+// group.
 func (s *server) multicastMsg(w http.ResponseWriter, r *http.Request) {
 	str := mux.Vars(r)["gid"]
 	gid, err := boson.ParseHexAddress(str)
@@ -76,6 +180,37 @@ func (s *server) multicastMsg(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.InternalServerError(w, err)
 		return
 	}
+	if requestEncrypt(r) {
+		body, err = s.sealGroupData(gid, body)
+		if errors.Is(err, errGroupNoKey) {
+			jsonhttp.BadRequest(w, err)
+			return
+		}
+		if err != nil {
+			jsonhttp.InternalServerError(w, err)
+			return
+		}
+	}
+
+	durable, err := s.groupLog.IsDurable(gid)
+	if err != nil {
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+	if durable {
+		entry, err := s.publishDurable(r.Context(), gid, body)
+		if errors.Is(err, grouplog.ErrTooLarge) {
+			jsonhttp.BadRequest(w, err)
+			return
+		}
+		if err != nil {
+			jsonhttp.InternalServerError(w, err)
+			return
+		}
+		jsonhttp.OK(w, entry)
+		return
+	}
+
 	err = s.multicast.Multicast(&pb.MulticastMsg{
 		Gid:  gid.Bytes(),
 		Data: body,
@@ -88,7 +223,10 @@ func (s *server) multicastMsg(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) groupObserveHandler(w http.ResponseWriter, r *http.Request) {
-	s.addGroup(w, r, model.GTypeObserve)
+	if !s.addGroup(w, r, model.GTypeObserve) {
+		return
+	}
+	jsonhttp.OK(w, nil)
 }
 
 func (s *server) groupObserveCancelHandler(w http.ResponseWriter, r *http.Request) {
@@ -103,9 +241,18 @@ func (s *server) groupObserveCancelHandler(w http.ResponseWriter, r *http.Reques
 		jsonhttp.InternalServerError(w, err)
 		return
 	}
+	if c, err := s.groupConf.Get(gid); err == nil && c.GType == model.GTypeObserve {
+		if err := s.groupConf.Delete(gid); err != nil {
+			s.logger.Errorf("multicast cancel observe group: delete saved group: %v", err)
+		}
+		s.clearGroupCounters(gid)
+	}
 	jsonhttp.OK(w, nil)
 }
 
+// sendReceive sends the body to the target and returns its reply. With the
+// Aurora-Encrypt header request and reply are encrypted for the public keys
+// of the target and of this node.
 func (s *server) sendReceive(w http.ResponseWriter, r *http.Request) {
 	str := mux.Vars(r)["gid"]
 	gid, err := boson.ParseHexAddress(str)
@@ -128,16 +275,49 @@ func (s *server) sendReceive(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.BadRequest(w, fmt.Errorf("missing body"))
 		return
 	}
-	out, err := s.multicast.SendReceive(r.Context(), body, gid, target)
+	timeout, err := groupRequestTimeout(r, 0)
 	if err != nil {
-		jsonhttp.InternalServerError(w, err)
+		jsonhttp.BadRequest(w, err.Error())
+		return
+	}
+	ctx := r.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var pub *ecdsa.PublicKey
+	if requestEncrypt(r) {
+		body, pub, err = s.sealPeerData(ctx, gid, target, body)
+		if err != nil {
+			s.groupSendError(w, err)
+			return
+		}
+	}
+	out, err := s.multicast.SendReceive(ctx, body, gid, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		s.groupSendError(w, err)
 		return
 	}
+	s.countGroupMessage(gid, target, true)
+	if pub != nil {
+		out, err = s.openPeerReply(out, pub)
+		if err != nil {
+			jsonhttp.InternalServerError(w, err)
+			return
+		}
+	}
 	jsonhttp.OK(w, struct {
 		Data []byte `json:"data"`
 	}{Data: out})
 }
 
+// notify sends the body to the target, encrypted for its public key with
+// the Aurora-Encrypt header.
 func (s *server) notify(w http.ResponseWriter, r *http.Request) {
 	str := mux.Vars(r)["gid"]
 	gid, err := boson.ParseHexAddress(str)
@@ -160,11 +340,19 @@ func (s *server) notify(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.BadRequest(w, fmt.Errorf("missing body"))
 		return
 	}
+	if requestEncrypt(r) {
+		body, _, err = s.sealPeerData(r.Context(), gid, target, body)
+		if err != nil {
+			s.groupSendError(w, err)
+			return
+		}
+	}
 	err = s.multicast.Send(r.Context(), body, gid, target)
 	if err != nil {
-		jsonhttp.InternalServerError(w, err)
+		s.groupSendError(w, err)
 		return
 	}
+	s.countGroupMessage(gid, target, true)
 	jsonhttp.OK(w, nil)
 }
 
@@ -175,5 +363,15 @@ func (s *server) peers(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.InternalServerError(w, err)
 		return
 	}
-	jsonhttp.OK(w, peers)
+
+	gid, err := boson.ParseHexAddress(groupName)
+	if err != nil {
+		gid = multicast.GenerateGID(groupName)
+	}
+	resp := groupPeersResponse{GroupPeers: peers}
+	if err := s.groupPeersMembers(gid, &resp); err != nil {
+		jsonhttp.InternalServerError(w, err)
+		return
+	}
+	jsonhttp.OK(w, resp)
 }
@@ -1,11 +1,371 @@
 package api
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/FavorLabs/favorX/pkg/netrelay"
 	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gorilla/mux"
 )
 
+const (
+	relayDirectionIn  = "in"
+	relayDirectionOut = "out"
+
+	// relayUnmapped labels the metrics of requests for domains that are
+	// not mapped on this node, so the labels are not taken from the url.
+	relayUnmapped = "unmapped"
+)
+
+type relayDomainRequest struct {
+	HTTP string `json:"http"`
+	WS   string `json:"ws"`
+}
+
+type relayDomainsResponse struct {
+	Domains []netrelay.Domain `json:"domains"`
+}
+
+type relayAllowlistRequest struct {
+	Hosts []string `json:"hosts"`
+	Ports []int    `json:"ports"`
+}
+
+type relayServiceRequest struct {
+	Addr   string          `json:"addr"`
+	Groups []string        `json:"groups"`
+	Peers  []boson.Address `json:"peers"`
+}
+
+type relayServicesResponse struct {
+	Services []netrelay.TCPService `json:"services"`
+}
+
+type relayForwardRequest struct {
+	Listen  string        `json:"listen"`
+	Target  boson.Address `json:"target"`
+	Service string        `json:"service"`
+	Lease   int64         `json:"lease"`
+}
+
+type relayForwardsResponse struct {
+	Forwards []netrelay.Forward `json:"forwards"`
+}
+
+// relayResponseWriter counts the bytes written to the client, including
+// those of hijacked websocket connections.
+type relayResponseWriter struct {
+	*responseWriter
+	written int64
+	read    int64
+}
+
+func (rw *relayResponseWriter) Write(b []byte) (int, error) {
+	n, err := rw.responseWriter.Write(b)
+	atomic.AddInt64(&rw.written, int64(n))
+	return n, err
+}
+
+func (rw *relayResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := rw.responseWriter.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// a successful upgrade has no status code written through the writer
+	rw.statusCode = http.StatusSwitchingProtocols
+	// reads go through the buffer of the connection, which may hold
+	// bytes the client sent right after the upgrade request
+	rc := &relayConn{Conn: conn, r: buf.Reader, rw: rw}
+	return rc, bufio.NewReadWriter(bufio.NewReader(rc), bufio.NewWriter(rc)), nil
+}
+
+type relayConn struct {
+	net.Conn
+	r  *bufio.Reader
+	rw *relayResponseWriter
+}
+
+func (c *relayConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddInt64(&c.rw.read, int64(n))
+	return n, err
+}
+
+func (c *relayConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.rw.written, int64(n))
+	return n, err
+}
+
+// CloseWrite lets the relay close the sending side of a websocket tunnel
+// only, as it does when the target closed its side.
+func (c *relayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+type relayBody struct {
+	io.ReadCloser
+	rw *relayResponseWriter
+}
+
+func (b *relayBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.rw.read, int64(n))
+	return n, err
+}
+
 func (s *server) relayDo(w http.ResponseWriter, r *http.Request) {
-	s.netRelay.RelayHttpDo(w, r, boson.ZeroAddress)
+	group, domain := mux.Vars(r)["gname"], mux.Vars(r)["domain"]
+	start := time.Now()
+	rw := &relayResponseWriter{responseWriter: newResponseWriter(w)}
+	if r.Body != nil {
+		r.Body = &relayBody{ReadCloser: r.Body, rw: rw}
+	}
+
+	s.netRelay.RelayHttpDo(rw, r, boson.ZeroAddress)
+
+	if _, err := s.netRelay.Domain(group, domain); err != nil {
+		group, domain = relayUnmapped, relayUnmapped
+	}
+	s.metrics.RelayRequestCount.WithLabelValues(group, domain, strconv.Itoa(rw.statusCode)).Inc()
+	s.metrics.RelayBytes.WithLabelValues(group, domain, relayDirectionIn).Add(float64(atomic.LoadInt64(&rw.read)))
+	s.metrics.RelayBytes.WithLabelValues(group, domain, relayDirectionOut).Add(float64(atomic.LoadInt64(&rw.written)))
+	s.metrics.RelayDuration.WithLabelValues(group, domain).Observe(time.Since(start).Seconds())
+}
+
+// relayError responds to a failed change of the relay settings.
+func (s *server) relayError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, netrelay.ErrInvalidAddress):
+		jsonhttp.BadRequest(w, err.Error())
+	case errors.Is(err, netrelay.ErrNotAllowed):
+		jsonhttp.Forbidden(w, err.Error())
+	case errors.Is(err, netrelay.ErrDomainNotFound),
+		errors.Is(err, netrelay.ErrServiceNotFound),
+		errors.Is(err, netrelay.ErrForwardNotFound):
+		jsonhttp.NotFound(w, nil)
+	case errors.Is(err, netrelay.ErrStaticDomain):
+		jsonhttp.Conflict(w, err.Error())
+	default:
+		jsonhttp.InternalServerError(w, nil)
+	}
+}
+
+// relayDomainsHandler lists the relay domains of all groups, or of a group.
+func (s *server) relayDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["gname"]
+	domains, err := s.netRelay.Domains(group)
+	if err != nil {
+		s.logger.Debugf("relay domains: %s: %v", group, err)
+		s.logger.Error("relay domains: list domains")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, relayDomainsResponse{Domains: domains})
+}
+
+func (s *server) relayDomainGetHandler(w http.ResponseWriter, r *http.Request) {
+	group, domain := mux.Vars(r)["gname"], mux.Vars(r)["domain"]
+	d, err := s.netRelay.Domain(group, domain)
+	if err != nil {
+		if !errors.Is(err, netrelay.ErrDomainNotFound) {
+			s.logger.Debugf("relay domain: %s/%s: %v", group, domain, err)
+			s.logger.Error("relay domain: get domain")
+		}
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, d)
+}
+
+// relayDomainPutHandler maps a domain of a group to the addresses requests
+// are forwarded to by this node.
+func (s *server) relayDomainPutHandler(w http.ResponseWriter, r *http.Request) {
+	group, domain := mux.Vars(r)["gname"], mux.Vars(r)["domain"]
+
+	var req relayDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("relay domain: decode request: %v", err)
+		s.logger.Error("relay domain: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	d := netrelay.Domain{Group: group, Domain: domain, HTTP: req.HTTP, WS: req.WS}
+	if err := s.netRelay.AddDomain(d); err != nil {
+		s.logger.Debugf("relay domain: add %s/%s: %v", group, domain, err)
+		s.logger.Error("relay domain: add domain")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, d)
+}
+
+func (s *server) relayDomainDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	group, domain := mux.Vars(r)["gname"], mux.Vars(r)["domain"]
+	if err := s.netRelay.RemoveDomain(group, domain); err != nil {
+		s.logger.Debugf("relay domain: remove %s/%s: %v", group, domain, err)
+		s.logger.Error("relay domain: remove domain")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, nil)
+}
+
+func (s *server) relayAllowlistGetHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["gname"]
+	a, err := s.netRelay.Allowlist(group)
+	if err != nil {
+		s.logger.Debugf("relay allowlist: %s: %v", group, err)
+		s.logger.Error("relay allowlist: get allowlist")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, a)
+}
+
+// relayAllowlistPutHandler replaces the hosts and ports the domains of a
+// group may be mapped to. Mappings already registered are checked again
+// when requests are forwarded.
+func (s *server) relayAllowlistPutHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["gname"]
+
+	var req relayAllowlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("relay allowlist: decode request: %v", err)
+		s.logger.Error("relay allowlist: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	a := netrelay.Allowlist{Group: group, Hosts: req.Hosts, Ports: req.Ports}
+	if err := s.netRelay.SetAllowlist(a); err != nil {
+		s.logger.Debugf("relay allowlist: set %s: %v", group, err)
+		s.logger.Error("relay allowlist: set allowlist")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, a)
+}
+
+func (s *server) relayServicesHandler(w http.ResponseWriter, r *http.Request) {
+	services, err := s.netRelay.Services()
+	if err != nil {
+		s.logger.Debugf("relay services: %v", err)
+		s.logger.Error("relay services: list services")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, relayServicesResponse{Services: services})
+}
+
+func (s *server) relayServiceGetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	svc, err := s.netRelay.TCPService(name)
+	if err != nil {
+		if !errors.Is(err, netrelay.ErrServiceNotFound) {
+			s.logger.Debugf("relay service: %s: %v", name, err)
+			s.logger.Error("relay service: get service")
+		}
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, svc)
+}
+
+// relayServicePutHandler exposes a tcp service of this node to the peers
+// its access policy allows.
+func (s *server) relayServicePutHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req relayServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("relay service: decode request: %v", err)
+		s.logger.Error("relay service: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+
+	svc := netrelay.TCPService{Name: name, Addr: req.Addr, Groups: req.Groups, Peers: req.Peers}
+	if err := s.netRelay.ExposeService(svc); err != nil {
+		s.logger.Debugf("relay service: expose %s: %v", name, err)
+		s.logger.Error("relay service: expose service")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, svc)
+}
+
+func (s *server) relayServiceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := s.netRelay.RemoveService(name); err != nil {
+		s.logger.Debugf("relay service: remove %s: %v", name, err)
+		s.logger.Error("relay service: remove service")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, nil)
+}
+
+func (s *server) relayForwardsHandler(w http.ResponseWriter, r *http.Request) {
+	jsonhttp.OK(w, relayForwardsResponse{Forwards: s.netRelay.Forwards()})
+}
+
+// relayForwardPostHandler opens a local listener whose connections are
+// tunneled to a service of the target node.
+func (s *server) relayForwardPostHandler(w http.ResponseWriter, r *http.Request) {
+	var req relayForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("relay forward: decode request: %v", err)
+		s.logger.Error("relay forward: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+	if req.Listen == "" {
+		req.Listen = "127.0.0.1:0"
+	}
+
+	f, err := s.netRelay.OpenForward(req.Listen, req.Target, req.Service, time.Duration(req.Lease)*time.Second)
+	if err != nil {
+		s.logger.Debugf("relay forward: open %s to %s/%s: %v", req.Listen, req.Target, req.Service, err)
+		s.logger.Error("relay forward: open forward")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.Created(w, f)
+}
+
+// relayForwardPutHandler renews the lease of a forward.
+func (s *server) relayForwardPutHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.netRelay.RenewForward(id); err != nil {
+		s.logger.Debugf("relay forward: renew %s: %v", id, err)
+		s.logger.Error("relay forward: renew forward")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, nil)
+}
+
+func (s *server) relayForwardDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.netRelay.CloseForward(id); err != nil {
+		s.logger.Debugf("relay forward: close %s: %v", id, err)
+		s.logger.Error("relay forward: close forward")
+		s.relayError(w, err)
+		return
+	}
+	jsonhttp.OK(w, nil)
 }
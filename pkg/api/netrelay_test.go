@@ -0,0 +1,180 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/api"
+	"github.com/FavorLabs/favorX/pkg/netrelay"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	statestore "github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+type relayServicesResponse struct {
+	Services []netrelay.TCPService `json:"services"`
+}
+
+type relayForwardsResponse struct {
+	Forwards []netrelay.Forward `json:"forwards"`
+}
+
+// newNetRelay returns a relay service without peers that is closed with
+// the test.
+func newNetRelay(t *testing.T) *netrelay.Service {
+	t.Helper()
+	s := netrelay.New(nil, logging.New(io.Discard, 0), statestore.NewStateStore(), nil, nil, nil)
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s
+}
+
+func TestRelayServices(t *testing.T) {
+	client := newTestServer(t, testServerOptions{NetRelay: newNetRelay(t)})
+	peer := boson.MustParseHexAddress("ca1e")
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/services/db", http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodPut, "/v1/netrelay/services/db", http.StatusOK,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{
+			"addr":  "127.0.0.1:5432",
+			"peers": []boson.Address{peer},
+		}),
+		jsonhttptest.WithExpectedJSONResponse(netrelay.TCPService{
+			Name:  "db",
+			Addr:  "127.0.0.1:5432",
+			Peers: []boson.Address{peer},
+		}),
+	)
+	var svc netrelay.TCPService
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/services/db", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&svc),
+	)
+	if svc.Addr != "127.0.0.1:5432" || len(svc.Peers) != 1 || !svc.Peers[0].Equal(peer) {
+		t.Fatalf("got service %+v", svc)
+	}
+	var list relayServicesResponse
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/services", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&list),
+	)
+	if len(list.Services) != 1 || list.Services[0].Name != "db" {
+		t.Fatalf("got services %+v", list)
+	}
+
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/netrelay/services/db", http.StatusOK)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/services/db", http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/netrelay/services/db", http.StatusNotFound)
+}
+
+func TestRelayServicesBadRequest(t *testing.T) {
+	client := newTestServer(t, testServerOptions{NetRelay: newNetRelay(t)})
+
+	jsonhttptest.Request(t, client, http.MethodPut, "/v1/netrelay/services/db", http.StatusBadRequest,
+		jsonhttptest.WithJSONRequestBody("db"),
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "invalid request",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPut, "/v1/netrelay/services/db", http.StatusBadRequest,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{"addr": "localhost"}),
+	)
+}
+
+func TestRelayForwards(t *testing.T) {
+	client := newTestServer(t, testServerOptions{NetRelay: newNetRelay(t)})
+	target := boson.MustParseHexAddress("be1e")
+
+	var f netrelay.Forward
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/netrelay/forwards", http.StatusCreated,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{
+			"target":  target,
+			"service": "echo",
+			"lease":   60,
+		}),
+		jsonhttptest.WithUnmarshalJSONResponse(&f),
+	)
+	if f.ID == "" || f.Listen == "" || !f.Target.Equal(target) || f.Service != "echo" || f.Lease != 60 {
+		t.Fatalf("got forward %+v", f)
+	}
+	var list relayForwardsResponse
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/forwards", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&list),
+	)
+	if len(list.Forwards) != 1 || list.Forwards[0].ID != f.ID {
+		t.Fatalf("got forwards %+v", list)
+	}
+
+	jsonhttptest.Request(t, client, http.MethodPut, "/v1/netrelay/forwards/"+f.ID, http.StatusOK)
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/netrelay/forwards/"+f.ID, http.StatusOK)
+	jsonhttptest.Request(t, client, http.MethodPut, "/v1/netrelay/forwards/"+f.ID, http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/netrelay/forwards/"+f.ID, http.StatusNotFound)
+}
+
+func TestRelayForwardsBadRequest(t *testing.T) {
+	client := newTestServer(t, testServerOptions{NetRelay: newNetRelay(t)})
+	target := boson.MustParseHexAddress("be1e")
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/netrelay/forwards", http.StatusBadRequest,
+		jsonhttptest.WithJSONRequestBody("forward"),
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "invalid request",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/netrelay/forwards", http.StatusBadRequest,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{"target": target}),
+	)
+
+	// only loopback addresses are listened on
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/netrelay/forwards", http.StatusForbidden,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{
+			"listen":  ":0",
+			"target":  target,
+			"service": "echo",
+		}),
+	)
+}
+
+func TestRelayRestricted(t *testing.T) {
+	client := newTestServer(t, testServerOptions{NetRelay: newNetRelay(t), Restricted: true})
+
+	for _, tc := range []struct {
+		role           string
+		method, path   string
+		wantStatusCode int
+	}{
+		{"", http.MethodGet, "/v1/netrelay/services", http.StatusForbidden},
+		{"consumer", http.MethodGet, "/v1/netrelay/services", http.StatusForbidden},
+		{"creator", http.MethodDelete, "/v1/netrelay/services/db", http.StatusForbidden},
+		{"maintainer", http.MethodGet, "/v1/netrelay/services", http.StatusOK},
+		{"maintainer", http.MethodDelete, "/v1/netrelay/services/db", http.StatusNotFound},
+		{"", http.MethodGet, "/v1/netrelay/forwards", http.StatusForbidden},
+		{"consumer", http.MethodGet, "/v1/netrelay/forwards", http.StatusForbidden},
+		{"creator", http.MethodDelete, "/v1/netrelay/forwards/unknown", http.StatusForbidden},
+		{"maintainer", http.MethodGet, "/v1/netrelay/forwards", http.StatusOK},
+		{"maintainer", http.MethodPut, "/v1/netrelay/forwards/unknown", http.StatusNotFound},
+	} {
+		var opts []jsonhttptest.Option
+		if tc.role != "" {
+			opts = append(opts, jsonhttptest.WithRequestHeader("Authorization", authToken(t, tc.role)))
+		}
+		jsonhttptest.Request(t, client, tc.method, tc.path, tc.wantStatusCode, opts...)
+	}
+}
+
+func TestRelayGatewayMode(t *testing.T) {
+	client := newTestServer(t, testServerOptions{NetRelay: newNetRelay(t), Options: api.Options{GatewayMode: true}})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/services", http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodPut, "/v1/netrelay/services/db", http.StatusForbidden,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{"addr": "127.0.0.1:5432"}),
+	)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/netrelay/forwards", http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/netrelay/forwards", http.StatusForbidden,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{"service": "echo"}),
+	)
+}
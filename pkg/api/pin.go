@@ -1,9 +1,14 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/FavorLabs/favorX/pkg/pinmeta"
 	"github.com/gauss-project/aurorafs/pkg/boson"
 	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
 	"github.com/gauss-project/aurorafs/pkg/pinning"
@@ -11,6 +16,119 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// maxPinBatch is the maximum number of references of a bulk pin request.
+const maxPinBatch = 1000
+
+type pinBatchRequest struct {
+	References []boson.Address `json:"references"`
+	Name       string          `json:"name"`
+}
+
+type pinBatchResult struct {
+	Reference boson.Address `json:"reference"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Stable messages of the failed entries of pin batches and checks.
+const (
+	pinErrNotFound   = "not found"
+	pinErrCancelled  = "cancelled"
+	pinErrPin        = "pin failed"
+	pinErrUnpin      = "unpin failed"
+	pinErrIncomplete = "chunks missing below an unreadable chunk"
+	pinErrCheck      = "check failed"
+)
+
+// pinErrorMessage maps the error of a pin operation to a stable message,
+// falling back to the given one for unexpected errors.
+func pinErrorMessage(err error, fallback string) string {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return pinErrNotFound
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return pinErrCancelled
+	default:
+		return fallback
+	}
+}
+
+type pinBatchResponse struct {
+	Pins []pinBatchResult `json:"pins"`
+}
+
+type pinListResponse struct {
+	References []boson.Address `json:"references"`
+	Pins       []pinmeta.Meta  `json:"pins"`
+	Total      int             `json:"total"`
+}
+
+// pinnedSize sums the size of the locally stored chunks of ref.
+func (s *server) pinnedSize(ctx context.Context, ref boson.Address) (uint64, error) {
+	var size uint64
+	err := s.traversal.Traverse(ctx, ref, func(addr boson.Address) error {
+		ch, err := s.storer.Get(ctx, storage.ModeGetLookup, addr)
+		if err != nil {
+			return err
+		}
+		size += uint64(len(ch.Data()))
+		return nil
+	})
+	return size, err
+}
+
+// createPin pins ref and records its metadata. Pins that already exist keep
+// their creation time, only a non-empty name replaces the recorded one.
+func (s *server) createPin(ctx context.Context, ref boson.Address, traverse bool, name string) error {
+	if err := s.pinning.CreatePin(ctx, ref, traverse); err != nil {
+		return err
+	}
+
+	m, err := s.pinMeta.Get(ref)
+	if err != nil {
+		return err
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	if name != "" {
+		m.Name = name
+	}
+	m.Size, err = s.pinnedSize(ctx, ref)
+	if err != nil {
+		s.logger.Debugf("pin: size of %s: %v", ref, err)
+	}
+	return s.pinMeta.Put(m)
+}
+
+// deletePin unpins ref and drops its metadata.
+func (s *server) deletePin(ctx context.Context, ref boson.Address) error {
+	if err := s.pinning.DeletePin(ctx, ref); err != nil {
+		return err
+	}
+	s.forgetPin(ref)
+	return nil
+}
+
+// forgetPin drops the metadata and the pinning service ownership of the
+// pin of ref.
+func (s *server) forgetPin(ref boson.Address) {
+	if err := s.pinMeta.Delete(ref); err != nil {
+		s.logger.Debugf("unpin: delete metadata of %s: %v", ref, err)
+	}
+	if err := s.pinService.Disown(ref.String()); err != nil {
+		s.logger.Debugf("unpin: disown %s: %v", ref, err)
+	}
+}
+
+// userPin pins ref for the user. A pin created by the pinning service
+// before becomes the user's, so the service no longer releases it.
+func (s *server) userPin(ctx context.Context, ref boson.Address, name string) error {
+	if err := s.createPin(ctx, ref, true, name); err != nil {
+		return err
+	}
+	return s.pinService.Disown(ref.String())
+}
+
 // pinRootHash pins root hash of given reference. This method is idempotent.
 func (s *server) pinRootHash(w http.ResponseWriter, r *http.Request) {
 	ref, err := boson.ParseHexAddress(mux.Vars(r)["reference"])
@@ -20,6 +138,7 @@ func (s *server) pinRootHash(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.BadRequest(w, "bad address")
 		return
 	}
+	name := r.URL.Query().Get("name")
 
 	has, err := s.pinning.HasPin(ref)
 	if err != nil {
@@ -29,11 +148,26 @@ func (s *server) pinRootHash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if has {
+		err := s.pinService.Disown(ref.String())
+		if err == nil && name != "" {
+			var m pinmeta.Meta
+			m, err = s.pinMeta.Get(ref)
+			if err == nil {
+				m.Name = name
+				err = s.pinMeta.Put(m)
+			}
+		}
+		if err != nil {
+			s.logger.Debugf("pin root hash: update pin %q failed: %v", ref, err)
+			s.logger.Error("pin root hash: update pin failed")
+			jsonhttp.InternalServerError(w, nil)
+			return
+		}
 		jsonhttp.OK(w, nil)
 		return
 	}
 
-	switch err = s.pinning.CreatePin(r.Context(), ref, true); {
+	switch err = s.userPin(r.Context(), ref, name); {
 	case errors.Is(err, storage.ErrNotFound):
 		jsonhttp.NotFound(w, nil)
 		return
@@ -69,7 +203,7 @@ func (s *server) unpinRootHash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch err := s.pinning.DeletePin(r.Context(), ref); {
+	switch err := s.deletePin(r.Context(), ref); {
 	case errors.Is(err, pinning.ErrTraversal):
 		s.logger.Debugf("unpin root hash: deletion of pin for %q failed: %v", ref, err)
 		jsonhttp.InternalServerError(w, nil)
@@ -84,7 +218,8 @@ func (s *server) unpinRootHash(w http.ResponseWriter, r *http.Request) {
 	jsonhttp.OK(w, nil)
 }
 
-// getPinnedRootHash returns the given reference if its root hash is pinned.
+// getPinnedRootHash returns the metadata of the given reference if its root
+// hash is pinned.
 func (s *server) getPinnedRootHash(w http.ResponseWriter, r *http.Request) {
 	ref, err := boson.ParseHexAddress(mux.Vars(r)["reference"])
 	if err != nil {
@@ -107,15 +242,34 @@ func (s *server) getPinnedRootHash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonhttp.OK(w, struct {
-		Reference boson.Address `json:"reference"`
-	}{
-		Reference: ref,
-	})
+	m, err := s.pinMeta.Get(ref)
+	if err != nil {
+		s.logger.Debugf("pinned root hash: unable to get metadata of %q: %v", ref, err)
+		s.logger.Error("pinned root hash: unable to get metadata")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	jsonhttp.OK(w, m)
 }
 
-// listPinnedRootHashes lists all the reference of the pinned root hashes..
+// listPinnedRootHashes lists the pinned root hashes newest first. The list
+// can be paged with the offset and limit query parameters.
 func (s *server) listPinnedRootHashes(w http.ResponseWriter, r *http.Request) {
+	var offset, limit int
+	for name, v := range map[string]*int{"offset": &offset, "limit": &limit} {
+		q := r.URL.Query().Get(name)
+		if q == "" {
+			continue
+		}
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 0 {
+			jsonhttp.BadRequest(w, "invalid "+name)
+			return
+		}
+		*v = n
+	}
+
 	pinned, err := s.pinning.Pins()
 	if err != nil {
 		s.logger.Debugf("list pinned root addresses: unable to list references: %v", err)
@@ -124,9 +278,95 @@ func (s *server) listPinnedRootHashes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonhttp.OK(w, struct {
-		References []boson.Address `json:"references"`
-	}{
-		References: pinned,
+	metas := make([]pinmeta.Meta, 0, len(pinned))
+	for _, ref := range pinned {
+		m, err := s.pinMeta.Get(ref)
+		if err != nil {
+			s.logger.Debugf("list pinned root addresses: unable to get metadata of %q: %v", ref, err)
+			s.logger.Error("list pinned root addresses: unable to get metadata")
+			jsonhttp.InternalServerError(w, nil)
+			return
+		}
+		metas = append(metas, m)
+	}
+
+	page := pinmeta.Page(metas, offset, limit)
+	refs := make([]boson.Address, 0, len(page))
+	for _, m := range page {
+		refs = append(refs, m.Reference)
+	}
+
+	jsonhttp.OK(w, pinListResponse{
+		References: refs,
+		Pins:       page,
+		Total:      len(metas),
 	})
 }
+
+func (s *server) decodePinBatch(w http.ResponseWriter, r *http.Request) (*pinBatchRequest, bool) {
+	var req pinBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("pin batch: decode request: %v", err)
+		s.logger.Error("pin batch: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return nil, false
+	}
+	if len(req.References) == 0 || len(req.References) > maxPinBatch {
+		jsonhttp.BadRequest(w, "invalid number of references")
+		return nil, false
+	}
+	return &req, true
+}
+
+// pinBatchHandler pins all references of the request and reports the
+// result of every reference.
+func (s *server) pinBatchHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodePinBatch(w, r)
+	if !ok {
+		return
+	}
+
+	results := make([]pinBatchResult, 0, len(req.References))
+	for _, ref := range req.References {
+		res := pinBatchResult{Reference: ref}
+		has, err := s.pinning.HasPin(ref)
+		switch {
+		case err == nil && has:
+			err = s.pinService.Disown(ref.String())
+		case err == nil:
+			err = s.userPin(r.Context(), ref, req.Name)
+		}
+		if err != nil {
+			s.logger.Debugf("pin batch: pin %q failed: %v", ref, err)
+			res.Error = pinErrorMessage(err, pinErrPin)
+		}
+		results = append(results, res)
+	}
+
+	jsonhttp.OK(w, pinBatchResponse{Pins: results})
+}
+
+// unpinBatchHandler unpins all references of the request and reports the
+// result of every reference.
+func (s *server) unpinBatchHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodePinBatch(w, r)
+	if !ok {
+		return
+	}
+
+	results := make([]pinBatchResult, 0, len(req.References))
+	for _, ref := range req.References {
+		res := pinBatchResult{Reference: ref}
+		has, err := s.pinning.HasPin(ref)
+		if err == nil && has {
+			err = s.deletePin(r.Context(), ref)
+		}
+		if err != nil {
+			s.logger.Debugf("unpin batch: unpin %q failed: %v", ref, err)
+			res.Error = pinErrorMessage(err, pinErrUnpin)
+		}
+		results = append(results, res)
+	}
+
+	jsonhttp.OK(w, pinBatchResponse{Pins: results})
+}
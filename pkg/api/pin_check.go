@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/cac"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/sctx"
+	"github.com/gauss-project/aurorafs/pkg/soc"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gauss-project/aurorafs/pkg/traversal"
+)
+
+type pinCheckResult struct {
+	Reference boson.Address `json:"reference"`
+	Chunks    int           `json:"chunks"`
+	Missing   int           `json:"missing"`
+	Corrupt   int           `json:"corrupt"`
+	Repaired  int           `json:"repaired"`
+	Error     string        `json:"error,omitempty"`
+}
+
+type pinCheckStatus struct {
+	Running    bool             `json:"running"`
+	Repair     bool             `json:"repair"`
+	StartedAt  time.Time        `json:"startedAt"`
+	FinishedAt time.Time        `json:"finishedAt"`
+	Results    []pinCheckResult `json:"results"`
+}
+
+// pinChecker verifies the chunks of a pinned root while it is traversed.
+// Missing and corrupt chunks are counted and, when repairing, retrieved
+// again from the peers holding the root and pinned.
+type pinChecker struct {
+	storage.Storer
+
+	root   boson.Address
+	repair bool
+
+	mu     sync.Mutex
+	seen   map[string]struct{}
+	result *pinCheckResult
+}
+
+// Get is used by the traversal to load the intermediate chunks of the root.
+func (c *pinChecker) Get(ctx context.Context, _ storage.ModeGet, addr boson.Address) (boson.Chunk, error) {
+	return c.check(ctx, addr)
+}
+
+func (c *pinChecker) check(ctx context.Context, addr boson.Address) (boson.Chunk, error) {
+	c.mu.Lock()
+	_, seen := c.seen[addr.String()]
+	c.seen[addr.String()] = struct{}{}
+	c.mu.Unlock()
+	if seen {
+		return c.Storer.Get(ctx, storage.ModeGetLookup, addr)
+	}
+
+	c.mu.Lock()
+	c.result.Chunks++
+	c.mu.Unlock()
+
+	ch, err := c.Storer.Get(ctx, storage.ModeGetLookup, addr)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.mu.Lock()
+		c.result.Missing++
+		c.mu.Unlock()
+	case err != nil:
+		return nil, err
+	case !cac.Valid(ch) && !soc.Valid(ch):
+		c.mu.Lock()
+		c.result.Corrupt++
+		c.mu.Unlock()
+		if c.repair {
+			if err := c.Storer.Set(ctx, storage.ModeSetRemove, addr); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return ch, nil
+	}
+
+	if !c.repair {
+		return nil, storage.ErrNotFound
+	}
+
+	ch, err = c.Storer.Get(sctx.SetRootHash(ctx, c.root), storage.ModeGetRequest, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Storer.Set(ctx, storage.ModeSetPin, addr); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.result.Repaired++
+	c.mu.Unlock()
+	return ch, nil
+}
+
+// checkPin verifies every chunk reachable from the pinned root.
+func (s *server) checkPin(ctx context.Context, root boson.Address, repair bool) pinCheckResult {
+	result := pinCheckResult{Reference: root}
+	c := &pinChecker{
+		Storer: s.storer,
+		root:   root,
+		repair: repair,
+		seen:   make(map[string]struct{}),
+		result: &result,
+	}
+
+	if repair && !s.chunkInfo.Init(sctx.SetRootHash(ctx, root), nil, root) {
+		s.logger.Debugf("pin check: no sources known for %s", root)
+	}
+
+	err := traversal.New(c).Traverse(ctx, root, func(addr boson.Address) error {
+		// a missing data chunk is counted, the others are checked still
+		if _, err := c.check(ctx, addr); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		// chunks below a missing intermediate chunk can not be checked
+		s.logger.Debugf("pin check: check %s: %v", root, err)
+		if errors.Is(err, storage.ErrNotFound) {
+			result.Error = pinErrIncomplete
+		} else {
+			result.Error = pinErrorMessage(err, pinErrCheck)
+		}
+	}
+	return result
+}
+
+// runPinCheck checks every pinned root and records the results in the
+// status of the current job.
+func (s *server) runPinCheck(status *pinCheckStatus, refs []boson.Address) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for _, ref := range refs {
+		if ctx.Err() != nil {
+			break
+		}
+		result := s.checkPin(ctx, ref, status.Repair)
+		if result.Missing > 0 || result.Corrupt > 0 || result.Error != "" {
+			s.logger.Infof("pin check: %s has %d missing and %d corrupt chunks, %d repaired", ref, result.Missing, result.Corrupt, result.Repaired)
+		}
+
+		s.pinCheckMu.Lock()
+		status.Results = append(status.Results, result)
+		s.pinCheckMu.Unlock()
+	}
+
+	s.pinCheckMu.Lock()
+	status.Running = false
+	status.FinishedAt = time.Now()
+	s.pinCheckMu.Unlock()
+}
+
+// pinCheckStartHandler starts checking all pinned roots in the background.
+// Missing and corrupt chunks are re-fetched from peers with repair=true.
+func (s *server) pinCheckStartHandler(w http.ResponseWriter, r *http.Request) {
+	repair := strings.ToLower(r.URL.Query().Get("repair")) == StringTrue
+
+	refs, err := s.pinning.Pins()
+	if err != nil {
+		s.logger.Debugf("pin check: unable to list references: %v", err)
+		s.logger.Error("pin check: unable to list references")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	s.pinCheckMu.Lock()
+	defer s.pinCheckMu.Unlock()
+	if s.pinCheck != nil && s.pinCheck.Running {
+		jsonhttp.Conflict(w, "pin check already running")
+		return
+	}
+
+	s.pinCheck = &pinCheckStatus{
+		Running:   true,
+		Repair:    repair,
+		StartedAt: time.Now(),
+		Results:   make([]pinCheckResult, 0, len(refs)),
+	}
+	go s.runPinCheck(s.pinCheck, refs)
+
+	jsonhttp.Accepted(w, s.pinCheck)
+}
+
+// pinCheckStatusHandler returns the progress or the results of the last check.
+func (s *server) pinCheckStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s.pinCheckMu.Lock()
+	defer s.pinCheckMu.Unlock()
+	if s.pinCheck == nil {
+		jsonhttp.NotFound(w, "no pin check has been run")
+		return
+	}
+	jsonhttp.OK(w, s.pinCheck)
+}
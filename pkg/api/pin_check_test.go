@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/file/loadsave"
+	"github.com/gauss-project/aurorafs/pkg/file/pipeline"
+	"github.com/gauss-project/aurorafs/pkg/file/pipeline/builder"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+	"github.com/gauss-project/aurorafs/pkg/manifest"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gauss-project/aurorafs/pkg/traversal"
+)
+
+type pinCheckStatus struct {
+	Running bool `json:"running"`
+	Repair  bool `json:"repair"`
+	Results []struct {
+		Reference boson.Address `json:"reference"`
+		Chunks    int           `json:"chunks"`
+		Missing   int           `json:"missing"`
+		Corrupt   int           `json:"corrupt"`
+		Repaired  int           `json:"repaired"`
+		Error     string        `json:"error,omitempty"`
+	} `json:"results"`
+}
+
+// storeCollection stores a manifest with a file of size random bytes in
+// store and returns the references of the manifest and the file.
+func storeCollection(t *testing.T, store storage.Storer, size int) (boson.Address, boson.Address) {
+	t.Helper()
+	ctx := context.Background()
+	file := storeFile(t, store, size)
+	m, err := manifest.NewDefaultManifest(loadsave.New(store, func() pipeline.Interface {
+		return builder.NewPipelineBuilder(ctx, store, storage.ModePutUpload, false)
+	}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(ctx, "file", manifest.NewEntry(file, nil)); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := m.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref, file
+}
+
+func TestPinCheck(t *testing.T) {
+	ctx := context.Background()
+	store := newStorer()
+	ref, file := storeCollection(t, store, 2*boson.ChunkSize+100)
+
+	// one of the three data chunks of the file is lost
+	var chunks int
+	if err := traversal.New(store).Traverse(ctx, ref, func(boson.Address) error {
+		chunks++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var leaf boson.Address
+	if err := traversal.New(store).Traverse(ctx, file, func(addr boson.Address) error {
+		if !addr.Equal(file) {
+			leaf = addr
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(ctx, storage.ModeSetRemove, leaf); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestServer(t, testServerOptions{Storer: store})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/check", http.StatusNotFound,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "no pin check has been run",
+			Code:    http.StatusNotFound,
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/"+ref.String(), http.StatusCreated)
+
+	var status pinCheckStatus
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/check", http.StatusAccepted,
+		jsonhttptest.WithUnmarshalJSONResponse(&status),
+	)
+	if !status.Running || status.Repair {
+		t.Fatalf("got status %+v", status)
+	}
+	for deadline := time.Now().Add(5 * time.Second); status.Running; {
+		if time.Now().After(deadline) {
+			t.Fatal("pin check did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+		jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/check", http.StatusOK,
+			jsonhttptest.WithUnmarshalJSONResponse(&status),
+		)
+	}
+	if len(status.Results) != 1 {
+		t.Fatalf("got %d results", len(status.Results))
+	}
+	if r := status.Results[0]; !r.Reference.Equal(ref) || r.Chunks != chunks || r.Missing != 1 || r.Corrupt != 0 || r.Error != "" {
+		t.Fatalf("got result %+v", r)
+	}
+}
+
+func TestPinCheckRestricted(t *testing.T) {
+	client := newTestServer(t, testServerOptions{Restricted: true})
+
+	// creators manage the pins under /pins/* and may check them
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/check", http.StatusForbidden,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "consumer")),
+	)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/check", http.StatusAccepted,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "creator")),
+	)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/check", http.StatusOK,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "maintainer")),
+	)
+}
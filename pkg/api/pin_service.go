@@ -0,0 +1,378 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/pinsvc"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/sctx"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+type pinServiceListResponse struct {
+	Count   int             `json:"count"`
+	Results []pinsvc.Status `json:"results"`
+}
+
+// pinServiceWorker processes queued pin requests one at a time, oldest
+// first, until none is left or the server is closed. Requests interrupted by
+// a restart are picked up again.
+func (s *server) pinServiceWorker() {
+	s.wakePinService()
+
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		for {
+			select {
+			case <-s.quit:
+				return
+			case <-s.pinServiceWake:
+			}
+
+			for more := true; more; {
+				count, pending, err := s.pinService.List(pinsvc.Filter{
+					Statuses: []string{pinsvc.StatusQueued, pinsvc.StatusPinning},
+					Limit:    pinsvc.MaxLimit,
+					Oldest:   true,
+				})
+				if err != nil {
+					s.logger.Errorf("pin service: list pending requests: %v", err)
+					break
+				}
+				more = count > len(pending)
+
+				for _, st := range pending {
+					select {
+					case <-s.quit:
+						return
+					default:
+					}
+					if err := s.processPinRequest(ctx, st.RequestID); err != nil {
+						// the request is left pending, try again on the next wake up
+						s.logger.Errorf("pin service: update request %s: %v", st.RequestID, err)
+						more = false
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (s *server) wakePinService() {
+	select {
+	case s.pinServiceWake <- struct{}{}:
+	default:
+	}
+}
+
+// processPinRequest retrieves every chunk of the requested reference from
+// the network and pins it. A request removed meanwhile has its pin released
+// again. It fails only if the request can not be updated.
+func (s *server) processPinRequest(ctx context.Context, id string) error {
+	s.pinServiceMu.Lock()
+	st, err := s.pinService.Get(id)
+	if err != nil {
+		s.pinServiceMu.Unlock()
+		// deleted while queued
+		return nil
+	}
+	st.Status = pinsvc.StatusPinning
+	err = s.pinService.Put(st)
+	s.pinServiceMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = s.fetchAndPin(ctx, st.Pin)
+	if err != nil {
+		s.logger.Debugf("pin service: pin %s: %v", st.Pin.Cid, err)
+		st.Status = pinsvc.StatusFailed
+		st.Info = map[string]string{"reason": err.Error()}
+	} else {
+		st.Status = pinsvc.StatusPinned
+		st.Info = nil
+	}
+
+	s.pinServiceMu.Lock()
+	defer s.pinServiceMu.Unlock()
+	// the request may have been removed while it was processed
+	if _, err := s.pinService.Get(id); errors.Is(err, storage.ErrNotFound) {
+		if err := s.releasePin(ctx, st); err != nil {
+			s.logger.Debugf("pin service: release %s: %v", st.Pin.Cid, err)
+		}
+		return nil
+	}
+	return s.pinService.Put(st)
+}
+
+func (s *server) fetchAndPin(ctx context.Context, pin pinsvc.Pin) error {
+	ref, err := boson.ParseHexAddress(pin.Cid)
+	if err != nil {
+		return err
+	}
+
+	ctx = sctx.SetRootHash(ctx, ref)
+	if !s.chunkInfo.Init(ctx, nil, ref) {
+		return errors.New("no peer holds the reference")
+	}
+
+	err = s.traversal.Traverse(ctx, ref, func(addr boson.Address) error {
+		_, err := s.storer.Get(ctx, storage.ModeGetRequest, addr)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// a pin the user created already is left to the user
+	has, err := s.pinning.HasPin(ref)
+	if err != nil {
+		return err
+	}
+	if has {
+		return nil
+	}
+	if err := s.pinService.Own(pin.Cid); err != nil {
+		return err
+	}
+	return s.createPin(ctx, ref, true, pin.Name)
+}
+
+// releasePin unpins the reference of a removed request unless another
+// request still refers to it. Only pins created by the service are removed,
+// pins of the user are left alone. It must be called with pinServiceMu held.
+func (s *server) releasePin(ctx context.Context, st pinsvc.Status) error {
+	if st.Status != pinsvc.StatusPinned {
+		return nil
+	}
+	count, _, err := s.pinService.List(pinsvc.Filter{
+		Cids:     []string{st.Pin.Cid},
+		Statuses: []string{pinsvc.StatusQueued, pinsvc.StatusPinning, pinsvc.StatusPinned},
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	owned, err := s.pinService.Owns(st.Pin.Cid)
+	if err != nil || !owned {
+		return err
+	}
+	ref, err := boson.ParseHexAddress(st.Pin.Cid)
+	if err != nil {
+		return err
+	}
+	has, err := s.pinning.HasPin(ref)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return s.pinService.Disown(st.Pin.Cid)
+	}
+	return s.deletePin(ctx, ref)
+}
+
+func (s *server) decodePinServicePin(w http.ResponseWriter, r *http.Request) (pinsvc.Pin, bool) {
+	var pin pinsvc.Pin
+	if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+		s.logger.Debugf("pin service: decode pin: %v", err)
+		s.logger.Error("pin service: decode pin")
+		jsonhttp.BadRequest(w, "invalid pin")
+		return pin, false
+	}
+	if _, err := boson.ParseHexAddress(pin.Cid); err != nil {
+		jsonhttp.BadRequest(w, "invalid cid")
+		return pin, false
+	}
+	return pin, true
+}
+
+func (s *server) queuePinRequest(pin pinsvc.Pin) (pinsvc.Status, error) {
+	id, err := pinsvc.NewRequestID()
+	if err != nil {
+		return pinsvc.Status{}, err
+	}
+	st := pinsvc.Status{
+		RequestID: id,
+		Status:    pinsvc.StatusQueued,
+		Created:   time.Now().UTC(),
+		Pin:       pin,
+		Delegates: []string{s.overlay.String()},
+	}
+	if err := s.pinService.Put(st); err != nil {
+		return pinsvc.Status{}, err
+	}
+	s.wakePinService()
+	return st, nil
+}
+
+func (s *server) pinServiceListHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := pinsvc.Filter{
+		Name:  q.Get("name"),
+		Match: q.Get("match"),
+	}
+	if v := q.Get("cid"); v != "" {
+		f.Cids = strings.Split(v, ",")
+	}
+	if v := q.Get("status"); v != "" {
+		f.Statuses = strings.Split(v, ",")
+		for _, st := range f.Statuses {
+			if !pinsvc.ValidStatus(st) {
+				jsonhttp.BadRequest(w, "invalid status")
+				return
+			}
+		}
+	}
+	for name, t := range map[string]*time.Time{"before": &f.Before, "after": &f.After} {
+		if v := q.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				jsonhttp.BadRequest(w, "invalid "+name)
+				return
+			}
+			*t = parsed
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > pinsvc.MaxLimit {
+			jsonhttp.BadRequest(w, "invalid limit")
+			return
+		}
+		f.Limit = limit
+	}
+
+	count, results, err := s.pinService.List(f)
+	if err != nil {
+		s.logger.Debugf("pin service: list requests: %v", err)
+		s.logger.Error("pin service: list requests")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	jsonhttp.OK(w, pinServiceListResponse{Count: count, Results: results})
+}
+
+func (s *server) pinServiceAddHandler(w http.ResponseWriter, r *http.Request) {
+	pin, ok := s.decodePinServicePin(w, r)
+	if !ok {
+		return
+	}
+
+	st, err := s.queuePinRequest(pin)
+	if err != nil {
+		s.logger.Debugf("pin service: queue request: %v", err)
+		s.logger.Error("pin service: queue request")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	jsonhttp.Accepted(w, st)
+}
+
+func (s *server) pinServiceGetHandler(w http.ResponseWriter, r *http.Request) {
+	st, err := s.pinService.Get(mux.Vars(r)["requestid"])
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+	if err != nil {
+		s.logger.Debugf("pin service: get request: %v", err)
+		s.logger.Error("pin service: get request")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	jsonhttp.OK(w, st)
+}
+
+// pinServiceReplaceHandler replaces a request with a new one for the given
+// pin. The old request is removed and its reference released once nothing
+// refers to it anymore.
+func (s *server) pinServiceReplaceHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["requestid"]
+	old, err := s.pinService.Get(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+	if err != nil {
+		s.logger.Debugf("pin service: get request: %v", err)
+		s.logger.Error("pin service: get request")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	pin, ok := s.decodePinServicePin(w, r)
+	if !ok {
+		return
+	}
+
+	s.pinServiceMu.Lock()
+	st, err := s.queuePinRequest(pin)
+	if err == nil {
+		err = s.pinService.Delete(id)
+	}
+	if err != nil {
+		s.pinServiceMu.Unlock()
+		s.logger.Debugf("pin service: replace request %s: %v", id, err)
+		s.logger.Error("pin service: replace request")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if old.Pin.Cid != pin.Cid {
+		if err := s.releasePin(r.Context(), old); err != nil {
+			s.logger.Debugf("pin service: release %s: %v", old.Pin.Cid, err)
+		}
+	}
+	s.pinServiceMu.Unlock()
+
+	jsonhttp.Accepted(w, st)
+}
+
+func (s *server) pinServiceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["requestid"]
+	s.pinServiceMu.Lock()
+	defer s.pinServiceMu.Unlock()
+	st, err := s.pinService.Get(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+	if err == nil {
+		err = s.pinService.Delete(id)
+	}
+	if err != nil {
+		s.logger.Debugf("pin service: delete request %s: %v", id, err)
+		s.logger.Error("pin service: delete request")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	// a request still pinning releases its pin once it is done
+	if err := s.releasePin(r.Context(), st); err != nil {
+		s.logger.Debugf("pin service: release %s: %v", st.Pin.Cid, err)
+	}
+
+	jsonhttp.Accepted(w, nil)
+}
@@ -0,0 +1,168 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/pinsvc"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+)
+
+type pinServiceListResponse struct {
+	Count   int             `json:"count"`
+	Results []pinsvc.Status `json:"results"`
+}
+
+// waitPinRequest polls the request with id until it is processed.
+func waitPinRequest(t *testing.T, client *http.Client, id string) pinsvc.Status {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var st pinsvc.Status
+		jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins/"+id, http.StatusOK,
+			jsonhttptest.WithUnmarshalJSONResponse(&st),
+		)
+		if st.Status != pinsvc.StatusQueued && st.Status != pinsvc.StatusPinning {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("request %s is still %s", id, st.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPinService(t *testing.T) {
+	store := newStorer()
+	ref := storeFile(t, store, 100)
+	client := newTestServer(t, testServerOptions{Storer: store})
+
+	var queued pinsvc.Status
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pinning/pins", http.StatusAccepted,
+		jsonhttptest.WithJSONRequestBody(pinsvc.Pin{Cid: ref.String(), Name: "doc"}),
+		jsonhttptest.WithUnmarshalJSONResponse(&queued),
+	)
+	if queued.RequestID == "" || queued.Status != pinsvc.StatusQueued || queued.Pin.Cid != ref.String() {
+		t.Fatalf("got request %+v", queued)
+	}
+	if st := waitPinRequest(t, client, queued.RequestID); st.Status != pinsvc.StatusPinned {
+		t.Fatalf("got request %+v", st)
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/"+ref.String(), http.StatusOK)
+
+	var list pinServiceListResponse
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins?status=pinned&name=DO&match=ipartial", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&list),
+	)
+	if list.Count != 1 || len(list.Results) != 1 || list.Results[0].RequestID != queued.RequestID {
+		t.Fatalf("got list %+v", list)
+	}
+
+	// the reference is released with the last request referring to it
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/pinning/pins/"+queued.RequestID, http.StatusAccepted)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins/"+queued.RequestID, http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/"+ref.String(), http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/pinning/pins/"+queued.RequestID, http.StatusNotFound)
+}
+
+func TestPinServiceUserPin(t *testing.T) {
+	store := newStorer()
+	ref := storeFile(t, store, 100)
+	client := newTestServer(t, testServerOptions{Storer: store})
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/"+ref.String(), http.StatusCreated)
+	var queued pinsvc.Status
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pinning/pins", http.StatusAccepted,
+		jsonhttptest.WithJSONRequestBody(pinsvc.Pin{Cid: ref.String()}),
+		jsonhttptest.WithUnmarshalJSONResponse(&queued),
+	)
+	waitPinRequest(t, client, queued.RequestID)
+
+	// the pin of the user is left alone
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/pinning/pins/"+queued.RequestID, http.StatusAccepted)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/"+ref.String(), http.StatusOK)
+}
+
+func TestPinServiceReplace(t *testing.T) {
+	store := newStorer()
+	first, second := storeFile(t, store, 100), storeFile(t, store, 200)
+	client := newTestServer(t, testServerOptions{Storer: store})
+
+	var queued, replaced pinsvc.Status
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pinning/pins", http.StatusAccepted,
+		jsonhttptest.WithJSONRequestBody(pinsvc.Pin{Cid: first.String()}),
+		jsonhttptest.WithUnmarshalJSONResponse(&queued),
+	)
+	waitPinRequest(t, client, queued.RequestID)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pinning/pins/"+queued.RequestID, http.StatusAccepted,
+		jsonhttptest.WithJSONRequestBody(pinsvc.Pin{Cid: second.String()}),
+		jsonhttptest.WithUnmarshalJSONResponse(&replaced),
+	)
+	if replaced.RequestID == queued.RequestID {
+		t.Fatal("replacing kept the request id")
+	}
+	if st := waitPinRequest(t, client, replaced.RequestID); st.Status != pinsvc.StatusPinned {
+		t.Fatalf("got request %+v", st)
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins/"+queued.RequestID, http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/"+first.String(), http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/"+second.String(), http.StatusOK)
+}
+
+func TestPinServiceFailed(t *testing.T) {
+	client := newTestServer(t, testServerOptions{})
+
+	// nothing of the reference is held or retrievable
+	var queued pinsvc.Status
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pinning/pins", http.StatusAccepted,
+		jsonhttptest.WithJSONRequestBody(pinsvc.Pin{Cid: test.RandomAddress().String()}),
+		jsonhttptest.WithUnmarshalJSONResponse(&queued),
+	)
+	if st := waitPinRequest(t, client, queued.RequestID); st.Status != pinsvc.StatusFailed || st.Info["reason"] == "" {
+		t.Fatalf("got request %+v", st)
+	}
+}
+
+func TestPinServiceBadRequest(t *testing.T) {
+	client := newTestServer(t, testServerOptions{})
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+		message      string
+	}{
+		{http.MethodPost, "/v1/pinning/pins", pinsvc.Pin{Cid: "not-an-address"}, "invalid cid"},
+		{http.MethodPost, "/v1/pinning/pins", "pin", "invalid pin"},
+		{http.MethodGet, "/v1/pinning/pins?status=lost", nil, "invalid status"},
+		{http.MethodGet, "/v1/pinning/pins?limit=0", nil, "invalid limit"},
+		{http.MethodGet, "/v1/pinning/pins?before=yesterday", nil, "invalid before"},
+	} {
+		opts := []jsonhttptest.Option{
+			jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+				Message: tc.message,
+				Code:    http.StatusBadRequest,
+			}),
+		}
+		if tc.body != nil {
+			opts = append(opts, jsonhttptest.WithJSONRequestBody(tc.body))
+		}
+		jsonhttptest.Request(t, client, tc.method, tc.path, http.StatusBadRequest, opts...)
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins/unknown", http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pinning/pins/unknown", http.StatusNotFound,
+		jsonhttptest.WithJSONRequestBody(pinsvc.Pin{Cid: test.RandomAddress().String()}),
+	)
+}
+
+func TestPinServiceRestricted(t *testing.T) {
+	client := newTestServer(t, testServerOptions{Restricted: true})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins", http.StatusForbidden,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "creator")),
+	)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pinning/pins", http.StatusOK,
+		jsonhttptest.WithRequestHeader("Authorization", authToken(t, "maintainer")),
+	)
+}
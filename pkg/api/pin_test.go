@@ -0,0 +1,156 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/api"
+	"github.com/FavorLabs/favorX/pkg/pinmeta"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp/jsonhttptest"
+)
+
+type pinListResponse struct {
+	References []boson.Address `json:"references"`
+	Pins       []pinmeta.Meta  `json:"pins"`
+	Total      int             `json:"total"`
+}
+
+type pinBatchResponse struct {
+	Pins []struct {
+		Reference boson.Address `json:"reference"`
+		Error     string        `json:"error,omitempty"`
+	} `json:"pins"`
+}
+
+func TestPin(t *testing.T) {
+	store := newStorer()
+	ref := storeFile(t, store, 100)
+	client := newTestServer(t, testServerOptions{Storer: store})
+	resource := "/v1/pins/" + ref.String()
+
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/not-an-address", http.StatusBadRequest,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "bad address",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+
+	jsonhttptest.Request(t, client, http.MethodPost, resource+"?name=doc", http.StatusCreated)
+	var m pinmeta.Meta
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&m),
+	)
+	if !m.Reference.Equal(ref) || m.Name != "doc" || m.Size != boson.SpanSize+100 || m.CreatedAt.IsZero() {
+		t.Fatalf("got metadata %+v", m)
+	}
+
+	// pinning again only renames the pin
+	jsonhttptest.Request(t, client, http.MethodPost, resource+"?name=renamed", http.StatusOK)
+	var renamed pinmeta.Meta
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&renamed),
+	)
+	if renamed.Name != "renamed" || !renamed.CreatedAt.Equal(m.CreatedAt) {
+		t.Fatalf("got metadata %+v, created %s", renamed, m.CreatedAt)
+	}
+
+	var list pinListResponse
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&list),
+	)
+	if list.Total != 1 || len(list.References) != 1 || !list.References[0].Equal(ref) {
+		t.Fatalf("got list %+v", list)
+	}
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins?limit=-1", http.StatusBadRequest,
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "invalid limit",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+
+	jsonhttptest.Request(t, client, http.MethodDelete, resource, http.StatusOK)
+	jsonhttptest.Request(t, client, http.MethodGet, resource, http.StatusNotFound)
+	jsonhttptest.Request(t, client, http.MethodDelete, resource, http.StatusNotFound)
+}
+
+func TestPinBatch(t *testing.T) {
+	store := newStorer()
+	first, second := storeFile(t, store, 100), storeFile(t, store, 200)
+	client := newTestServer(t, testServerOptions{Storer: store})
+
+	var resp pinBatchResponse
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins", http.StatusOK,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{
+			"references": []boson.Address{first, second},
+			"name":       "batch",
+		}),
+		jsonhttptest.WithUnmarshalJSONResponse(&resp),
+	)
+	if len(resp.Pins) != 2 || resp.Pins[0].Error != "" || resp.Pins[1].Error != "" {
+		t.Fatalf("got response %+v", resp)
+	}
+	var list pinListResponse
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins", http.StatusOK,
+		jsonhttptest.WithUnmarshalJSONResponse(&list),
+	)
+	if list.Total != 2 || list.Pins[0].Name != "batch" {
+		t.Fatalf("got list %+v", list)
+	}
+
+	jsonhttptest.Request(t, client, http.MethodDelete, "/v1/pins", http.StatusOK,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{
+			"references": []boson.Address{first, second},
+		}),
+	)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins", http.StatusOK,
+		jsonhttptest.WithExpectedJSONResponse(pinListResponse{
+			References: []boson.Address{},
+			Pins:       []pinmeta.Meta{},
+		}),
+	)
+
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins", http.StatusBadRequest,
+		jsonhttptest.WithJSONRequestBody(map[string]interface{}{
+			"references": []boson.Address{},
+		}),
+		jsonhttptest.WithExpectedJSONResponse(jsonhttp.StatusResponse{
+			Message: "invalid number of references",
+			Code:    http.StatusBadRequest,
+		}),
+	)
+}
+
+func TestPinRestricted(t *testing.T) {
+	ref := test.RandomAddress()
+	client := newTestServer(t, testServerOptions{Restricted: true})
+
+	for _, tc := range []struct {
+		role           string
+		method, path   string
+		wantStatusCode int
+	}{
+		{"", http.MethodGet, "/v1/pins", http.StatusForbidden},
+		{"consumer", http.MethodPost, "/v1/pins/" + ref.String(), http.StatusForbidden},
+		{"creator", http.MethodPost, "/v1/pins/" + ref.String(), http.StatusCreated},
+		{"creator", http.MethodGet, "/v1/pins", http.StatusForbidden},
+		{"maintainer", http.MethodGet, "/v1/pins", http.StatusOK},
+	} {
+		var opts []jsonhttptest.Option
+		if tc.role != "" {
+			opts = append(opts, jsonhttptest.WithRequestHeader("Authorization", authToken(t, tc.role)))
+		}
+		jsonhttptest.Request(t, client, tc.method, tc.path, tc.wantStatusCode, opts...)
+	}
+}
+
+func TestPinGatewayMode(t *testing.T) {
+	client := newTestServer(t, testServerOptions{Options: api.Options{GatewayMode: true}})
+
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins", http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodPost, "/v1/pins/"+test.RandomAddress().String(), http.StatusForbidden)
+	jsonhttptest.Request(t, client, http.MethodGet, "/v1/pins/check", http.StatusForbidden)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/FavorLabs/favorX/pkg/batchreg"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gorilla/mux"
+)
+
+// maxRegisterReferences limits the references of one registration request.
+const maxRegisterReferences = 10000
+
+type fileRegisterBatchRequest struct {
+	References []string `json:"references"`
+	All        bool     `json:"all"`
+}
+
+// fileRegisterBatch starts a job registering the node as a source of the
+// given references, or of all local files, on the oracle.
+func (s *server) fileRegisterBatch(w http.ResponseWriter, r *http.Request) {
+	var req fileRegisterBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Debugf("file register batch: decode request: %v", err)
+		s.logger.Error("file register batch: decode request")
+		jsonhttp.BadRequest(w, "invalid request")
+		return
+	}
+	if req.All == (len(req.References) > 0) {
+		jsonhttp.BadRequest(w, "either references or all required")
+		return
+	}
+	if len(req.References) > maxRegisterReferences {
+		jsonhttp.BadRequest(w, "too many references")
+		return
+	}
+
+	var roots []boson.Address
+	if req.All {
+		_, roots = s.chunkInfo.GetFileList(s.overlay)
+		if len(roots) > maxRegisterReferences {
+			jsonhttp.BadRequest(w, "too many local files, register them by reference")
+			return
+		}
+	} else {
+		roots = make([]boson.Address, 0, len(req.References))
+		for _, ref := range req.References {
+			root, err := s.resolveNameOrAddress(ref)
+			if err != nil {
+				s.logger.Debugf("file register batch: parse address %s: %v", ref, err)
+				jsonhttp.BadRequest(w, "invalid reference "+ref)
+				return
+			}
+			roots = append(roots, root)
+		}
+	}
+
+	job, err := s.registerJobs.Start(roots)
+	if err != nil {
+		if errors.Is(err, batchreg.ErrNoRoots) {
+			jsonhttp.BadRequest(w, "no local files")
+			return
+		}
+		s.logger.Debugf("file register batch: start: %v", err)
+		s.logger.Error("file register batch: start")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.Accepted(w, job)
+}
+
+// lockRegister locks the registration of root, which is either sent by the
+// fileRegister handler or by a registration job.
+func (s *server) lockRegister(root boson.Address) (unlock func(), ok bool) {
+	key := "fileRegister" + root.String()
+	if _, loaded := s.tranProcess.LoadOrStore(key, "-"); loaded {
+		return nil, false
+	}
+	return func() { s.tranProcess.Delete(key) }, true
+}
+
+func (s *server) fileRegisterJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jsonhttp.OK(w, s.registerJobs.Jobs())
+}
+
+func (s *server) fileRegisterJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := s.registerJobs.Job(mux.Vars(r)["id"])
+	if err != nil {
+		jsonhttp.NotFound(w, nil)
+		return
+	}
+	jsonhttp.OK(w, job)
+}
+
+func (s *server) fileRegisterJobCancelHandler(w http.ResponseWriter, r *http.Request) {
+	switch err := s.registerJobs.Cancel(mux.Vars(r)["id"]); {
+	case errors.Is(err, batchreg.ErrNotFound):
+		jsonhttp.NotFound(w, nil)
+	case errors.Is(err, batchreg.ErrNotRunning):
+		jsonhttp.Conflict(w, "job not running")
+	case err != nil:
+		s.logger.Debugf("file register job: cancel: %v", err)
+		s.logger.Error("file register job: cancel")
+		jsonhttp.InternalServerError(w, nil)
+	default:
+		jsonhttp.OK(w, nil)
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/retention"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+type retentionReport struct {
+	Policy     retention.Policy      `json:"policy"`
+	DryRun     bool                  `json:"dryRun"`
+	Files      int                   `json:"files"`
+	TotalSize  uint64                `json:"totalSize"`
+	FreedSize  uint64                `json:"freedSize"`
+	Candidates []retention.Candidate `json:"candidates"`
+}
+
+// registerState returns whether this node is registered on the oracle as a
+// source of the given root, preferring the state cached by the file list.
+func (s *server) registerState(ctx context.Context, root boson.Address) (bool, error) {
+	if v, ok := s.auroraChainSate.Load(root.String()); ok {
+		return v.(bool), nil
+	}
+	registered, err := s.oracleChain.GetRegisterState(ctx, root, s.overlay)
+	if err != nil {
+		return false, err
+	}
+	s.auroraChainSate.Store(root.String(), registered)
+	return registered, nil
+}
+
+// chunkCount returns the chunk count of the file list entry v under key.
+func chunkCount(v map[string]interface{}, key string) (uint64, bool) {
+	switch n := v[key].(type) {
+	case int:
+		return uint64(n), n >= 0
+	case int64:
+		return uint64(n), n >= 0
+	case uint64:
+		return n, true
+	case float64:
+		return uint64(n), n >= 0
+	}
+	return 0, false
+}
+
+// retentionFiles collects the retention view of every file held by the node.
+// Files not seen before are taken as new, and recorded as such if record is
+// set; the dry-run report leaves the state store alone.
+func (s *server) retentionFiles(ctx context.Context, record bool) ([]retention.File, error) {
+	now := time.Now()
+	fileListInfo, _ := s.chunkInfo.GetFileList(s.overlay)
+	files := make([]retention.File, 0, len(fileListInfo))
+	for _, v := range fileListInfo {
+		rootCid, _ := v["rootCid"].(string)
+		root, err := boson.ParseHexAddress(rootCid)
+		if err != nil {
+			continue
+		}
+		fileSize, ok := chunkCount(v, "fileSize")
+		treeSize, ok2 := chunkCount(v, "treeSize")
+		if !ok || !ok2 {
+			s.logger.Debugf("retention: unknown size of %s", root)
+			continue
+		}
+		created, err := s.retention.Created(root)
+		if errors.Is(err, storage.ErrNotFound) {
+			created, err = now, nil
+			if record {
+				err = s.retention.Record(root, now)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		pinned, err := s.pinning.HasPin(root)
+		if err != nil {
+			return nil, err
+		}
+		var registered bool
+		if s.Retention.KeepRegistered {
+			registered, err = s.registerState(ctx, root)
+			if err != nil {
+				// keep the file if the chain can not tell us otherwise
+				s.logger.Debugf("retention: register state of %s: %v", root, err)
+				registered = true
+			}
+		}
+		files = append(files, retention.File{
+			RootCid:    root,
+			Size:       (fileSize + treeSize) * boson.ChunkSize,
+			CreatedAt:  created,
+			Pinned:     pinned,
+			Registered: registered,
+		})
+	}
+	return files, nil
+}
+
+// applyRetention selects the files that violate the retention policy and,
+// unless dryRun is set, removes them through the same path as the file
+// delete endpoint.
+func (s *server) applyRetention(ctx context.Context, dryRun bool) (*retentionReport, error) {
+	files, err := s.retentionFiles(ctx, !dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &retentionReport{
+		Policy:     s.Retention,
+		DryRun:     dryRun,
+		Files:      len(files),
+		Candidates: make([]retention.Candidate, 0),
+	}
+	for _, f := range files {
+		report.TotalSize += f.Size
+	}
+
+	if !dryRun {
+		s.rootsMu.Lock()
+		defer s.rootsMu.Unlock()
+	}
+	refs := newChunkRefs()
+	for _, c := range s.Retention.Select(files, time.Now()) {
+		if !dryRun {
+			// pinned files are only selected without keepPinned
+			if _, err := s.deleteFile(ctx, refs, c.RootCid, !s.Retention.KeepPinned); err != nil {
+				s.logger.Errorf("retention: remove %s: %v", c.RootCid, err)
+				continue
+			}
+			s.logger.Infof("retention: removed %s (%s)", c.RootCid, c.Reason)
+		}
+		report.FreedSize += c.Size
+		report.Candidates = append(report.Candidates, c)
+	}
+
+	return report, nil
+}
+
+// retentionSweeper periodically applies the retention policy until the
+// server is closed.
+func (s *server) retentionSweeper() {
+	if !s.Retention.Enabled() || s.RetentionInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.RetentionInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.quit:
+				return
+			case <-ticker.C:
+				if _, err := s.applyRetention(context.Background(), false); err != nil {
+					s.logger.Errorf("retention: sweep: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *server) retentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	jsonhttp.OK(w, struct {
+		Policy   retention.Policy `json:"policy"`
+		Interval time.Duration    `json:"interval"`
+	}{
+		Policy:   s.Retention,
+		Interval: s.RetentionInterval,
+	})
+}
+
+func (s *server) retentionReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.applyRetention(r.Context(), true)
+	if err != nil {
+		s.logger.Debugf("retention report: %v", err)
+		s.logger.Error("retention report: collect files failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, report)
+}
+
+func (s *server) retentionSweepHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.applyRetention(r.Context(), false)
+	if err != nil {
+		s.logger.Debugf("retention sweep: %v", err)
+		s.logger.Error("retention sweep: collect files failed")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, report)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/FavorLabs/favorX/pkg/nochain"
 	"github.com/gauss-project/aurorafs/pkg/aurora"
 
 	"github.com/gauss-project/aurorafs/pkg/auth"
@@ -92,6 +93,23 @@ func (s *server) setupRouting() {
 		),
 	})
 
+	handle("/chunks/stream", web.ChainHandlers(
+		s.newTracingHandler("chunks-stream-upload"),
+		web.FinalHandlerFunc(s.chunkUploadStreamHandler),
+	))
+
+	handle("/chunks/batch", jsonhttp.MethodHandler{
+		"GET": web.ChainHandlers(
+			s.newTracingHandler("chunks-batch-download"),
+			web.FinalHandlerFunc(s.chunkBatchGetHandler),
+		),
+		"POST": web.ChainHandlers(
+			jsonhttp.NewMaxBodyBytesHandler(maxChunkBatch*(boson.ChunkWithSpanSize+512)),
+			s.newTracingHandler("chunks-batch-upload"),
+			web.FinalHandlerFunc(s.chunkBatchUploadHandler),
+		),
+	})
+
 	handle("/chunks/{addr}", jsonhttp.MethodHandler{
 		"GET": http.HandlerFunc(s.chunkGetHandler),
 	})
@@ -114,6 +132,13 @@ func (s *server) setupRouting() {
 		),
 	})
 
+	handle("/analysis/{address}", jsonhttp.MethodHandler{
+		"GET": web.ChainHandlers(
+			s.newTracingHandler("file-analysis"),
+			web.FinalHandlerFunc(s.analysisHandler),
+		),
+	})
+
 	handle("/file/{address}", jsonhttp.MethodHandler{
 		"GET": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			u := r.URL
@@ -151,7 +176,34 @@ func (s *server) setupRouting() {
 	handle("/pins", web.ChainHandlers(
 		s.gatewayModeForbidEndpointHandler,
 		web.FinalHandler(jsonhttp.MethodHandler{
-			"GET": http.HandlerFunc(s.listPinnedRootHashes),
+			"GET":    http.HandlerFunc(s.listPinnedRootHashes),
+			"POST":   http.HandlerFunc(s.pinBatchHandler),
+			"DELETE": http.HandlerFunc(s.unpinBatchHandler),
+		})),
+	)
+
+	handle("/pins/check", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":  http.HandlerFunc(s.pinCheckStatusHandler),
+			"POST": http.HandlerFunc(s.pinCheckStartHandler),
+		})),
+	)
+
+	handle("/pinning/pins", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":  http.HandlerFunc(s.pinServiceListHandler),
+			"POST": http.HandlerFunc(s.pinServiceAddHandler),
+		})),
+	)
+
+	handle("/pinning/pins/{requestid}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":    http.HandlerFunc(s.pinServiceGetHandler),
+			"POST":   http.HandlerFunc(s.pinServiceReplaceHandler),
+			"DELETE": http.HandlerFunc(s.pinServiceDeleteHandler),
 		})),
 	)
 
@@ -164,6 +216,42 @@ func (s *server) setupRouting() {
 		})),
 	)
 
+	handle("/act", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"POST": http.HandlerFunc(s.actCreateHandler),
+		})),
+	)
+
+	handle("/act/{address}/grantees", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":   http.HandlerFunc(s.actGranteesGetHandler),
+			"PATCH": http.HandlerFunc(s.actGranteesPatchHandler),
+		})),
+	)
+
+	handle("/retention", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.retentionPolicyHandler),
+		})),
+	)
+
+	handle("/retention/report", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.retentionReportHandler),
+		})),
+	)
+
+	handle("/retention/sweep", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"POST": http.HandlerFunc(s.retentionSweepHandler),
+		})),
+	)
+
 	handle("/traffic/info", web.ChainHandlers(
 		s.gatewayModeForbidEndpointHandler,
 		web.FinalHandler(jsonhttp.MethodHandler{
@@ -185,6 +273,35 @@ func (s *server) setupRouting() {
 		})),
 	)
 
+	handle("/traffic/history", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.trafficHistory),
+		})),
+	)
+
+	handle("/traffic/peers/{address}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.trafficPeer),
+		})),
+	)
+
+	handle("/traffic/autocash", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.autoCashPolicyHandler),
+			"PUT": http.HandlerFunc(s.autoCashPolicyUpdateHandler),
+		})),
+	)
+
+	handle("/traffic/autocash/attempts", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.autoCashAttemptsHandler),
+		})),
+	)
+
 	handle("/traffic/cash/{address}", web.ChainHandlers(
 		s.gatewayModeForbidEndpointHandler,
 		web.FinalHandler(jsonhttp.MethodHandler{
@@ -192,12 +309,51 @@ func (s *server) setupRouting() {
 		})),
 	)
 
+	handle("/transactions", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		s.chainRequiredHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.transactionsHandler),
+		})),
+	)
+
+	handle("/transactions/{hash}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		s.chainRequiredHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.transactionHandler),
+		})),
+	)
+
+	handle("/fileRegister", jsonhttp.MethodHandler{
+		"POST": web.ChainHandlers(
+			s.oracleRequiredHandler,
+			s.newTracingHandler("aurora-RegisterBatch"),
+			web.FinalHandlerFunc(s.fileRegisterBatch),
+		),
+	})
+	handle("/fileRegister/jobs", web.ChainHandlers(
+		s.oracleRequiredHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.fileRegisterJobsHandler),
+		})),
+	)
+	handle("/fileRegister/jobs/{id}", web.ChainHandlers(
+		s.oracleRequiredHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":    http.HandlerFunc(s.fileRegisterJobHandler),
+			"DELETE": http.HandlerFunc(s.fileRegisterJobCancelHandler),
+		})),
+	)
+
 	handle("/fileRegister/{address}", jsonhttp.MethodHandler{
 		"POST": web.ChainHandlers(
+			s.oracleRequiredHandler,
 			s.newTracingHandler("aurora-Register"),
 			web.FinalHandlerFunc(s.fileRegister),
 		),
 		"DELETE": web.ChainHandlers(
+			s.oracleRequiredHandler,
 			s.newTracingHandler("aurora-RegisterRemove"),
 			web.FinalHandlerFunc(s.fileRegisterRemove),
 		),
@@ -220,9 +376,87 @@ func (s *server) setupRouting() {
 	handle("/group/send/{gid}/{target}", jsonhttp.MethodHandler{
 		"POST": http.HandlerFunc(s.sendReceive),
 	})
+	handle("/group/stream/{gid}/{target}", jsonhttp.MethodHandler{
+		"POST": http.HandlerFunc(s.groupStreamHandler),
+	})
+	handle("/group/token/{name}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"POST": http.HandlerFunc(s.groupTokenHandler),
+		})),
+	)
+	handle("/group/messages/{gid}", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.groupMessagesHandler),
+	})
+	handle("/group/subscribe/{gid}", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.groupSubscribeHandler),
+	})
 	handle("/group/peers/{gid}", jsonhttp.MethodHandler{
 		"GET": http.HandlerFunc(s.peers),
 	})
+	handle("/group/topology/{gid}", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.groupTopologyHandler),
+	})
+	handle("/group/topology/{gid}/subscribe", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.groupTopologySubscribeHandler),
+	})
+	handle("/group", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.groupListHandler),
+	})
+	handle("/group/{gid}", jsonhttp.MethodHandler{
+		"GET":   http.HandlerFunc(s.groupGetHandler),
+		"PATCH": http.HandlerFunc(s.groupPatchHandler),
+	})
+
+	handle("/netrelay/domains", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.relayDomainsHandler),
+	})
+	handle("/netrelay/domains/{gname}", jsonhttp.MethodHandler{
+		"GET": http.HandlerFunc(s.relayDomainsHandler),
+	})
+	handle("/netrelay/domains/{gname}/{domain}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":    http.HandlerFunc(s.relayDomainGetHandler),
+			"PUT":    http.HandlerFunc(s.relayDomainPutHandler),
+			"DELETE": http.HandlerFunc(s.relayDomainDeleteHandler),
+		})),
+	)
+	handle("/netrelay/allowlist/{gname}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.relayAllowlistGetHandler),
+			"PUT": http.HandlerFunc(s.relayAllowlistPutHandler),
+		})),
+	)
+	handle("/netrelay/services", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET": http.HandlerFunc(s.relayServicesHandler),
+		})),
+	)
+	handle("/netrelay/services/{name}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":    http.HandlerFunc(s.relayServiceGetHandler),
+			"PUT":    http.HandlerFunc(s.relayServicePutHandler),
+			"DELETE": http.HandlerFunc(s.relayServiceDeleteHandler),
+		})),
+	)
+	handle("/netrelay/forwards", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"GET":  http.HandlerFunc(s.relayForwardsHandler),
+			"POST": http.HandlerFunc(s.relayForwardPostHandler),
+		})),
+	)
+	handle("/netrelay/forwards/{id}", web.ChainHandlers(
+		s.gatewayModeForbidEndpointHandler,
+		web.FinalHandler(jsonhttp.MethodHandler{
+			"PUT":    http.HandlerFunc(s.relayForwardPutHandler),
+			"DELETE": http.HandlerFunc(s.relayForwardDeleteHandler),
+		})),
+	)
 
 	s.newLoopbackRouter(router)
 
@@ -257,6 +491,7 @@ func (s *server) newLoopbackRouter(router *mux.Router) {
 	}
 
 	handle("/chain", web.ChainHandlers(
+		s.chainRequiredHandler,
 		web.FinalHandler(jsonhttp.MethodHandler{
 			"POST": http.HandlerFunc(s.chainHandler),
 			"GET":  http.HandlerFunc(s.chainTransactionHandler),
@@ -275,6 +510,30 @@ func (s *server) gatewayModeForbidEndpointHandler(h http.Handler) http.Handler {
 	})
 }
 
+// oracleRequiredHandler answers endpoints that register at the oracle with
+// 501 on nodes running without an oracle.
+func (s *server) oracleRequiredHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.oracleChain.(*nochain.Resolver); ok {
+			jsonhttp.NotImplemented(w, nochain.ErrNoChain.Error())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// chainRequiredHandler answers endpoints that relay to the chain with 501 on
+// nodes running without a chain.
+func (s *server) chainRequiredHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.commonChain.(*nochain.Common); ok {
+			jsonhttp.NotImplemented(w, nochain.ErrNoChain.Error())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 func (s *server) gatewayModeForbidHeadersHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if s.GatewayMode {
@@ -283,7 +542,7 @@ func (s *server) gatewayModeForbidHeadersHandler(h http.Handler) http.Handler {
 				jsonhttp.Forbidden(w, "pinning is disabled")
 				return
 			}
-			if strings.ToLower(r.Header.Get(AuroraEncryptHeader)) == "true" {
+			if strings.ToLower(r.Header.Get(AuroraEncryptHeader)) == "true" || requestAct(r) {
 				s.logger.Tracef("gateway mode: forbidden encryption %s", r.URL.String())
 				jsonhttp.Forbidden(w, "encryption is disabled")
 				return
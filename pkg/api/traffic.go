@@ -1,14 +1,19 @@
 package api
 
 import (
-	"context"
+	"errors"
 	"math/big"
 	"net/http"
 	"sort"
+	"time"
 
+	"github.com/FavorLabs/favorX/pkg/accounting"
+	"github.com/FavorLabs/favorX/pkg/chaintraffic"
+	"github.com/FavorLabs/favorX/pkg/traffichistory"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gauss-project/aurorafs/pkg/boson"
 	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/cheque"
 	"github.com/gorilla/mux"
 )
 
@@ -90,10 +95,17 @@ func (s *server) cashCheque(w http.ResponseWriter, r *http.Request) {
 		jsonhttp.NotFound(w, err)
 		return
 	}
-	hash, err := s.traffic.CashCheque(context.Background(), peer)
+	hash, err := s.traffic.CashCheque(r.Context(), peer)
 	if err != nil {
 		s.logger.Errorf("api cashCheque: query failed %s: %v", nameOrHex, err)
-		jsonhttp.NotFound(w, err)
+		switch {
+		case errors.Is(err, cheque.ErrNoCheque):
+			jsonhttp.NotFound(w, err.Error())
+		case errors.Is(err, chaintraffic.ErrCashPending):
+			jsonhttp.Conflict(w, err.Error())
+		default:
+			jsonhttp.InternalServerError(w, "cash cheque failed")
+		}
 		return
 	}
 
@@ -102,3 +114,94 @@ func (s *server) cashCheque(w http.ResponseWriter, r *http.Request) {
 	}
 	jsonhttp.OK(w, out{Hash: hash})
 }
+
+// trafficHistory returns the per peer traffic accounting in hourly or daily
+// buckets between from and to, the last day by default. With format csv the
+// records are exported as a csv file.
+func (s *server) trafficHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			jsonhttp.BadRequest(w, "invalid to")
+			return
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			jsonhttp.BadRequest(w, "invalid from")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		jsonhttp.BadRequest(w, "from must be before to")
+		return
+	}
+	interval := traffichistory.Hour
+	if v := q.Get("interval"); v != "" {
+		interval = traffichistory.Interval(v)
+	}
+	peer := boson.ZeroAddress
+	if v := q.Get("peer"); v != "" {
+		p, err := boson.ParseHexAddress(v)
+		if err != nil {
+			jsonhttp.BadRequest(w, "invalid peer")
+			return
+		}
+		peer = p
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		jsonhttp.BadRequest(w, "invalid format")
+		return
+	}
+
+	records, err := s.TrafficHistory.Query(from, to, interval, peer)
+	if err != nil {
+		if errors.Is(err, traffichistory.ErrInvalidInterval) {
+			jsonhttp.BadRequest(w, "invalid interval")
+			return
+		}
+		s.logger.Errorf("api trafficHistory: query: %v", err)
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set(contentTypeHeader, "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="traffic-history.csv"`)
+		if err := traffichistory.WriteCSV(w, records); err != nil {
+			s.logger.Errorf("api trafficHistory: write csv: %v", err)
+		}
+		return
+	}
+	jsonhttp.OK(w, records)
+}
+
+// trafficPeer returns the traffic the node owes the peer and the peer owes
+// the node with the payment threshold and tolerance of the peer.
+func (s *server) trafficPeer(w http.ResponseWriter, r *http.Request) {
+	nameOrHex := mux.Vars(r)["address"]
+	peer, err := s.resolveNameOrAddress(nameOrHex)
+	if err != nil {
+		s.logger.Debugf("api trafficPeer: parse address %s: %v", nameOrHex, err)
+		jsonhttp.BadRequest(w, "invalid address")
+		return
+	}
+	balance, err := s.Accounting.Balance(peer)
+	if errors.Is(err, accounting.ErrPeerNotFound) {
+		jsonhttp.NotFound(w, "peer not found")
+		return
+	}
+	if err != nil {
+		s.logger.Errorf("api trafficPeer: balance of %s: %v", peer, err)
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, balance)
+}
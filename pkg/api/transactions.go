@@ -0,0 +1,59 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+	"github.com/gorilla/mux"
+)
+
+// transactionsHandler lists the transactions sent by the node, newest
+// first, optionally only those with the status given in the query.
+func (s *server) transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	status := txmgr.Status(r.URL.Query().Get("status"))
+	if status != "" && !txmgr.ValidStatus(status) {
+		jsonhttp.BadRequest(w, "invalid status")
+		return
+	}
+
+	records, err := s.Transactions.Records(status)
+	if err != nil {
+		s.logger.Debugf("transactions: list: %v", err)
+		s.logger.Error("transactions: list")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	if records == nil {
+		records = make([]txmgr.Record, 0)
+	}
+	jsonhttp.OK(w, records)
+}
+
+// transactionHandler returns the transaction sent with the hash, which may
+// have been replaced since.
+func (s *server) transactionHandler(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+	b, err := hexutil.Decode(hash)
+	if err != nil || len(b) != common.HashLength {
+		jsonhttp.BadRequest(w, "invalid hash")
+		return
+	}
+
+	record, err := s.Transactions.Record(common.BytesToHash(b))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			jsonhttp.NotFound(w, nil)
+			return
+		}
+		s.logger.Debugf("transaction %s: %v", hash, err)
+		s.logger.Error("transaction: get")
+		jsonhttp.InternalServerError(w, nil)
+		return
+	}
+	jsonhttp.OK(w, record)
+}
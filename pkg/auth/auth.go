@@ -230,9 +230,13 @@ func applyPolicies(e *casbin.Enforcer) error {
 		{"creator", "/bytes", "POST"},
 		{"consumer", "/chunks/*", "GET"},
 		{"creator", "/chunks", "POST"},
+		{"creator", "/chunks/stream", "GET"},
+		{"consumer", "/chunks/batch", "GET"},
+		{"creator", "/chunks/batch", "POST"},
 		{"creator", "/soc/*/*", "POST"},
 		{"consumer", "/file", "GET"},
 		{"creator", "/file", "POST"},
+		{"consumer", "/analysis/*", "GET"},
 		{"consumer", "/file/*", "GET"},
 		{"creator", "/file/*", "DELETE"},
 		{"consumer", "/file/*/*", "GET"},
@@ -240,12 +244,46 @@ func applyPolicies(e *casbin.Enforcer) error {
 		{"consumer", "/manifest/*/*", "GET"},
 		{"creator", "/pins/*", "(GET)|(DELETE)|(POST)"},
 		{"consumer", "/group/peers/*", "GET"},
+		{"consumer", "/group/topology/*", "GET"},
+		{"consumer", "/group/subscribe/*", "GET"},
+		{"consumer", "/group/messages/*", "GET"},
 		{"consumer", "/group/multicast/*", "POST"},
 		{"consumer", "/group/send/*/*", "POST"},
+		{"consumer", "/group/stream/*/*", "POST"},
 		{"consumer", "/group/notify/*/*", "POST"},
 		{"consumer", "/group/join/*", "(DELETE)|(POST)"},
 		{"consumer", "/group/observe/*", "(DELETE)|(POST)"},
-		{"maintainer", "/pins", "GET"},
+		{"consumer", "/group", "GET"},
+		{"consumer", "/group/*", "GET"},
+		{"maintainer", "/group/*", "PATCH"},
+		{"maintainer", "/group/token/*", "POST"},
+		{"maintainer", "/pins", "(GET)|(DELETE)|(POST)"},
+		{"maintainer", "/pins/check", "(GET)|(POST)"},
+		{"maintainer", "/pinning/pins", "(GET)|(POST)"},
+		{"maintainer", "/pinning/pins/*", "(GET)|(POST)|(DELETE)"},
+		{"creator", "/act", "POST"},
+		{"creator", "/act/*/grantees", "(GET)|(PATCH)"},
+		{"maintainer", "/retention", "GET"},
+		{"maintainer", "/retention/report", "GET"},
+		{"maintainer", "/retention/sweep", "POST"},
+		{"maintainer", "/netrelay/domains", "GET"},
+		{"maintainer", "/netrelay/domains/*", "GET"},
+		{"maintainer", "/netrelay/domains/*/*", "(GET)|(PUT)|(DELETE)"},
+		{"maintainer", "/netrelay/allowlist/*", "(GET)|(PUT)"},
+		{"maintainer", "/netrelay/services", "GET"},
+		{"maintainer", "/netrelay/services/*", "(GET)|(PUT)|(DELETE)"},
+		{"maintainer", "/netrelay/forwards", "(GET)|(POST)"},
+		{"maintainer", "/netrelay/forwards/*", "(PUT)|(DELETE)"},
+		{"maintainer", "/traffic/history", "GET"},
+		{"maintainer", "/traffic/peers/*", "GET"},
+		{"maintainer", "/traffic/autocash", "(GET)|(PUT)"},
+		{"maintainer", "/traffic/autocash/attempts", "GET"},
+		{"maintainer", "/transactions", "GET"},
+		{"maintainer", "/transactions/*", "GET"},
+		{"creator", "/fileRegister/jobs", "GET"},
+		{"creator", "/fileRegister/jobs/*", "(GET)|(DELETE)"},
+		{"maintainer", "/fileRegister/jobs", "GET"},
+		{"maintainer", "/fileRegister/jobs/*", "(GET)|(DELETE)"},
 
 		// debug api
 		{"maintainer", "/addresses", "GET"},
@@ -0,0 +1,491 @@
+// Package autocash cashes the traffic cheques received from peers by policy.
+//
+// A cheque is cashed once its uncashed value reaches the threshold of the
+// policy, while the gas price is within its ceiling and no more than the
+// allowed number of cashing transactions were sent in the last interval.
+// In dry-run mode the cheques are only logged as if they were cashed.
+// Every attempt is kept in a log in the state store, the cashing
+// transactions the rate limit counts in a log of their own.
+package autocash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/chaintraffic"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	policyKey     = "autocash-policy"
+	attemptPrefix = "autocash-attempt-"
+	cashedPrefix  = "autocash-cashed-"
+	maxAttempts   = 1000
+	// MaxTransactions is the highest transaction limit of a policy.
+	MaxTransactions = maxAttempts
+	// checkInterval is how often the cheques are checked against the
+	// policy.
+	checkInterval = time.Minute
+)
+
+const (
+	ResultCashed    = "cashed"
+	ResultDryRun    = "dry-run"
+	ResultGasPrice  = "gas-price"
+	ResultRateLimit = "rate-limited"
+	ResultPending   = "pending"
+	ResultFailed    = "failed"
+)
+
+// Policy describes when the cheques of a peer are cashed.
+type Policy struct {
+	// Threshold is the uncashed value a cheque is cashed at, nil or zero
+	// disables automatic cashing.
+	Threshold *big.Int `json:"threshold"`
+	// MaxGasPrice is the highest gas price in wei cheques are cashed at,
+	// nil or zero for no ceiling.
+	MaxGasPrice *big.Int `json:"maxGasPrice"`
+	// MaxTransactions is the number of cashing transactions sent per
+	// Interval at most, zero for no limit.
+	MaxTransactions int      `json:"maxTransactions"`
+	Interval        Duration `json:"interval"`
+	DryRun          bool     `json:"dryRun"`
+}
+
+// ErrInvalidPolicy is returned for policies with negative values or a
+// transaction limit above MaxTransactions.
+var ErrInvalidPolicy = errors.New("autocash: invalid policy")
+
+// Validate returns ErrInvalidPolicy if p is invalid.
+func (p Policy) Validate() error {
+	if (p.Threshold != nil && p.Threshold.Sign() < 0) || (p.MaxGasPrice != nil && p.MaxGasPrice.Sign() < 0) ||
+		p.MaxTransactions < 0 || p.MaxTransactions > MaxTransactions || p.Interval < 0 {
+		return ErrInvalidPolicy
+	}
+	return nil
+}
+
+// Duration is a time.Duration given in json as a duration string, like
+// "1h30m", or as a number of seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		t, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(t)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+// Enabled reports whether the policy cashes anything at all.
+func (p Policy) Enabled() bool {
+	return p.Threshold != nil && p.Threshold.Sign() > 0
+}
+
+// Select returns the cheques whose uncashed value reached the threshold and
+// are not being cashed already, highest uncashed value first.
+func (p Policy) Select(cheques []*traffic.TrafficCheque) []*traffic.TrafficCheque {
+	if !p.Enabled() {
+		return nil
+	}
+	var out []*traffic.TrafficCheque
+	for _, c := range cheques {
+		if c.Status != int(traffic.UnOperation) || c.Uncashed == nil || c.Uncashed.Cmp(p.Threshold) < 0 {
+			continue
+		}
+		out = append(out, c)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Uncashed.Cmp(out[j].Uncashed) > 0
+	})
+	return out
+}
+
+// GasPriceAllowed reports whether cheques are cashed at gasPrice. An unknown
+// gas price is only allowed without a ceiling.
+func (p Policy) GasPriceAllowed(gasPrice *big.Int) bool {
+	if p.MaxGasPrice == nil || p.MaxGasPrice.Sign() == 0 {
+		return true
+	}
+	return gasPrice != nil && gasPrice.Cmp(p.MaxGasPrice) <= 0
+}
+
+func (p Policy) rateLimited() bool {
+	return p.MaxTransactions > 0 && p.Interval > 0
+}
+
+// Attempt is an entry of the log of cashing attempts.
+type Attempt struct {
+	Time     time.Time     `json:"time"`
+	Peer     boson.Address `json:"peer"`
+	Uncashed *big.Int      `json:"uncashed"`
+	GasPrice *big.Int      `json:"gasPrice,omitempty"`
+	DryRun   bool          `json:"dryRun"`
+	Result   string        `json:"result"`
+	TxHash   *common.Hash  `json:"txHash,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Store persists the policy set at runtime and the logs in a state store.
+// The logs are read once and kept in memory.
+type Store struct {
+	store  storage.StateStorer
+	mu     sync.Mutex
+	loaded bool
+	// attempts and cashed are the logs, oldest first
+	attempts []entry
+	cashed   []entry
+}
+
+type entry struct {
+	key     string
+	attempt Attempt
+}
+
+// NewStore returns a Store keeping the policy and the logs in store. The
+// logs are loaded from it on first use.
+func NewStore(store storage.StateStorer) *Store {
+	return &Store{store: store}
+}
+
+// Policy returns the saved policy, or storage.ErrNotFound if none was saved.
+func (s *Store) Policy() (Policy, error) {
+	var p Policy
+	err := s.store.Get(policyKey, &p)
+	return p, err
+}
+
+// PutPolicy saves p.
+func (s *Store) PutPolicy(p Policy) error {
+	return s.store.Put(policyKey, p)
+}
+
+func attemptKey(prefix string, t time.Time) string {
+	return fmt.Sprintf("%s%020d", prefix, t.UnixNano())
+}
+
+// read returns the entries of the log of prefix, oldest first.
+func (s *Store) read(prefix string) ([]entry, error) {
+	var entries []entry
+	err := s.store.Iterate(prefix, func(k, v []byte) (bool, error) {
+		var a Attempt
+		if err := json.Unmarshal(v, &a); err != nil {
+			return true, err
+		}
+		entries = append(entries, entry{key: string(k), attempt: a})
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// load reads the logs unless they were read already. The lock must be held
+// when called.
+func (s *Store) load() error {
+	if s.loaded {
+		return nil
+	}
+	attempts, err := s.read(attemptPrefix)
+	if err != nil {
+		return err
+	}
+	cashed, err := s.read(cashedPrefix)
+	if err != nil {
+		return err
+	}
+	s.attempts, s.cashed, s.loaded = attempts, cashed, true
+	return nil
+}
+
+// appendEntry appends a to the log of prefix, dropping the oldest entries
+// beyond the last thousand.
+func (s *Store) appendEntry(log []entry, prefix string, a Attempt) ([]entry, error) {
+	k := attemptKey(prefix, a.Time)
+	if err := s.store.Put(k, a); err != nil {
+		return log, err
+	}
+	log = append(log, entry{key: k, attempt: a})
+	for len(log) > maxAttempts {
+		if err := s.store.Delete(log[0].key); err != nil {
+			return log, err
+		}
+		log = log[1:]
+	}
+	return log, nil
+}
+
+// Add appends a to the log, and to the log of cashing transactions if it
+// sent one. Each log keeps its last thousand entries.
+func (s *Store) Add(a Attempt) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	if s.attempts, err = s.appendEntry(s.attempts, attemptPrefix, a); err != nil {
+		return err
+	}
+	if a.Result == ResultCashed {
+		if s.cashed, err = s.appendEntry(s.cashed, cashedPrefix, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attempts returns the last limit entries of the log, newest first. A limit
+// of zero returns all of them.
+func (s *Store) Attempts(limit int) ([]Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	out := make([]Attempt, 0, len(s.attempts))
+	for i := len(s.attempts) - 1; i >= 0; i-- {
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, s.attempts[i].attempt)
+	}
+	return out, nil
+}
+
+// cashedSince returns the number of cashing transactions sent since t.
+func (s *Store) cashedSince(t time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	i := sort.Search(len(s.cashed), func(i int) bool {
+		return !s.cashed[i].attempt.Time.Before(t)
+	})
+	return len(s.cashed) - i, nil
+}
+
+// GasPriceFunc returns the current gas price of the chain.
+type GasPriceFunc func(ctx context.Context) (*big.Int, error)
+
+// Cashier cashes the cheques of a traffic service by policy.
+type Cashier struct {
+	store    *Store
+	traffic  traffic.ApiInterface
+	gasPrice GasPriceFunc
+	logger   logging.Logger
+	now      func() time.Time
+	mu       sync.Mutex
+	// last is the last logged attempt of every peer, so a cheque waiting
+	// on the gas price or the rate limit is logged once and not on every
+	// check.
+	last     map[string]Attempt
+	policyMu sync.Mutex
+	policy   Policy
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// NewCashier returns a cashier of the cheques received by t, logging its
+// attempts to store, and starts checking the cheques every minute. The
+// policy is read on every check, so it can be enabled while the node runs.
+// The policy saved in store overrides policy.
+func NewCashier(store *Store, t traffic.ApiInterface, gasPrice GasPriceFunc, policy Policy, logger logging.Logger) *Cashier {
+	c := &Cashier{
+		store:    store,
+		traffic:  t,
+		gasPrice: gasPrice,
+		logger:   logger,
+		now:      time.Now,
+		last:     make(map[string]Attempt),
+		policy:   policy,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	p, err := store.Policy()
+	switch {
+	case err == nil:
+		c.policy = p
+	case !errors.Is(err, storage.ErrNotFound):
+		logger.Errorf("autocash: load policy: %v", err)
+	}
+	go c.checkLoop()
+	return c
+}
+
+// Policy returns the policy the cheques are cashed by.
+func (c *Cashier) Policy() Policy {
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	return c.policy
+}
+
+// SetPolicy saves p and cashes the cheques by it from now on.
+func (c *Cashier) SetPolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	if err := c.store.PutPolicy(p); err != nil {
+		return err
+	}
+	c.policy = p
+	return nil
+}
+
+// Attempts returns the last limit entries of the log, newest first.
+func (c *Cashier) Attempts(limit int) ([]Attempt, error) {
+	return c.store.Attempts(limit)
+}
+
+func (c *Cashier) checkLoop() {
+	defer close(c.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.quit
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+			p := c.Policy()
+			if !p.Enabled() {
+				continue
+			}
+			if _, err := c.Check(ctx, p); err != nil {
+				c.logger.Errorf("autocash: check: %v", err)
+			}
+		}
+	}
+}
+
+// Close stops checking the cheques and waits for a running check.
+func (c *Cashier) Close() error {
+	close(c.quit)
+	<-c.done
+	return nil
+}
+
+// Check cashes the cheques selected by p and returns the attempts made.
+func (c *Cashier) Check(ctx context.Context, p Policy) ([]Attempt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cheques, err := c.traffic.TrafficCheques()
+	if err != nil {
+		return nil, err
+	}
+	selected := p.Select(cheques)
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	gasPrice, err := c.gasPrice(ctx)
+	if err != nil {
+		c.logger.Debugf("autocash: gas price: %v", err)
+		gasPrice = nil
+	}
+	now := c.now()
+	var sent int
+	if p.rateLimited() {
+		if sent, err = c.store.cashedSince(now.Add(-time.Duration(p.Interval))); err != nil {
+			return nil, err
+		}
+	}
+
+	var attempts []Attempt
+	for _, ch := range selected {
+		a := Attempt{
+			Time:     now,
+			Peer:     ch.Peer,
+			Uncashed: ch.Uncashed,
+			GasPrice: gasPrice,
+			DryRun:   p.DryRun,
+		}
+		switch {
+		case !p.GasPriceAllowed(gasPrice):
+			a.Result = ResultGasPrice
+		case p.rateLimited() && sent >= p.MaxTransactions:
+			a.Result = ResultRateLimit
+		case p.DryRun:
+			a.Result = ResultDryRun
+		default:
+			hash, err := c.traffic.CashCheque(ctx, ch.Peer)
+			switch {
+			case errors.Is(err, chaintraffic.ErrCashPending):
+				a.Result = ResultPending
+			case err != nil:
+				a.Result = ResultFailed
+				a.Error = err.Error()
+			default:
+				a.Result = ResultCashed
+				a.TxHash = &hash
+				sent++
+			}
+		}
+		if !c.logged(a) {
+			continue
+		}
+		if err := c.store.Add(a); err != nil {
+			return attempts, err
+		}
+		if a.Result == ResultCashed {
+			c.logger.Infof("autocash: cashed cheque of %s with uncashed %s in %s", a.Peer, a.Uncashed, a.TxHash)
+		}
+		attempts = append(attempts, a)
+		// keep attempts made in the same check apart in the log
+		now = now.Add(time.Nanosecond)
+	}
+	return attempts, nil
+}
+
+// logged reports whether a is logged, which it is unless the last attempt
+// of the peer had the same result for the same uncashed value.
+func (c *Cashier) logged(a Attempt) bool {
+	k := a.Peer.String()
+	last, ok := c.last[k]
+	c.last[k] = a
+	if a.Result == ResultCashed || a.Result == ResultFailed {
+		return true
+	}
+	return !ok || last.Result != a.Result || last.DryRun != a.DryRun || last.Uncashed.Cmp(a.Uncashed) != 0
+}
@@ -0,0 +1,227 @@
+package autocash_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/autocash"
+	"github.com/FavorLabs/favorX/pkg/chaintraffic"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/mock"
+	statestore "github.com/gauss-project/aurorafs/pkg/statestore/mock"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+func TestSelect(t *testing.T) {
+	cheques := []*traffic.TrafficCheque{
+		{Peer: boson.MustParseHexAddress("01"), Uncashed: big.NewInt(50)},
+		{Peer: boson.MustParseHexAddress("02"), Uncashed: big.NewInt(200)},
+		{Peer: boson.MustParseHexAddress("03"), Uncashed: big.NewInt(500), Status: int(traffic.Operation)},
+		{Peer: boson.MustParseHexAddress("04"), Uncashed: big.NewInt(100)},
+	}
+
+	if got := (autocash.Policy{}).Select(cheques); got != nil {
+		t.Fatalf("disabled policy selected %v", got)
+	}
+	got := autocash.Policy{Threshold: big.NewInt(100)}.Select(cheques)
+	if len(got) != 2 || got[0].Peer.String() != "02" || got[1].Peer.String() != "04" {
+		t.Fatalf("got %v", got)
+	}
+
+	p := autocash.Policy{MaxGasPrice: big.NewInt(10)}
+	if !p.GasPriceAllowed(big.NewInt(10)) || p.GasPriceAllowed(big.NewInt(11)) || p.GasPriceAllowed(nil) {
+		t.Fatal("gas price ceiling not applied")
+	}
+	if !(autocash.Policy{}).GasPriceAllowed(nil) {
+		t.Fatal("gas price limited without a ceiling")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	peers := []boson.Address{boson.MustParseHexAddress("01"), boson.MustParseHexAddress("02"), boson.MustParseHexAddress("03")}
+	var cheques []*traffic.TrafficCheque
+	for i, p := range peers {
+		cheques = append(cheques, &traffic.TrafficCheque{Peer: p, Uncashed: big.NewInt(int64(1000 - i))})
+	}
+	var cashed []boson.Address
+	service := mock.NewTraffic(
+		mock.WithTrafficCheques(func() ([]*traffic.TrafficCheque, error) {
+			return cheques, nil
+		}),
+		mock.WithCashCheque(func(_ context.Context, peer boson.Address) (common.Hash, error) {
+			if peer.Equal(peers[2]) {
+				return common.Hash{}, chaintraffic.ErrCashPending
+			}
+			cashed = append(cashed, peer)
+			return common.HexToHash("0x1"), nil
+		}),
+	)
+	gasPrice := big.NewInt(5)
+	store := autocash.NewStore(statestore.NewStateStore())
+	policy := autocash.Policy{
+		Threshold:       big.NewInt(100),
+		MaxGasPrice:     big.NewInt(10),
+		MaxTransactions: 1,
+		Interval:        autocash.Duration(time.Hour),
+		DryRun:          true,
+	}
+	cashier := autocash.NewCashier(store, service, func(context.Context) (*big.Int, error) {
+		return gasPrice, nil
+	}, policy, logging.New(io.Discard, 0))
+	defer cashier.Close()
+	results := func(attempts []autocash.Attempt) []string {
+		var out []string
+		for _, a := range attempts {
+			out = append(out, a.Result)
+		}
+		return out
+	}
+	check := func(want ...string) {
+		t.Helper()
+		attempts, err := cashier.Check(context.Background(), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := results(attempts)
+		if len(got) != len(want) {
+			t.Fatalf("got results %v, want %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("got results %v, want %v", got, want)
+			}
+		}
+	}
+
+	check(autocash.ResultDryRun, autocash.ResultDryRun, autocash.ResultDryRun)
+	// unchanged cheques are not logged again
+	check()
+	if len(cashed) != 0 {
+		t.Fatalf("dry run cashed %v", cashed)
+	}
+
+	gasPrice = big.NewInt(20)
+	policy.DryRun = false
+	check(autocash.ResultGasPrice, autocash.ResultGasPrice, autocash.ResultGasPrice)
+
+	gasPrice = big.NewInt(10)
+	check(autocash.ResultCashed, autocash.ResultRateLimit, autocash.ResultRateLimit)
+	if len(cashed) != 1 || !cashed[0].Equal(peers[0]) {
+		t.Fatalf("cashed %v", cashed)
+	}
+
+	policy.MaxTransactions = 0
+	check(autocash.ResultCashed, autocash.ResultCashed, autocash.ResultPending)
+
+	attempts, err := store.Attempts(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results(attempts); len(got) != 2 || got[0] != autocash.ResultPending || got[1] != autocash.ResultCashed {
+		t.Fatalf("got last attempts %v", got)
+	}
+	if attempts, err = store.Attempts(0); err != nil || len(attempts) != 12 {
+		t.Fatalf("got %d attempts, error %v", len(attempts), err)
+	}
+}
+
+func TestStorePolicy(t *testing.T) {
+	store := autocash.NewStore(statestore.NewStateStore())
+	if _, err := store.Policy(); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	want := autocash.Policy{Threshold: big.NewInt(100), MaxTransactions: 2, Interval: autocash.Duration(time.Hour)}
+	if err := store.PutPolicy(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Threshold.Cmp(want.Threshold) != 0 || got.MaxTransactions != 2 || got.Interval != autocash.Duration(time.Hour) || got.MaxGasPrice != nil {
+		t.Fatalf("got policy %+v", got)
+	}
+}
+
+func TestStoreCashedLog(t *testing.T) {
+	store := autocash.NewStore(statestore.NewStateStore())
+	start := time.Unix(1000, 0)
+	if err := store.Add(autocash.Attempt{Time: start, Peer: boson.MustParseHexAddress("01"), Result: autocash.ResultCashed}); err != nil {
+		t.Fatal(err)
+	}
+	// failed attempts push the cashing transaction out of the log of
+	// attempts but not out of the log the rate limit counts
+	for i := 1; i <= autocash.MaxTransactions; i++ {
+		a := autocash.Attempt{Time: start.Add(time.Duration(i)), Peer: boson.MustParseHexAddress("01"), Result: autocash.ResultFailed}
+		if err := store.Add(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempts, err := store.Attempts(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != autocash.MaxTransactions || attempts[len(attempts)-1].Result != autocash.ResultFailed {
+		t.Fatalf("got %d attempts", len(attempts))
+	}
+	if n, err := autocash.CashedSince(store, start); err != nil || n != 1 {
+		t.Fatalf("got %d cashed, error %v", n, err)
+	}
+	if n, err := autocash.CashedSince(store, start.Add(1)); err != nil || n != 0 {
+		t.Fatalf("got %d cashed, error %v", n, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want time.Duration
+	}{
+		{`{"interval":"1h30m"}`, 90 * time.Minute},
+		{`{"interval":90}`, 90 * time.Second},
+		{`{}`, 0},
+	} {
+		var p autocash.Policy
+		if err := json.Unmarshal([]byte(tc.json), &p); err != nil {
+			t.Fatalf("%s: %v", tc.json, err)
+		}
+		if time.Duration(p.Interval) != tc.want {
+			t.Fatalf("%s: got interval %v, want %v", tc.json, time.Duration(p.Interval), tc.want)
+		}
+	}
+	var p autocash.Policy
+	if err := json.Unmarshal([]byte(`{"interval":"soon"}`), &p); err == nil {
+		t.Fatal("invalid interval accepted")
+	}
+	b, err := json.Marshal(autocash.Policy{Interval: autocash.Duration(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"interval":"1h0m0s"`) {
+		t.Fatalf("got %s", b)
+	}
+
+	for _, p := range []autocash.Policy{
+		{Threshold: big.NewInt(-1)},
+		{MaxGasPrice: big.NewInt(-1)},
+		{MaxTransactions: -1},
+		{MaxTransactions: autocash.MaxTransactions + 1},
+		{Interval: autocash.Duration(-time.Second)},
+	} {
+		if err := p.Validate(); !errors.Is(err, autocash.ErrInvalidPolicy) {
+			t.Fatalf("policy %+v: got error %v", p, err)
+		}
+	}
+	if err := (autocash.Policy{Threshold: big.NewInt(1), MaxTransactions: autocash.MaxTransactions}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
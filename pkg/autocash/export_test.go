@@ -0,0 +1,3 @@
+package autocash
+
+var CashedSince = (*Store).cashedSince
@@ -0,0 +1,404 @@
+// Package batchreg registers many roots on the oracle in background jobs.
+//
+// Roots already registered are skipped, the others are registered with one
+// transaction each, throttled by an interval. The receipts of the sent
+// transactions are followed even after a job is cancelled, and the
+// progress of every job can be followed by its id. Jobs are kept in memory
+// only; a restart forgets them.
+package batchreg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+)
+
+const (
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateCancelled = "cancelled"
+
+	DefaultInterval = time.Second
+
+	// maxFinished is the number of finished jobs that are kept.
+	maxFinished = 100
+	// receiptTimeout limits following the receipt of a transaction.
+	receiptTimeout = 30 * time.Minute
+)
+
+var (
+	ErrNotFound   = errors.New("batchreg: job not found")
+	ErrNotRunning = errors.New("batchreg: job not running")
+	ErrNoRoots    = errors.New("batchreg: no roots")
+	ErrClosed     = errors.New("batchreg: closed")
+	// ErrLocked is the failure of roots that were being registered
+	// otherwise when a job got to them.
+	ErrLocked = errors.New("batchreg: root being registered")
+)
+
+// Oracle registers roots one at a time.
+type Oracle interface {
+	GetRegisterState(ctx context.Context, rootCid boson.Address, address boson.Address) (bool, error)
+	RegisterCidAndNode(ctx context.Context, rootCid boson.Address, address boson.Address) (common.Hash, error)
+	WaitForReceipt(ctx context.Context, rootCid boson.Address, txHash common.Hash) (*types.Receipt, error)
+}
+
+// LockFunc locks rootCid while a job registers it, so it is not registered
+// otherwise meanwhile. It returns false if rootCid is locked already.
+type LockFunc func(rootCid boson.Address) (unlock func(), ok bool)
+
+// Options tune the jobs. Zero values select the defaults.
+type Options struct {
+	// Interval is the pause between two transactions of a job.
+	Interval time.Duration
+	// Lock, if set, locks every root while it is registered.
+	Lock LockFunc
+}
+
+// Failure is a root that could not be registered.
+type Failure struct {
+	RootCid boson.Address `json:"rootCid"`
+	Error   string        `json:"error"`
+}
+
+// Job is the progress of a registration job. Of the Total roots, Skipped
+// were registered already, Sent are in transactions sent so far and of
+// these Registered were mined successfully. Failed counts the roots whose
+// state could not be read, whose transaction could not be sent or failed.
+type Job struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Created    time.Time  `json:"created"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Total      int        `json:"total"`
+	Skipped    int        `json:"skipped"`
+	Sent       int        `json:"sent"`
+	Registered int        `json:"registered"`
+	Failed     int        `json:"failed"`
+	Failures   []Failure  `json:"failures"`
+}
+
+type job struct {
+	Job
+	roots  []boson.Address
+	cancel context.CancelFunc
+}
+
+// NotifyFunc is called for every root found or made registered by a job.
+type NotifyFunc func(rootCid boson.Address)
+
+// Registry runs the registration jobs of the node.
+type Registry struct {
+	logger  logging.Logger
+	oracle  Oracle
+	overlay boson.Address
+	notify  NotifyFunc
+	o       Options
+	now     func() time.Time
+	// ctx is cancelled on close, it stops following receipts
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// New returns a registry registering overlay as a source on oracle. notify
+// may be nil.
+func New(logger logging.Logger, oracle Oracle, overlay boson.Address, notify NotifyFunc, o Options) *Registry {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if notify == nil {
+		notify = func(boson.Address) {}
+	}
+	if o.Lock == nil {
+		o.Lock = func(boson.Address) (func(), bool) { return func() {}, true }
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		logger:  logger,
+		oracle:  oracle,
+		overlay: overlay,
+		notify:  notify,
+		o:       o,
+		now:     time.Now,
+		ctx:     ctx,
+		cancel:  cancel,
+		jobs:    make(map[string]*job),
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Start starts a job registering roots, of which duplicates are dropped,
+// and returns its initial progress.
+func (r *Registry) Start(roots []boson.Address) (Job, error) {
+	seen := make(map[string]struct{}, len(roots))
+	unique := make([]boson.Address, 0, len(roots))
+	for _, root := range roots {
+		if _, ok := seen[root.ByteString()]; ok {
+			continue
+		}
+		seen[root.ByteString()] = struct{}{}
+		unique = append(unique, root)
+	}
+	if len(unique) == 0 {
+		return Job{}, ErrNoRoots
+	}
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return Job{}, ErrClosed
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		Job: Job{
+			ID:       id,
+			State:    StateRunning,
+			Created:  r.now(),
+			Total:    len(unique),
+			Failures: make([]Failure, 0),
+		},
+		roots:  unique,
+		cancel: cancel,
+	}
+	r.jobs[id] = j
+	r.prune()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(ctx, j)
+	}()
+	return j.snapshot(), nil
+}
+
+// prune forgets the oldest finished jobs beyond maxFinished. It must be
+// called with the lock held.
+func (r *Registry) prune() {
+	var finished []*job
+	for _, j := range r.jobs {
+		if j.State != StateRunning {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].Created.Before(finished[k].Created)
+	})
+	for _, j := range finished[:len(finished)-maxFinished] {
+		delete(r.jobs, j.ID)
+	}
+}
+
+func (j *job) snapshot() Job {
+	s := j.Job
+	s.Failures = append(make([]Failure, 0, len(j.Failures)), j.Failures...)
+	return s
+}
+
+// Job returns the progress of the job with id.
+func (r *Registry) Job(id string) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// Jobs returns the progress of the known jobs, newest first.
+func (r *Registry) Jobs() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]Job, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Created.After(jobs[k].Created)
+	})
+	return jobs
+}
+
+// Cancel stops the job with id. Transactions sent already are not undone,
+// their receipts are still followed.
+func (r *Registry) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.State != StateRunning {
+		return ErrNotRunning
+	}
+	j.State = StateCancelled
+	j.cancel()
+	return nil
+}
+
+// Close cancels the running jobs, stops following receipts and waits for
+// the jobs to stop.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	for _, j := range r.jobs {
+		if j.State == StateRunning {
+			j.State = StateCancelled
+			j.cancel()
+		}
+	}
+	r.mu.Unlock()
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Registry) update(j *job, f func(*Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(&j.Job)
+}
+
+func (r *Registry) fail(j *job, root boson.Address, err error) {
+	r.update(j, func(j *Job) {
+		j.Failures = append(j.Failures, Failure{RootCid: root, Error: err.Error()})
+		j.Failed++
+	})
+}
+
+type pending struct {
+	root boson.Address
+	hash common.Hash
+}
+
+// run registers the roots of j. Sent transactions are handed to a waiter
+// that follows their receipts in order, so sending goes on meanwhile. The
+// waiter is not stopped by cancelling the job, only by closing the
+// registry.
+func (r *Registry) run(ctx context.Context, j *job) {
+	defer j.cancel()
+
+	sent := make(chan pending, len(j.roots))
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		for p := range sent {
+			r.wait(j, p)
+		}
+	}()
+
+	first := true
+	for _, root := range j.roots {
+		if ctx.Err() != nil {
+			break
+		}
+		p, ok := r.register(ctx, j, root, first)
+		if !ok {
+			continue
+		}
+		first = false
+		sent <- p
+	}
+	close(sent)
+	<-waited
+
+	r.update(j, func(j *Job) {
+		if j.State == StateRunning {
+			j.State = StateDone
+		}
+		finished := r.now()
+		j.Finished = &finished
+	})
+}
+
+// register sends the transaction registering root unless it is registered
+// already, after the interval unless it is the first of the job. It
+// returns false if nothing was sent.
+func (r *Registry) register(ctx context.Context, j *job, root boson.Address, first bool) (pending, bool) {
+	unlock, ok := r.o.Lock(root)
+	if !ok {
+		r.fail(j, root, ErrLocked)
+		return pending{}, false
+	}
+	defer unlock()
+
+	registered, err := r.oracle.GetRegisterState(ctx, root, r.overlay)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Debugf("batchreg: job %s: register state of %s: %v", j.ID, root, err)
+			r.fail(j, root, err)
+		}
+		return pending{}, false
+	}
+	if registered {
+		r.update(j, func(j *Job) { j.Skipped++ })
+		r.notify(root)
+		return pending{}, false
+	}
+
+	if !first {
+		select {
+		case <-ctx.Done():
+			return pending{}, false
+		case <-time.After(r.o.Interval):
+		}
+	}
+	hash, err := r.oracle.RegisterCidAndNode(ctx, root, r.overlay)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Debugf("batchreg: job %s: register %s: %v", j.ID, root, err)
+			r.fail(j, root, err)
+		}
+		return pending{}, false
+	}
+	r.update(j, func(j *Job) { j.Sent++ })
+	return pending{root: root, hash: hash}, true
+}
+
+func (r *Registry) wait(j *job, p pending) {
+	ctx, cancel := context.WithTimeout(r.ctx, receiptTimeout)
+	defer cancel()
+	receipt, err := r.oracle.WaitForReceipt(ctx, p.root, p.hash)
+	if err != nil {
+		if r.ctx.Err() == nil {
+			r.logger.Debugf("batchreg: job %s: wait for %s: %v", j.ID, p.hash, err)
+			r.fail(j, p.root, err)
+		}
+		return
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		r.fail(j, p.root, fmt.Errorf("transaction %s failed", p.hash))
+		return
+	}
+	r.update(j, func(j *Job) { j.Registered++ })
+	r.notify(p.root)
+}
@@ -0,0 +1,272 @@
+package batchreg_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/batchreg"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+)
+
+// oracle registers roots in memory. Roots in failing can not be sent,
+// transactions of roots in reverting fail. Sending waits for block and
+// receipts for mine if set.
+type oracle struct {
+	mu         sync.Mutex
+	registered map[string]bool
+	failing    map[string]bool
+	reverting  map[string]bool
+	txs        map[common.Hash][]boson.Address
+	block      chan struct{}
+	mine       chan struct{}
+}
+
+func newOracle() *oracle {
+	return &oracle{
+		registered: make(map[string]bool),
+		failing:    make(map[string]bool),
+		reverting:  make(map[string]bool),
+		txs:        make(map[common.Hash][]boson.Address),
+	}
+}
+
+func (o *oracle) GetRegisterState(_ context.Context, root, _ boson.Address) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.registered[root.String()], nil
+}
+
+func (o *oracle) send(ctx context.Context, roots []boson.Address) (common.Hash, error) {
+	if o.block != nil {
+		select {
+		case <-o.block:
+		case <-ctx.Done():
+			return common.Hash{}, ctx.Err()
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, root := range roots {
+		if o.failing[root.String()] {
+			return common.Hash{}, errors.New("rejected")
+		}
+	}
+	hash := common.BigToHash(big.NewInt(int64(len(o.txs) + 1)))
+	o.txs[hash] = roots
+	return hash, nil
+}
+
+func (o *oracle) RegisterCidAndNode(ctx context.Context, root, _ boson.Address) (common.Hash, error) {
+	return o.send(ctx, []boson.Address{root})
+}
+
+func (o *oracle) WaitForReceipt(ctx context.Context, _ boson.Address, hash common.Hash) (*types.Receipt, error) {
+	if o.mine != nil {
+		select {
+		case <-o.mine:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status := types.ReceiptStatusSuccessful
+	for _, root := range o.txs[hash] {
+		if o.reverting[root.String()] {
+			status = types.ReceiptStatusFailed
+		}
+	}
+	if status == types.ReceiptStatusSuccessful {
+		for _, root := range o.txs[hash] {
+			o.registered[root.String()] = true
+		}
+	}
+	return &types.Receipt{TxHash: hash, Status: status}, nil
+}
+
+func wait(t *testing.T, r *batchreg.Registry, id string) batchreg.Job {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		j, err := r.Job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Finished != nil {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("job still running")
+	return batchreg.Job{}
+}
+
+func TestQueue(t *testing.T) {
+	o := newOracle()
+	roots := []boson.Address{test.RandomAddress(), test.RandomAddress(), test.RandomAddress(), test.RandomAddress()}
+	o.registered[roots[0].String()] = true
+	o.failing[roots[1].String()] = true
+	o.reverting[roots[2].String()] = true
+
+	var (
+		mu       sync.Mutex
+		notified []boson.Address
+	)
+	r := batchreg.New(logging.New(io.Discard, 0), o, test.RandomAddress(), func(root boson.Address) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, root)
+	}, batchreg.Options{Interval: time.Millisecond})
+	defer r.Close()
+
+	if _, err := r.Start(nil); !errors.Is(err, batchreg.ErrNoRoots) {
+		t.Fatalf("got error %v, want %v", err, batchreg.ErrNoRoots)
+	}
+	started, err := r.Start(append(roots, roots[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started.State != batchreg.StateRunning || started.Total != 4 {
+		t.Fatalf("got job %+v", started)
+	}
+
+	j := wait(t, r, started.ID)
+	if j.State != batchreg.StateDone || j.Finished == nil || j.Skipped != 1 || j.Sent != 2 || j.Registered != 1 || j.Failed != 2 {
+		t.Fatalf("got job %+v", j)
+	}
+	if len(o.txs) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(o.txs))
+	}
+	if !j.Failures[0].RootCid.Equal(roots[1]) || !j.Failures[1].RootCid.Equal(roots[2]) {
+		t.Fatalf("got failures %+v", j.Failures)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 2 || !notified[0].Equal(roots[0]) || !notified[1].Equal(roots[3]) {
+		t.Fatalf("got notified %v", notified)
+	}
+}
+
+func TestLock(t *testing.T) {
+	o := newOracle()
+	roots := []boson.Address{test.RandomAddress(), test.RandomAddress(), test.RandomAddress()}
+	var (
+		mu     sync.Mutex
+		locked = map[string]bool{roots[1].String(): true}
+	)
+	r := batchreg.New(logging.New(io.Discard, 0), o, test.RandomAddress(), nil, batchreg.Options{
+		Interval: time.Millisecond,
+		Lock: func(root boson.Address) (func(), bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if locked[root.String()] {
+				return nil, false
+			}
+			locked[root.String()] = true
+			return func() {
+				mu.Lock()
+				defer mu.Unlock()
+				delete(locked, root.String())
+			}, true
+		},
+	})
+	defer r.Close()
+
+	started, err := r.Start(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := wait(t, r, started.ID)
+	if j.State != batchreg.StateDone || j.Sent != 2 || j.Registered != 2 || j.Failed != 1 {
+		t.Fatalf("got job %+v", j)
+	}
+	if !j.Failures[0].RootCid.Equal(roots[1]) || j.Failures[0].Error != batchreg.ErrLocked.Error() {
+		t.Fatalf("got failures %+v", j.Failures)
+	}
+	// the roots are unlocked once sent
+	mu.Lock()
+	defer mu.Unlock()
+	if len(locked) != 1 {
+		t.Fatalf("got locked %v", locked)
+	}
+
+	if jobs := r.Jobs(); len(jobs) != 1 || jobs[0].ID != started.ID {
+		t.Fatalf("got jobs %+v", jobs)
+	}
+	if _, err := r.Job("unknown"); !errors.Is(err, batchreg.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, batchreg.ErrNotFound)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	o := newOracle()
+	o.block = make(chan struct{})
+	r := batchreg.New(logging.New(io.Discard, 0), o, test.RandomAddress(), nil, batchreg.Options{})
+	defer r.Close()
+
+	started, err := r.Start([]boson.Address{test.RandomAddress(), test.RandomAddress()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Cancel(started.ID); err != nil {
+		t.Fatal(err)
+	}
+	j := wait(t, r, started.ID)
+	if j.State != batchreg.StateCancelled || j.Sent != 0 || j.Failed != 0 {
+		t.Fatalf("got job %+v", j)
+	}
+	if err := r.Cancel(started.ID); !errors.Is(err, batchreg.ErrNotRunning) {
+		t.Fatalf("got error %v, want %v", err, batchreg.ErrNotRunning)
+	}
+}
+
+func TestCancelFollowsReceipts(t *testing.T) {
+	o := newOracle()
+	o.mine = make(chan struct{})
+	notified := make(chan boson.Address, 1)
+	r := batchreg.New(logging.New(io.Discard, 0), o, test.RandomAddress(), func(root boson.Address) {
+		notified <- root
+	}, batchreg.Options{Interval: time.Hour})
+	defer r.Close()
+
+	roots := []boson.Address{test.RandomAddress(), test.RandomAddress()}
+	started, err := r.Start(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the job waits for the interval after sending the first root
+	for i := 0; ; i++ {
+		j, err := r.Job(started.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Sent == 1 {
+			break
+		}
+		if i == 500 {
+			t.Fatal("first root not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := r.Cancel(started.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction sent before is followed until mined
+	close(o.mine)
+	j := wait(t, r, started.ID)
+	if j.State != batchreg.StateCancelled || j.Sent != 1 || j.Registered != 1 || j.Failed != 0 {
+		t.Fatalf("got job %+v", j)
+	}
+	if root := <-notified; !root.Equal(roots[0]) {
+		t.Fatalf("got notified %s, want %s", root, roots[0])
+	}
+}
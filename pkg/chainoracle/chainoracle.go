@@ -0,0 +1,110 @@
+// Package chainoracle registers the sources of files with the oracle
+// contract. It wraps the oracle chain service of aurorafs, which answers the
+// calls to the contract, and sends the transactions registering sources
+// through the transaction manager of the node, so registrations of several
+// files may be pending at the same time.
+package chainoracle
+
+import (
+	"context"
+
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+	contract "github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle"
+)
+
+const (
+	// registerGasLimit is the gas limit of registrations and removals.
+	registerGasLimit = 1000000
+
+	// PurposeRegister and PurposeRemove are the purposes registrations and
+	// removals are recorded with by the transaction manager.
+	PurposeRegister = "oracle-register"
+	PurposeRemove   = "oracle-remove"
+)
+
+// publisher is implemented by the oracle service of aurorafs, which
+// notifies the subscribers of the register state of files.
+type publisher interface {
+	PublishRegisterStatus(rootCid boson.Address, status uint64)
+}
+
+var _ chain.Resolver = (*Service)(nil)
+
+// Service is the oracle chain service of aurorafs with registrations sent
+// through the transaction manager, which picks the nonce and gas price and
+// records the transaction.
+type Service struct {
+	chain.Resolver
+
+	logger       logging.Logger
+	address      common.Address
+	abi          abi.ABI
+	transactions *txmgr.Manager
+}
+
+// New wraps resolver, the service of the oracle contract at address, which
+// is usually created by contract.NewServer.
+func New(logger logging.Logger, resolver chain.Resolver, transactions *txmgr.Manager, address common.Address) (*Service, error) {
+	oracleABI, err := contract.OracleMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		Resolver:     resolver,
+		logger:       logger,
+		address:      address,
+		abi:          *oracleABI,
+		transactions: transactions,
+	}, nil
+}
+
+// send sends the transaction calling method of the oracle with the root
+// and the overlay.
+func (s *Service) send(ctx context.Context, purpose, method string, rootCid, address boson.Address) (common.Hash, error) {
+	data, err := s.abi.Pack(method, common.BytesToHash(rootCid.Bytes()), common.BytesToHash(address.Bytes()))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	tx, err := s.transactions.SendTransaction(txmgr.WithPurpose(ctx, purpose, rootCid.String()), &chain.TxRequest{
+		To:       &s.address,
+		Data:     data,
+		GasLimit: registerGasLimit,
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+// RegisterCidAndNode registers address as a source of rootCid.
+func (s *Service) RegisterCidAndNode(ctx context.Context, rootCid boson.Address, address boson.Address) (common.Hash, error) {
+	return s.send(ctx, PurposeRegister, "set", rootCid, address)
+}
+
+// RemoveCidAndNode removes address from the sources of rootCid.
+func (s *Service) RemoveCidAndNode(ctx context.Context, rootCid boson.Address, address boson.Address) (common.Hash, error) {
+	return s.send(ctx, PurposeRemove, "remove", rootCid, address)
+}
+
+// WaitForReceipt waits until the transaction with txHash, or the one that
+// replaced it, is mined and publishes the register state of rootCid.
+func (s *Service) WaitForReceipt(ctx context.Context, rootCid boson.Address, txHash common.Hash) (*types.Receipt, error) {
+	receipt, err := s.transactions.WaitForReceipt(ctx, txHash)
+	status := uint64(0)
+	if err == nil {
+		status = receipt.Status
+	}
+	if p, ok := s.Resolver.(publisher); ok {
+		p.PublishRegisterStatus(rootCid, status)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
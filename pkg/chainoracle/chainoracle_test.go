@@ -0,0 +1,140 @@
+package chainoracle_test
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/chainoracle"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	contract "github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle"
+	oraclemock "github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle/mock"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+// resolver is the wrapped service, which records the published register
+// states.
+type resolver struct {
+	*oraclemock.ChainOracle
+	published map[string]uint64
+}
+
+func (r *resolver) PublishRegisterStatus(rootCid boson.Address, status uint64) {
+	r.published[rootCid.String()] = status
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.New(io.Discard, 0)
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	address, err := signer.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contractAddress := common.HexToAddress("0x0dac1e")
+
+	// the contract is not deployed, the test checks the transactions sent
+	// to it and leaves the calls to the wrapped service
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		address: {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)},
+	}, 8000000)
+	defer backend.Close()
+
+	transactions, err := txmgr.New(logger, backend, signer, backend.Blockchain().Config().ChainID, mock.NewStateStore(), txmgr.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transactions.Close()
+	wrapped := &resolver{ChainOracle: oraclemock.NewServer(), published: make(map[string]uint64)}
+	o, err := chainoracle.New(logger, wrapped, transactions, contractAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second, overlay := test.RandomAddress(), test.RandomAddress(), test.RandomAddress()
+	registered, err := o.RegisterCidAndNode(ctx, first, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second registration is not rejected while the first is pending
+	if _, err := o.RegisterCidAndNode(ctx, second, overlay); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	if receipt, err := o.WaitForReceipt(ctx, first, registered); err != nil || receipt.Status != 1 {
+		t.Fatalf("got receipt %v, error %v", receipt, err)
+	}
+	if status, ok := wrapped.published[first.String()]; !ok || status != 1 {
+		t.Fatalf("got published status %d, %t", status, ok)
+	}
+
+	// the transaction calls set with the root and the overlay
+	tx, _, err := backend.TransactionByHash(ctx, registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.To() == nil || *tx.To() != contractAddress {
+		t.Fatalf("got transaction to %v, want %s", tx.To(), contractAddress)
+	}
+	oracleABI, err := contract.OracleMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err := oracleABI.MethodById(tx.Data()[:4])
+	if err != nil || method.Name != "set" {
+		t.Fatalf("got method %v, error %v", method, err)
+	}
+	args, err := method.Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != [32]byte(common.BytesToHash(first.Bytes())) || args[1] != [32]byte(common.BytesToHash(overlay.Bytes())) {
+		t.Fatalf("got arguments %v", args)
+	}
+
+	removed, err := o.RemoveCidAndNode(ctx, first, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	if _, err := o.WaitForReceipt(ctx, first, removed); err != nil {
+		t.Fatal(err)
+	}
+
+	// calls are answered by the wrapped service
+	if registered, err := o.GetRegisterState(ctx, first, overlay); err != nil || registered {
+		t.Fatalf("got register state %t, error %v", registered, err)
+	}
+
+	records, err := transactions.Records("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		purpose, subject string
+	}{
+		{chainoracle.PurposeRemove, first.String()},
+		{chainoracle.PurposeRegister, second.String()},
+		{chainoracle.PurposeRegister, first.String()},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i, w := range want {
+		if records[i].Purpose != w.purpose || records[i].Subject != w.subject {
+			t.Fatalf("record %d: got purpose %s of %s, want %s of %s", i, records[i].Purpose, records[i].Subject, w.purpose, w.subject)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package chaintraffic settles traffic cheques against the traffic contract.
+// It wraps the traffic chain service of aurorafs, which answers the calls to
+// the contract, and sends the transactions cashing cheques through the
+// transaction manager of the node.
+package chaintraffic
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+	contract "github.com/gauss-project/aurorafs/pkg/settlement/chain/traffic"
+)
+
+const (
+	// sendTimeout limits estimating and sending a transaction.
+	sendTimeout = 10 * time.Second
+
+	// PurposeCashCheque is the purpose transactions cashing a cheque are
+	// recorded with by the transaction manager.
+	PurposeCashCheque = "cash-cheque"
+)
+
+// ErrCashPending is returned when cashing the cheque of a peer while the
+// last transaction cashing it is not mined yet.
+var ErrCashPending = errors.New("chaintraffic: cheque of the peer is being cashed")
+
+var _ chain.Traffic = (*Service)(nil)
+
+// Service is the traffic chain service of aurorafs with cheques cashed
+// through the transaction manager, which picks the nonce and gas price and
+// records the transaction.
+type Service struct {
+	chain.Traffic
+
+	mu           sync.Mutex
+	logger       logging.Logger
+	address      common.Address
+	abi          abi.ABI
+	transactions *txmgr.Manager
+}
+
+// New wraps traffic, the service of the traffic contract at address, which
+// is usually created by contract.NewServer.
+func New(logger logging.Logger, traffic chain.Traffic, transactions *txmgr.Manager, address common.Address) (*Service, error) {
+	trafficABI, err := contract.TrafficMetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		Traffic:      traffic,
+		logger:       logger,
+		address:      address,
+		abi:          *trafficABI,
+		transactions: transactions,
+	}, nil
+}
+
+// CashChequeBeneficiary sends the transaction cashing the cheque of
+// beneficiary. The gas is estimated, so a cheque the contract rejects fails
+// here instead of in a mined transaction. The transaction manager assigns
+// the nonce, so cheques may be cashed while other transactions are pending,
+// but not while the cheque of the same peer is.
+func (s *Service) CashChequeBeneficiary(ctx context.Context, peer boson.Address, beneficiary, recipient common.Address, cumulativePayout *big.Int, signature []byte) (*types.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := s.transactions.Records(txmgr.StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range pending {
+		if r.Purpose == PurposeCashCheque && r.Subject == peer.String() {
+			return nil, ErrCashPending
+		}
+	}
+	data, err := s.abi.Pack("cashChequeBeneficiary", beneficiary, recipient, cumulativePayout, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(txmgr.WithPurpose(ctx, PurposeCashCheque, peer.String()), sendTimeout)
+	defer cancel()
+	tx, err := s.transactions.SendTransaction(ctx, &chain.TxRequest{
+		To:   &s.address,
+		Data: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Infof("chaintraffic: cashing cheque of %s in transaction %s", beneficiary, tx.Hash())
+	return tx, nil
+}
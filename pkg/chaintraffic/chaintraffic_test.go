@@ -0,0 +1,270 @@
+package chaintraffic_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/chaintraffic"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	contract "github.com/gauss-project/aurorafs/pkg/settlement/chain/traffic"
+	trafficmock "github.com/gauss-project/aurorafs/pkg/settlement/chain/traffic/mock"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/cheque"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+func newSigner(t *testing.T) (crypto.Signer, common.Address) {
+	t.Helper()
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	address, err := signer.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, address
+}
+
+func TestCashCheque(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.New(io.Discard, 0)
+	signer, address := newSigner(t)
+	peerSigner, peerAddress := newSigner(t)
+	peer := boson.MustParseHexAddress("ca1e")
+	contractAddress := common.HexToAddress("0x7af1c")
+
+	// the contract is not deployed, the test checks the transactions sent
+	// to it and leaves the calls to the wrapped service
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		address: {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)},
+	}, 8000000)
+	defer backend.Close()
+	chainID := backend.Blockchain().Config().ChainID
+
+	store := mock.NewStateStore()
+	transactions, err := txmgr.New(logger, backend, signer, chainID, store, txmgr.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transactions.Close()
+	paid := new(big.Int)
+	calls := trafficmock.New(trafficmock.WithTransAmount(func(beneficiary, recipient common.Address) (*big.Int, error) {
+		if beneficiary != peerAddress || recipient != address {
+			return nil, errors.New("unexpected cheque")
+		}
+		return paid, nil
+	}))
+	s, err := chaintraffic.New(logger, calls, transactions, contractAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer pays with a cheque, which is cashed at the contract
+	chequeStore := cheque.NewChequeStore(store, address, cheque.RecoverCheque, chainID.Int64())
+	c := cheque.Cheque{Recipient: address, Beneficiary: peerAddress, CumulativePayout: big.NewInt(1000)}
+	signature, err := cheque.NewChequeSigner(peerSigner, chainID.Int64()).Sign(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chequeStore.ReceiveCheque(ctx, &cheque.SignedCheque{Cheque: c, Signature: signature}); err != nil {
+		t.Fatal(err)
+	}
+
+	cashout := cheque.NewCashoutService(store, transactions, s, chequeStore, contractAddress)
+	hash, err := cashout.CashCheque(ctx, peer, peerAddress, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cashout.CashCheque(ctx, peer, peerAddress, address); !errors.Is(err, chaintraffic.ErrCashPending) {
+		t.Fatalf("got error %v, want %v", err, chaintraffic.ErrCashPending)
+	}
+	backend.Commit()
+
+	status, err := cashout.WaitForReceipt(ctx, hash)
+	if err != nil || status != 1 {
+		t.Fatalf("got status %d, error %v", status, err)
+	}
+
+	// the transaction calls cashChequeBeneficiary with the cheque
+	tx, _, err := backend.TransactionByHash(ctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.To() == nil || *tx.To() != contractAddress {
+		t.Fatalf("got transaction to %v, want %s", tx.To(), contractAddress)
+	}
+	trafficABI, err := contract.TrafficMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, err := trafficABI.MethodById(tx.Data()[:4])
+	if err != nil || method.Name != "cashChequeBeneficiary" {
+		t.Fatalf("got method %v, error %v", method, err)
+	}
+	args, err := method.Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != peerAddress || args[1] != address || args[2].(*big.Int).Cmp(c.CumulativePayout) != 0 || !bytes.Equal(args[3].([]byte), signature) {
+		t.Fatalf("got arguments %v", args)
+	}
+	paid.Set(c.CumulativePayout)
+
+	// calls are answered by the wrapped service
+	if amount, err := s.TransAmount(peerAddress, address); err != nil || amount.Cmp(c.CumulativePayout) != 0 {
+		t.Fatalf("got amount %v, error %v, want %v", amount, err, c.CumulativePayout)
+	}
+
+	// the cheque is cashed again once the last transaction is mined
+	if _, err := cashout.CashCheque(ctx, peer, peerAddress, address); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := transactions.Records("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Hash != hash || records[1].Purpose != chaintraffic.PurposeCashCheque || records[1].Subject != peer.String() {
+		t.Fatalf("got records %+v", records)
+	}
+}
+
+// trafficCodeEnv names the file holding the creation bytecode of the traffic
+// contract in hex, as written by solc --bin. The bytecode is not part of the
+// repository, the contract test is skipped without it.
+const trafficCodeEnv = "FAVORX_TEST_TRAFFIC_CODE"
+
+func trafficCode(t *testing.T) []byte {
+	t.Helper()
+	path := os.Getenv(trafficCodeEnv)
+	if path == "" {
+		t.Skipf("%s is not set", trafficCodeEnv)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return code
+}
+
+func TestCashChequeContract(t *testing.T) {
+	code := trafficCode(t)
+	ctx := context.Background()
+	logger := logging.New(io.Discard, 0)
+	signer, address := newSigner(t)
+	peerSigner, peerAddress := newSigner(t)
+	ownerKey, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := boson.MustParseHexAddress("ca1e")
+	ether := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		address: {Balance: ether},
+		ethcrypto.PubkeyToAddress(ownerKey.PublicKey): {Balance: ether},
+	}, 8000000)
+	defer backend.Close()
+	chainID := backend.Blockchain().Config().ChainID
+
+	// the owner deploys the traffic contract and pays the peer
+	owner, err := bind.NewKeyedTransactorWithChainID(ownerKey, chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trafficABI, err := contract.TrafficMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contractAddress, _, traffic, err := bind.DeployContract(owner, *trafficABI, code, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	if _, err := traffic.Transact(owner, "initialize"); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	if _, err := traffic.Transact(owner, "transfer", peerAddress, big.NewInt(2000)); err != nil {
+		t.Fatal(err)
+	}
+	backend.Commit()
+	calls, err := contract.NewTraffic(contractAddress, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &bind.CallOpts{Context: ctx}
+
+	store := mock.NewStateStore()
+	transactions, err := txmgr.New(logger, backend, signer, chainID, store, txmgr.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transactions.Close()
+	s, err := chaintraffic.New(logger, trafficmock.New(), transactions, contractAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cash cashes the cheque of the peer with the cumulative payout and
+	// checks what the contract moved
+	cash := func(payout int64) {
+		t.Helper()
+		c := cheque.Cheque{Recipient: address, Beneficiary: peerAddress, CumulativePayout: big.NewInt(payout)}
+		signature, err := cheque.NewChequeSigner(peerSigner, chainID.Int64()).Sign(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := s.CashChequeBeneficiary(ctx, peer, peerAddress, address, c.CumulativePayout, signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend.Commit()
+		receipt, err := transactions.WaitForReceipt(ctx, tx.Hash())
+		if err != nil || receipt.Status != types.ReceiptStatusSuccessful {
+			t.Fatalf("got receipt %+v, error %v", receipt, err)
+		}
+		if amount, err := calls.TransTraffic(opts, peerAddress, address); err != nil || amount.Int64() != payout {
+			t.Fatalf("got transferred amount %v, error %v, want %d", amount, err, payout)
+		}
+		if balance, err := calls.BalanceOf(opts, address); err != nil || balance.Int64() != payout {
+			t.Fatalf("got balance %v, error %v, want %d", balance, err, payout)
+		}
+		if balance, err := calls.BalanceOf(opts, peerAddress); err != nil || balance.Int64() != 2000-payout {
+			t.Fatalf("got peer balance %v, error %v, want %d", balance, err, 2000-payout)
+		}
+	}
+
+	cash(1000)
+	// the next cheque only moves the difference to the last one
+	cash(1500)
+
+	records, err := transactions.Records(txmgr.StatusMined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Purpose != chaintraffic.PurposeCashCheque || records[0].Subject != peer.String() {
+		t.Fatalf("got records %+v", records)
+	}
+}
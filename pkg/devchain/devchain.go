@@ -0,0 +1,271 @@
+//go:build devchain
+// +build devchain
+
+// Package devchain runs a go-ethereum node in developer mode, the chain of
+// geth --dev, for development and integration tests. The oracle and traffic
+// contracts are deployed from their compiled bytecode, if it is given, and
+// the given accounts are funded with ether and traffic. Every transaction is
+// mined as soon as it is sent.
+//
+// The package links the go-ethereum node and is only built with the
+// devchain tag, so the node binary does not carry it.
+package devchain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain/traffic"
+)
+
+const (
+	gasLimit      = 30000000
+	deployTimeout = time.Minute
+)
+
+// Options are the options of the dev chain.
+type Options struct {
+	// Accounts are funded with Balance wei and Traffic traffic.
+	Accounts []common.Address
+	Balance  *big.Int
+	Traffic  *big.Int
+	// OracleCode and TrafficCode are the compiled creation bytecode of the
+	// contracts, as solc --bin prints it. A contract without bytecode is
+	// not deployed.
+	OracleCode  []byte
+	TrafficCode []byte
+}
+
+// Chain is a go-ethereum node in developer mode serving the json-rpc api
+// over http.
+type Chain struct {
+	stack    *node.Node
+	backend  *eth.Ethereum
+	client   *ethclient.Client
+	key      *ecdsa.PrivateKey
+	chainID  *big.Int
+	oracle   common.Address
+	traffic  common.Address
+	endpoint string
+}
+
+// New starts a chain serving the json-rpc api on listen, deploys the
+// contracts with bytecode and funds the accounts. The accounts get 1000 ether and 10^18
+// traffic by default.
+func New(listen string, o Options) (_ *Chain, err error) {
+	if o.Balance == nil {
+		o.Balance = new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))
+	}
+	if o.Traffic == nil {
+		o.Traffic = big.NewInt(params.Ether)
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen address: %w", err)
+	}
+	httpPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("listen address: %w", err)
+	}
+
+	// the go-ethereum packages log to stderr once they are imported
+	log.Root().SetHandler(log.DiscardHandler())
+
+	// the developer account signs the blocks and deploys the contracts
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	developer := crypto.PubkeyToAddress(key.PublicKey)
+
+	stack, err := node.New(&node.Config{
+		Name:        "devchain",
+		HTTPHost:    host,
+		HTTPPort:    httpPort,
+		HTTPModules: []string{"eth", "net", "web3"},
+		P2P:         p2p.Config{MaxPeers: 0, NoDiscovery: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = stack.Close()
+		}
+	}()
+	ks := keystore.NewKeyStore(stack.KeyStoreDir(), keystore.LightScryptN, keystore.LightScryptP)
+	stack.AccountManager().AddBackend(ks)
+	account, err := ks.ImportECDSA(key, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.Unlock(account, ""); err != nil {
+		return nil, err
+	}
+
+	genesis := core.DeveloperGenesisBlock(0, gasLimit, developer)
+	for _, a := range o.Accounts {
+		genesis.Alloc[a] = core.GenesisAccount{Balance: o.Balance}
+	}
+	config := ethconfig.Defaults
+	config.NetworkId = 1337
+	config.SyncMode = downloader.FullSync
+	config.Genesis = genesis
+	config.Miner.Etherbase = developer
+	config.Miner.GasPrice = big.NewInt(1)
+	backend, err := eth.New(stack, &config)
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.Start(); err != nil {
+		return nil, err
+	}
+	if err := backend.StartMining(0); err != nil {
+		return nil, err
+	}
+	rpcClient, err := stack.Attach()
+	if err != nil {
+		return nil, err
+	}
+
+	client := ethclient.NewClient(rpcClient)
+	defer func() {
+		if err != nil {
+			client.Close()
+		}
+	}()
+
+	chain := &Chain{
+		stack:    stack,
+		backend:  backend,
+		client:   client,
+		key:      key,
+		chainID:  backend.BlockChain().Config().ChainID,
+		endpoint: stack.HTTPEndpoint(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deployTimeout)
+	defer cancel()
+	if len(o.OracleCode) > 0 {
+		if chain.oracle, err = chain.deploy(ctx, oracle.OracleMetaData, o.OracleCode); err != nil {
+			return nil, fmt.Errorf("oracle contract: %w", err)
+		}
+	}
+	if len(o.TrafficCode) == 0 {
+		return chain, nil
+	}
+	if chain.traffic, err = chain.deploy(ctx, traffic.TrafficMetaData, o.TrafficCode); err != nil {
+		return nil, fmt.Errorf("traffic contract: %w", err)
+	}
+	for _, a := range o.Accounts {
+		if err := chain.transact(ctx, traffic.TrafficMetaData, chain.traffic, "transfer", a, o.Traffic); err != nil {
+			return nil, fmt.Errorf("fund %s: %w", a, err)
+		}
+	}
+	return chain, nil
+}
+
+// deploy deploys and initializes a contract.
+func (c *Chain) deploy(ctx context.Context, meta *bind.MetaData, code []byte) (common.Address, error) {
+	parsed, err := meta.GetAbi()
+	if err != nil {
+		return common.Address{}, err
+	}
+	opts, err := c.transactOpts(ctx)
+	if err != nil {
+		return common.Address{}, err
+	}
+	address, tx, _, err := bind.DeployContract(opts, *parsed, code, c.client)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if _, err := bind.WaitDeployed(ctx, c.client, tx); err != nil {
+		return common.Address{}, err
+	}
+	if err := c.transact(ctx, meta, address, "initialize"); err != nil {
+		return common.Address{}, err
+	}
+	return address, nil
+}
+
+// transact calls method of the contract at address and waits until the
+// call is mined.
+func (c *Chain) transact(ctx context.Context, meta *bind.MetaData, address common.Address, method string, args ...interface{}) error {
+	parsed, err := meta.GetAbi()
+	if err != nil {
+		return err
+	}
+	opts, err := c.transactOpts(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := bind.NewBoundContract(address, *parsed, c.client, c.client, c.client).Transact(opts, method, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	receipt, err := bind.WaitMined(ctx, c.client, tx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("%s: transaction reverted", method)
+	}
+	return nil
+}
+
+func (c *Chain) transactOpts(ctx context.Context) (*bind.TransactOpts, error) {
+	opts, err := bind.NewKeyedTransactorWithChainID(c.key, c.chainID)
+	if err != nil {
+		return nil, err
+	}
+	opts.Context = ctx
+	return opts, nil
+}
+
+// Endpoint returns the json-rpc endpoint of the chain.
+func (c *Chain) Endpoint() string {
+	return c.endpoint
+}
+
+// ChainID returns the id of the chain.
+func (c *Chain) ChainID() *big.Int {
+	return c.chainID
+}
+
+// OracleAddress returns the address of the oracle contract, the zero
+// address if it is not deployed.
+func (c *Chain) OracleAddress() common.Address {
+	return c.oracle
+}
+
+// TrafficAddress returns the address of the traffic contract, the zero
+// address if it is not deployed.
+func (c *Chain) TrafficAddress() common.Address {
+	return c.traffic
+}
+
+// Close stops the node and discards the chain.
+func (c *Chain) Close() error {
+	c.client.Close()
+	return c.stack.Close()
+}
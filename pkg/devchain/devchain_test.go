@@ -0,0 +1,143 @@
+//go:build devchain
+// +build devchain
+
+package devchain_test
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/devchain"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain/traffic"
+)
+
+func newSigner(t *testing.T) (crypto.Signer, common.Address) {
+	t.Helper()
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	address, err := signer.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, address
+}
+
+// contractCode returns the creation bytecode in the file named by env, as
+// solc --bin writes it. The bytecode is not part of the repository, the
+// test is skipped without it.
+func contractCode(t *testing.T, env string) []byte {
+	t.Helper()
+	path := os.Getenv(env)
+	if path == "" {
+		t.Skipf("%s is not set", env)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return code
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	signer, address := newSigner(t)
+	balance := big.NewInt(1e18)
+	chain, err := devchain.New("127.0.0.1:0", devchain.Options{
+		Accounts: []common.Address{address},
+		Balance:  balance,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	client, err := ethclient.Dial(chain.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if chainID, err := client.ChainID(ctx); err != nil || chainID.Cmp(chain.ChainID()) != 0 {
+		t.Fatalf("got chain id %v, error %v, want %v", chainID, err, chain.ChainID())
+	}
+	if got, err := client.BalanceAt(ctx, address, nil); err != nil || got.Cmp(balance) != 0 {
+		t.Fatalf("got balance %v, error %v, want %v", got, err, balance)
+	}
+	// contracts without bytecode are not deployed
+	if chain.OracleAddress() != (common.Address{}) || chain.TrafficAddress() != (common.Address{}) {
+		t.Fatalf("got contracts %s and %s", chain.OracleAddress(), chain.TrafficAddress())
+	}
+
+	// a transaction of a funded account is mined as soon as it is sent
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(0, common.HexToAddress("0xca1e"), big.NewInt(1), 21000, gasPrice, nil)
+	tx, err = signer.SignTx(tx, chain.ChainID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendTransaction(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	if receipt, err := bind.WaitMined(ctx, client, tx); err != nil || receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("got receipt %v, error %v", receipt, err)
+	}
+}
+
+func TestContracts(t *testing.T) {
+	oracleCode := contractCode(t, "FAVORX_TEST_ORACLE_CODE")
+	trafficCode := contractCode(t, "FAVORX_TEST_TRAFFIC_CODE")
+	ctx := context.Background()
+	_, address := newSigner(t)
+	funded := big.NewInt(1e15)
+	chain, err := devchain.New("127.0.0.1:0", devchain.Options{
+		Accounts:    []common.Address{address},
+		Traffic:     funded,
+		OracleCode:  oracleCode,
+		TrafficCode: trafficCode,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	client, err := ethclient.Dial(chain.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the contracts are deployed, initialized and fund the account
+	o, err := oracle.NewOracle(chain.OracleAddress(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := o.Owner(&bind.CallOpts{Context: ctx}); err != nil || owner == (common.Address{}) {
+		t.Fatalf("got oracle owner %s, error %v", owner, err)
+	}
+	tr, err := traffic.NewTraffic(chain.TrafficAddress(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := tr.BalanceOf(&bind.CallOpts{Context: ctx}, address); err != nil || got.Cmp(funded) != 0 {
+		t.Fatalf("got traffic %v, error %v, want %v", got, err, funded)
+	}
+}
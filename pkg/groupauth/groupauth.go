@@ -0,0 +1,449 @@
+// Package groupauth implements the access control of private multicast
+// groups.
+//
+// The id of a private group is derived from the ethereum address of its
+// owner and its name, so the owner of a group can be told from its id alone.
+// Joining a private group requires a Token signed by the owner. A token may
+// list the overlays allowed to use it and may expire. Members announce their
+// token to the group in a hello message, every member keeps the announced
+// members and drops messages from everyone else. Members sign what they send
+// to a private group with the time of sending, so the sender of a message
+// can not be forged and captured messages can not be replayed. Requests
+// of overlays that are not members are answered with a refusal.
+//
+// The owner creates a key for encrypting the messages of the group. The
+// hello message carries the public key of the member, members that hold the
+// group key pass it to new members sealed for that public key.
+package groupauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	groupPrefix  = "groupauth-group-"
+	memberPrefix = "groupauth-member-"
+)
+
+var (
+	helloMagic   = []byte("\x00groupauth/hello/1\x00")
+	keyMagic     = []byte("\x00groupauth/key/1\x00")
+	signedMagic  = []byte("\x00groupauth/signed/1\x00")
+	refusalMagic = []byte("\x00groupauth/refused/1\x00")
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that can not be decoded or
+	// whose signature does not match the owner of the group.
+	ErrInvalidToken = errors.New("groupauth: invalid token")
+	// ErrExpired is returned for expired tokens.
+	ErrExpired = errors.New("groupauth: token expired")
+	// ErrNotAllowed is returned when the token does not list the overlay.
+	ErrNotAllowed = errors.New("groupauth: overlay not allowed")
+	// ErrInvalidSignature is returned for messages that are not signed by
+	// the overlay they claim to be sent by.
+	ErrInvalidSignature = errors.New("groupauth: invalid signature")
+	// ErrStale is returned for messages signed too long ago or ahead of
+	// the local time.
+	ErrStale = errors.New("groupauth: stale message")
+	// ErrReplayed is returned for messages that were opened before.
+	ErrReplayed = errors.New("groupauth: replayed message")
+)
+
+// GroupID returns the id of the private group name owned by owner.
+func GroupID(owner common.Address, name string) boson.Address {
+	return multicast.GenerateGID(strings.ToLower(owner.Hex()) + "/" + name)
+}
+
+// Token grants access to a private group.
+type Token struct {
+	GID       boson.Address   `json:"gid"`
+	Name      string          `json:"name"`
+	Owner     common.Address  `json:"owner"`
+	Members   []boson.Address `json:"members,omitempty"`
+	Expires   int64           `json:"expires,omitempty"`
+	Signature []byte          `json:"signature,omitempty"`
+}
+
+func (t Token) signedData() ([]byte, error) {
+	t.Signature = nil
+	return json.Marshal(t)
+}
+
+// Issue creates a token for the private group name of the signer. Without
+// members any overlay may use the token, a zero expiry never expires.
+func Issue(signer crypto.Signer, name string, members []boson.Address, expires time.Time) (Token, error) {
+	owner, err := signer.EthereumAddress()
+	if err != nil {
+		return Token{}, err
+	}
+	t := Token{
+		GID:     GroupID(owner, name),
+		Name:    name,
+		Owner:   owner,
+		Members: members,
+	}
+	if !expires.IsZero() {
+		t.Expires = expires.Unix()
+	}
+
+	data, err := t.signedData()
+	if err != nil {
+		return Token{}, err
+	}
+	t.Signature, err = signer.Sign(data)
+	if err != nil {
+		return Token{}, err
+	}
+	return t, nil
+}
+
+// Verify checks that the token is signed by the owner of its group and
+// grants overlay access at the given time.
+func (t Token) Verify(overlay boson.Address, now time.Time) error {
+	if !t.GID.Equal(GroupID(t.Owner, t.Name)) {
+		return ErrInvalidToken
+	}
+	data, err := t.signedData()
+	if err != nil {
+		return err
+	}
+	pub, err := crypto.Recover(t.Signature, data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	signer, err := crypto.NewEthereumAddress(*pub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(signer, t.Owner.Bytes()) {
+		return ErrInvalidToken
+	}
+
+	if t.Expires != 0 && now.Unix() >= t.Expires {
+		return ErrExpired
+	}
+	if len(t.Members) > 0 && !overlay.MemberOf(t.Members) {
+		return ErrNotAllowed
+	}
+	return nil
+}
+
+// Encode returns the token in the form it is passed in headers.
+func (t Token) Encode() (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decode parses a token encoded by Encode.
+func Decode(s string) (Token, error) {
+	var t Token
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return t, nil
+}
+
+// Hello announces a member to the group. NeedKey asks the members for the
+// group key.
+type Hello struct {
+	Token     Token  `json:"token"`
+	PublicKey []byte `json:"publicKey,omitempty"`
+	NeedKey   bool   `json:"needKey,omitempty"`
+}
+
+// Marshal returns the hello message.
+func (h Hello) Marshal() ([]byte, error) {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, helloMagic...), b...), nil
+}
+
+// IsHello reports whether data is a hello message.
+func IsHello(data []byte) bool {
+	return bytes.HasPrefix(data, helloMagic)
+}
+
+// ParseHello parses a hello message.
+func ParseHello(data []byte) (Hello, error) {
+	var h Hello
+	if !IsHello(data) {
+		return h, ErrInvalidToken
+	}
+	if err := json.Unmarshal(data[len(helloMagic):], &h); err != nil {
+		return h, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return h, nil
+}
+
+// KeyMessage returns the message passing the group key, sealed for a new
+// member, to that member.
+func KeyMessage(sealed []byte) []byte {
+	return append(append([]byte{}, keyMagic...), sealed...)
+}
+
+// IsKeyMessage reports whether data is a key message.
+func IsKeyMessage(data []byte) bool {
+	return bytes.HasPrefix(data, keyMagic)
+}
+
+// ParseKeyMessage returns the sealed group key of a key message.
+func ParseKeyMessage(data []byte) ([]byte, error) {
+	if !IsKeyMessage(data) {
+		return nil, ErrInvalidToken
+	}
+	return data[len(keyMagic):], nil
+}
+
+// MaxMessageAge is how far the signing time of a message received in a
+// private group may lie away from the local time.
+const MaxMessageAge = 5 * time.Minute
+
+// signedPayload returns what is signed for data sent to the group gid at
+// the unix nano time ts.
+func signedPayload(gid boson.Address, ts []byte, data []byte) []byte {
+	payload := make([]byte, 0, len(gid.Bytes())+len(ts)+len(data))
+	return append(append(append(payload, gid.Bytes()...), ts...), data...)
+}
+
+// Sign returns data sent to the group gid signed by signer together with
+// the time now.
+func Sign(signer crypto.Signer, gid boson.Address, data []byte, now time.Time) ([]byte, error) {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(now.UnixNano()))
+	sig, err := signer.Sign(signedPayload(gid, ts, data))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(signedMagic)+len(sig)+len(ts)+len(data))
+	out = append(append(append(append(out, signedMagic...), sig...), ts...), data...)
+	return out, nil
+}
+
+// Verifier opens the signed messages received in private groups. A message
+// is accepted once, and only while its signing time is within MaxMessageAge
+// of the local time, so captured messages can not be replayed.
+type Verifier struct {
+	networkID uint64
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewVerifier returns a Verifier for the overlays of the network networkID.
+func NewVerifier(networkID uint64) *Verifier {
+	return &Verifier{
+		networkID: networkID,
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// Open checks that a message received in the group gid at the time now is
+// signed by the overlay from, recently and for the first time, and returns
+// the data it carries.
+func (v *Verifier) Open(gid, from boson.Address, msg []byte, now time.Time) ([]byte, error) {
+	const sigSize, tsSize = 65, 8
+	if !bytes.HasPrefix(msg, signedMagic) || len(msg) < len(signedMagic)+sigSize+tsSize {
+		return nil, ErrInvalidSignature
+	}
+	sig := msg[len(signedMagic) : len(signedMagic)+sigSize]
+	ts := msg[len(signedMagic)+sigSize : len(signedMagic)+sigSize+tsSize]
+	data := msg[len(signedMagic)+sigSize+tsSize:]
+
+	pub, err := crypto.Recover(sig, signedPayload(gid, ts, data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	overlay, err := crypto.NewOverlayAddress(*pub, v.networkID)
+	if err != nil {
+		return nil, err
+	}
+	if !overlay.Equal(from) {
+		return nil, ErrInvalidSignature
+	}
+
+	signed := time.Unix(0, int64(binary.BigEndian.Uint64(ts)))
+	if signed.Before(now.Add(-MaxMessageAge)) || signed.After(now.Add(MaxMessageAge)) {
+		return nil, ErrStale
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.pruned) > MaxMessageAge {
+		for k, t := range v.seen {
+			if t.Before(now.Add(-MaxMessageAge)) {
+				delete(v.seen, k)
+			}
+		}
+		v.pruned = now
+	}
+	if _, ok := v.seen[string(sig)]; ok {
+		return nil, ErrReplayed
+	}
+	v.seen[string(sig)] = signed
+	return data, nil
+}
+
+// Refusal returns the answer to requests of overlays that are not members.
+func Refusal() []byte {
+	return append([]byte{}, refusalMagic...)
+}
+
+// IsRefusal reports whether data is the answer to a refused request.
+func IsRefusal(data []byte) bool {
+	return bytes.Equal(data, refusalMagic)
+}
+
+// Group is a private group known to this node. Token is the token this
+// node joined with, it is empty for groups the node only owns. Key is the
+// group key, it is empty until a member passed it to this node.
+type Group struct {
+	GID   boson.Address  `json:"gid"`
+	Name  string         `json:"name"`
+	Owner common.Address `json:"owner"`
+	Token *Token         `json:"token,omitempty"`
+	Key   []byte         `json:"key,omitempty"`
+}
+
+// Member is a member announced to a private group.
+type Member struct {
+	Overlay boson.Address `json:"overlay"`
+	Expires int64         `json:"expires,omitempty"`
+}
+
+// Active reports whether the membership is valid at the given time.
+func (m Member) Active(now time.Time) bool {
+	return m.Expires == 0 || now.Unix() < m.Expires
+}
+
+// Store persists private groups and their members in a state store.
+type Store struct {
+	store storage.StateStorer
+}
+
+// NewStore returns a Store keeping the private groups and their members in
+// store.
+func NewStore(store storage.StateStorer) *Store {
+	return &Store{store: store}
+}
+
+func memberKey(gid, overlay boson.Address) string {
+	return fmt.Sprintf("%s%s-%s", memberPrefix, gid, overlay)
+}
+
+// PutGroup saves g, overwriting a previous record of the same group.
+func (s *Store) PutGroup(g Group) error {
+	return s.store.Put(groupPrefix+g.GID.String(), g)
+}
+
+// Group returns the private group gid. It returns storage.ErrNotFound for
+// groups that are not private.
+func (s *Store) Group(gid boson.Address) (Group, error) {
+	var g Group
+	err := s.store.Get(groupPrefix+gid.String(), &g)
+	return g, err
+}
+
+// Groups returns all private groups.
+func (s *Store) Groups() ([]Group, error) {
+	var groups []Group
+	err := s.store.Iterate(groupPrefix, func(_, value []byte) (bool, error) {
+		var g Group
+		if err := json.Unmarshal(value, &g); err != nil {
+			return true, err
+		}
+		groups = append(groups, g)
+		return false, nil
+	})
+	return groups, err
+}
+
+// AddMember records a member announced with token t. It returns false if
+// the member was known already.
+func (s *Store) AddMember(overlay boson.Address, t Token) (bool, error) {
+	key := memberKey(t.GID, overlay)
+	var m Member
+	err := s.store.Get(key, &m)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	known := err == nil
+	if known && m.Expires == t.Expires {
+		return false, nil
+	}
+	return !known, s.store.Put(key, Member{Overlay: overlay, Expires: t.Expires})
+}
+
+// Member returns the member overlay of the group gid.
+func (s *Store) Member(gid, overlay boson.Address) (Member, error) {
+	var m Member
+	err := s.store.Get(memberKey(gid, overlay), &m)
+	return m, err
+}
+
+// IsMember reports whether overlay is an active member of the group gid.
+func (s *Store) IsMember(gid, overlay boson.Address, now time.Time) (bool, error) {
+	m, err := s.Member(gid, overlay)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.Active(now), nil
+}
+
+// Leave drops the token and the members of the group gid. Groups owned by
+// this node are kept so tokens can still be issued for them.
+func (s *Store) Leave(gid boson.Address, owner common.Address) error {
+	var keys []string
+	err := s.store.Iterate(memberPrefix+gid.String()+"-", func(key, _ []byte) (bool, error) {
+		keys = append(keys, string(key))
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.store.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	g, err := s.Group(gid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if g.Owner == owner {
+		g.Token = nil
+		return s.PutGroup(g)
+	}
+	return s.store.Delete(groupPrefix + gid.String())
+}
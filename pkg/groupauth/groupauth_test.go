@@ -0,0 +1,198 @@
+package groupauth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupauth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+var (
+	alice = boson.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	bob   = boson.MustParseHexAddress("0f3b6d3c9e2b2d1a0c9e8d7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b")
+)
+
+func newSigner(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.NewDefaultSigner(key)
+}
+
+func TestToken(t *testing.T) {
+	owner := newSigner(t)
+	now := time.Unix(1000, 0)
+
+	token, err := groupauth.Issue(owner, "chat", []boson.Address{alice}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := owner.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.GID.Equal(groupauth.GroupID(addr, "chat")) {
+		t.Fatal("token of another group")
+	}
+
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := groupauth.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := decoded.Verify(alice, now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := decoded.Verify(bob, now); !errors.Is(err, groupauth.ErrNotAllowed) {
+		t.Fatalf("got error %v, want %v", err, groupauth.ErrNotAllowed)
+	}
+	if err := decoded.Verify(alice, now.Add(2*time.Hour)); !errors.Is(err, groupauth.ErrExpired) {
+		t.Fatalf("got error %v, want %v", err, groupauth.ErrExpired)
+	}
+
+	// tokens signed by someone else than the owner are rejected
+	forged, err := groupauth.Issue(newSigner(t), "chat", nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.GID, forged.Owner = token.GID, token.Owner
+	if err := forged.Verify(alice, now); !errors.Is(err, groupauth.ErrInvalidToken) {
+		t.Fatalf("got error %v, want %v", err, groupauth.ErrInvalidToken)
+	}
+
+	hello, err := groupauth.Hello{Token: token, PublicKey: []byte("public key"), NeedKey: true}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	announced, err := groupauth.ParseHello(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := announced.Token.Verify(alice, now); err != nil {
+		t.Fatalf("verify announced: %v", err)
+	}
+	if string(announced.PublicKey) != "public key" || !announced.NeedKey {
+		t.Fatalf("got hello %+v", announced)
+	}
+
+	sealed, err := groupauth.ParseKeyMessage(groupauth.KeyMessage([]byte("sealed")))
+	if err != nil || string(sealed) != "sealed" {
+		t.Fatalf("got sealed key %q %v", sealed, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	const networkID = 10
+	signer := newSigner(t)
+	pub, err := signer.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := crypto.NewOverlayAddress(*pub, networkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid := groupauth.GroupID(common.Address{}, "chat")
+	now := time.Now()
+
+	msg, err := groupauth.Sign(signer, gid, []byte("hello"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := groupauth.NewVerifier(networkID)
+
+	for name, open := range map[string]func() ([]byte, error){
+		"other sender": func() ([]byte, error) { return v.Open(gid, alice, msg, now) },
+		"other group":  func() ([]byte, error) { return v.Open(alice, overlay, msg, now) },
+		"unsigned":     func() ([]byte, error) { return v.Open(gid, overlay, []byte("hello"), now) },
+		"altered": func() ([]byte, error) {
+			return v.Open(gid, overlay, append(append([]byte{}, msg...), '!'), now)
+		},
+	} {
+		if _, err := open(); !errors.Is(err, groupauth.ErrInvalidSignature) {
+			t.Errorf("%s: got error %v, want %v", name, err, groupauth.ErrInvalidSignature)
+		}
+	}
+
+	if _, err := v.Open(gid, overlay, msg, now.Add(groupauth.MaxMessageAge+time.Second)); !errors.Is(err, groupauth.ErrStale) {
+		t.Fatalf("late: got error %v, want %v", err, groupauth.ErrStale)
+	}
+	if _, err := v.Open(gid, overlay, msg, now.Add(-groupauth.MaxMessageAge-time.Second)); !errors.Is(err, groupauth.ErrStale) {
+		t.Fatalf("early: got error %v, want %v", err, groupauth.ErrStale)
+	}
+
+	data, err := v.Open(gid, overlay, msg, now)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("got %q %v", data, err)
+	}
+	if _, err := v.Open(gid, overlay, msg, now.Add(time.Second)); !errors.Is(err, groupauth.ErrReplayed) {
+		t.Fatalf("replay: got error %v, want %v", err, groupauth.ErrReplayed)
+	}
+
+	if !groupauth.IsRefusal(groupauth.Refusal()) || groupauth.IsRefusal(msg) {
+		t.Fatal("refusal not recognised")
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := groupauth.NewStore(mock.NewStateStore())
+	owner := newSigner(t)
+	addr, err := owner.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+
+	token, err := groupauth.Issue(owner, "chat", nil, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutGroup(groupauth.Group{GID: token.GID, Name: "chat", Owner: addr, Token: &token}); err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := s.AddMember(alice, token)
+	if err != nil || !added {
+		t.Fatalf("add member: got %v %v", added, err)
+	}
+	added, err = s.AddMember(alice, token)
+	if err != nil || added {
+		t.Fatalf("add known member: got %v %v", added, err)
+	}
+
+	if ok, _ := s.IsMember(token.GID, alice, now); !ok {
+		t.Fatal("alice is no member")
+	}
+	if ok, _ := s.IsMember(token.GID, alice, now.Add(time.Hour)); ok {
+		t.Fatal("expired member is active")
+	}
+	if ok, _ := s.IsMember(token.GID, bob, now); ok {
+		t.Fatal("bob is a member")
+	}
+
+	// owned groups are kept on leave
+	if err := s.Leave(token.GID, addr); err != nil {
+		t.Fatal(err)
+	}
+	g, err := s.Group(token.GID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Token != nil {
+		t.Fatal("token kept after leave")
+	}
+	if ok, _ := s.IsMember(token.GID, alice, now); ok {
+		t.Fatal("member kept after leave")
+	}
+}
@@ -0,0 +1,132 @@
+// Package groupconf persists the multicast groups joined or observed at
+// runtime, so they are applied again when the node restarts. The nodes
+// removed from a group by a change of its settings are kept alongside.
+package groupconf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	storePrefix   = "group-conf-"
+	removedPrefix = "group-removed-"
+)
+
+// Store persists group configurations in a state store.
+type Store struct {
+	store storage.StateStorer
+
+	removedMu sync.Mutex
+}
+
+// NewStore returns a Store keeping the group configurations and their
+// removed nodes in store.
+func NewStore(store storage.StateStorer) *Store {
+	return &Store{store: store}
+}
+
+// GroupID returns the id of the group named by a configuration, which is
+// either the hex encoded id or a name the id is derived from.
+func GroupID(name string) boson.Address {
+	gid, err := boson.ParseHexAddress(name)
+	if err != nil {
+		return multicast.GenerateGID(name)
+	}
+	return gid
+}
+
+func confKey(gid boson.Address) string {
+	return fmt.Sprintf("%s%s", storePrefix, gid)
+}
+
+func removedKey(gid boson.Address) string {
+	return fmt.Sprintf("%s%s", removedPrefix, gid)
+}
+
+// Put saves c, overwriting a previous configuration of the same group.
+func (s *Store) Put(c model.ConfigNodeGroup) error {
+	return s.store.Put(confKey(GroupID(c.Name)), c)
+}
+
+// Get returns the configuration of the group gid.
+func (s *Store) Get(gid boson.Address) (model.ConfigNodeGroup, error) {
+	var c model.ConfigNodeGroup
+	err := s.store.Get(confKey(gid), &c)
+	return c, err
+}
+
+// Delete removes the configuration of the group gid and its removed nodes.
+func (s *Store) Delete(gid boson.Address) error {
+	s.removedMu.Lock()
+	defer s.removedMu.Unlock()
+
+	for _, key := range []string{confKey(gid), removedKey(gid)} {
+		if err := s.store.Delete(key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removed returns the nodes removed from the group gid.
+func (s *Store) Removed(gid boson.Address) ([]boson.Address, error) {
+	var removed []boson.Address
+	err := s.store.Get(removedKey(gid), &removed)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	return removed, err
+}
+
+// SetRemoved records the nodes removed from the group gid. The nodes the
+// group keeps are taken off the record.
+func (s *Store) SetRemoved(gid boson.Address, nodes, removed []boson.Address) error {
+	s.removedMu.Lock()
+	defer s.removedMu.Unlock()
+
+	prev, err := s.Removed(gid)
+	if err != nil {
+		return err
+	}
+	var set []boson.Address
+	for _, node := range prev {
+		if !node.MemberOf(nodes) {
+			set = append(set, node)
+		}
+	}
+	for _, node := range removed {
+		if !node.MemberOf(set) {
+			set = append(set, node)
+		}
+	}
+	if len(set) == 0 {
+		err := s.store.Delete(removedKey(gid))
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.store.Put(removedKey(gid), set)
+}
+
+// List returns all saved configurations.
+func (s *Store) List() ([]model.ConfigNodeGroup, error) {
+	var groups []model.ConfigNodeGroup
+	err := s.store.Iterate(storePrefix, func(_, value []byte) (bool, error) {
+		var c model.ConfigNodeGroup
+		if err := json.Unmarshal(value, &c); err != nil {
+			return true, err
+		}
+		groups = append(groups, c)
+		return false, nil
+	})
+	return groups, err
+}
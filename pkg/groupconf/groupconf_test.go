@@ -0,0 +1,97 @@
+package groupconf_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+func TestStore(t *testing.T) {
+	s := groupconf.NewStore(mock.NewStateStore())
+
+	chat := model.ConfigNodeGroup{Name: "chat", GType: model.GTypeJoin, KeepConnectedPeers: 2}
+	gid := multicast.GenerateGID("chat")
+	news := model.ConfigNodeGroup{Name: gid.String(), GType: model.GTypeObserve, KeepPingPeers: 1}
+
+	if err := s.Put(chat); err != nil {
+		t.Fatal(err)
+	}
+	// the hex id of a group names the same group as its name
+	if err := s.Put(news); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GType != model.GTypeObserve || got.KeepPingPeers != 1 {
+		t.Fatalf("got %+v, want %+v", got, news)
+	}
+
+	chat.Name = "other"
+	if err := s.Put(chat); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+
+	if err := s.Delete(gid); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(gid); err != nil {
+		t.Fatalf("delete missing: %v", err)
+	}
+	if _, err := s.Get(gid); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if got := groupconf.GroupID(gid.String()); !got.Equal(gid) {
+		t.Fatalf("got group id %s, want %s", got, gid)
+	}
+}
+
+func TestRemoved(t *testing.T) {
+	s := groupconf.NewStore(mock.NewStateStore())
+	gid := multicast.GenerateGID("chat")
+	a, b, c := test.RandomAddress(), test.RandomAddress(), test.RandomAddress()
+
+	if removed, err := s.Removed(gid); err != nil || removed != nil {
+		t.Fatalf("got removed %v, error %v", removed, err)
+	}
+	if err := s.SetRemoved(gid, []boson.Address{c}, []boson.Address{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	// a node given again is taken off the record
+	if err := s.SetRemoved(gid, []boson.Address{a, c}, nil); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := s.Removed(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || !removed[0].Equal(b) {
+		t.Fatalf("got removed %v, want [%s]", removed, b)
+	}
+
+	if err := s.Put(model.ConfigNodeGroup{Name: "chat", KeepConnectedPeers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(gid); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := s.Removed(gid); err != nil || removed != nil {
+		t.Fatalf("got removed %v after delete, error %v", removed, err)
+	}
+}
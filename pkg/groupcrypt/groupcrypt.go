@@ -0,0 +1,243 @@
+// Package groupcrypt encrypts the payloads of multicast group messages.
+//
+// Multicast messages are sealed with the key of their group, messages to a
+// single peer with a key agreed by ECDH between the sender and the public
+// key of the peer. Every message is encrypted with ChaCha20-Poly1305 under a
+// key derived by HKDF from the group key or the ECDH secret and a random
+// salt the message carries. Messages sealed for a peer also carry the public
+// key of the sender, which is authenticated with the ciphertext.
+package groupcrypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/encryption"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Modes of sealed messages.
+const (
+	ModeGroup = "group"
+	ModePeer  = "peer"
+)
+
+const saltSize = 32
+
+var (
+	sealedMagic     = []byte("\x00groupcrypt/sealed/2\x00")
+	keyRequestMagic = []byte("\x00groupcrypt/pubkey/1\x00")
+)
+
+var (
+	// ErrNotSealed is returned for data that is not a sealed message.
+	ErrNotSealed = errors.New("groupcrypt: not a sealed message")
+	// ErrInvalidKey is returned for keys of the wrong length or public keys
+	// that can not be decoded.
+	ErrInvalidKey = errors.New("groupcrypt: invalid key")
+	// ErrAuthentication is returned when a message can not be authenticated,
+	// it was sealed with another key or was modified.
+	ErrAuthentication = errors.New("groupcrypt: message authentication failed")
+)
+
+type sealed struct {
+	Mode   string `json:"mode"`
+	Sender []byte `json:"sender,omitempty"`
+	Salt   []byte `json:"salt"`
+	Data   []byte `json:"data"`
+}
+
+// NewGroupKey returns a random group key.
+func NewGroupKey() []byte {
+	return encryption.GenerateRandomKey(encryption.KeyLength)
+}
+
+// IsSealed reports whether data is a sealed message.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// Mode returns the mode data was sealed with.
+func Mode(data []byte) (string, error) {
+	m, err := unmarshal(data)
+	if err != nil {
+		return "", err
+	}
+	return m.Mode, nil
+}
+
+// SealGroup encrypts plaintext with the group key.
+func SealGroup(key, plaintext []byte) ([]byte, error) {
+	if len(key) != encryption.KeyLength {
+		return nil, ErrInvalidKey
+	}
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	return seal(sealed{Mode: ModeGroup, Salt: salt}, key, plaintext)
+}
+
+// OpenGroup decrypts a message sealed with the group key.
+func OpenGroup(key, data []byte) ([]byte, error) {
+	m, err := unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.Mode != ModeGroup || len(key) != encryption.KeyLength {
+		return nil, ErrInvalidKey
+	}
+	return open(m, key)
+}
+
+// Peer seals and opens the messages exchanged with single peers.
+type Peer struct {
+	key *ecdsa.PrivateKey
+	dh  crypto.DH
+}
+
+// NewPeer creates a Peer using the private key of the node.
+func NewPeer(key *ecdsa.PrivateKey) *Peer {
+	return &Peer{key: key, dh: crypto.NewDH(key)}
+}
+
+// PublicKey returns the compressed public key peers seal messages for.
+func (p *Peer) PublicKey() []byte {
+	return crypto.EncodeSecp256k1PublicKey(&p.key.PublicKey)
+}
+
+// Seal encrypts plaintext for the owner of the public key to.
+func (p *Peer) Seal(to *ecdsa.PublicKey, plaintext []byte) ([]byte, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	shared, err := p.dh.SharedKey(to, nil)
+	if err != nil {
+		return nil, err
+	}
+	return seal(sealed{Mode: ModePeer, Sender: p.PublicKey(), Salt: salt}, shared, plaintext)
+}
+
+// Open decrypts a message sealed for this peer and returns it with the
+// public key of its sender.
+func (p *Peer) Open(data []byte) ([]byte, *ecdsa.PublicKey, error) {
+	m, err := unmarshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Mode != ModePeer {
+		return nil, nil, ErrInvalidKey
+	}
+	sender, err := DecodePublicKey(m.Sender)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := p.dh.SharedKey(sender, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := open(m, shared)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, sender, nil
+}
+
+// DecodePublicKey parses a compressed public key.
+func DecodePublicKey(b []byte) (*ecdsa.PublicKey, error) {
+	pub, err := ethcrypto.DecompressPubkey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return pub, nil
+}
+
+// KeyRequest returns the message asking a peer for its public key. Peers
+// answer it with the compressed key.
+func KeyRequest() []byte {
+	return append([]byte{}, keyRequestMagic...)
+}
+
+// IsKeyRequest reports whether data asks for the public key.
+func IsKeyRequest(data []byte) bool {
+	return bytes.Equal(data, keyRequestMagic)
+}
+
+// aead returns the cipher of a message, keyed by HKDF from secret and the
+// salt of the message. The nonce is derived along with the key, which is
+// used for a single message only.
+func aead(m sealed, secret []byte) (cipher.AEAD, []byte, error) {
+	kdf := hkdf.New(sha256.New, secret, m.Salt, []byte("groupcrypt/"+m.Mode))
+	key := make([]byte, chacha20poly1305.KeySize+chacha20poly1305.NonceSize)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, nil, err
+	}
+	c, err := chacha20poly1305.New(key[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, key[chacha20poly1305.KeySize:], nil
+}
+
+// additionalData binds the mode and the sender to the ciphertext.
+func additionalData(m sealed) []byte {
+	return append(append([]byte(m.Mode), 0), m.Sender...)
+}
+
+func seal(m sealed, secret, plaintext []byte) ([]byte, error) {
+	c, nonce, err := aead(m, secret)
+	if err != nil {
+		return nil, err
+	}
+	m.Data = c.Seal(nil, nonce, plaintext, additionalData(m))
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, sealedMagic...), b...), nil
+}
+
+func open(m sealed, secret []byte) ([]byte, error) {
+	c, nonce, err := aead(m, secret)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.Open(nil, nonce, m.Data, additionalData(m))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
+
+func unmarshal(data []byte) (sealed, error) {
+	var m sealed
+	if !IsSealed(data) {
+		return m, ErrNotSealed
+	}
+	if err := json.Unmarshal(data[len(sealedMagic):], &m); err != nil {
+		return m, fmt.Errorf("%w: %v", ErrNotSealed, err)
+	}
+	if len(m.Salt) != saltSize {
+		return m, ErrNotSealed
+	}
+	return m, nil
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
@@ -0,0 +1,111 @@
+package groupcrypt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/groupcrypt"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+)
+
+func newPeer(t *testing.T) *groupcrypt.Peer {
+	t.Helper()
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groupcrypt.NewPeer(key)
+}
+
+func TestGroup(t *testing.T) {
+	key := groupcrypt.NewGroupKey()
+	plaintext := []byte("hello group")
+
+	sealed, err := groupcrypt.SealGroup(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !groupcrypt.IsSealed(sealed) || groupcrypt.IsSealed(plaintext) {
+		t.Fatal("sealed messages not told apart")
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("plaintext in sealed message")
+	}
+	if mode, err := groupcrypt.Mode(sealed); err != nil || mode != groupcrypt.ModeGroup {
+		t.Fatalf("got mode %q %v", mode, err)
+	}
+
+	got, err := groupcrypt.OpenGroup(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("got %q, want %q", got, plaintext)
+	}
+
+	if _, err := groupcrypt.OpenGroup(groupcrypt.NewGroupKey(), sealed); !errors.Is(err, groupcrypt.ErrAuthentication) {
+		t.Fatalf("got error %v, want %v", err, groupcrypt.ErrAuthentication)
+	}
+	// flipping a bit of the ciphertext fails the authentication
+	start := bytes.IndexByte(sealed, '{')
+	var m struct {
+		Mode string `json:"mode"`
+		Salt []byte `json:"salt"`
+		Data []byte `json:"data"`
+	}
+	if err := json.Unmarshal(sealed[start:], &m); err != nil {
+		t.Fatal(err)
+	}
+	m.Data[0] ^= 1
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append(append([]byte{}, sealed[:start]...), b...)
+	if _, err := groupcrypt.OpenGroup(key, tampered); !errors.Is(err, groupcrypt.ErrAuthentication) {
+		t.Fatalf("got error %v, want %v", err, groupcrypt.ErrAuthentication)
+	}
+	if _, err := groupcrypt.OpenGroup(key, plaintext); !errors.Is(err, groupcrypt.ErrNotSealed) {
+		t.Fatalf("got error %v, want %v", err, groupcrypt.ErrNotSealed)
+	}
+}
+
+func TestPeer(t *testing.T) {
+	alice, bob, eve := newPeer(t), newPeer(t), newPeer(t)
+	plaintext := []byte("hello bob")
+
+	bobKey, err := groupcrypt.DecodePublicKey(bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := alice.Seal(bobKey, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, sender, err := bob.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("got %q, want %q", got, plaintext)
+	}
+	if !bytes.Equal(crypto.EncodeSecp256k1PublicKey(sender), alice.PublicKey()) {
+		t.Fatal("sender is not alice")
+	}
+
+	if _, _, err := eve.Open(sealed); !errors.Is(err, groupcrypt.ErrAuthentication) {
+		t.Fatalf("got error %v, want %v", err, groupcrypt.ErrAuthentication)
+	}
+}
+
+func TestKeyRequest(t *testing.T) {
+	if !groupcrypt.IsKeyRequest(groupcrypt.KeyRequest()) {
+		t.Fatal("key request not recognized")
+	}
+	if groupcrypt.IsKeyRequest([]byte("data")) {
+		t.Fatal("data taken for key request")
+	}
+}
@@ -0,0 +1,100 @@
+package groupfeed
+
+import (
+	"context"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+)
+
+// API returns the message and multicast subscriptions of the group
+// namespace served by the hub. Registered after the api of the multicast
+// service they take the place of its subscriptions.
+func (h *Hub) API() rpc.API {
+	return rpc.API{
+		Namespace: "group",
+		Version:   "1.0",
+		Service:   &apiService{h: h},
+		Public:    true,
+	}
+}
+
+type apiService struct {
+	h *Hub
+}
+
+// GroupMessage is a notify or request message as sent to rpc subscribers.
+type GroupMessage struct {
+	multicast.GroupMessage
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// MulticastMessage is a multicast message as sent to rpc subscribers.
+type MulticastMessage struct {
+	multicast.Message
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// Message subscribes to the notify and request messages of a group.
+func (a *apiService) Message(ctx context.Context, name string) (*rpc.Subscription, error) {
+	return a.subscribe(ctx, name, func(ev Event, dropped int) interface{} {
+		if ev.Message == nil {
+			return nil
+		}
+		return GroupMessage{GroupMessage: *ev.Message, Dropped: dropped}
+	})
+}
+
+// Multicast subscribes to the multicast messages of a group.
+func (a *apiService) Multicast(ctx context.Context, name string) (*rpc.Subscription, error) {
+	return a.subscribe(ctx, name, func(ev Event, dropped int) interface{} {
+		if ev.Multicast == nil {
+			return nil
+		}
+		return MulticastMessage{Message: *ev.Multicast, Dropped: dropped}
+	})
+}
+
+// subscribe forwards the events of a group that convert selects. The losses
+// reported with skipped events are carried over to the next forwarded one.
+func (a *apiService) subscribe(ctx context.Context, name string, convert func(ev Event, dropped int) interface{}) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	gid, err := boson.ParseHexAddress(name)
+	if err != nil {
+		gid = multicast.GenerateGID(name)
+	}
+	s, err := a.h.Subscribe(gid)
+	if err != nil {
+		return nil, err
+	}
+	sub := notifier.CreateSubscription()
+
+	go func() {
+		defer s.Unsubscribe()
+		dropped := 0
+		for {
+			select {
+			case <-sub.Err():
+				return
+			case ev, ok := <-s.Events():
+				if !ok {
+					return
+				}
+				dropped += ev.Dropped
+				msg := convert(ev, dropped)
+				if msg == nil {
+					continue
+				}
+				dropped = 0
+				if err := notifier.Notify(sub.ID, msg); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,289 @@
+// Package groupfeed shares the message subscriptions of the groups joined by
+// the node between all of their subscribers.
+//
+// The multicast service accepts a single multicast subscription per group
+// and never forgets it once taken, so every further subscriber would be
+// refused. The hub takes it once and listens to the published messages
+// itself. Per group one feed fans the messages out to the subscribers. It is
+// created by the first subscriber and torn down with the last. Subscribers
+// that do not keep up lose events, and the next event they receive carries
+// the number of events they lost.
+package groupfeed
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+	"github.com/gauss-project/aurorafs/pkg/subscribe"
+)
+
+// Buffer is the number of events queued for a subscriber before further
+// events are dropped.
+const Buffer = 64
+
+var ErrClosed = errors.New("groupfeed: closed")
+
+// Event is a message received in a group, either a notify or request
+// message or a multicast message. Encrypted and ContentType are set by the
+// filter for the messages it decrypted or put together from a stream.
+// Dropped is the number of events the subscriber lost before this one.
+type Event struct {
+	Message     *multicast.GroupMessage
+	Multicast   *multicast.Message
+	Encrypted   bool
+	ContentType string
+	Dropped     int
+}
+
+// Filter decides whether an event received in the group gid is passed on
+// to the subscribers. It is called once per event, whatever the number of
+// subscribers, and may replace the data of the event.
+type Filter func(gid boson.Address, ev *Event) bool
+
+// Hub keeps the feeds of the groups.
+type Hub struct {
+	logger logging.Logger
+	rpc    *rpc.Client
+	subPub subscribe.SubPub
+	filter atomic.Value
+
+	mu        sync.Mutex
+	feeds     map[string]*feed
+	multicast map[string]bool
+	closed    bool
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type feed struct {
+	subs map[*Subscription]struct{}
+	stop chan struct{}
+}
+
+// Subscription receives the events of one group.
+type Subscription struct {
+	hub     *Hub
+	gid     string
+	c       chan Event
+	dropped int
+}
+
+// New returns a hub subscribing through the rpc api of the multicast
+// service, whose messages are published on subPub.
+func New(logger logging.Logger, group rpc.API, subPub subscribe.SubPub) (*Hub, error) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(group.Namespace, group.Service); err != nil {
+		return nil, err
+	}
+	return &Hub{
+		logger:    logger,
+		rpc:       rpc.DialInProc(srv),
+		subPub:    subPub,
+		feeds:     make(map[string]*feed),
+		multicast: make(map[string]bool),
+		quit:      make(chan struct{}),
+	}, nil
+}
+
+// SetFilter installs the filter applied to the events of all groups before
+// they are passed on.
+func (h *Hub) SetFilter(f Filter) {
+	h.filter.Store(f)
+}
+
+// Subscribe returns a new subscription to the messages of the joined group
+// gid.
+func (h *Hub) Subscribe(gid boson.Address) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+
+	f, ok := h.feeds[gid.String()]
+	if !ok {
+		var err error
+		if f, err = h.start(gid); err != nil {
+			return nil, err
+		}
+		h.feeds[gid.String()] = f
+	}
+	s := &Subscription{
+		hub: h,
+		gid: gid.String(),
+		c:   make(chan Event, Buffer),
+	}
+	f.subs[s] = struct{}{}
+	return s, nil
+}
+
+// multicastNotifier receives the multicast messages of a group published by
+// the multicast service.
+type multicastNotifier struct {
+	events  chan multicast.Message
+	dropped int64
+	done    chan error
+}
+
+func (n *multicastNotifier) Notify(_ string, data interface{}) error {
+	m, ok := data.(multicast.Message)
+	if !ok {
+		return nil
+	}
+	select {
+	case n.events <- m:
+	default:
+		atomic.AddInt64(&n.dropped, 1)
+	}
+	return nil
+}
+
+func (n *multicastNotifier) Err() <-chan error {
+	return n.done
+}
+
+// start subscribes to the messages of gid. It must be called with the lock
+// held.
+func (h *Hub) start(gid boson.Address) (*feed, error) {
+	ctx := context.Background()
+	messages := make(chan multicast.GroupMessage, Buffer)
+	msgSub, err := h.rpc.Subscribe(ctx, "group", messages, "message", gid.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.multicast[gid.String()] {
+		// taking the subscription once makes the service publish the
+		// multicast messages of the group, failing means it was taken before
+		mcSub, err := h.rpc.Subscribe(ctx, "group", make(chan multicast.Message), "multicast", gid.String())
+		if err == nil {
+			mcSub.Unsubscribe()
+		}
+		h.multicast[gid.String()] = true
+	}
+	n := &multicastNotifier{
+		events: make(chan multicast.Message, Buffer),
+		done:   make(chan error),
+	}
+	if err := h.subPub.Subscribe(n, "group", "multicastMsg", gid.String()); err != nil {
+		msgSub.Unsubscribe()
+		return nil, err
+	}
+
+	f := &feed{
+		subs: make(map[*Subscription]struct{}),
+		stop: make(chan struct{}),
+	}
+	h.wg.Add(1)
+	go h.run(gid, f, msgSub, messages, n)
+	return f, nil
+}
+
+func (h *Hub) run(gid boson.Address, f *feed, msgSub *rpc.ClientSubscription, messages chan multicast.GroupMessage, n *multicastNotifier) {
+	defer h.wg.Done()
+	defer func() {
+		msgSub.Unsubscribe()
+		close(n.done)
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.feeds[gid.String()] == f {
+			delete(h.feeds, gid.String())
+		}
+		for s := range f.subs {
+			close(s.c)
+		}
+		f.subs = nil
+	}()
+
+	for {
+		var ev Event
+		select {
+		case <-h.quit:
+			return
+		case <-f.stop:
+			return
+		case err := <-msgSub.Err():
+			h.logger.Debugf("groupfeed: %s message subscription: %v", gid, err)
+			return
+		case m := <-messages:
+			ev.Message = &m
+		case m := <-n.events:
+			ev.Multicast = &m
+		}
+		dropped := int(atomic.SwapInt64(&n.dropped, 0))
+		if filter, ok := h.filter.Load().(Filter); ok && !filter(gid, &ev) {
+			// the events lost by the feed are reported with the next one
+			atomic.AddInt64(&n.dropped, int64(dropped))
+			continue
+		}
+		h.publish(f, ev, dropped)
+	}
+}
+
+// publish hands ev to the subscribers of f. Events dropped by the feed
+// itself count as lost for every subscriber.
+func (h *Hub) publish(f *feed, ev Event, dropped int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range f.subs {
+		s.dropped += dropped
+		ev.Dropped = s.dropped
+		select {
+		case s.c <- ev:
+			s.dropped = 0
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Events returns the events of the subscription. The channel is closed when
+// the subscription ends.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Unsubscribe ends the subscription. The feed of the group is torn down
+// with its last subscription.
+func (s *Subscription) Unsubscribe() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, ok := h.feeds[s.gid]
+	if !ok {
+		return
+	}
+	if _, ok := f.subs[s]; !ok {
+		return
+	}
+	delete(f.subs, s)
+	close(s.c)
+	if len(f.subs) == 0 {
+		delete(h.feeds, s.gid)
+		close(f.stop)
+	}
+}
+
+// Close ends all subscriptions.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.quit)
+	h.mu.Unlock()
+
+	h.wg.Wait()
+	h.rpc.Close()
+	return nil
+}
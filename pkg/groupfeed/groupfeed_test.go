@@ -0,0 +1,223 @@
+package groupfeed_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupfeed"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/boson/test"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+	"github.com/gauss-project/aurorafs/pkg/subscribe"
+)
+
+// group mimics the rpc api of the multicast service, which refuses a second
+// multicast subscription of a group.
+type group struct {
+	subPub subscribe.SubPub
+
+	mu        sync.Mutex
+	multicast map[string]bool
+}
+
+func (g *group) subscribe(ctx context.Context, kind, name string) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	return sub, g.subPub.Subscribe(subscribe.NewNotifier(notifier, sub), "group", kind, name)
+}
+
+func (g *group) Message(ctx context.Context, name string) (*rpc.Subscription, error) {
+	return g.subscribe(ctx, "groupMessage", name)
+}
+
+func (g *group) Multicast(ctx context.Context, name string) (*rpc.Subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.multicast[name] {
+		return nil, errors.New("multicast message subscription already exists")
+	}
+	g.multicast[name] = true
+	return g.subscribe(ctx, "multicastMsg", name)
+}
+
+func newHub(t *testing.T) (*groupfeed.Hub, subscribe.SubPub) {
+	t.Helper()
+	subPub := subscribe.NewSubPub()
+	h, err := groupfeed.New(logging.New(io.Discard, 0), rpc.API{
+		Namespace: "group",
+		Service:   &group{subPub: subPub, multicast: make(map[string]bool)},
+	}, subPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = h.Close() })
+	return h, subPub
+}
+
+func receive(t *testing.T, s *groupfeed.Subscription) groupfeed.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-s.Events():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return groupfeed.Event{}
+}
+
+// drain takes the events of a subscription until none come in for a while.
+func drain(s *groupfeed.Subscription) (n int) {
+	for {
+		select {
+		case <-s.Events():
+			n++
+		case <-time.After(100 * time.Millisecond):
+			return n
+		}
+	}
+}
+
+// publish publishes a multicast message until the feed passes it on, the
+// subscriptions with the service are set up asynchronously.
+func publish(t *testing.T, subPub subscribe.SubPub, gid boson.Address, s *groupfeed.Subscription, data string) groupfeed.Event {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		_ = subPub.Publish("group", "multicastMsg", gid.String(), multicast.Message{GID: gid, Data: []byte(data)})
+		select {
+		case ev := <-s.Events():
+			return ev
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Fatal("no event")
+	return groupfeed.Event{}
+}
+
+func TestFanOut(t *testing.T) {
+	h, subPub := newHub(t)
+	gid := test.RandomAddress()
+
+	a, err := h.Subscribe(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := h.Subscribe(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := publish(t, subPub, gid, a, "first")
+	if ev.Multicast == nil || string(ev.Multicast.Data) != "first" {
+		t.Fatalf("got event %+v", ev)
+	}
+	if ev := receive(t, b); ev.Multicast == nil || string(ev.Multicast.Data) != "first" {
+		t.Fatalf("got event %+v", ev)
+	}
+
+	_ = subPub.Publish("group", "groupMessage", gid.String(), multicast.GroupMessage{GID: gid, Data: []byte("notify")})
+	for _, s := range []*groupfeed.Subscription{a, b} {
+		if ev := receive(t, s); ev.Message == nil || string(ev.Message.Data) != "notify" {
+			t.Fatalf("got event %+v", ev)
+		}
+	}
+
+	// the feed is torn down with the last subscription and set up again
+	a.Unsubscribe()
+	b.Unsubscribe()
+	if _, ok := <-a.Events(); ok {
+		t.Fatal("subscription not closed")
+	}
+	c, err := h.Subscribe(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Unsubscribe()
+	if ev := publish(t, subPub, gid, c, "again"); ev.Multicast == nil || string(ev.Multicast.Data) != "again" {
+		t.Fatalf("got event %+v", ev)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	h, subPub := newHub(t)
+	gid := test.RandomAddress()
+
+	slow, err := h.Subscribe(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Unsubscribe()
+	fast, err := h.Subscribe(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Unsubscribe()
+
+	publish(t, subPub, gid, fast, "ready")
+	// messages published while the feed was set up may still come in
+	for _, s := range []*groupfeed.Subscription{slow, fast} {
+		drain(s)
+	}
+
+	// the fast subscriber takes every message, the slow one none
+	for i := 0; i < groupfeed.Buffer+10; i++ {
+		_ = subPub.Publish("group", "groupMessage", gid.String(), multicast.GroupMessage{GID: gid})
+		if ev := receive(t, fast); ev.Dropped != 0 {
+			t.Fatalf("fast subscriber dropped %d", ev.Dropped)
+		}
+	}
+	if n := drain(slow); n != groupfeed.Buffer {
+		t.Fatalf("got %d queued, want %d", n, groupfeed.Buffer)
+	}
+
+	_ = subPub.Publish("group", "groupMessage", gid.String(), multicast.GroupMessage{GID: gid})
+	if ev := receive(t, slow); ev.Dropped != 10 {
+		t.Fatalf("got %d dropped, want 10", ev.Dropped)
+	}
+	if ev := receive(t, fast); ev.Dropped != 0 {
+		t.Fatalf("fast subscriber dropped %d", ev.Dropped)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	h, subPub := newHub(t)
+	gid := test.RandomAddress()
+
+	h.SetFilter(func(_ boson.Address, ev *groupfeed.Event) bool {
+		if ev.Message == nil {
+			return true
+		}
+		if string(ev.Message.Data) == "drop" {
+			return false
+		}
+		m := *ev.Message
+		m.Data = []byte("opened " + string(m.Data))
+		ev.Message = &m
+		return true
+	})
+
+	s, err := h.Subscribe(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Unsubscribe()
+	publish(t, subPub, gid, s, "ready")
+	drain(s)
+
+	_ = subPub.Publish("group", "groupMessage", gid.String(), multicast.GroupMessage{GID: gid, Data: []byte("drop")})
+	_ = subPub.Publish("group", "groupMessage", gid.String(), multicast.GroupMessage{GID: gid, Data: []byte("message")})
+	if ev := receive(t, s); ev.Message == nil || string(ev.Message.Data) != "opened message" || ev.Dropped != 0 {
+		t.Fatalf("got event %+v", ev)
+	}
+}
@@ -0,0 +1,330 @@
+// Package grouplog keeps the durable message log of multicast groups.
+//
+// Messages of a durable group are wrapped in an Envelope carrying the
+// sender overlay and its sequence number, stored as a chunk and appended to
+// a per group log. Every entry of the log gets a cursor in the order it was
+// received, members read the log or sync it from other members since a
+// cursor. Envelopes are identified by their chunk address, so a message
+// received both by multicast and by sync is only logged once.
+package grouplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	keyPrefix = "grouplog-"
+
+	durablePrefix = keyPrefix + "durable-"
+	headPrefix    = keyPrefix + "head-"
+	entryPrefix   = keyPrefix + "entry-"
+	refPrefix     = keyPrefix + "ref-"
+	seqPrefix     = keyPrefix + "seq-"
+	peerPrefix    = keyPrefix + "peer-"
+)
+
+var (
+	envelopeMagic    = []byte("\x00grouplog/msg/1\x00")
+	syncRequestMagic = []byte("\x00grouplog/sync/1\x00")
+)
+
+var (
+	// ErrTooLarge is returned for messages that do not fit into a chunk.
+	ErrTooLarge = errors.New("grouplog: message too large")
+	// ErrInvalidEnvelope is returned for data that is not an envelope.
+	ErrInvalidEnvelope = errors.New("grouplog: invalid envelope")
+)
+
+// Envelope is a message of a durable group as it is multicast and stored.
+type Envelope struct {
+	GID     boson.Address `json:"gid"`
+	Origin  boson.Address `json:"origin"`
+	Seq     uint64        `json:"seq"`
+	Created int64         `json:"created"`
+	Data    []byte        `json:"data"`
+}
+
+// Marshal encodes the envelope. The result is at most one chunk in size.
+func (e Envelope) Marshal() ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	b = append(append([]byte{}, envelopeMagic...), b...)
+	if len(b) > boson.ChunkSize {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+// IsEnvelope reports whether data is an encoded envelope.
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+// UnmarshalEnvelope decodes an envelope encoded by Marshal.
+func UnmarshalEnvelope(data []byte) (Envelope, error) {
+	var e Envelope
+	if !IsEnvelope(data) {
+		return e, ErrInvalidEnvelope
+	}
+	if err := json.Unmarshal(data[len(envelopeMagic):], &e); err != nil {
+		return e, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	return e, nil
+}
+
+// SyncRequest asks a member for the envelopes of its log after Since.
+type SyncRequest struct {
+	Since uint64 `json:"since"`
+	Limit int    `json:"limit"`
+}
+
+// SyncResponse answers a SyncRequest. Cursor is the cursor of the last
+// returned envelope, More is set when the log holds further entries.
+type SyncResponse struct {
+	Envelopes [][]byte `json:"envelopes"`
+	Cursor    uint64   `json:"cursor"`
+	More      bool     `json:"more"`
+}
+
+// Marshal encodes the sync request.
+func (r SyncRequest) Marshal() ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, syncRequestMagic...), b...), nil
+}
+
+// IsSyncRequest reports whether data is an encoded sync request.
+func IsSyncRequest(data []byte) bool {
+	return bytes.HasPrefix(data, syncRequestMagic)
+}
+
+// UnmarshalSyncRequest decodes a sync request encoded by Marshal.
+func UnmarshalSyncRequest(data []byte) (SyncRequest, error) {
+	var r SyncRequest
+	if !IsSyncRequest(data) {
+		return r, ErrInvalidEnvelope
+	}
+	err := json.Unmarshal(data[len(syncRequestMagic):], &r)
+	return r, err
+}
+
+// Entry is a message in the log of a group.
+type Entry struct {
+	Cursor    uint64        `json:"cursor"`
+	Reference boson.Address `json:"reference"`
+	Origin    boson.Address `json:"origin"`
+	Seq       uint64        `json:"seq"`
+	Created   int64         `json:"created"`
+}
+
+// Log persists the logs of durable groups in a state store.
+type Log struct {
+	store storage.StateStorer
+	mu    sync.Mutex
+}
+
+// New is a convenient constructor for Log.
+func New(store storage.StateStorer) *Log {
+	return &Log{store: store}
+}
+
+func entryKey(gid boson.Address, cursor uint64) string {
+	return fmt.Sprintf("%s%s-%020d", entryPrefix, gid, cursor)
+}
+
+func refKey(gid, ref boson.Address) string {
+	return fmt.Sprintf("%s%s-%s", refPrefix, gid, ref)
+}
+
+func peerKey(gid, peer boson.Address) string {
+	return fmt.Sprintf("%s%s-%s", peerPrefix, gid, peer)
+}
+
+func (l *Log) getUint(key string) (uint64, error) {
+	var v uint64
+	err := l.store.Get(key, &v)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// SetDurable enables the durable mode of a group.
+func (l *Log) SetDurable(gid boson.Address) error {
+	return l.store.Put(durablePrefix+gid.String(), true)
+}
+
+// IsDurable reports whether the durable mode of a group is enabled.
+func (l *Log) IsDurable(gid boson.Address) (bool, error) {
+	var v bool
+	err := l.store.Get(durablePrefix+gid.String(), &v)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return v, err
+}
+
+// Durable returns the groups with durable mode enabled.
+func (l *Log) Durable() ([]boson.Address, error) {
+	var gids []boson.Address
+	err := l.store.Iterate(durablePrefix, func(key, _ []byte) (bool, error) {
+		gid, err := boson.ParseHexAddress(strings.TrimPrefix(string(key), durablePrefix))
+		if err != nil {
+			return true, err
+		}
+		gids = append(gids, gid)
+		return false, nil
+	})
+	return gids, err
+}
+
+// NextSeq returns the sequence number of the next message sent to the group
+// by this node.
+func (l *Log) NextSeq(gid boson.Address) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := seqPrefix + gid.String()
+	seq, err := l.getUint(key)
+	if err != nil {
+		return 0, err
+	}
+	seq++
+	return seq, l.store.Put(key, seq)
+}
+
+// Head returns the cursor of the last entry in the log of the group.
+func (l *Log) Head(gid boson.Address) (uint64, error) {
+	return l.getUint(headPrefix + gid.String())
+}
+
+// Append logs the envelope stored in the chunk ref. It returns false if the
+// envelope has been logged before.
+func (l *Log) Append(env Envelope, ref boson.Address) (Entry, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var cursor uint64
+	err := l.store.Get(refKey(env.GID, ref), &cursor)
+	if err == nil {
+		var e Entry
+		err = l.store.Get(entryKey(env.GID, cursor), &e)
+		return e, false, err
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return Entry{}, false, err
+	}
+
+	head, err := l.Head(env.GID)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	e := Entry{
+		Cursor:    head + 1,
+		Reference: ref,
+		Origin:    env.Origin,
+		Seq:       env.Seq,
+		Created:   env.Created,
+	}
+	if err := l.store.Put(entryKey(env.GID, e.Cursor), e); err != nil {
+		return Entry{}, false, err
+	}
+	if err := l.store.Put(refKey(env.GID, ref), e.Cursor); err != nil {
+		return Entry{}, false, err
+	}
+	if err := l.store.Put(headPrefix+env.GID.String(), e.Cursor); err != nil {
+		return Entry{}, false, err
+	}
+	return e, true, nil
+}
+
+// Has reports whether the envelope stored in the chunk ref is logged.
+func (l *Log) Has(gid, ref boson.Address) (bool, error) {
+	var cursor uint64
+	err := l.store.Get(refKey(gid, ref), &cursor)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Since returns up to limit entries logged after the cursor, in log order.
+// The returned flag is set when the log holds further entries.
+func (l *Log) Since(gid boson.Address, cursor uint64, limit int) ([]Entry, bool, error) {
+	head, err := l.Head(gid)
+	if err != nil {
+		return nil, false, err
+	}
+
+	entries := make([]Entry, 0)
+	for c := cursor + 1; c <= head; c++ {
+		if len(entries) == limit {
+			return entries, true, nil
+		}
+		var e Entry
+		if err := l.store.Get(entryKey(gid, c), &e); err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, false, nil
+}
+
+// PeerCursor returns the cursor up to which the log of peer has been synced.
+func (l *Log) PeerCursor(gid, peer boson.Address) (uint64, error) {
+	return l.getUint(peerKey(gid, peer))
+}
+
+// SetPeerCursor records the cursor up to which the log of peer has been
+// synced.
+func (l *Log) SetPeerCursor(gid, peer boson.Address, cursor uint64) error {
+	return l.store.Put(peerKey(gid, peer), cursor)
+}
+
+// Remove disables the durable mode of a group and drops its log. The chunk
+// references of the dropped entries are returned.
+func (l *Log) Remove(gid boson.Address) ([]boson.Address, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		refs []boson.Address
+		keys []string
+	)
+	for _, prefix := range []string{entryPrefix, refPrefix, peerPrefix} {
+		err := l.store.Iterate(prefix+gid.String()+"-", func(key, value []byte) (bool, error) {
+			keys = append(keys, string(key))
+			if prefix == entryPrefix {
+				var e Entry
+				if err := json.Unmarshal(value, &e); err != nil {
+					return true, err
+				}
+				refs = append(refs, e.Reference)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	keys = append(keys, durablePrefix+gid.String(), headPrefix+gid.String())
+
+	for _, key := range keys {
+		if err := l.store.Delete(key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
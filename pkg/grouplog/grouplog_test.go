@@ -0,0 +1,108 @@
+package grouplog_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/grouplog"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+var (
+	gid    = boson.MustParseHexAddress("ca1e9f3938cc1425c6061b96ad9eb93e134dfe8734ad490164ef20af9d1cf59c")
+	origin = boson.MustParseHexAddress("0f3b6d3c9e2b2d1a0c9e8d7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b")
+)
+
+func TestEnvelope(t *testing.T) {
+	env := grouplog.Envelope{GID: gid, Origin: origin, Seq: 7, Created: 1000, Data: []byte("hello")}
+
+	b, err := env.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !grouplog.IsEnvelope(b) || grouplog.IsSyncRequest(b) {
+		t.Fatal("envelope not recognized")
+	}
+	got, err := grouplog.UnmarshalEnvelope(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Origin.Equal(origin) || got.Seq != 7 || !bytes.Equal(got.Data, env.Data) {
+		t.Fatalf("got %+v, want %+v", got, env)
+	}
+
+	if _, err := grouplog.UnmarshalEnvelope([]byte("hello")); !errors.Is(err, grouplog.ErrInvalidEnvelope) {
+		t.Fatalf("got error %v, want %v", err, grouplog.ErrInvalidEnvelope)
+	}
+
+	env.Data = make([]byte, boson.ChunkSize)
+	if _, err := env.Marshal(); !errors.Is(err, grouplog.ErrTooLarge) {
+		t.Fatalf("got error %v, want %v", err, grouplog.ErrTooLarge)
+	}
+}
+
+func TestLog(t *testing.T) {
+	l := grouplog.New(mock.NewStateStore())
+
+	if err := l.SetDurable(gid); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := l.IsDurable(gid); err != nil || !ok {
+		t.Fatalf("durable: got %v %v", ok, err)
+	}
+
+	refs := []boson.Address{
+		boson.MustParseHexAddress("01"),
+		boson.MustParseHexAddress("02"),
+		boson.MustParseHexAddress("03"),
+	}
+	for i, ref := range refs {
+		e, added, err := l.Append(grouplog.Envelope{GID: gid, Origin: origin, Seq: uint64(i + 1)}, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !added || e.Cursor != uint64(i+1) {
+			t.Fatalf("append %d: got cursor %d added %v", i, e.Cursor, added)
+		}
+	}
+
+	// duplicates keep their cursor
+	e, added, err := l.Append(grouplog.Envelope{GID: gid, Origin: origin, Seq: 2}, refs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added || e.Cursor != 2 {
+		t.Fatalf("duplicate: got cursor %d added %v", e.Cursor, added)
+	}
+
+	entries, more, err := l.Since(gid, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Cursor != 2 || !more {
+		t.Fatalf("since: got %+v more %v", entries, more)
+	}
+	entries, more, err = l.Since(gid, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Cursor != 3 || more {
+		t.Fatalf("since: got %+v more %v", entries, more)
+	}
+
+	removed, err := l.Remove(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != len(refs) {
+		t.Fatalf("remove: got %d references, want %d", len(removed), len(refs))
+	}
+	if ok, _ := l.IsDurable(gid); ok {
+		t.Fatal("group still durable")
+	}
+	if head, _ := l.Head(gid); head != 0 {
+		t.Fatalf("head after remove: got %d", head)
+	}
+}
@@ -0,0 +1,391 @@
+// Package groupstream carries request and response bodies of any size over
+// the request/reply messages of multicast groups.
+//
+// A single group request is limited in size and its reply has to be sent
+// within a fixed time. The caller therefore sends the request body in
+// numbered data frames followed by an end frame, the target collects them
+// in a Session and hands the whole request to its handler. The handler may
+// write the response in parts. The caller pulls the response frame by
+// frame, a pull waits a while for the next part and is answered as pending
+// if it is not there yet. Frames are dropped once the caller pulled past
+// them, so only the parts not pulled yet are held.
+package groupstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FrameSize is the maximum size of the data of a frame.
+const FrameSize = 256 * 1024
+
+// Kinds of frames.
+const (
+	KindData   = "data"
+	KindEnd    = "end"
+	KindPull   = "pull"
+	KindCancel = "cancel"
+)
+
+// Statuses of replies.
+const (
+	StatusOK      = "ok"
+	StatusPending = "pending"
+	StatusMore    = "more"
+	StatusDone    = "done"
+	StatusError   = "error"
+)
+
+var (
+	frameMagic = []byte("\x00groupstream/frame/1\x00")
+	replyMagic = []byte("\x00groupstream/reply/1\x00")
+)
+
+var (
+	// ErrInvalidFrame is returned for data that is not a frame or a reply.
+	ErrInvalidFrame = errors.New("groupstream: invalid frame")
+	// ErrOutOfOrder is returned for request frames received out of order.
+	ErrOutOfOrder = errors.New("groupstream: frame out of order")
+	// ErrTooLarge is returned when a request exceeds the size limit.
+	ErrTooLarge = errors.New("groupstream: request too large")
+	// ErrComplete is returned for request frames after the end frame and
+	// for responses written after the last part.
+	ErrComplete = errors.New("groupstream: request complete")
+	// ErrTooManySessions is returned when a peer or all peers together
+	// hold as many sessions as allowed.
+	ErrTooManySessions = errors.New("groupstream: too many sessions")
+)
+
+// Frame is sent by the caller of a stream.
+type Frame struct {
+	Stream      string `json:"stream"`
+	Seq         uint64 `json:"seq"`
+	Kind        string `json:"kind"`
+	ContentType string `json:"contentType,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// Marshal returns the frame as sent in a group request.
+func (f Frame) Marshal() ([]byte, error) {
+	return marshal(frameMagic, f)
+}
+
+// IsFrame reports whether data is a frame.
+func IsFrame(data []byte) bool {
+	return bytes.HasPrefix(data, frameMagic)
+}
+
+// ParseFrame parses a frame.
+func ParseFrame(data []byte) (Frame, error) {
+	var f Frame
+	err := unmarshal(frameMagic, data, &f)
+	return f, err
+}
+
+// Reply answers a frame. Replies to pulls carry the response frame of the
+// pulled sequence number.
+type Reply struct {
+	Status      string `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// Marshal returns the reply as sent in a group reply.
+func (r Reply) Marshal() ([]byte, error) {
+	return marshal(replyMagic, r)
+}
+
+// ParseReply parses a reply.
+func ParseReply(data []byte) (Reply, error) {
+	var r Reply
+	err := unmarshal(replyMagic, data, &r)
+	return r, err
+}
+
+// ErrorReply returns the reply reporting err.
+func ErrorReply(err error) Reply {
+	return Reply{Status: StatusError, Error: err.Error()}
+}
+
+func marshal(magic []byte, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, magic...), b...), nil
+}
+
+func unmarshal(magic, data []byte, v interface{}) error {
+	if !bytes.HasPrefix(data, magic) {
+		return ErrInvalidFrame
+	}
+	if err := json.Unmarshal(data[len(magic):], v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	return nil
+}
+
+// Session is the target side of a stream. It collects the request and
+// holds the parts of the response until they are pulled.
+type Session struct {
+	peer    string
+	maxSize int
+	timer   *time.Timer
+
+	mu          sync.Mutex
+	next        uint64
+	complete    bool
+	request     bytes.Buffer
+	contentType string
+
+	// changed is closed and replaced when the response changes
+	changed      chan struct{}
+	responseType string
+	frames       [][]byte
+	base         uint64
+	held         int
+	closed       bool
+	errMsg       string
+}
+
+func newSession(peer string, maxSize int) *Session {
+	return &Session{peer: peer, maxSize: maxSize, changed: make(chan struct{})}
+}
+
+// Append adds a request frame. It reports whether the request is complete,
+// which is the case after the end frame.
+func (s *Session) Append(f Frame) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.complete {
+		return false, ErrComplete
+	}
+	if f.Seq != s.next {
+		return false, ErrOutOfOrder
+	}
+	if s.request.Len()+len(f.Data) > s.maxSize {
+		return false, ErrTooLarge
+	}
+	if f.Seq == 0 {
+		s.contentType = f.ContentType
+	}
+	s.request.Write(f.Data)
+	s.next++
+	s.complete = f.Kind == KindEnd
+	return s.complete, nil
+}
+
+// Request returns the collected request body and its content type.
+func (s *Session) Request() ([]byte, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.request.Bytes(), s.contentType
+}
+
+// Write adds a part of the response. The content type of the first part is
+// the one of the response. The parts not pulled yet are limited to the
+// size limit of the request.
+func (s *Session) Write(data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrComplete
+	}
+	if s.held+len(data) > s.maxSize {
+		return ErrTooLarge
+	}
+	if s.responseType == "" {
+		s.responseType = contentType
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > FrameSize {
+			n = FrameSize
+		}
+		s.frames = append(s.frames, append([]byte{}, data[:n]...))
+		s.held += n
+		data = data[n:]
+	}
+	s.notify()
+	return nil
+}
+
+// Close ends the response, a non-empty errMsg reports a failed handler.
+// Only the first call has an effect.
+func (s *Session) Close(errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.errMsg = errMsg
+	s.notify()
+}
+
+// Respond sets the whole response of the handler, a non-empty errMsg
+// reports a failed handler. Only the first response is kept.
+func (s *Session) Respond(data []byte, contentType, errMsg string) {
+	if errMsg == "" {
+		if err := s.Write(data, contentType); errors.Is(err, ErrTooLarge) {
+			errMsg = err.Error()
+		}
+	}
+	s.Close(errMsg)
+}
+
+// notify wakes up the waiting pulls. It must be called with the lock held.
+func (s *Session) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Pull returns the response frame seq, pulling it drops the frames before.
+// It waits for the frame until ctx is done and returns a pending reply then.
+func (s *Session) Pull(ctx context.Context, seq uint64) Reply {
+	for {
+		s.mu.Lock()
+		if s.errMsg != "" {
+			s.mu.Unlock()
+			return Reply{Status: StatusError, Error: s.errMsg}
+		}
+		if seq < s.base {
+			s.mu.Unlock()
+			return ErrorReply(ErrOutOfOrder)
+		}
+		for s.base < seq && len(s.frames) > 0 {
+			s.held -= len(s.frames[0])
+			s.frames[0] = nil
+			s.frames = s.frames[1:]
+			s.base++
+		}
+		if i := seq - s.base; i < uint64(len(s.frames)) {
+			status := StatusMore
+			if s.closed && i == uint64(len(s.frames))-1 {
+				status = StatusDone
+			}
+			r := Reply{Status: status, ContentType: s.responseType, Data: s.frames[i]}
+			s.mu.Unlock()
+			return r
+		}
+		if seq > s.base {
+			s.mu.Unlock()
+			return ErrorReply(ErrOutOfOrder)
+		}
+		if s.closed {
+			r := Reply{Status: StatusDone, ContentType: s.responseType}
+			s.mu.Unlock()
+			return r
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Reply{Status: StatusPending}
+		}
+	}
+}
+
+// Options limit the sessions of a node.
+type Options struct {
+	// Idle is the time after which a session that is not used is dropped.
+	Idle time.Duration
+	// MaxSize limits the request and the response parts not pulled yet.
+	MaxSize int
+	// MaxSessions limits the sessions of all peers together.
+	MaxSessions int
+	// MaxPeerSessions limits the sessions of a single peer.
+	MaxPeerSessions int
+}
+
+// Sessions keeps the sessions of the streams a node is the target of.
+// Sessions are dropped when they are not used for the idle time of the
+// options, whether their response was pulled or not.
+type Sessions struct {
+	o Options
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	peers    map[string]int
+}
+
+// NewSessions creates the session table.
+func NewSessions(o Options) *Sessions {
+	return &Sessions{
+		o:        o,
+		sessions: make(map[string]*Session),
+		peers:    make(map[string]int),
+	}
+}
+
+// Open returns the session id of peer, creating it if it does not exist
+// and the limits allow.
+func (s *Sessions) Open(peer, id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[id]; ok {
+		sess.timer.Reset(s.o.Idle)
+		return sess, nil
+	}
+	if len(s.sessions) >= s.o.MaxSessions || s.peers[peer] >= s.o.MaxPeerSessions {
+		return nil, ErrTooManySessions
+	}
+	sess := newSession(peer, s.o.MaxSize)
+	sess.timer = time.AfterFunc(s.o.Idle, func() {
+		s.remove(id, sess)
+	})
+	s.sessions[id] = sess
+	s.peers[peer]++
+	return sess, nil
+}
+
+// Get returns the session id. Getting a session keeps it from expiring.
+func (s *Sessions) Get(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if ok {
+		sess.timer.Reset(s.o.Idle)
+	}
+	return sess, ok
+}
+
+// Remove drops the session id.
+func (s *Sessions) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[id]; ok {
+		s.drop(id, sess)
+	}
+}
+
+func (s *Sessions) remove(id string, sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[id] == sess {
+		s.drop(id, sess)
+	}
+}
+
+// drop removes a session and ends its response. It must be called with the
+// lock held.
+func (s *Sessions) drop(id string, sess *Session) {
+	sess.timer.Stop()
+	delete(s.sessions, id)
+	if s.peers[sess.peer]--; s.peers[sess.peer] <= 0 {
+		delete(s.peers, sess.peer)
+	}
+	sess.Close("session closed")
+}
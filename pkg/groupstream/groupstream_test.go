@@ -0,0 +1,194 @@
+package groupstream_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupstream"
+)
+
+func TestFrame(t *testing.T) {
+	f := groupstream.Frame{Stream: "s", Seq: 1, Kind: groupstream.KindData, Data: []byte("data")}
+	b, err := f.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !groupstream.IsFrame(b) || groupstream.IsFrame([]byte("data")) {
+		t.Fatal("frames not told apart")
+	}
+	got, err := groupstream.ParseFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Stream != f.Stream || got.Seq != f.Seq || !bytes.Equal(got.Data, f.Data) {
+		t.Fatalf("got %+v, want %+v", got, f)
+	}
+	if _, err := groupstream.ParseReply(b); !errors.Is(err, groupstream.ErrInvalidFrame) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrInvalidFrame)
+	}
+}
+
+func newSessions() *groupstream.Sessions {
+	return groupstream.NewSessions(groupstream.Options{
+		Idle:            time.Minute,
+		MaxSize:         10,
+		MaxSessions:     3,
+		MaxPeerSessions: 2,
+	})
+}
+
+func open(t *testing.T, sessions *groupstream.Sessions, peer, id string) *groupstream.Session {
+	t.Helper()
+	s, err := sessions.Open(peer, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSession(t *testing.T) {
+	sessions := newSessions()
+	s := open(t, sessions, "p", "a")
+	if got := open(t, sessions, "p", "a"); got != s {
+		t.Fatal("session opened twice")
+	}
+
+	if _, err := s.Append(groupstream.Frame{Seq: 1}); !errors.Is(err, groupstream.ErrOutOfOrder) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrOutOfOrder)
+	}
+	complete, err := s.Append(groupstream.Frame{Seq: 0, Kind: groupstream.KindData, ContentType: "text/plain", Data: []byte("hello")})
+	if err != nil || complete {
+		t.Fatalf("got %v %v", complete, err)
+	}
+	if _, err := s.Append(groupstream.Frame{Seq: 1, Kind: groupstream.KindData, Data: []byte("too large")}); !errors.Is(err, groupstream.ErrTooLarge) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrTooLarge)
+	}
+	complete, err = s.Append(groupstream.Frame{Seq: 1, Kind: groupstream.KindEnd})
+	if err != nil || !complete {
+		t.Fatalf("got %v %v", complete, err)
+	}
+	data, contentType := s.Request()
+	if string(data) != "hello" || contentType != "text/plain" {
+		t.Fatalf("got request %q %q", data, contentType)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if r := s.Pull(ctx, 0); r.Status != groupstream.StatusPending {
+		t.Fatalf("got status %s, want %s", r.Status, groupstream.StatusPending)
+	}
+
+	s.Respond([]byte("response"), "text/plain", "")
+	s.Respond(nil, "", "ignored")
+
+	r := s.Pull(context.Background(), 0)
+	if r.Status != groupstream.StatusDone || string(r.Data) != "response" || r.ContentType != "text/plain" {
+		t.Fatalf("got reply %+v", r)
+	}
+
+	sessions.Remove("a")
+	if _, ok := sessions.Get("a"); ok {
+		t.Fatal("session kept after remove")
+	}
+}
+
+func TestSessionStream(t *testing.T) {
+	sessions := groupstream.NewSessions(groupstream.Options{
+		Idle:            time.Minute,
+		MaxSize:         groupstream.FrameSize + 1,
+		MaxSessions:     1,
+		MaxPeerSessions: 1,
+	})
+	s := open(t, sessions, "p", "a")
+
+	// a pull waits for the next part
+	pulled := make(chan groupstream.Reply)
+	go func() {
+		pulled <- s.Pull(context.Background(), 0)
+	}()
+	if err := s.Write(make([]byte, groupstream.FrameSize+1), "application/octet-stream"); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-pulled; r.Status != groupstream.StatusMore || len(r.Data) != groupstream.FrameSize {
+		t.Fatalf("got status %s with %d bytes", r.Status, len(r.Data))
+	}
+
+	// the parts not pulled yet are limited, pulled frames are dropped
+	if err := s.Write(make([]byte, groupstream.FrameSize), ""); !errors.Is(err, groupstream.ErrTooLarge) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrTooLarge)
+	}
+	if r := s.Pull(context.Background(), 1); r.Status != groupstream.StatusMore || len(r.Data) != 1 {
+		t.Fatalf("got status %s with %d bytes", r.Status, len(r.Data))
+	}
+	if r := s.Pull(context.Background(), 0); r.Status != groupstream.StatusError {
+		t.Fatalf("got status %s, want %s", r.Status, groupstream.StatusError)
+	}
+	if err := s.Write([]byte("last"), ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Close("")
+	if err := s.Write([]byte("late"), ""); !errors.Is(err, groupstream.ErrComplete) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrComplete)
+	}
+
+	r := s.Pull(context.Background(), 2)
+	if r.Status != groupstream.StatusDone || string(r.Data) != "last" || r.ContentType != "application/octet-stream" {
+		t.Fatalf("got reply %+v", r)
+	}
+}
+
+func TestSessionError(t *testing.T) {
+	s := open(t, newSessions(), "p", "a")
+	s.Respond(nil, "", "handler failed")
+	r := s.Pull(context.Background(), 0)
+	if r.Status != groupstream.StatusError || r.Error != "handler failed" {
+		t.Fatalf("got reply %+v", r)
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	sessions := newSessions()
+	open(t, sessions, "p", "a")
+	open(t, sessions, "p", "b")
+	if _, err := sessions.Open("p", "c"); !errors.Is(err, groupstream.ErrTooManySessions) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrTooManySessions)
+	}
+	open(t, sessions, "q", "c")
+	if _, err := sessions.Open("r", "d"); !errors.Is(err, groupstream.ErrTooManySessions) {
+		t.Fatalf("got error %v, want %v", err, groupstream.ErrTooManySessions)
+	}
+
+	// removed sessions no longer count
+	sessions.Remove("a")
+	open(t, sessions, "p", "d")
+}
+
+func TestSessionIdle(t *testing.T) {
+	sessions := groupstream.NewSessions(groupstream.Options{
+		Idle:            50 * time.Millisecond,
+		MaxSize:         10,
+		MaxSessions:     1,
+		MaxPeerSessions: 1,
+	})
+	s := open(t, sessions, "p", "a")
+
+	// using the session keeps it
+	for i := 0; i < 4; i++ {
+		time.Sleep(25 * time.Millisecond)
+		if _, ok := sessions.Get("a"); !ok {
+			t.Fatal("used session expired")
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := sessions.Get("a"); ok {
+		t.Fatal("idle session kept")
+	}
+	if r := s.Pull(context.Background(), 0); r.Status != groupstream.StatusError {
+		t.Fatalf("got status %s, want %s", r.Status, groupstream.StatusError)
+	}
+	open(t, sessions, "p", "b")
+}
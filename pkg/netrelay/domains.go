@@ -0,0 +1,287 @@
+package netrelay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	domainPrefix    = "netrelay-domain-"
+	allowlistPrefix = "netrelay-allowlist-"
+)
+
+var (
+	// ErrDomainNotFound is returned for domains not mapped in a group.
+	ErrDomainNotFound = errors.New("netrelay: domain not found")
+	// ErrStaticDomain is returned when removing a domain of the config.
+	ErrStaticDomain = errors.New("netrelay: domain set by config")
+	// ErrNotAllowed is returned for target addresses that are not in the
+	// allowlist of the group.
+	ErrNotAllowed = errors.New("netrelay: target not allowed")
+	// ErrInvalidAddress is returned for target addresses that can not be
+	// parsed or have an unsupported scheme.
+	ErrInvalidAddress = errors.New("netrelay: invalid target address")
+)
+
+// Domain maps a domain of a group to the addresses requests are forwarded
+// to, HTTP for plain requests and WS for websocket upgrades. Static domains
+// come from the group config and can not be removed.
+type Domain struct {
+	Group  string `json:"group"`
+	Domain string `json:"domain"`
+	HTTP   string `json:"http,omitempty"`
+	WS     string `json:"ws,omitempty"`
+	Static bool   `json:"static"`
+}
+
+// Allowlist limits the targets of the domains of a group to the listed
+// hosts and ports. Without hosts no target is allowed, without ports any
+// port of the listed hosts is. The domains of the group config are not
+// limited.
+type Allowlist struct {
+	Group string   `json:"group"`
+	Hosts []string `json:"hosts"`
+	Ports []int    `json:"ports"`
+}
+
+// Allows checks that the target address addr is allowed.
+func (a Allowlist) Allows(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http", "ws":
+			port = "80"
+		case "https", "wss":
+			port = "443"
+		}
+	}
+
+	var ok bool
+	for _, h := range a.Hosts {
+		if strings.EqualFold(h, host) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%w: host %s", ErrNotAllowed, host)
+	}
+	if len(a.Ports) > 0 {
+		var ok bool
+		for _, p := range a.Ports {
+			if strconv.Itoa(p) == port {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%w: port %s", ErrNotAllowed, port)
+		}
+	}
+	return nil
+}
+
+func validateAddress(addr string, schemes ...string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidAddress)
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: scheme %q", ErrInvalidAddress, u.Scheme)
+}
+
+func domainKey(group, domain string) string {
+	return domainPrefix + group + "/" + domain
+}
+
+// staticDomains returns the domains of the group config.
+func (s *Service) staticDomains() map[string]*Domain {
+	domains := make(map[string]*Domain)
+	get := func(group, domain string) *Domain {
+		key := group + "/" + domain
+		d, ok := domains[key]
+		if !ok {
+			d = &Domain{Group: group, Domain: domain, Static: true}
+			domains[key] = d
+		}
+		return d
+	}
+	for _, g := range s.groups {
+		for _, v := range g.AgentHttp {
+			get(g.Name, v.Domain).HTTP = v.Addr
+		}
+		for _, v := range g.AgentWS {
+			get(g.Name, v.Domain).WS = v.Addr
+		}
+	}
+	return domains
+}
+
+// Domains returns the domains mapped in group, or in all groups if group is
+// empty. Domains registered at runtime replace those of the config.
+func (s *Service) Domains(group string) ([]Domain, error) {
+	domains := s.staticDomains()
+	prefix := domainPrefix
+	if group != "" {
+		prefix += group + "/"
+	}
+	err := s.store.Iterate(prefix, func(_, value []byte) (bool, error) {
+		var d Domain
+		if err := json.Unmarshal(value, &d); err != nil {
+			return true, err
+		}
+		domains[d.Group+"/"+d.Domain] = &d
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Domain, 0, len(domains))
+	for _, d := range domains {
+		if group == "" || d.Group == group {
+			list = append(list, *d)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Group != list[j].Group {
+			return list[i].Group < list[j].Group
+		}
+		return list[i].Domain < list[j].Domain
+	})
+	return list, nil
+}
+
+// Domain returns the mapping of domain in group.
+func (s *Service) Domain(group, domain string) (Domain, error) {
+	var d Domain
+	err := s.store.Get(domainKey(group, domain), &d)
+	if err == nil {
+		return d, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return d, err
+	}
+	if sd, ok := s.staticDomains()[group+"/"+domain]; ok {
+		return *sd, nil
+	}
+	return d, ErrDomainNotFound
+}
+
+// AddDomain registers d, replacing a previous mapping of the same domain.
+// The target addresses must be allowed by the allowlist of the group.
+func (s *Service) AddDomain(d Domain) error {
+	if d.Group == "" || d.Domain == "" || strings.Contains(d.Domain, "/") {
+		return fmt.Errorf("%w: group and domain required", ErrInvalidAddress)
+	}
+	if d.HTTP == "" && d.WS == "" {
+		return fmt.Errorf("%w: no target address", ErrInvalidAddress)
+	}
+	allow, err := s.Allowlist(d.Group)
+	if err != nil {
+		return err
+	}
+	if d.HTTP != "" {
+		if err := validateAddress(d.HTTP, "http", "https"); err != nil {
+			return err
+		}
+		if err := allow.Allows(d.HTTP); err != nil {
+			return err
+		}
+	}
+	if d.WS != "" {
+		if err := validateAddress(d.WS, "ws", "wss"); err != nil {
+			return err
+		}
+		if err := allow.Allows(d.WS); err != nil {
+			return err
+		}
+	}
+	d.Static = false
+	return s.store.Put(domainKey(d.Group, d.Domain), d)
+}
+
+// RemoveDomain removes a domain registered at runtime.
+func (s *Service) RemoveDomain(group, domain string) error {
+	var d Domain
+	err := s.store.Get(domainKey(group, domain), &d)
+	if err == nil {
+		return s.store.Delete(domainKey(group, domain))
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if _, ok := s.staticDomains()[group+"/"+domain]; ok {
+		return ErrStaticDomain
+	}
+	return ErrDomainNotFound
+}
+
+// Allowlist returns the allowlist of group.
+func (s *Service) Allowlist(group string) (Allowlist, error) {
+	a := Allowlist{Group: group}
+	err := s.store.Get(allowlistPrefix+group, &a)
+	if errors.Is(err, storage.ErrNotFound) {
+		return Allowlist{Group: group}, nil
+	}
+	return a, err
+}
+
+// SetAllowlist replaces the allowlist of a group.
+func (s *Service) SetAllowlist(a Allowlist) error {
+	if a.Group == "" {
+		return fmt.Errorf("%w: group required", ErrInvalidAddress)
+	}
+	for _, p := range a.Ports {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("%w: port %d", ErrInvalidAddress, p)
+		}
+	}
+	return s.store.Put(allowlistPrefix+a.Group, a)
+}
+
+// target returns the address a request for domain of group is forwarded
+// to, scheme tells websocket upgrades from plain requests.
+func (s *Service) target(scheme, group, domain string) (string, error) {
+	d, err := s.Domain(group, domain)
+	if err != nil {
+		return "", err
+	}
+	addr := d.HTTP
+	if scheme == "ws" {
+		addr = d.WS
+	}
+	if addr == "" {
+		return "", ErrDomainNotFound
+	}
+	if d.Static {
+		return addr, nil
+	}
+	allow, err := s.Allowlist(group)
+	if err != nil {
+		return "", err
+	}
+	if err := allow.Allows(addr); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
@@ -0,0 +1,12 @@
+package netrelay
+
+import "github.com/gauss-project/aurorafs/pkg/boson"
+
+var (
+	Tunnel      = tunnel
+	IsWebsocket = isWebsocket
+)
+
+func (s *Service) Allows(svc TCPService, peer boson.Address) (bool, error) {
+	return s.allows(svc, peer)
+}
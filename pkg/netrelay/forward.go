@@ -0,0 +1,428 @@
+package netrelay
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/p2p"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	servicePrefix = "netrelay-service-"
+
+	// forwardDialTimeout limits opening the stream to the target and the
+	// connection to the service behind it.
+	forwardDialTimeout = 15 * time.Second
+)
+
+var (
+	// ErrServiceNotFound is returned for services that are not exposed.
+	ErrServiceNotFound = errors.New("netrelay: service not found")
+	// ErrAccessDenied is returned when the access policy of a service does
+	// not allow the peer.
+	ErrAccessDenied = errors.New("netrelay: access denied")
+	// ErrForwardNotFound is returned for unknown forwards.
+	ErrForwardNotFound = errors.New("netrelay: forward not found")
+)
+
+// TCPService is a tcp service exposed to other nodes. Peers may connect if
+// they are listed or are among the nodes configured for one of the listed
+// groups, a service without peers and groups can not be connected to.
+type TCPService struct {
+	Name   string          `json:"name"`
+	Addr   string          `json:"addr"`
+	Groups []string        `json:"groups"`
+	Peers  []boson.Address `json:"peers"`
+}
+
+// Forward is a local listener whose connections are tunneled to a service
+// of the target node. A forward with a lease is closed unless it is renewed
+// within the lease, Lease is in seconds.
+type Forward struct {
+	ID      string        `json:"id"`
+	Listen  string        `json:"listen"`
+	Target  boson.Address `json:"target"`
+	Service string        `json:"service"`
+	Lease   int64         `json:"lease,omitempty"`
+}
+
+type forward struct {
+	Forward
+	listener net.Listener
+	lease    time.Duration
+	timer    *time.Timer
+}
+
+// tcpRequest and tcpResponse open a tcp tunnel, each is sent as a line of
+// json before the tunneled bytes.
+type tcpRequest struct {
+	Service string `json:"service"`
+}
+
+type tcpResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+func writeLine(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func readLine(r *bufio.Reader, v interface{}) error {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// Services returns the exposed tcp services.
+func (s *Service) Services() ([]TCPService, error) {
+	services := make([]TCPService, 0)
+	err := s.store.Iterate(servicePrefix, func(_, value []byte) (bool, error) {
+		var svc TCPService
+		if err := json.Unmarshal(value, &svc); err != nil {
+			return true, err
+		}
+		services = append(services, svc)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services, nil
+}
+
+// TCPService returns the exposed service name.
+func (s *Service) TCPService(name string) (TCPService, error) {
+	var svc TCPService
+	err := s.store.Get(servicePrefix+name, &svc)
+	if errors.Is(err, storage.ErrNotFound) {
+		return svc, ErrServiceNotFound
+	}
+	return svc, err
+}
+
+// ExposeService exposes svc, replacing a service of the same name.
+func (s *Service) ExposeService(svc TCPService) error {
+	if svc.Name == "" || strings.Contains(svc.Name, "/") {
+		return fmt.Errorf("%w: service name required", ErrInvalidAddress)
+	}
+	if _, _, err := net.SplitHostPort(svc.Addr); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	return s.store.Put(servicePrefix+svc.Name, svc)
+}
+
+// RemoveService stops exposing service name. Open connections are kept.
+func (s *Service) RemoveService(name string) error {
+	if _, err := s.TCPService(name); err != nil {
+		return err
+	}
+	return s.store.Delete(servicePrefix + name)
+}
+
+// allows checks the access policy of svc for peer. Only the listed peers
+// and the nodes listed in the configuration of the listed groups are
+// allowed, being known or connected in a group is not enough.
+func (s *Service) allows(svc TCPService, peer boson.Address) (bool, error) {
+	for _, p := range svc.Peers {
+		if p.Equal(peer) {
+			return true, nil
+		}
+	}
+	for _, g := range svc.Groups {
+		nodes, err := s.groupNodes(groupconf.GroupID(g))
+		if err != nil {
+			return false, err
+		}
+		for _, n := range nodes {
+			if n.Equal(peer) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// groupNodes returns the nodes listed in the configuration of group gid.
+// A configuration saved at runtime replaces the one the node started with.
+func (s *Service) groupNodes(gid boson.Address) ([]boson.Address, error) {
+	c, err := groupconf.NewStore(s.store).Get(gid)
+	if err == nil {
+		return c.Nodes, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	for _, g := range s.groups {
+		if groupconf.GroupID(g.Name).Equal(gid) {
+			return g.Nodes, nil
+		}
+	}
+	return nil, nil
+}
+
+// Forwards returns the open forwards.
+func (s *Service) Forwards() []Forward {
+	s.forwardMu.Lock()
+	defer s.forwardMu.Unlock()
+
+	list := make([]Forward, 0, len(s.forwards))
+	for _, f := range s.forwards {
+		list = append(list, f.Forward)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// isLoopback reports whether the listen address host is a loopback address.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// OpenForward listens on listen and tunnels every accepted connection to
+// service of target, like ssh -L does. A port 0 listens on a free port, the
+// address listened on is returned in the forward. Only loopback addresses
+// are listened on unless any address is allowed. A forward with a lease is
+// closed once it is not renewed for the lease, one without is kept until
+// it is closed.
+func (s *Service) OpenForward(listen string, target boson.Address, service string, lease time.Duration) (Forward, error) {
+	if service == "" {
+		return Forward{}, fmt.Errorf("%w: service name required", ErrInvalidAddress)
+	}
+	if target.IsZero() {
+		return Forward{}, fmt.Errorf("%w: target required", ErrInvalidAddress)
+	}
+	if lease < 0 {
+		return Forward{}, fmt.Errorf("%w: negative lease", ErrInvalidAddress)
+	}
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return Forward{}, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	s.forwardMu.Lock()
+	anyAddress := s.forwardAnyAddress
+	s.forwardMu.Unlock()
+	if !anyAddress && !isLoopback(host) {
+		return Forward{}, fmt.Errorf("%w: listen address %s is not a loopback address", ErrNotAllowed, listen)
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return Forward{}, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		_ = l.Close()
+		return Forward{}, err
+	}
+
+	f := &forward{
+		Forward:  Forward{ID: hex.EncodeToString(id), Listen: l.Addr().String(), Target: target, Service: service, Lease: int64(lease / time.Second)},
+		listener: l,
+		lease:    lease,
+	}
+	s.forwardMu.Lock()
+	s.forwards[f.ID] = f
+	if lease > 0 {
+		f.timer = time.AfterFunc(lease, func() {
+			s.expireForward(f)
+		})
+	}
+	s.forwardMu.Unlock()
+
+	go s.serveForward(f)
+	return f.Forward, nil
+}
+
+// RenewForward extends the lease of forward id.
+func (s *Service) RenewForward(id string) error {
+	s.forwardMu.Lock()
+	defer s.forwardMu.Unlock()
+
+	f, ok := s.forwards[id]
+	if !ok {
+		return ErrForwardNotFound
+	}
+	if f.timer != nil {
+		f.timer.Reset(f.lease)
+	}
+	return nil
+}
+
+// expireForward closes f once its lease ran out, unless it was closed
+// before.
+func (s *Service) expireForward(f *forward) {
+	s.forwardMu.Lock()
+	if s.forwards[f.ID] != f {
+		s.forwardMu.Unlock()
+		return
+	}
+	delete(s.forwards, f.ID)
+	s.forwardMu.Unlock()
+
+	s.logger.Debugf("netrelay forward %s: lease expired", f.ID)
+	_ = f.listener.Close()
+}
+
+// CloseForward stops listening for forward id. Open connections are kept.
+func (s *Service) CloseForward(id string) error {
+	s.forwardMu.Lock()
+	f, ok := s.forwards[id]
+	delete(s.forwards, id)
+	s.forwardMu.Unlock()
+
+	if !ok {
+		return ErrForwardNotFound
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	return f.listener.Close()
+}
+
+// Close stops listening for all forwards.
+func (s *Service) Close() error {
+	s.forwardMu.Lock()
+	defer s.forwardMu.Unlock()
+
+	for id, f := range s.forwards {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		_ = f.listener.Close()
+		delete(s.forwards, id)
+	}
+	return nil
+}
+
+func (s *Service) serveForward(f *forward) {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			s.logger.Tracef("netrelay forward %s: accept: %v", f.ID, err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.forwardConn(conn, f.Target, f.Service); err != nil {
+				s.logger.Debugf("netrelay forward %s to %s/%s: %v", f.ID, f.Target, f.Service, err)
+			}
+		}()
+	}
+}
+
+// forwardConn tunnels conn to service of target.
+func (s *Service) forwardConn(conn net.Conn, target boson.Address, service string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardDialTimeout)
+	defer cancel()
+
+	var st p2p.Stream
+	if s.route.IsNeighbor(target) {
+		st, err = s.streamer.NewStream(ctx, target, nil, protocolName, protocolVersion, streamRelayTCP)
+	} else {
+		st, err = s.streamer.NewConnChainRelayStream(ctx, target, nil, protocolName, protocolVersion, streamRelayTCP)
+	}
+	if err != nil {
+		return fmt.Errorf("new stream %s", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = st.Reset()
+		} else {
+			_ = st.Close()
+		}
+	}()
+
+	if err = writeLine(st, tcpRequest{Service: service}); err != nil {
+		return err
+	}
+	r := bufio.NewReader(st)
+	var resp tcpResponse
+	if err = readLine(r, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return tunnel(conn, bufferedConn{ReadWriteCloser: st, r: r})
+}
+
+// onRelayTCP connects a peer to an exposed service its access policy
+// allows.
+func (s *Service) onRelayTCP(ctx context.Context, p p2p.Peer, stream p2p.Stream) (err error) {
+	defer func() {
+		if err != nil {
+			s.logger.Tracef("onRelayTCP from %s err %s", p.Address, err)
+			_ = stream.Reset()
+		} else {
+			_ = stream.Close()
+		}
+	}()
+
+	r := bufio.NewReader(stream)
+	var req tcpRequest
+	if err = readLine(r, &req); err != nil {
+		return err
+	}
+	reject := func(e error) error {
+		_ = writeLine(stream, tcpResponse{Error: e.Error()})
+		return e
+	}
+
+	svc, err := s.TCPService(req.Service)
+	if err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			return reject(err)
+		}
+		return reject(errors.New("netrelay: service unavailable"))
+	}
+	allowed, err := s.allows(svc, p.Address)
+	if err != nil {
+		s.logger.Debugf("onRelayTCP: access policy of service %s: %v", svc.Name, err)
+		return reject(errors.New("netrelay: service unavailable"))
+	}
+	if !allowed {
+		s.logger.Infof("onRelayTCP: %s denied access to service %s", p.Address, svc.Name)
+		return reject(ErrAccessDenied)
+	}
+
+	d := net.Dialer{Timeout: forwardDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", svc.Addr)
+	if err != nil {
+		s.logger.Debugf("onRelayTCP: service %s: %v", svc.Name, err)
+		return reject(errors.New("netrelay: service unavailable"))
+	}
+	defer conn.Close()
+	if err = writeLine(stream, tcpResponse{}); err != nil {
+		return err
+	}
+	s.logger.Infof("onRelayTCP from %s to service %s", p.Address, svc.Name)
+	return tunnel(bufferedConn{ReadWriteCloser: stream, r: r}, conn)
+}
@@ -0,0 +1,201 @@
+// Package netrelay proxies HTTP and websocket requests to services reachable
+// by the members of a multicast group.
+//
+// A request to /group/http/{group}/{domain}/... is forwarded to a peer of
+// the group, which sends it on to the target address the domain is mapped
+// to. Domains are mapped in the group config and at runtime, the targets of
+// a group can be limited by an allowlist of hosts and ports.
+//
+// Other tcp services are exposed by name with an access policy each. A
+// forward listens locally and tunnels its connections to a service of a
+// target node.
+//
+// The streams are those of the aurorafs netrelay protocol, so both relay
+// with each other. Its handlers only resolve the domains of the group
+// config and can not be given other targets, so they are served here.
+package netrelay
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/aurora"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/jsonhttp"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/multicast"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	"github.com/gauss-project/aurorafs/pkg/p2p"
+	"github.com/gauss-project/aurorafs/pkg/routetab"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+type NetRelay interface {
+	RelayHttpDo(w http.ResponseWriter, r *http.Request, address boson.Address)
+	Domains(group string) ([]Domain, error)
+	Domain(group, domain string) (Domain, error)
+	AddDomain(d Domain) error
+	RemoveDomain(group, domain string) error
+	Allowlist(group string) (Allowlist, error)
+	SetAllowlist(a Allowlist) error
+	Services() ([]TCPService, error)
+	TCPService(name string) (TCPService, error)
+	ExposeService(svc TCPService) error
+	RemoveService(name string) error
+	Forwards() []Forward
+	OpenForward(listen string, target boson.Address, service string, lease time.Duration) (Forward, error)
+	RenewForward(id string) error
+	CloseForward(id string) error
+}
+
+type Service struct {
+	streamer  p2p.Streamer
+	logger    logging.Logger
+	route     routetab.RouteTab
+	groups    []model.ConfigNodeGroup
+	multicast multicast.GroupInterface
+	store     storage.StateStorer
+
+	forwardMu         sync.Mutex
+	forwards          map[string]*forward
+	forwardAnyAddress bool
+}
+
+func New(streamer p2p.Streamer, logging logging.Logger, store storage.StateStorer, groups []model.ConfigNodeGroup, route routetab.RouteTab, multicast multicast.GroupInterface) *Service {
+	return &Service{
+		streamer:  streamer,
+		logger:    logging,
+		store:     store,
+		groups:    groups,
+		route:     route,
+		multicast: multicast,
+		forwards:  make(map[string]*forward),
+	}
+}
+
+// SetForwardAnyAddress lets forwards listen on any address, by default they
+// only listen on loopback addresses.
+func (s *Service) SetForwardAnyAddress(allow bool) {
+	s.forwardMu.Lock()
+	defer s.forwardMu.Unlock()
+	s.forwardAnyAddress = allow
+}
+
+// RelayHttpDo forwards r to address, or to the peers of the group of the
+// request until one of them answers if address is the zero address.
+func (s *Service) RelayHttpDo(w http.ResponseWriter, r *http.Request, address boson.Address) {
+	url := strings.ReplaceAll(r.URL.String(), aurora.RelayPrefixHttp, "")
+	var forward []boson.Address
+	if boson.ZeroAddress.Equal(address) {
+		urls := strings.Split(url, "/")
+		group := urls[1]
+		nodes, err1 := s.multicast.GetGroupPeers(group)
+		if err1 != nil {
+			jsonhttp.InternalServerError(w, err1)
+			return
+		}
+
+		if len(nodes.Connected) == 0 && len(nodes.Keep) == 0 {
+			jsonhttp.InternalServerError(w, fmt.Sprintf("No corresponding node found of group:%s", group))
+			return
+		}
+		forward = append(forward, nodes.Connected...)
+		forward = append(forward, nodes.Keep...)
+	} else {
+		forward = append(forward, address)
+	}
+
+	var (
+		err       error
+		responded bool
+	)
+	for _, addr := range forward {
+		responded, err = s.copyStream(w, r, addr)
+		if err == nil || responded {
+			break
+		}
+	}
+	if err != nil && !responded {
+		jsonhttp.InternalServerError(w, err)
+	}
+}
+
+// copyStream forwards r to addr and copies back the response, or tunnels
+// the connection for websocket upgrades. It reports whether the response
+// was started, after which the request can not be sent to another peer.
+func (s *Service) copyStream(w http.ResponseWriter, r *http.Request, addr boson.Address) (responded bool, err error) {
+	var st p2p.Stream
+	if s.route.IsNeighbor(addr) {
+		st, err = s.streamer.NewStream(r.Context(), addr, nil, protocolName, protocolVersion, streamRelayHttpReqV2)
+	} else {
+		st, err = s.streamer.NewConnChainRelayStream(r.Context(), addr, nil, protocolName, protocolVersion, streamRelayHttpReqV2)
+	}
+	if err != nil {
+		return false, fmt.Errorf("new stream %s", err)
+	}
+	defer func() {
+		if err != nil {
+			s.logger.Tracef("RelayHttpDoV2 to %s err %s", addr, err)
+			_ = st.Reset()
+		} else {
+			_ = st.Close()
+			s.logger.Tracef("RelayHttpDoV2 to %s stream close", addr)
+		}
+	}()
+	err = r.Write(st)
+	if err != nil {
+		return false, err
+	}
+
+	if isWebsocket(r.Header) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			return false, errors.New("websocket not supported")
+		}
+		w.Header().Set("hijack", "true")
+		conn, buf, err := hj.Hijack()
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		// the upgrade response of the target is passed through the tunnel
+		err = tunnel(bufferedConn{ReadWriteCloser: conn, r: buf.Reader}, st)
+		s.logger.Tracef("RelayHttpDoV2 to %s tunnel closed: %v", addr, err)
+		return true, err
+	}
+
+	// give up on the target when the client goes away, a streaming
+	// response may otherwise be waited for forever
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			_ = st.Reset()
+		case <-done:
+		}
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(st), r)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	// Copy any headers
+	for k, v := range resp.Header {
+		w.Header().Del(k)
+		for _, h := range v {
+			w.Header().Add(k, h)
+		}
+	}
+	// Write response status and headers
+	w.WriteHeader(resp.StatusCode)
+
+	return true, copyResponse(w, resp)
+}
@@ -0,0 +1,574 @@
+package netrelay_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/FavorLabs/favorX/pkg/netrelay"
+	"github.com/gauss-project/aurorafs/pkg/aurora"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/multicast/model"
+	"github.com/gauss-project/aurorafs/pkg/p2p/streamtest"
+	"github.com/gauss-project/aurorafs/pkg/routetab"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+	"github.com/gorilla/websocket"
+)
+
+func newService() *netrelay.Service {
+	groups := []model.ConfigNodeGroup{{
+		Name:      "chat",
+		AgentHttp: []model.ConfigNetDomain{{Domain: "web", Addr: "http://127.0.0.1:8080"}},
+		AgentWS:   []model.ConfigNetDomain{{Domain: "web", Addr: "ws://127.0.0.1:8081"}},
+	}}
+	return netrelay.New(nil, logging.New(io.Discard, 0), mock.NewStateStore(), groups, nil, nil)
+}
+
+func TestDomains(t *testing.T) {
+	s := newService()
+
+	d, err := s.Domain("chat", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Static || d.HTTP != "http://127.0.0.1:8080" || d.WS != "ws://127.0.0.1:8081" {
+		t.Fatalf("got %+v", d)
+	}
+
+	for _, group := range []string{"chat", "other"} {
+		if err := s.SetAllowlist(netrelay.Allowlist{Group: group, Hosts: []string{"example.com"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := netrelay.Domain{Group: "chat", Domain: "api", HTTP: "https://example.com"}
+	if err := s.AddDomain(api); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDomain(netrelay.Domain{Group: "chat", Domain: "bad", HTTP: "ws://example.com"}); !errors.Is(err, netrelay.ErrInvalidAddress) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrInvalidAddress)
+	}
+	if err := s.AddDomain(netrelay.Domain{Group: "other", Domain: "web", WS: "wss://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	domains, err := s.Domains("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[0].Domain != "api" || domains[0].Static || domains[1].Domain != "web" {
+		t.Fatalf("got domains %+v", domains)
+	}
+	if domains, err = s.Domains(""); err != nil || len(domains) != 3 {
+		t.Fatalf("got domains %+v, error %v", domains, err)
+	}
+
+	if err := s.RemoveDomain("chat", "web"); !errors.Is(err, netrelay.ErrStaticDomain) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrStaticDomain)
+	}
+	if err := s.RemoveDomain("chat", "api"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Domain("chat", "api"); !errors.Is(err, netrelay.ErrDomainNotFound) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrDomainNotFound)
+	}
+	if err := s.RemoveDomain("chat", "api"); !errors.Is(err, netrelay.ErrDomainNotFound) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrDomainNotFound)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	s := newService()
+
+	a, err := s.Allowlist("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Hosts) != 0 || len(a.Ports) != 0 {
+		t.Fatalf("got allowlist %+v", a)
+	}
+
+	if err := s.SetAllowlist(netrelay.Allowlist{Group: "chat", Ports: []int{0}}); !errors.Is(err, netrelay.ErrInvalidAddress) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrInvalidAddress)
+	}
+	if err := s.SetAllowlist(netrelay.Allowlist{Group: "chat", Hosts: []string{"example.com"}, Ports: []int{443}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.AddDomain(netrelay.Domain{Group: "chat", Domain: "api", HTTP: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"http://example.com", "https://other.com", "https://example.com:8443"} {
+		err := s.AddDomain(netrelay.Domain{Group: "chat", Domain: "api", HTTP: addr})
+		if !errors.Is(err, netrelay.ErrNotAllowed) {
+			t.Fatalf("%s: got error %v, want %v", addr, err, netrelay.ErrNotAllowed)
+		}
+	}
+	// groups without allowlist allow no target
+	if err := s.AddDomain(netrelay.Domain{Group: "other", Domain: "api", HTTP: "http://other.com:81"}); !errors.Is(err, netrelay.ErrNotAllowed) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrNotAllowed)
+	}
+	// without ports any port of the listed hosts is allowed
+	if err := s.SetAllowlist(netrelay.Allowlist{Group: "other", Hosts: []string{"other.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDomain(netrelay.Domain{Group: "other", Domain: "api", HTTP: "http://other.com:81"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsWebsocket(t *testing.T) {
+	for _, tc := range []struct {
+		connection, upgrade string
+		want                bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+	} {
+		h := http.Header{}
+		h.Set("Connection", tc.connection)
+		h.Set("Upgrade", tc.upgrade)
+		if got := netrelay.IsWebsocket(h); got != tc.want {
+			t.Errorf("%q %q: got %v, want %v", tc.connection, tc.upgrade, got, tc.want)
+		}
+	}
+}
+
+// tcpPair returns both ends of a loopback tcp connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c1.Close()
+		_ = c2.Close()
+	})
+	return c1.(*net.TCPConn), c2.(*net.TCPConn)
+}
+
+func TestTunnel(t *testing.T) {
+	client, a := tcpPair(t)
+	b, target := tcpPair(t)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- netrelay.Tunnel(a, b)
+	}()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(target, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("got %q, error %v", buf, err)
+	}
+	if _, err := target.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "world" {
+		t.Fatalf("got %q, error %v", buf, err)
+	}
+
+	// the client closing its side is passed on, and the target can still
+	// send before it closes its side too
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Read(buf); err != io.EOF {
+		t.Fatalf("got error %v, want %v", err, io.EOF)
+	}
+	if _, err := target.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(client)
+	if err != nil || string(got) != "bye" {
+		t.Fatalf("got %q, error %v", got, err)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("tunnel not closed")
+	}
+}
+
+// neighbors reports every peer as a neighbor, so streams are opened directly.
+type neighbors struct {
+	routetab.RouteTab
+}
+
+func (neighbors) IsNeighbor(boson.Address) bool {
+	return true
+}
+
+// startRelay starts a node relaying http requests to a node serving the
+// domain web of the group chat at httpAddr and wsAddr, and returns the
+// address of the relaying node.
+func startRelay(t *testing.T, httpAddr, wsAddr string) string {
+	t.Helper()
+	clientAddr := boson.MustParseHexAddress("ca1e")
+	serverAddr := boson.MustParseHexAddress("be1e")
+	logger := logging.New(io.Discard, 0)
+
+	groups := []model.ConfigNodeGroup{{
+		Name:      "chat",
+		AgentHttp: []model.ConfigNetDomain{{Domain: "web", Addr: httpAddr}},
+		AgentWS:   []model.ConfigNetDomain{{Domain: "web", Addr: wsAddr}},
+	}}
+	server := netrelay.New(nil, logger, mock.NewStateStore(), groups, nil, nil)
+	recorder := streamtest.New(streamtest.WithProtocols(server.Protocol()), streamtest.WithBaseAddr(clientAddr))
+	client := netrelay.New(recorder, logger, mock.NewStateStore(), nil, neighbors{}, nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client.RelayHttpDo(w, r, serverAddr)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.Listener.Addr().String()
+}
+
+func TestRelayEventStream(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-next:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = io.WriteString(w, "data: 2\n\n")
+	}))
+	defer backend.Close()
+	relay := startRelay(t, backend.URL, "")
+
+	resp, err := http.Get("http://" + relay + aurora.RelayPrefixHttp + "/chat/web/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// the first event arrives while the backend still holds the response
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil || line != "data: 1\n" {
+		t.Fatalf("got %q, error %v", line, err)
+	}
+	close(next)
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "\ndata: 2\n\n" {
+		t.Fatalf("got %q, error %v", rest, err)
+	}
+}
+
+func TestRelayWebsocket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+	relay := startRelay(t, "", "ws://"+backend.Listener.Addr().String())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+relay+aurora.RelayPrefixHttp+"/chat/web/echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, msg := range []string{"ping", "pong"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != msg {
+			t.Fatalf("got %q, error %v", data, err)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	// echo service
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	clientAddr := boson.MustParseHexAddress("ca1e")
+	serverAddr := boson.MustParseHexAddress("be1e")
+	logger := logging.New(io.Discard, 0)
+
+	server := netrelay.New(nil, logger, mock.NewStateStore(), nil, nil, nil)
+	if err := server.ExposeService(netrelay.TCPService{Name: "echo", Addr: "localhost"}); !errors.Is(err, netrelay.ErrInvalidAddress) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrInvalidAddress)
+	}
+	if err := server.ExposeService(netrelay.TCPService{Name: "echo", Addr: l.Addr().String(), Peers: []boson.Address{clientAddr}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ExposeService(netrelay.TCPService{Name: "private", Addr: l.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	services, err := server.Services()
+	if err != nil || len(services) != 2 {
+		t.Fatalf("got services %+v, error %v", services, err)
+	}
+
+	recorder := streamtest.New(streamtest.WithProtocols(server.Protocol()), streamtest.WithBaseAddr(clientAddr))
+	client := netrelay.New(recorder, logger, mock.NewStateStore(), nil, neighbors{}, nil)
+	defer client.Close()
+
+	if _, err := client.OpenForward(":0", serverAddr, "echo", 0); !errors.Is(err, netrelay.ErrNotAllowed) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrNotAllowed)
+	}
+	echo, err := client.OpenForward("127.0.0.1:0", serverAddr, "echo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", echo.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, error %v", buf, err)
+	}
+
+	// the access policy of the service does not allow the client
+	private, err := client.OpenForward("127.0.0.1:0", serverAddr, "private", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, err := net.Dial("tcp", private.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Close()
+	_ = denied.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := denied.Read(buf); err != io.EOF {
+		t.Fatalf("got error %v, want %v", err, io.EOF)
+	}
+
+	if got := client.Forwards(); len(got) != 2 {
+		t.Fatalf("got forwards %+v", got)
+	}
+	if err := client.CloseForward(echo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseForward(echo.ID); !errors.Is(err, netrelay.ErrForwardNotFound) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrForwardNotFound)
+	}
+	if _, err := net.Dial("tcp", echo.Listen); err == nil {
+		t.Fatal("forward still listening")
+	}
+}
+
+func TestForwardLease(t *testing.T) {
+	s := netrelay.New(nil, logging.New(io.Discard, 0), mock.NewStateStore(), nil, nil, nil)
+	defer s.Close()
+	target := boson.MustParseHexAddress("be1e")
+
+	f, err := s.OpenForward("localhost:0", target, "echo", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// renewing keeps the forward
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if err := s.RenewForward(f.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := s.RenewForward(f.ID); !errors.Is(err, netrelay.ErrForwardNotFound) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrForwardNotFound)
+	}
+	if _, err := net.Dial("tcp", f.Listen); err == nil {
+		t.Fatal("expired forward still listening")
+	}
+
+	s.SetForwardAnyAddress(true)
+	wide, err := s.OpenForward(":0", target, "echo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloseForward(wide.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceAccess(t *testing.T) {
+	alice := boson.MustParseHexAddress("a11c")
+	bob := boson.MustParseHexAddress("b0b0")
+	store := mock.NewStateStore()
+	groups := []model.ConfigNodeGroup{{Name: "team", Nodes: []boson.Address{alice}}}
+	s := netrelay.New(nil, logging.New(io.Discard, 0), store, groups, nil, nil)
+
+	svc := netrelay.TCPService{Name: "db", Addr: "127.0.0.1:5432", Groups: []string{"team"}}
+	for peer, want := range map[string]bool{alice.String(): true, bob.String(): false} {
+		got, err := s.Allows(svc, boson.MustParseHexAddress(peer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("peer %s: got allowed %v, want %v", peer, got, want)
+		}
+	}
+
+	// a configuration saved at runtime replaces the one of the config
+	if err := groupconf.NewStore(store).Put(model.ConfigNodeGroup{Name: "team", Nodes: []boson.Address{bob}}); err != nil {
+		t.Fatal(err)
+	}
+	for peer, want := range map[string]bool{alice.String(): false, bob.String(): true} {
+		got, err := s.Allows(svc, boson.MustParseHexAddress(peer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("peer %s: got allowed %v, want %v", peer, got, want)
+		}
+	}
+
+	svc.Peers = []boson.Address{alice}
+	if got, err := s.Allows(svc, alice); err != nil || !got {
+		t.Fatalf("listed peer: got allowed %v, error %v", got, err)
+	}
+}
+
+func TestService(t *testing.T) {
+	s := netrelay.New(nil, logging.New(io.Discard, 0), mock.NewStateStore(), nil, nil, nil)
+
+	if _, err := s.TCPService("db"); !errors.Is(err, netrelay.ErrServiceNotFound) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrServiceNotFound)
+	}
+	if err := s.RemoveService("db"); !errors.Is(err, netrelay.ErrServiceNotFound) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrServiceNotFound)
+	}
+	if err := s.ExposeService(netrelay.TCPService{Name: "a/b", Addr: "127.0.0.1:5432"}); !errors.Is(err, netrelay.ErrInvalidAddress) {
+		t.Fatalf("got error %v, want %v", err, netrelay.ErrInvalidAddress)
+	}
+
+	// exposing again replaces the service
+	if err := s.ExposeService(netrelay.TCPService{Name: "db", Addr: "127.0.0.1:5432"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ExposeService(netrelay.TCPService{Name: "db", Addr: "127.0.0.1:5433", Groups: []string{"team"}}); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := s.TCPService("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Addr != "127.0.0.1:5433" || len(svc.Groups) != 1 {
+		t.Fatalf("got service %+v", svc)
+	}
+
+	if err := s.RemoveService("db"); err != nil {
+		t.Fatal(err)
+	}
+	if services, err := s.Services(); err != nil || len(services) != 0 {
+		t.Fatalf("got services %+v, error %v", services, err)
+	}
+}
+
+func TestForwardClose(t *testing.T) {
+	s := netrelay.New(nil, logging.New(io.Discard, 0), mock.NewStateStore(), nil, nil, nil)
+	target := boson.MustParseHexAddress("be1e")
+
+	for _, tc := range []struct {
+		listen, service string
+		target          boson.Address
+		lease           time.Duration
+	}{
+		{"127.0.0.1:0", "", target, 0},
+		{"127.0.0.1:0", "echo", boson.ZeroAddress, 0},
+		{"127.0.0.1:0", "echo", target, -time.Second},
+		{"127.0.0.1", "echo", target, 0},
+	} {
+		if _, err := s.OpenForward(tc.listen, tc.target, tc.service, tc.lease); !errors.Is(err, netrelay.ErrInvalidAddress) {
+			t.Fatalf("open %+v: got error %v, want %v", tc, err, netrelay.ErrInvalidAddress)
+		}
+	}
+
+	var forwards []netrelay.Forward
+	for i := 0; i < 2; i++ {
+		f, err := s.OpenForward("127.0.0.1:0", target, "echo", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forwards = append(forwards, f)
+	}
+
+	// closing the service stops listening for every forward
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Forwards(); len(got) != 0 {
+		t.Fatalf("got forwards %+v", got)
+	}
+	for _, f := range forwards {
+		if _, err := net.Dial("tcp", f.Listen); err == nil {
+			t.Fatalf("forward %s still listening", f.ID)
+		}
+		if err := s.RenewForward(f.ID); !errors.Is(err, netrelay.ErrForwardNotFound) {
+			t.Fatalf("got error %v, want %v", err, netrelay.ErrForwardNotFound)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package netrelay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+
+	"github.com/gauss-project/aurorafs/pkg/aurora"
+	"github.com/gauss-project/aurorafs/pkg/netrelay/pb"
+	"github.com/gauss-project/aurorafs/pkg/p2p"
+	"github.com/gauss-project/aurorafs/pkg/p2p/protobuf"
+)
+
+const (
+	protocolName         = "netrelay"
+	protocolVersion      = "2.0.0"
+	streamRelayHttpReq   = "httpreq"   // v1 http proxy
+	streamRelayHttpReqV2 = "httpreqv2" // v2 http proxy support ws
+	streamRelayTCP       = "tcp"       // tunnel to an exposed tcp service
+)
+
+func (s *Service) Protocol() p2p.ProtocolSpec {
+	return p2p.ProtocolSpec{
+		Name:    protocolName,
+		Version: protocolVersion,
+		StreamSpecs: []p2p.StreamSpec{
+			{
+				Name:    streamRelayHttpReq,
+				Handler: s.relayHttpReq,
+			},
+			{
+				Name:    streamRelayHttpReqV2,
+				Handler: s.onRelayHttpReqV2,
+			},
+			{
+				Name:    streamRelayTCP,
+				Handler: s.onRelayTCP,
+			},
+		},
+	}
+}
+
+// relayHttpReq serves the requests of peers still on the v1 protocol, which
+// sends the whole request and response as single messages.
+// Deprecated: see onRelayHttpReqV2
+func (s *Service) relayHttpReq(ctx context.Context, p p2p.Peer, stream p2p.Stream) (err error) {
+	var httpResp pb.RelayHttpResp
+
+	w, r := protobuf.NewWriterAndReader(stream)
+
+	defer func() {
+		if err != nil {
+			_ = stream.Reset()
+		} else {
+			go stream.FullClose()
+		}
+	}()
+
+	reqWriter := func(resp pb.RelayHttpResp) error {
+		if err = w.WriteMsgWithContext(ctx, &resp); err != nil {
+			s.logger.Errorf("relayHttpReq: write resp to %s err %v", p.Address, err)
+			return fmt.Errorf("write resp: %w", err)
+		}
+		return nil
+	}
+	fail := func(status int, format string, args ...interface{}) error {
+		httpResp.Status = int32(status)
+		httpResp.Body = []byte(fmt.Sprintf(format, args...))
+		return reqWriter(httpResp)
+	}
+
+	var httpReq pb.RelayHttpReq
+	if err = r.ReadMsgWithContext(ctx, &httpReq); err != nil {
+		s.logger.Errorf("relayHttpReq: read req from %s err %v", p.Address, err)
+		return fmt.Errorf("read req: %w", err)
+	}
+
+	s.logger.Tracef("relayHttpReq: from %s got req: %s", p.Address, httpReq.Url)
+
+	urls := strings.Split(httpReq.Url, "/")
+	if len(urls) < 3 {
+		return fail(http.StatusBadRequest, "Bad Request")
+	}
+	addr, err := s.target("http", urls[1], urls[2])
+	if err != nil {
+		s.logger.Errorf("relayHttpReq: domain %s of group %s: %v", urls[2], urls[1], err)
+		return fail(http.StatusBadGateway, "Bad Gateway")
+	}
+
+	url := addr + strings.ReplaceAll(httpReq.Url, "/"+urls[1]+"/"+urls[2], "")
+	req, err := http.NewRequestWithContext(ctx, string(httpReq.Method), url, bytes.NewReader(httpReq.Body))
+	if err != nil {
+		return fail(http.StatusInternalServerError, "on req convert to http.request err: %v", err)
+	}
+
+	reqHeaderMp := make(map[string]string)
+	respHeaderMp := make(map[string]string)
+	if err = json.Unmarshal(httpReq.Header, &reqHeaderMp); err != nil {
+		return fail(http.StatusInternalServerError, "on req parase header err: %v", err)
+	}
+	for k, v := range reqHeaderMp {
+		req.Header.Set(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "on req do real request err: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "on req read real resp.Body err: %v", err)
+	}
+
+	for k, v := range res.Header {
+		respHeaderMp[k] = v[0]
+	}
+	headerByte, err := json.Marshal(respHeaderMp)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "on req parase real resp header err: %v", err)
+	}
+	httpResp.Header = headerByte
+	httpResp.Body = body
+	httpResp.Status = int32(res.StatusCode)
+
+	return reqWriter(httpResp)
+}
+
+func (s *Service) onRelayHttpReqV2(ctx context.Context, p p2p.Peer, stream p2p.Stream) (err error) {
+	defer func() {
+		if err != nil {
+			s.logger.Tracef("onRelayHttpReqV2 from %s err %s", p.Address, err)
+			_ = stream.Reset()
+		} else {
+			_ = stream.Close()
+			s.logger.Tracef("onRelayHttpReqV2 from %s stream close", p.Address)
+		}
+	}()
+
+	buf := bufio.NewReader(stream)
+	req, err := http.ReadRequest(buf)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req = req.WithContext(ctx)
+	defer req.Body.Close()
+
+	url := strings.ReplaceAll(req.URL.String(), aurora.RelayPrefixHttp, "")
+	urls := strings.Split(url, "/")
+	if len(urls) < 3 {
+		return errors.New("domain parse err")
+	}
+
+	reqWS := isWebsocket(req.Header)
+	if reqWS {
+		req.URL.Scheme = "ws"
+	} else {
+		req.URL.Scheme = "http"
+	}
+	addr, err := s.target(req.URL.Scheme, urls[1], urls[2])
+	if err != nil {
+		s.logger.Errorf("onRelayHttpReqV2: domain %s of group %s: %v", urls[2], urls[1], err)
+		return err
+	}
+	req.URL, err = req.URL.Parse(addr + strings.ReplaceAll(url, "/"+urls[1]+"/"+urls[2], ""))
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("onRelayHttpReqV2 from %s request to %s", p.Address, req.URL)
+
+	req.Host = req.URL.Host
+	req.RequestURI = req.URL.RequestURI()
+
+	if !reqWS {
+		// the caller resets the stream when its client goes away, which
+		// is seen once the request body is read and cancels the request
+		watch := func() {
+			_, _ = io.Copy(io.Discard, buf)
+			cancel()
+		}
+		if req.Body == http.NoBody {
+			go watch()
+		} else {
+			body := &closeNotifyBody{ReadCloser: req.Body, closed: make(chan struct{})}
+			req.Body = body
+			go func() {
+				select {
+				case <-body.closed:
+					watch()
+				case <-ctx.Done():
+				}
+			}()
+		}
+
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			_, _ = stream.Write([]byte(err.Error()))
+			return err
+		}
+		// resp.Write writes whatever response we obtained for our
+		// request back to the stream, streaming bodies as they are read.
+		return resp.Write(stream)
+	}
+
+	var remoteConn net.Conn
+	switch req.URL.Scheme {
+	case "ws":
+		remoteConn, err = net.Dial("tcp", req.URL.Host)
+	case "wss":
+		remoteConn, err = tls.Dial("tcp", req.URL.Host, &tls.Config{
+			InsecureSkipVerify: true,
+		})
+	}
+	if err != nil {
+		_, _ = stream.Write([]byte(err.Error()))
+		return err
+	}
+	defer remoteConn.Close()
+	b, _ := httputil.DumpRequest(req, false)
+	_, err = remoteConn.Write(b)
+	if err != nil {
+		_, _ = stream.Write([]byte(err.Error()))
+		return err
+	}
+	// frames sent by the client right after the upgrade request may
+	// already be buffered
+	err = tunnel(bufferedConn{ReadWriteCloser: stream, r: buf}, remoteConn)
+	s.logger.Tracef("onRelayHttpReqV2 from %s tunnel closed: %v", p.Address, err)
+	return err
+}
+
+// closeNotifyBody closes closed when the request body is closed, which
+// the transport does once it is sent.
+type closeNotifyBody struct {
+	io.ReadCloser
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (b *closeNotifyBody) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,116 @@
+package netrelay
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// tunnelBufferSize is the size of the copy buffers. A copy blocks on a
+	// slow writer, so no more than this is held per direction.
+	tunnelBufferSize = 32 * 1024
+	// tunnelCloseTimeout is how long a tunnel waits for the second
+	// direction to end after the first one did.
+	tunnelCloseTimeout = 5 * time.Second
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// bufferedConn reads through r, which may hold bytes read from the
+// connection before it was handed over, like those buffered by a hijacked
+// http connection or by reading the request from a stream.
+type bufferedConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c bufferedConn) CloseWrite() error {
+	if cw, ok := c.ReadWriteCloser.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// tunnel copies bytes between a and b in both directions. When a direction
+// ends, the write side of its destination is closed so that the other end
+// sees it, and the other direction is given tunnelCloseTimeout to end
+// before both are closed.
+func tunnel(a, b io.ReadWriteCloser) error {
+	errc := make(chan error, 2)
+	cp := func(dst, src io.ReadWriteCloser) {
+		_, err := io.CopyBuffer(dst, src, make([]byte, tunnelBufferSize))
+		if cw, ok := dst.(closeWriter); ok {
+			_ = cw.CloseWrite()
+		}
+		errc <- err
+	}
+	go cp(a, b)
+	go cp(b, a)
+
+	err := <-errc
+	select {
+	case err2 := <-errc:
+		if err == nil {
+			err = err2
+		}
+	case <-time.After(tunnelCloseTimeout):
+		_ = a.Close()
+		_ = b.Close()
+		<-errc
+	}
+	return err
+}
+
+// copyResponse copies body to w. Responses without length and event streams
+// are flushed after every write, so they reach the client as they come.
+func copyResponse(w http.ResponseWriter, resp *http.Response) error {
+	var flusher http.Flusher
+	if resp.ContentLength == -1 || isEventStream(resp.Header) {
+		flusher, _ = w.(http.Flusher)
+	}
+	buf := make([]byte, tunnelBufferSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isWebsocket reports whether h requests a websocket upgrade.
+func isWebsocket(h http.Header) bool {
+	if !strings.EqualFold(h.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isEventStream(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+}
@@ -0,0 +1,105 @@
+// Package nochain stands in for the chain services of a node that runs
+// without a chain endpoint or without an oracle contract. Sources of chunks
+// are then only discovered through the chunkinfo protocol, and everything
+// that needs a transaction fails with ErrNoChain.
+package nochain
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/rpc"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+)
+
+// ErrNoChain is returned for everything that needs the chain.
+var ErrNoChain = errors.New("nochain: node runs without a chain")
+
+var (
+	_ chain.Resolver = (*Resolver)(nil)
+	_ chain.Common   = (*Common)(nil)
+)
+
+// Resolver is an oracle that knows no sources and can not register any.
+type Resolver struct{}
+
+// NewResolver returns the oracle of a node without a chain.
+func NewResolver() *Resolver {
+	return &Resolver{}
+}
+
+func (*Resolver) GetCid(string) []byte {
+	return nil
+}
+
+func (*Resolver) GetNodesFromCid([]byte) []boson.Address {
+	return nil
+}
+
+func (*Resolver) GetSourceNodes(string) []boson.Address {
+	return nil
+}
+
+func (*Resolver) OnStoreMatched(boson.Address, uint64, uint64, boson.Address) {}
+
+func (*Resolver) DataStoreFinished(boson.Address, uint64, uint64, []byte, chan chain.ChainResult) {}
+
+func (*Resolver) RegisterCidAndNode(context.Context, boson.Address, boson.Address) (common.Hash, error) {
+	return common.Hash{}, ErrNoChain
+}
+
+func (*Resolver) RemoveCidAndNode(context.Context, boson.Address, boson.Address) (common.Hash, error) {
+	return common.Hash{}, ErrNoChain
+}
+
+// GetRegisterState reports every root as not registered.
+func (*Resolver) GetRegisterState(context.Context, boson.Address, boson.Address) (bool, error) {
+	return false, nil
+}
+
+func (*Resolver) WaitForReceipt(context.Context, boson.Address, common.Hash) (*types.Receipt, error) {
+	return nil, ErrNoChain
+}
+
+// API keeps the oracle namespace of the rpc api, its subscriptions fail.
+func (*Resolver) API() rpc.API {
+	return rpc.API{
+		Namespace: "oracle",
+		Version:   "1.0",
+		Service:   &apiService{},
+		Public:    true,
+	}
+}
+
+type apiService struct{}
+
+func (*apiService) RegisterStatus(context.Context, []string) (*rpc.Subscription, error) {
+	return nil, ErrNoChain
+}
+
+// Common is the common chain service of a node without a chain endpoint.
+type Common struct{}
+
+// NewCommon returns the common chain service of a node without a chain.
+func NewCommon() *Common {
+	return &Common{}
+}
+
+func (*Common) All(context.Context, *chain.AllRequest) (*chain.AllResponse, error) {
+	return nil, ErrNoChain
+}
+
+func (*Common) SyncTransaction(chain.TransactionType, string, string) {}
+
+func (*Common) IsTransaction() bool {
+	return false
+}
+
+func (*Common) UpdateStatus(bool) {}
+
+func (*Common) GetTransaction() *chain.TxInfo {
+	return &chain.TxInfo{}
+}
@@ -0,0 +1,46 @@
+package nochain_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FavorLabs/favorX/pkg/nochain"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+)
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+	r := nochain.NewResolver()
+	root := boson.MustParseHexAddress("ca1e")
+	overlay := boson.MustParseHexAddress("be1e")
+
+	if nodes := r.GetNodesFromCid(root.Bytes()); len(nodes) != 0 {
+		t.Fatalf("got sources %v", nodes)
+	}
+	if _, err := r.RegisterCidAndNode(ctx, root, overlay); !errors.Is(err, nochain.ErrNoChain) {
+		t.Fatalf("got error %v, want %v", err, nochain.ErrNoChain)
+	}
+	if _, err := r.RemoveCidAndNode(ctx, root, overlay); !errors.Is(err, nochain.ErrNoChain) {
+		t.Fatalf("got error %v, want %v", err, nochain.ErrNoChain)
+	}
+	if registered, err := r.GetRegisterState(ctx, root, overlay); err != nil || registered {
+		t.Fatalf("got registered %v, error %v", registered, err)
+	}
+	if api := r.API(); api.Namespace != "oracle" {
+		t.Fatalf("got namespace %q", api.Namespace)
+	}
+}
+
+func TestCommon(t *testing.T) {
+	c := nochain.NewCommon()
+
+	if _, err := c.All(context.Background(), &chain.AllRequest{Method: "eth_chainId"}); !errors.Is(err, nochain.ErrNoChain) {
+		t.Fatalf("got error %v, want %v", err, nochain.ErrNoChain)
+	}
+	c.SyncTransaction(chain.TRAFFIC, "value", "hash")
+	if c.IsTransaction() {
+		t.Fatal("got pending transaction")
+	}
+}
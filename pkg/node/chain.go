@@ -2,8 +2,17 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/big"
 
+	"github.com/FavorLabs/favorX/pkg/chainoracle"
+	"github.com/FavorLabs/favorX/pkg/chaintraffic"
+	"github.com/FavorLabs/favorX/pkg/nochain"
+	"github.com/FavorLabs/favorX/pkg/traffichistory"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gauss-project/aurorafs/pkg/crypto"
 	"github.com/gauss-project/aurorafs/pkg/logging"
@@ -11,15 +20,27 @@ import (
 	"github.com/gauss-project/aurorafs/pkg/settlement"
 	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
 	chainCommon "github.com/gauss-project/aurorafs/pkg/settlement/chain/common"
-	"github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle"
+	chainOracle "github.com/gauss-project/aurorafs/pkg/settlement/chain/oracle"
+	chainTraffic "github.com/gauss-project/aurorafs/pkg/settlement/chain/traffic"
 	"github.com/gauss-project/aurorafs/pkg/settlement/pseudosettle"
 	"github.com/gauss-project/aurorafs/pkg/settlement/traffic"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/cheque"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/trafficprotocol"
 	"github.com/gauss-project/aurorafs/pkg/storage"
 	"github.com/gauss-project/aurorafs/pkg/subscribe"
 )
 
 // InitChain will initialize the Ethereum backend at the given endpoint and
 // set up the Transaction Service to interact with it using the provided signer.
+// With traffic enabled, traffic is settled with cheques cashed at the traffic
+// contract, otherwise it is settled without the chain.
+//
+// Only with noChain the endpoint or the oracle contract may be missing.
+// Without an endpoint the node then runs without a chain: sources are only
+// found through chunkinfo, nothing can be registered and traffic is settled
+// without the chain. Without an oracle contract only the oracle is left out.
+// Received cheques are recorded in history. The transactions of the node
+// are sent by the returned transaction manager, nil without a chain.
 func InitChain(
 	ctx context.Context,
 	logger logging.Logger,
@@ -27,43 +48,142 @@ func InitChain(
 	oracleContractAddress string,
 	stateStore storage.StateStorer,
 	signer crypto.Signer,
+	noChain bool,
 	trafficEnable bool,
 	trafficContractAddr string,
 	p2pService *libp2p.Service,
 	subPub subscribe.SubPub,
-) (chain.Resolver, settlement.Interface, traffic.ApiInterface, chain.Common, error) {
+	history *traffichistory.History,
+	txOptions txmgr.Options,
+) (chain.Resolver, settlement.Interface, traffic.ApiInterface, chain.Common, *txmgr.Manager, error) {
+	address, err := signer.EthereumAddress()
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("chain address: %w", err)
+	}
+	logger.Infof("address  %s", address.String())
+
+	if endpoint == "" {
+		if !noChain {
+			return nil, nil, nil, nil, nil, errors.New("no chain endpoint and running without a chain is not enabled")
+		}
+		if trafficEnable {
+			return nil, nil, nil, nil, nil, fmt.Errorf("traffic requires a chain endpoint")
+		}
+		logger.Warning("no chain endpoint, running without a chain")
+		service, err := initPseudosettle(p2pService, logger, stateStore, address)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		return nochain.NewResolver(), service, service, nochain.NewCommon(), nil, nil
+	}
+
 	backend, err := ethclient.Dial(endpoint)
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("dial eth client: %w", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("dial eth client: %w", err)
 	}
 
 	chainID, err := backend.ChainID(ctx)
 	if err != nil {
 		logger.Infof("could not connect to backend at %v. In a swap-enabled network a working blockchain node (for goerli network in production) is required. Check your node or specify another node using --traffic-endpoint.", endpoint)
-		return nil, nil, nil, nil, fmt.Errorf("get chain id: %w", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("get chain id: %w", err)
 	}
 	cc, err := chainCommon.New(logger, signer, chainID, endpoint)
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("new common serveice: %v", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("new common serveice: %v", err)
 	}
+	transactions, err := txmgr.New(logger, backend, signer, chainID, stateStore, txOptions)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("new transaction manager: %w", err)
+	}
+	var oracleServer chain.Resolver
 	if oracleContractAddress == "" {
-		return nil, nil, nil, nil, fmt.Errorf("oracle contract address is empty")
+		if !noChain {
+			return nil, nil, nil, nil, nil, errors.New("no oracle contract address and running without a chain is not enabled")
+		}
+		logger.Warning("no oracle contract address, sources are only found through chunkinfo")
+		oracleServer = nochain.NewResolver()
+	} else {
+		if !common.IsHexAddress(oracleContractAddress) {
+			return nil, nil, nil, nil, nil, fmt.Errorf("invalid oracle contract address %q", oracleContractAddress)
+		}
+		contractService, err := chainOracle.NewServer(logger, backend, oracleContractAddress, signer, cc, subPub)
+		if err != nil {
+			return nil, nil, nil, nil, nil, fmt.Errorf("new oracle service: %w", err)
+		}
+		oracleServer, err = chainoracle.New(logger, contractService, transactions, common.HexToAddress(oracleContractAddress))
+		if err != nil {
+			return nil, nil, nil, nil, nil, fmt.Errorf("new oracle service: %w", err)
+		}
 	}
-	oracleServer, err := oracle.NewServer(logger, backend, oracleContractAddress, signer, cc, subPub)
-	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("new oracle service: %w", err)
+
+	if !trafficEnable {
+		service, err := initPseudosettle(p2pService, logger, stateStore, address)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		return oracleServer, service, service, cc, transactions, nil
 	}
 
-	address, err := signer.EthereumAddress()
-	logger.Infof("address  %s", address.String())
+	if !common.IsHexAddress(trafficContractAddr) {
+		return nil, nil, nil, nil, nil, fmt.Errorf("invalid traffic contract address %q", trafficContractAddr)
+	}
+	contractService, err := chainTraffic.NewServer(logger, chainID, backend, signer, transactions, trafficContractAddr, cc)
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("chain address: %w", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("new traffic chain service: %w", err)
+	}
+	trafficChainService, err := chaintraffic.New(logger, contractService, transactions, common.HexToAddress(trafficContractAddr))
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("new traffic chain service: %w", err)
+	}
+	service, err := InitTraffic(stateStore, address, trafficChainService, transactions, logger, p2pService, signer, chainID.Int64(), common.HexToAddress(trafficContractAddr), subPub, history)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
 	}
-
-	service := pseudosettle.New(p2pService, logger, stateStore, address)
 	if err = service.Init(); err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("InitTraffic:: %w", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("InitTraffic:: %w", err)
+	}
+
+	return oracleServer, service, service, cc, transactions, nil
+}
+
+func initPseudosettle(p2pService *libp2p.Service, logger logging.Logger, store storage.StateStorer, address common.Address) (*pseudosettle.Service, error) {
+	service := pseudosettle.New(p2pService, logger, store, address)
+	if err := service.Init(); err != nil {
+		return nil, fmt.Errorf("InitTraffic:: %w", err)
 	}
+	return service, nil
+}
 
-	return oracleServer, service, service, cc, nil
+// InitTraffic sets up the cheque based traffic settlement and registers its
+// protocol with the p2p service. The cheques the protocol receives are
+// recorded in history.
+func InitTraffic(store storage.StateStorer, address common.Address, trafficChainService chain.Traffic,
+	transactionService chain.Transaction, logger logging.Logger, p2pService *libp2p.Service, signer crypto.Signer,
+	chainID int64, trafficContractAddr common.Address, subPub subscribe.SubPub, history *traffichistory.History) (*traffic.Service, error) {
+	chequeStore := cheque.NewChequeStore(store, address, cheque.RecoverCheque, chainID)
+	cashOut := cheque.NewCashoutService(store, transactionService, trafficChainService, chequeStore, trafficContractAddr)
+	addressBook := traffic.NewAddressBook(store)
+	protocol := trafficprotocol.New(p2pService, logger, address)
+	if err := p2pService.AddProtocol(protocol.Protocol()); err != nil {
+		return nil, fmt.Errorf("traffic protocol: %w", err)
+	}
+	chequeSigner := cheque.NewChequeSigner(signer, chainID)
+	service := traffic.New(logger, address, store, trafficChainService, chequeStore, cashOut, p2pService, addressBook, chequeSigner, protocol, chainID, subPub)
+	protocol.SetTraffic(history.Traffic(service))
+	return service, nil
+}
+
+// gasPrice returns a function that asks the chain for its current gas price.
+func gasPrice(commonChain chain.Common) func(ctx context.Context) (*big.Int, error) {
+	return func(ctx context.Context) (*big.Int, error) {
+		resp, err := commonChain.All(ctx, &chain.AllRequest{Method: "eth_gasPrice"})
+		if err != nil {
+			return nil, err
+		}
+		v, ok := resp.Result.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected gas price %v", resp.Result)
+		}
+		return hexutil.DecodeBig(v)
+	}
 }
@@ -12,8 +12,16 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/FavorLabs/favorX/pkg/accounting"
 	"github.com/FavorLabs/favorX/pkg/api"
-	"github.com/gauss-project/aurorafs/pkg/accounting"
+	"github.com/FavorLabs/favorX/pkg/autocash"
+	"github.com/FavorLabs/favorX/pkg/groupconf"
+	"github.com/FavorLabs/favorX/pkg/groupfeed"
+	"github.com/FavorLabs/favorX/pkg/netrelay"
+	"github.com/FavorLabs/favorX/pkg/pingrtt"
+	"github.com/FavorLabs/favorX/pkg/retention"
+	"github.com/FavorLabs/favorX/pkg/traffichistory"
+	"github.com/FavorLabs/favorX/pkg/txmgr"
 	"github.com/gauss-project/aurorafs/pkg/addressbook"
 	"github.com/gauss-project/aurorafs/pkg/aurora"
 	"github.com/gauss-project/aurorafs/pkg/auth"
@@ -28,7 +36,6 @@ import (
 	"github.com/gauss-project/aurorafs/pkg/metrics"
 	"github.com/gauss-project/aurorafs/pkg/multicast"
 	"github.com/gauss-project/aurorafs/pkg/multicast/model"
-	"github.com/gauss-project/aurorafs/pkg/netrelay"
 	"github.com/gauss-project/aurorafs/pkg/netstore"
 	"github.com/gauss-project/aurorafs/pkg/node"
 	"github.com/gauss-project/aurorafs/pkg/p2p/libp2p"
@@ -61,10 +68,15 @@ type Favor struct {
 	errorLogWriter   *io.PipeWriter
 	tracerCloser     io.Closer
 	groupCloser      io.Closer
+	groupFeedCloser  io.Closer
+	relayCloser      io.Closer
 	stateStoreCloser io.Closer
 	localstoreCloser io.Closer
 	topologyCloser   io.Closer
 	ethClientCloser  func()
+	historyCloser    io.Closer
+	autoCashCloser   io.Closer
+	txCloser         io.Closer
 }
 
 type Options struct {
@@ -95,9 +107,14 @@ type Options struct {
 	GatewayMode            bool
 	TrafficEnable          bool
 	TrafficContractAddr    string
+	NoChain                bool
+	PaymentThreshold       uint64
+	PaymentTolerance       uint64
+	PeerLimits             []string
 	KadBinMaxPeers         int
 	LightNodeMaxPeers      int
 	AllowPrivateCIDRs      bool
+	ForwardAnyAddress      bool
 	Restricted             bool
 	TokenEncryptionKey     string
 	AdminPasswordHash      string
@@ -106,6 +123,24 @@ type Options struct {
 	EnableApiTLS           bool
 	TlsCrtFile             string
 	TlsKeyFile             string
+	RetentionMaxAge        time.Duration
+	RetentionMaxSize       uint64
+	RetentionKeepPinned    bool
+	RetentionKeepRegister  bool
+	RetentionInterval      time.Duration
+	AutoCashThreshold      uint64
+	AutoCashMaxGasPrice    uint64
+	AutoCashMaxTxs         int
+	AutoCashInterval       time.Duration
+	AutoCashDryRun         bool
+	TxGasPriceStrategy     string
+	TxGasPrice             uint64
+	TxGasPricePercentile   int
+	TxGasPriceBlocks       int
+	TxMaxGasPrice          uint64
+	TxReplaceAfter         time.Duration
+	TxGasBumpPercent       int
+	RegisterInterval       time.Duration
 }
 
 func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, publicKey ecdsa.PublicKey, signer crypto.Signer, networkID uint64, logger logging.Logger, libp2pPrivateKey *ecdsa.PrivateKey, o Options) (b *Favor, err error) {
@@ -226,20 +261,37 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 		return nil, fmt.Errorf("p2p service: %w", err)
 	}
 
-	oracleChain, settlement, apiInterface, commonChain, err := InitChain(
+	trafficHistory := traffichistory.New(stateStore, logger)
+	b.historyCloser = trafficHistory
+
+	oracleChain, settlement, apiInterface, commonChain, transactions, err := InitChain(
 		p2pCtx,
 		logger,
 		o.ChainEndpoint,
 		o.OracleContractAddress,
 		stateStore,
 		signer,
+		o.NoChain,
 		o.TrafficEnable,
 		o.TrafficContractAddr,
 		p2ps,
-		subPub)
+		subPub,
+		trafficHistory,
+		txmgr.Options{
+			Strategy:     txmgr.Strategy(o.TxGasPriceStrategy),
+			GasPrice:     new(big.Int).SetUint64(o.TxGasPrice),
+			Percentile:   o.TxGasPricePercentile,
+			Blocks:       o.TxGasPriceBlocks,
+			MaxGasPrice:  new(big.Int).SetUint64(o.TxMaxGasPrice),
+			ReplaceAfter: o.TxReplaceAfter,
+			BumpPercent:  o.TxGasBumpPercent,
+		})
 	if err != nil {
 		return nil, err
 	}
+	if transactions != nil {
+		b.txCloser = transactions
+	}
 	b.p2pService = p2ps
 
 	if !o.Standalone {
@@ -281,17 +333,23 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 		}
 	}
 
-	paymentThreshold := new(big.Int).SetUint64(256 * 4 * 4)
-	paymentTolerance := new(big.Int).Mul(paymentThreshold, new(big.Int).SetUint64(2*32))
-
-	acc := accounting.NewAccounting(
-		paymentTolerance,
-		paymentThreshold,
-		logger,
-		stateStore,
-		settlement,
-	)
-	settlement.SetNotifyPaymentFunc(acc.AsyncNotifyPayment)
+	if o.PaymentThreshold == 0 {
+		o.PaymentThreshold = accounting.DefaultPaymentThreshold
+	}
+	if o.PaymentTolerance == 0 {
+		o.PaymentTolerance = accounting.DefaultPaymentTolerance
+	}
+	peerLimits, err := accounting.ParsePeerLimits(o.PeerLimits)
+	if err != nil {
+		return nil, fmt.Errorf("accounting: %w", err)
+	}
+	recordedSettlement := trafficHistory.Settlement(settlement)
+	acc := accounting.NewAccounting(accounting.Options{
+		PaymentThreshold: new(big.Int).SetUint64(o.PaymentThreshold),
+		PaymentTolerance: new(big.Int).SetUint64(o.PaymentTolerance),
+		PeerLimits:       peerLimits,
+	}, logger, stateStore, recordedSettlement)
+	recordedSettlement.SetNotifyPaymentFunc(acc.AsyncNotifyPayment)
 
 	metricsDB, err := shed.NewDBWrap(stateStore.DB())
 	if err != nil {
@@ -303,7 +361,10 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 		return nil, fmt.Errorf("hive service: %w", err)
 	}
 
-	kad, err := kademlia.New(bosonAddress, addressBook, hiveObj, p2ps, pingPong, lightNodes, bootNodes, metricsDB, logger, subPub, kademlia.Options{
+	// the recorder keeps the last round trip times of the pings of the
+	// topology for the api
+	pingRTT := pingrtt.New(pingPong)
+	kad, err := kademlia.New(bosonAddress, addressBook, hiveObj, p2ps, pingRTT, lightNodes, bootNodes, metricsDB, logger, subPub, kademlia.Options{
 		Bootnodes:   bootnodes,
 		NodeMode:    nodeMode,
 		BinMaxPeers: o.KadBinMaxPeers,
@@ -385,6 +446,11 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 	if err != nil {
 		return nil, err
 	}
+	groupFeed, err := groupfeed.New(logger, group.API(), subPub)
+	if err != nil {
+		return nil, fmt.Errorf("group feed: %w", err)
+	}
+	b.groupFeedCloser = groupFeed
 	var configGroups []model.ConfigNodeGroup
 	if o.Groups != nil {
 		err = gconv.Struct(o.Groups, &configGroups)
@@ -397,17 +463,47 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 			return nil, err
 		}
 	}
+	// groups joined, observed or changed through the api override the config
+	savedGroups, err := groupconf.NewStore(stateStore).List()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range savedGroups {
+		if err := group.AddGroup([]model.ConfigNodeGroup{g}); err != nil {
+			logger.Errorf("group %s: restore: %v", g.Name, err)
+		}
+	}
 
-	relay := netrelay.New(p2ps, logger, configGroups, route, group)
+	relay := netrelay.New(p2ps, logger, stateStore, configGroups, route, group)
+	relay.SetForwardAnyAddress(o.ForwardAnyAddress)
 	err = p2ps.AddProtocol(relay.Protocol())
 	if err != nil {
 		return nil, err
 	}
+	b.relayCloser = relay
+
+	// only the cheques of the chain traffic settlement are cashed out,
+	// pseudosettle has nothing to cash
+	var autoCash *autocash.Cashier
+	if o.TrafficEnable {
+		policy := autocash.Policy{
+			Threshold:       new(big.Int).SetUint64(o.AutoCashThreshold),
+			MaxGasPrice:     new(big.Int).SetUint64(o.AutoCashMaxGasPrice),
+			MaxTransactions: o.AutoCashMaxTxs,
+			Interval:        autocash.Duration(o.AutoCashInterval),
+			DryRun:          o.AutoCashDryRun,
+		}
+		if err = policy.Validate(); err != nil {
+			return nil, fmt.Errorf("autocash: %w", err)
+		}
+		autoCash = autocash.NewCashier(autocash.NewStore(stateStore), apiInterface, gasPrice(commonChain), policy, logger)
+		b.autoCashCloser = autoCash
+	}
 
 	var apiService api.Service
 	if o.APIAddr != "" {
 		// API server
-		apiService = api.New(ns, multiResolver, bosonAddress, chunkInfo, traversalService, pinningService,
+		apiService = api.New(ns, stateStore, multiResolver, bosonAddress, signer, chunkInfo, traversalService, pinningService,
 			authenticator, logger, tracer, apiInterface, commonChain, oracleChain, relay, group, kad, route,
 			api.Options{
 				CORSAllowedOrigins: o.CORSAllowedOrigins,
@@ -417,6 +513,22 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 				Restricted:         o.Restricted,
 				DebugApiAddr:       o.DebugAPIAddr,
 				RPCWSAddr:          o.WSAddr,
+				Retention: retention.Policy{
+					MaxAge:         o.RetentionMaxAge,
+					MaxTotalSize:   o.RetentionMaxSize,
+					KeepPinned:     o.RetentionKeepPinned,
+					KeepRegistered: o.RetentionKeepRegister,
+				},
+				RetentionInterval: o.RetentionInterval,
+				NetworkID:         networkID,
+				TrafficHistory:    trafficHistory,
+				Accounting:        acc,
+				Transactions:      transactions,
+				RegisterInterval:  o.RegisterInterval,
+				LocalStore:        storer,
+				GroupFeed:         groupFeed,
+				PingRTT:           pingRTT,
+				AutoCash:          autoCash,
 			})
 		apiListener, err := net.Listen("tcp", o.APIAddr)
 		if err != nil {
@@ -454,7 +566,7 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 		// register metrics from components
 		debugAPIService.MustRegisterMetrics(p2ps.Metrics()...)
 		debugAPIService.MustRegisterMetrics(pingPong.Metrics()...)
-		// debugAPIService.MustRegisterMetrics(acc.Metrics()...)
+		debugAPIService.MustRegisterMetrics(acc.Metrics()...)
 		debugAPIService.MustRegisterMetrics(storer.Metrics()...)
 		debugAPIService.MustRegisterMetrics(kad.Metrics()...)
 		debugAPIService.MustRegisterMetrics(lightNodes.Metrics()...)
@@ -509,6 +621,7 @@ func NewNode(nodeMode aurora.Model, addr string, bosonAddress boson.Address, pub
 	}
 	stack.RegisterAPIs([]rpc.API{
 		group.API(),        // group
+		groupFeed.API(),    // group message and multicast subscriptions
 		kad.API(),          // p2p
 		chunkInfo.API(),    // chunkInfo
 		apiInterface.API(), // traffic
@@ -562,6 +675,30 @@ func (b *Favor) Shutdown(ctx context.Context) error {
 		errs.add(fmt.Errorf("p2p server: %w", err))
 	}
 
+	if b.relayCloser != nil {
+		if err := b.relayCloser.Close(); err != nil {
+			errs.add(fmt.Errorf("netrelay: %w", err))
+		}
+	}
+
+	if b.groupFeedCloser != nil {
+		if err := b.groupFeedCloser.Close(); err != nil {
+			errs.add(fmt.Errorf("group feed: %w", err))
+		}
+	}
+
+	if b.autoCashCloser != nil {
+		if err := b.autoCashCloser.Close(); err != nil {
+			errs.add(fmt.Errorf("autocash: %w", err))
+		}
+	}
+
+	if b.txCloser != nil {
+		if err := b.txCloser.Close(); err != nil {
+			errs.add(fmt.Errorf("transaction manager: %w", err))
+		}
+	}
+
 	if c := b.ethClientCloser; c != nil {
 		c()
 	}
@@ -570,6 +707,12 @@ func (b *Favor) Shutdown(ctx context.Context) error {
 		errs.add(fmt.Errorf("tracer: %w", err))
 	}
 
+	if b.historyCloser != nil {
+		if err := b.historyCloser.Close(); err != nil {
+			errs.add(fmt.Errorf("traffic history: %w", err))
+		}
+	}
+
 	if err := b.stateStoreCloser.Close(); err != nil {
 		errs.add(fmt.Errorf("statestore: %w", err))
 	}
@@ -0,0 +1,7 @@
+package pingrtt
+
+import "time"
+
+func (r *Recorder) SetNow(now func() time.Time) {
+	r.now = now
+}
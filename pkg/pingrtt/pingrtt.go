@@ -0,0 +1,72 @@
+// Package pingrtt remembers the round trip time of the last successful ping
+// of every peer. The topology only keeps a moving average of them.
+package pingrtt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/pingpong"
+)
+
+// StaleAfter is the time after which the round trip time of a peer that was
+// not pinged again is forgotten. The topology pings connected peers far
+// more often, so only those of gone peers go stale.
+const StaleAfter = 5 * time.Minute
+
+type sample struct {
+	rtt time.Duration
+	at  time.Time
+}
+
+// Recorder wraps a pinger and records the round trip times of its pings.
+// The time of a peer is forgotten when a ping of it fails or it goes stale.
+type Recorder struct {
+	pinger pingpong.Interface
+	now    func() time.Time
+
+	mu        sync.Mutex
+	samples   map[string]sample
+	lastSweep time.Time
+}
+
+// New returns a recorder pinging through pinger.
+func New(pinger pingpong.Interface) *Recorder {
+	return &Recorder{pinger: pinger, now: time.Now, samples: make(map[string]sample)}
+}
+
+// Ping pings address and records the round trip time.
+func (r *Recorder) Ping(ctx context.Context, address boson.Address, msgs ...string) (time.Duration, error) {
+	rtt, err := r.pinger.Ping(ctx, address, msgs...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.lastSweep) > StaleAfter {
+		for k, s := range r.samples {
+			if now.Sub(s.at) > StaleAfter {
+				delete(r.samples, k)
+			}
+		}
+		r.lastSweep = now
+	}
+	if err != nil {
+		delete(r.samples, address.String())
+		return rtt, err
+	}
+	r.samples[address.String()] = sample{rtt: rtt, at: now}
+	return rtt, nil
+}
+
+// Last returns the round trip time of the last successful ping of address.
+func (r *Recorder) Last(address boson.Address) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.samples[address.String()]
+	if !ok || r.now().Sub(s.at) > StaleAfter {
+		return 0, false
+	}
+	return s.rtt, true
+}
@@ -0,0 +1,63 @@
+package pingrtt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/pingrtt"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+)
+
+// pinger answers pings with the round trip times of rtt, peers without one
+// fail.
+type pinger map[string]time.Duration
+
+func (p pinger) Ping(_ context.Context, address boson.Address, _ ...string) (time.Duration, error) {
+	rtt, ok := p[address.String()]
+	if !ok {
+		return 0, errors.New("unreachable")
+	}
+	return rtt, nil
+}
+
+func TestRecorder(t *testing.T) {
+	a := boson.MustParseHexAddress("aa")
+	b := boson.MustParseHexAddress("bb")
+	p := pinger{a.String(): 30 * time.Millisecond, b.String(): 10 * time.Millisecond}
+	r := pingrtt.New(p)
+	now := time.Unix(1000, 0)
+	r.SetNow(func() time.Time { return now })
+	ctx := context.Background()
+
+	if _, ok := r.Last(a); ok {
+		t.Fatal("time of a peer never pinged")
+	}
+	for _, addr := range []boson.Address{a, b} {
+		if _, err := r.Ping(ctx, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p[a.String()] = 20 * time.Millisecond
+	if _, err := r.Ping(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if rtt, ok := r.Last(a); !ok || rtt != 20*time.Millisecond {
+		t.Fatalf("got %v %v, want the last time", rtt, ok)
+	}
+
+	// a failed ping forgets the time
+	delete(p, b.String())
+	if _, err := r.Ping(ctx, b); err == nil {
+		t.Fatal("ping of an unreachable peer")
+	}
+	if _, ok := r.Last(b); ok {
+		t.Fatal("time kept after a failed ping")
+	}
+
+	now = now.Add(pingrtt.StaleAfter + time.Second)
+	if _, ok := r.Last(a); ok {
+		t.Fatal("stale time returned")
+	}
+}
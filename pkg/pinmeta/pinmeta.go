@@ -0,0 +1,88 @@
+// Package pinmeta keeps the metadata of pinned roots next to the pins
+// tracked by the pinning service.
+package pinmeta
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const storePrefix = "pin-meta"
+
+// Meta describes a pinned root.
+type Meta struct {
+	Reference boson.Address `json:"reference"`
+	Name      string        `json:"name"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Size      uint64        `json:"size"`
+}
+
+// Store persists Meta records in a state store.
+type Store struct {
+	store storage.StateStorer
+}
+
+// NewStore returns a Store keeping the metadata of pins in store.
+func NewStore(store storage.StateStorer) *Store {
+	return &Store{store: store}
+}
+
+func metaKey(ref boson.Address) string {
+	return fmt.Sprintf("%s-%s", storePrefix, ref)
+}
+
+// Put saves m, overwriting a previous record of the same reference.
+func (s *Store) Put(m Meta) error {
+	return s.store.Put(metaKey(m.Reference), m)
+}
+
+// Get returns the record of ref. Pins created without metadata are
+// returned with only their reference set.
+func (s *Store) Get(ref boson.Address) (Meta, error) {
+	var m Meta
+	err := s.store.Get(metaKey(ref), &m)
+	if errors.Is(err, storage.ErrNotFound) {
+		return Meta{Reference: ref}, nil
+	}
+	if err != nil {
+		return Meta{}, err
+	}
+	return m, nil
+}
+
+// Delete removes the record of ref.
+func (s *Store) Delete(ref boson.Address) error {
+	err := s.store.Delete(metaKey(ref))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Page sorts metas newest first and returns the page starting at offset.
+// A limit of zero or less returns everything after offset.
+func Page(metas []Meta, offset, limit int) []Meta {
+	sort.SliceStable(metas, func(i, j int) bool {
+		if !metas[i].CreatedAt.Equal(metas[j].CreatedAt) {
+			return metas[i].CreatedAt.After(metas[j].CreatedAt)
+		}
+		return metas[i].Reference.String() < metas[j].Reference.String()
+	})
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(metas) {
+		return []Meta{}
+	}
+	metas = metas[offset:]
+	if limit > 0 && limit < len(metas) {
+		metas = metas[:limit]
+	}
+	return metas
+}
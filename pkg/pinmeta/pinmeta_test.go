@@ -0,0 +1,78 @@
+package pinmeta_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/pinmeta"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+func TestStore(t *testing.T) {
+	s := pinmeta.NewStore(mock.NewStateStore())
+	ref := boson.NewAddress([]byte{31: 1})
+
+	got, err := s.Get(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Reference.Equal(ref) || got.Name != "" {
+		t.Fatalf("got %+v, want bare reference", got)
+	}
+
+	want := pinmeta.Meta{Reference: ref, Name: "docs", CreatedAt: time.Unix(1000, 0).UTC(), Size: 4096}
+	if err := s.Put(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Get(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Reference.Equal(want.Reference) || got.Name != want.Name || !got.CreatedAt.Equal(want.CreatedAt) || got.Size != want.Size {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	if err := s.Delete(ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ref); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPage(t *testing.T) {
+	var metas []pinmeta.Meta
+	for i := 1; i <= 5; i++ {
+		metas = append(metas, pinmeta.Meta{
+			Reference: boson.NewAddress([]byte{31: byte(i)}),
+			CreatedAt: time.Unix(int64(i), 0),
+		})
+	}
+
+	tt := []struct {
+		desc          string
+		offset, limit int
+		expected      []byte
+	}{
+		{desc: "all", expected: []byte{5, 4, 3, 2, 1}},
+		{desc: "first page", limit: 2, expected: []byte{5, 4}},
+		{desc: "second page", offset: 2, limit: 2, expected: []byte{3, 2}},
+		{desc: "last page", offset: 4, limit: 2, expected: []byte{1}},
+		{desc: "past the end", offset: 5, limit: 2},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := pinmeta.Page(metas, tc.offset, tc.limit)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("got %d pins, want %d", len(got), len(tc.expected))
+			}
+			for i, m := range got {
+				if want := boson.NewAddress([]byte{31: tc.expected[i]}); !m.Reference.Equal(want) {
+					t.Fatalf("pin %d: got %s, want %s", i, m.Reference, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Package pinsvc keeps the pin requests of the remote pinning service API,
+// which follows the IPFS Pinning Service API specification with boson
+// references in place of CIDs.
+package pinsvc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusPinning = "pinning"
+	StatusPinned  = "pinned"
+	StatusFailed  = "failed"
+)
+
+const (
+	MatchExact    = "exact"
+	MatchIExact   = "iexact"
+	MatchPartial  = "partial"
+	MatchIPartial = "ipartial"
+)
+
+const (
+	DefaultLimit = 10
+	MaxLimit     = 1000
+)
+
+const (
+	storePrefix = "pinsvc-request-"
+	ownedPrefix = "pinsvc-owned-"
+)
+
+// Pin is the object a client asks to be pinned.
+type Pin struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// Status is the state of a pin request.
+type Status struct {
+	RequestID string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       Pin               `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+// ValidStatus reports whether s is one of the pin statuses.
+func ValidStatus(s string) bool {
+	switch s {
+	case StatusQueued, StatusPinning, StatusPinned, StatusFailed:
+		return true
+	}
+	return false
+}
+
+// NewRequestID returns a random request id.
+func NewRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Filter selects pin requests when listing.
+type Filter struct {
+	Cids     []string
+	Name     string
+	Match    string
+	Statuses []string
+	Before   time.Time
+	After    time.Time
+	Limit    int
+	// Oldest selects the oldest matching requests instead of the newest.
+	Oldest bool
+}
+
+// Matches reports whether st is selected by the filter.
+func (f Filter) Matches(st Status) bool {
+	if len(f.Cids) > 0 && !contains(f.Cids, st.Pin.Cid) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !contains(f.Statuses, st.Status) {
+		return false
+	}
+	if !f.Before.IsZero() && !st.Created.Before(f.Before) {
+		return false
+	}
+	if !f.After.IsZero() && !st.Created.After(f.After) {
+		return false
+	}
+	if f.Name == "" {
+		return true
+	}
+	switch f.Match {
+	case MatchIExact:
+		return strings.EqualFold(st.Pin.Name, f.Name)
+	case MatchPartial:
+		return strings.Contains(st.Pin.Name, f.Name)
+	case MatchIPartial:
+		return strings.Contains(strings.ToLower(st.Pin.Name), strings.ToLower(f.Name))
+	default:
+		return st.Pin.Name == f.Name
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists pin requests in a state store.
+type Store struct {
+	store storage.StateStorer
+}
+
+// NewStore returns a Store keeping the pin requests in store, where the
+// ones queued before the node restarted are found again.
+func NewStore(store storage.StateStorer) *Store {
+	return &Store{store: store}
+}
+
+// Put saves st, overwriting a previous record with the same request id.
+func (s *Store) Put(st Status) error {
+	return s.store.Put(storePrefix+st.RequestID, st)
+}
+
+// Get returns the request with the given id.
+func (s *Store) Get(id string) (Status, error) {
+	var st Status
+	err := s.store.Get(storePrefix+id, &st)
+	return st, err
+}
+
+// Delete removes the request with the given id.
+func (s *Store) Delete(id string) error {
+	return s.store.Delete(storePrefix + id)
+}
+
+// Own records that the pin of cid was created by the pinning service, so
+// the service may remove it once no request refers to it anymore.
+func (s *Store) Own(cid string) error {
+	return s.store.Put(ownedPrefix+cid, true)
+}
+
+// Owns reports whether the pin of cid was created by the pinning service.
+func (s *Store) Owns(cid string) (bool, error) {
+	var owned bool
+	err := s.store.Get(ownedPrefix+cid, &owned)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	return owned, err
+}
+
+// Disown forgets that the pin of cid was created by the pinning service,
+// for instance because the user pinned it too.
+func (s *Store) Disown(cid string) error {
+	err := s.store.Delete(ownedPrefix + cid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// List returns the number of requests matching the filter and the newest
+// of them up to the limit of the filter.
+func (s *Store) List(f Filter) (int, []Status, error) {
+	var all []Status
+	err := s.store.Iterate(storePrefix, func(_, value []byte) (bool, error) {
+		var st Status
+		if err := json.Unmarshal(value, &st); err != nil {
+			return true, fmt.Errorf("invalid pin request: %w", err)
+		}
+		if f.Matches(st) {
+			all = append(all, st)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if f.Oldest {
+			return all[i].Created.Before(all[j].Created)
+		}
+		return all[i].Created.After(all[j].Created)
+	})
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	count := len(all)
+	if limit < len(all) {
+		all = all[:limit]
+	}
+	if all == nil {
+		all = make([]Status, 0)
+	}
+	return count, all, nil
+}
@@ -0,0 +1,86 @@
+package pinsvc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/pinsvc"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+func TestStoreList(t *testing.T) {
+	s := pinsvc.NewStore(mock.NewStateStore())
+	base := time.Unix(1000, 0)
+
+	requests := []pinsvc.Status{
+		{RequestID: "a", Status: pinsvc.StatusPinned, Created: base.Add(1 * time.Minute), Pin: pinsvc.Pin{Cid: "c1", Name: "Photos"}},
+		{RequestID: "b", Status: pinsvc.StatusQueued, Created: base.Add(2 * time.Minute), Pin: pinsvc.Pin{Cid: "c2", Name: "photos-2021"}},
+		{RequestID: "c", Status: pinsvc.StatusFailed, Created: base.Add(3 * time.Minute), Pin: pinsvc.Pin{Cid: "c1", Name: "docs"}},
+	}
+	for _, r := range requests {
+		if err := s.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		desc     string
+		filter   pinsvc.Filter
+		count    int
+		expected []string
+	}{
+		{desc: "all newest first", count: 3, expected: []string{"c", "b", "a"}},
+		{desc: "oldest first", filter: pinsvc.Filter{Limit: 2, Oldest: true}, count: 3, expected: []string{"a", "b"}},
+		{desc: "limit", filter: pinsvc.Filter{Limit: 1}, count: 3, expected: []string{"c"}},
+		{desc: "cid", filter: pinsvc.Filter{Cids: []string{"c1"}}, count: 2, expected: []string{"c", "a"}},
+		{desc: "status", filter: pinsvc.Filter{Statuses: []string{pinsvc.StatusQueued, pinsvc.StatusPinned}}, count: 2, expected: []string{"b", "a"}},
+		{desc: "exact name", filter: pinsvc.Filter{Name: "photos-2021"}, count: 1, expected: []string{"b"}},
+		{desc: "ipartial name", filter: pinsvc.Filter{Name: "PHOTO", Match: pinsvc.MatchIPartial}, count: 2, expected: []string{"b", "a"}},
+		{desc: "before and after", filter: pinsvc.Filter{After: base.Add(time.Minute), Before: base.Add(3 * time.Minute)}, count: 1, expected: []string{"b"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.desc, func(t *testing.T) {
+			count, got, err := s.List(tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tc.count {
+				t.Fatalf("got count %d, want %d", count, tc.count)
+			}
+			if len(got) != len(tc.expected) {
+				t.Fatalf("got %d results, want %d", len(got), len(tc.expected))
+			}
+			for i, st := range got {
+				if st.RequestID != tc.expected[i] {
+					t.Fatalf("result %d: got %s, want %s", i, st.RequestID, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStoreOwned(t *testing.T) {
+	s := pinsvc.NewStore(mock.NewStateStore())
+
+	if owned, err := s.Owns("c1"); err != nil || owned {
+		t.Fatalf("got %v, %v, want not owned", owned, err)
+	}
+	if err := s.Own("c1"); err != nil {
+		t.Fatal(err)
+	}
+	if owned, err := s.Owns("c1"); err != nil || !owned {
+		t.Fatalf("got %v, %v, want owned", owned, err)
+	}
+	if _, got, err := s.List(pinsvc.Filter{}); err != nil || len(got) != 0 {
+		t.Fatalf("got %v, %v, want no requests", got, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Disown("c1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if owned, err := s.Owns("c1"); err != nil || owned {
+		t.Fatalf("got %v, %v, want not owned", owned, err)
+	}
+}
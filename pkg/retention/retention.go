@@ -0,0 +1,137 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	ReasonMaxAge       = "max-age"
+	ReasonMaxTotalSize = "max-total-size"
+)
+
+const storePrefix = "retention-file"
+
+// Policy describes which of the locally held files are kept. Pinned files
+// are only selected without KeepPinned, and are unpinned when removed.
+type Policy struct {
+	MaxAge         time.Duration `json:"maxAge"`
+	MaxTotalSize   uint64        `json:"maxTotalSize"`
+	KeepPinned     bool          `json:"keepPinned"`
+	KeepRegistered bool          `json:"keepRegistered"`
+}
+
+// Enabled reports whether the policy removes anything at all.
+func (p Policy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxTotalSize > 0
+}
+
+// File is the retention view of a locally held root.
+type File struct {
+	RootCid    boson.Address `json:"rootCid"`
+	Size       uint64        `json:"size"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	Pinned     bool          `json:"pinned"`
+	Registered bool          `json:"registered"`
+}
+
+// Candidate is a file selected for removal together with the rule that selected it.
+type Candidate struct {
+	File
+	Reason string `json:"reason"`
+}
+
+func (p Policy) protected(f File) bool {
+	return (p.KeepPinned && f.Pinned) || (p.KeepRegistered && f.Registered)
+}
+
+// Select returns the files that have to be removed to satisfy the policy,
+// oldest first. Files protected by the policy are never selected, even if
+// the total size can not be brought under the limit without them.
+func (p Policy) Select(files []File, now time.Time) []Candidate {
+	if !p.Enabled() {
+		return nil
+	}
+
+	sorted := make([]File, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	var total uint64
+	for _, f := range sorted {
+		total += f.Size
+	}
+
+	var out []Candidate
+	for _, f := range sorted {
+		if p.protected(f) {
+			continue
+		}
+		switch {
+		case p.MaxAge > 0 && now.Sub(f.CreatedAt) > p.MaxAge:
+			out = append(out, Candidate{File: f, Reason: ReasonMaxAge})
+		case p.MaxTotalSize > 0 && total > p.MaxTotalSize:
+			out = append(out, Candidate{File: f, Reason: ReasonMaxTotalSize})
+		default:
+			continue
+		}
+		total -= f.Size
+	}
+
+	return out
+}
+
+type record struct {
+	Created int64 `json:"created"`
+}
+
+// Store keeps the time each root was first held by the node.
+type Store struct {
+	store storage.StateStorer
+}
+
+// NewStore returns a Store keeping the creation times in store. Roots
+// recorded by an earlier run of the node keep their times.
+func NewStore(store storage.StateStorer) *Store {
+	return &Store{store: store}
+}
+
+func recordKey(root boson.Address) string {
+	return fmt.Sprintf("%s-%s", storePrefix, root)
+}
+
+// Record saves t as the creation time of root unless root was seen before,
+// so uploading a file again does not make it younger.
+func (s *Store) Record(root boson.Address, t time.Time) error {
+	_, err := s.Created(root)
+	if errors.Is(err, storage.ErrNotFound) {
+		return s.store.Put(recordKey(root), record{Created: t.UnixNano()})
+	}
+	return err
+}
+
+// Created returns the time root was first seen, or storage.ErrNotFound if
+// it was not recorded.
+func (s *Store) Created(root boson.Address) (time.Time, error) {
+	var r record
+	if err := s.store.Get(recordKey(root), &r); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, r.Created), nil
+}
+
+// Forget removes the record of root.
+func (s *Store) Forget(root boson.Address) error {
+	err := s.store.Delete(recordKey(root))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	return err
+}
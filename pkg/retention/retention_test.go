@@ -0,0 +1,125 @@
+package retention_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/retention"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+func TestSelect(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	file := func(b byte, age time.Duration, size uint64, pinned, registered bool) retention.File {
+		return retention.File{
+			RootCid:    boson.NewAddress([]byte{31: b}),
+			Size:       size,
+			CreatedAt:  now.Add(-age),
+			Pinned:     pinned,
+			Registered: registered,
+		}
+	}
+	files := []retention.File{
+		file(1, time.Hour, 100, false, false),
+		file(2, 3*time.Hour, 100, true, false),
+		file(3, 2*time.Hour, 100, false, true),
+		file(4, 4*time.Hour, 100, false, false),
+	}
+
+	tt := []struct {
+		desc     string
+		policy   retention.Policy
+		expected []byte
+	}{
+		{
+			desc:   "disabled",
+			policy: retention.Policy{KeepPinned: true},
+		}, {
+			desc:     "max age",
+			policy:   retention.Policy{MaxAge: 90 * time.Minute},
+			expected: []byte{4, 2, 3},
+		}, {
+			desc:     "max age keeps pinned and registered",
+			policy:   retention.Policy{MaxAge: 90 * time.Minute, KeepPinned: true, KeepRegistered: true},
+			expected: []byte{4},
+		}, {
+			desc:     "max total size removes oldest first",
+			policy:   retention.Policy{MaxTotalSize: 250},
+			expected: []byte{4, 2},
+		}, {
+			desc:     "max total size skips protected",
+			policy:   retention.Policy{MaxTotalSize: 150, KeepPinned: true},
+			expected: []byte{4, 3, 1},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := tc.policy.Select(files, now)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("got %d candidates, want %d", len(got), len(tc.expected))
+			}
+			for i, c := range got {
+				if want := boson.NewAddress([]byte{31: tc.expected[i]}); !c.RootCid.Equal(want) {
+					t.Fatalf("candidate %d: got %s, want %s", i, c.RootCid, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := retention.NewStore(mock.NewStateStore())
+	root := boson.NewAddress([]byte{31: 1})
+	first := time.Unix(1000, 0)
+
+	if _, err := s.Created(root); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if err := s.Record(root, first); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Created(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(first) {
+		t.Fatalf("got %v, want %v", got, first)
+	}
+
+	// uploading the file again keeps its age
+	if err := s.Record(root, first.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Created(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(first) {
+		t.Fatalf("got %v after upload, want first seen time %v", got, first)
+	}
+
+	if err := s.Forget(root); err != nil {
+		t.Fatal(err)
+	}
+	// a deleted file is new again
+	if err := s.Record(root, first.Add(4*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Created(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(first.Add(4 * time.Hour)) {
+		t.Fatalf("got %v after delete, want %v", got, first.Add(4*time.Hour))
+	}
+	if err := s.Forget(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Forget(root); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,13 @@
+package traffichistory
+
+import "time"
+
+var RangePrefixes = rangePrefixes
+
+func (h *History) SetNow(now func() time.Time) {
+	h.now = now
+}
+
+func (h *History) Prune() error {
+	return h.prune()
+}
@@ -0,0 +1,78 @@
+package traffichistory
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/settlement"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/cheque"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/trafficprotocol"
+)
+
+type recordSettlement struct {
+	settlement.Interface
+	history *History
+}
+
+// Settlement returns s recording the traffic the accounting reports to it
+// and the payments it notifies the accounting of as sent settlements.
+func (h *History) Settlement(s settlement.Interface) settlement.Interface {
+	return &recordSettlement{Interface: s, history: h}
+}
+
+// PutRetrieveTraffic records traffic retrieved from peer, which the node
+// pays for.
+func (s *recordSettlement) PutRetrieveTraffic(peer boson.Address, traffic *big.Int) error {
+	if err := s.Interface.PutRetrieveTraffic(peer, traffic); err != nil {
+		return err
+	}
+	s.history.AddReceivedTraffic(peer, traffic)
+	return nil
+}
+
+// PutTransferTraffic records traffic transferred to peer, which the peer
+// pays for.
+func (s *recordSettlement) PutTransferTraffic(peer boson.Address, traffic *big.Int) error {
+	if err := s.Interface.PutTransferTraffic(peer, traffic); err != nil {
+		return err
+	}
+	s.history.AddSentTraffic(peer, traffic)
+	return nil
+}
+
+func (s *recordSettlement) SetNotifyPaymentFunc(f settlement.NotifyPaymentFunc) {
+	s.Interface.SetNotifyPaymentFunc(func(peer boson.Address, amount *big.Int) error {
+		s.history.AddSentSettlement(peer, amount)
+		return f(peer, amount)
+	})
+}
+
+type recordTraffic struct {
+	trafficprotocol.Traffic
+	history *History
+	mu      sync.Mutex // serializes receiving cheques
+}
+
+// Traffic returns t recording the increase of the cumulative payout of
+// every cheque received as a received settlement.
+func (h *History) Traffic(t trafficprotocol.Traffic) trafficprotocol.Traffic {
+	return &recordTraffic{Traffic: t, history: h}
+}
+
+func (t *recordTraffic) ReceiveCheque(ctx context.Context, peer boson.Address, c *cheque.SignedCheque) error {
+	// the last cheque must not change until this one is received
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last := new(big.Int)
+	if prev, err := t.LastReceivedCheque(peer); err == nil && prev != nil {
+		last = prev.CumulativePayout
+	}
+	if err := t.Traffic.ReceiveCheque(ctx, peer, c); err != nil {
+		return err
+	}
+	t.history.AddReceivedSettlement(peer, new(big.Int).Sub(c.CumulativePayout, last))
+	return nil
+}
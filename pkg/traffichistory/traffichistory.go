@@ -0,0 +1,405 @@
+// Package traffichistory keeps the per peer traffic accounting of the node
+// over time.
+//
+// The traffic sent to and received from every peer and the settlements
+// paid and received are summed up in hourly buckets kept in the state
+// store. Hourly buckets older than 30 days are merged into daily buckets.
+// The history is recorded by wrapping the settlement the accounting
+// reports traffic to and the traffic service cheques are received by, and
+// can be queried over a time range in hourly or daily buckets.
+package traffichistory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	keyPrefix    = "traffichistory-"
+	dayKeyPrefix = "traffichistory-day-"
+)
+
+const (
+	// flushInterval is how often the recorded traffic is written to the store.
+	flushInterval = time.Minute
+	// pruneInterval is how often old hourly buckets are merged into daily
+	// buckets.
+	pruneInterval = time.Hour
+	// hourRetention is how long hourly buckets are kept.
+	hourRetention = 30 * 24 * time.Hour
+)
+
+// Interval is the size of the buckets of a query.
+type Interval string
+
+const (
+	Hour Interval = "hour"
+	Day  Interval = "day"
+)
+
+// ErrInvalidInterval is returned for intervals other than Hour and Day.
+var ErrInvalidInterval = errors.New("traffichistory: invalid interval")
+
+func (i Interval) duration() (time.Duration, error) {
+	switch i {
+	case Hour:
+		return time.Hour, nil
+	case Day:
+		return 24 * time.Hour, nil
+	}
+	return 0, ErrInvalidInterval
+}
+
+// Counters are the traffic and settlements of a peer in a bucket.
+type Counters struct {
+	SentTraffic         *big.Int `json:"sentTraffic"`
+	ReceivedTraffic     *big.Int `json:"receivedTraffic"`
+	SentSettlements     *big.Int `json:"sentSettlements"`
+	ReceivedSettlements *big.Int `json:"receivedSettlements"`
+}
+
+func newCounters() *Counters {
+	return &Counters{
+		SentTraffic:         new(big.Int),
+		ReceivedTraffic:     new(big.Int),
+		SentSettlements:     new(big.Int),
+		ReceivedSettlements: new(big.Int),
+	}
+}
+
+func (c *Counters) add(o *Counters) {
+	c.SentTraffic.Add(c.SentTraffic, o.SentTraffic)
+	c.ReceivedTraffic.Add(c.ReceivedTraffic, o.ReceivedTraffic)
+	c.SentSettlements.Add(c.SentSettlements, o.SentSettlements)
+	c.ReceivedSettlements.Add(c.ReceivedSettlements, o.ReceivedSettlements)
+}
+
+// Record is the accounting of a peer in the bucket starting at Time.
+type Record struct {
+	Time time.Time     `json:"time"`
+	Peer boson.Address `json:"peer"`
+	Counters
+}
+
+// History records the traffic accounting in a state store. Recorded
+// traffic is kept in memory and written to the store every minute, on
+// queries and on Close.
+type History struct {
+	store   storage.StateStorer
+	logger  logging.Logger
+	now     func() time.Time
+	mu      sync.Mutex
+	pending map[string]*Counters
+	storeMu sync.Mutex // serializes the updates of stored buckets
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// New returns a history kept in store and starts writing recorded traffic
+// to it.
+func New(store storage.StateStorer, logger logging.Logger) *History {
+	h := &History{
+		store:   store,
+		logger:  logger,
+		now:     time.Now,
+		pending: make(map[string]*Counters),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go h.flushLoop()
+	return h
+}
+
+func key(prefix string, bucket time.Time, peer boson.Address) string {
+	return fmt.Sprintf("%s%020d-%s", prefix, bucket.Unix(), peer)
+}
+
+func parseKey(prefix, k string) (time.Time, boson.Address, error) {
+	parts := strings.SplitN(strings.TrimPrefix(k, prefix), "-", 2)
+	if len(parts) != 2 {
+		return time.Time{}, boson.ZeroAddress, fmt.Errorf("traffichistory: invalid key %q", k)
+	}
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, boson.ZeroAddress, fmt.Errorf("traffichistory: invalid key %q", k)
+	}
+	peer, err := boson.ParseHexAddress(parts[1])
+	if err != nil {
+		return time.Time{}, boson.ZeroAddress, fmt.Errorf("traffichistory: invalid key %q", k)
+	}
+	return time.Unix(sec, 0).UTC(), peer, nil
+}
+
+// add records amount in the current bucket of peer with update.
+func (h *History) add(peer boson.Address, amount *big.Int, update func(c *Counters, amount *big.Int)) {
+	if amount == nil || amount.Sign() <= 0 {
+		return
+	}
+	k := key(keyPrefix, h.now().UTC().Truncate(time.Hour), peer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.pending[k]
+	if !ok {
+		c = newCounters()
+		h.pending[k] = c
+	}
+	update(c, amount)
+}
+
+// AddSentTraffic records traffic sent to peer.
+func (h *History) AddSentTraffic(peer boson.Address, traffic *big.Int) {
+	h.add(peer, traffic, func(c *Counters, v *big.Int) { c.SentTraffic.Add(c.SentTraffic, v) })
+}
+
+// AddReceivedTraffic records traffic received from peer.
+func (h *History) AddReceivedTraffic(peer boson.Address, traffic *big.Int) {
+	h.add(peer, traffic, func(c *Counters, v *big.Int) { c.ReceivedTraffic.Add(c.ReceivedTraffic, v) })
+}
+
+// AddSentSettlement records a settlement paid to peer.
+func (h *History) AddSentSettlement(peer boson.Address, amount *big.Int) {
+	h.add(peer, amount, func(c *Counters, v *big.Int) { c.SentSettlements.Add(c.SentSettlements, v) })
+}
+
+// AddReceivedSettlement records a settlement received from peer.
+func (h *History) AddReceivedSettlement(peer boson.Address, amount *big.Int) {
+	h.add(peer, amount, func(c *Counters, v *big.Int) { c.ReceivedSettlements.Add(c.ReceivedSettlements, v) })
+}
+
+// Flush writes the recorded traffic to the store. Traffic is recorded
+// while it is written, what could not be written is kept for the next
+// flush.
+func (h *History) Flush() error {
+	h.storeMu.Lock()
+	defer h.storeMu.Unlock()
+
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = make(map[string]*Counters)
+	h.mu.Unlock()
+
+	for k, c := range pending {
+		if err := h.addStored(k, c); err != nil {
+			h.mu.Lock()
+			for k, c := range pending {
+				if p, ok := h.pending[k]; ok {
+					c.add(p)
+				}
+				h.pending[k] = c
+			}
+			h.mu.Unlock()
+			return err
+		}
+		delete(pending, k)
+	}
+	return nil
+}
+
+// addStored adds c to the bucket stored under k.
+func (h *History) addStored(k string, c *Counters) error {
+	stored := newCounters()
+	if err := h.store.Get(k, stored); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	stored.add(c)
+	return h.store.Put(k, stored)
+}
+
+// prune merges the hourly buckets older than hourRetention into the daily
+// buckets.
+func (h *History) prune() error {
+	h.storeMu.Lock()
+	defer h.storeMu.Unlock()
+
+	type bucket struct {
+		key  string
+		time time.Time
+		peer boson.Address
+		c    *Counters
+	}
+	var old []bucket
+	before := h.now().UTC().Add(-hourRetention).Truncate(time.Hour)
+	err := h.iterate(keyPrefix, time.Unix(0, 0), before, func(k string, t time.Time, peer boson.Address, c *Counters) error {
+		old = append(old, bucket{key: k, time: t, peer: peer, c: c})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, b := range old {
+		if err := h.addStored(key(dayKeyPrefix, b.time.Truncate(24*time.Hour), b.peer), b.c); err != nil {
+			return err
+		}
+		if err := h.store.Delete(b.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *History) flushLoop() {
+	defer close(h.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var pruned time.Time
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C:
+			if err := h.Flush(); err != nil {
+				h.logger.Errorf("traffic history: flush: %v", err)
+			}
+			if time.Since(pruned) >= pruneInterval {
+				if err := h.prune(); err != nil {
+					h.logger.Errorf("traffic history: prune: %v", err)
+				}
+				pruned = time.Now()
+			}
+		}
+	}
+}
+
+// rangePrefixes returns the fewest prefixes of the zero padded numbers in
+// [lo, hi), so that only the keys of buckets in a range are read.
+func rangePrefixes(lo, hi uint64) []string {
+	var prefixes []string
+	for lo < hi {
+		digits, step := 0, uint64(1)
+		for digits < 19 && lo%(step*10) == 0 && hi-lo >= step*10 {
+			digits++
+			step *= 10
+		}
+		prefixes = append(prefixes, fmt.Sprintf("%020d", lo)[:20-digits])
+		lo += step
+	}
+	return prefixes
+}
+
+// iterate calls f with the stored buckets of prefix starting in [from, to).
+func (h *History) iterate(prefix string, from, to time.Time, f func(k string, t time.Time, peer boson.Address, c *Counters) error) error {
+	lo, hi := from.Unix(), to.Unix()
+	if lo < 0 {
+		lo = 0
+	}
+	if hi <= lo {
+		return nil
+	}
+	for _, p := range rangePrefixes(uint64(lo), uint64(hi)) {
+		err := h.store.Iterate(prefix+p, func(k, v []byte) (bool, error) {
+			t, peer, err := parseKey(prefix, string(k))
+			if err != nil {
+				return true, err
+			}
+			c := newCounters()
+			if err := json.Unmarshal(v, c); err != nil {
+				return true, err
+			}
+			return false, f(string(k), t, peer, c)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the accounting of the buckets of interval starting in
+// [from, to), ordered by time and peer. Only the records of peer are
+// returned unless it is the zero address. Days start at midnight UTC.
+// Hourly records are only returned for the last 30 days.
+func (h *History) Query(from, to time.Time, interval Interval, peer boson.Address) ([]Record, error) {
+	size, err := interval.duration()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Flush(); err != nil {
+		return nil, err
+	}
+	// the buckets of interval starting in [from, to) end before end
+	from = from.UTC().Truncate(size)
+	end := to.UTC().Truncate(size)
+	if end.Before(to) {
+		end = end.Add(size)
+	}
+
+	buckets := make(map[string]*Record)
+	add := func(_ string, t time.Time, p boson.Address, c *Counters) error {
+		if !peer.IsZero() && !p.Equal(peer) {
+			return nil
+		}
+		t = t.Truncate(size)
+		bk := key(keyPrefix, t, p)
+		r, ok := buckets[bk]
+		if !ok {
+			r = &Record{Time: t, Peer: p, Counters: *newCounters()}
+			buckets[bk] = r
+		}
+		r.add(c)
+		return nil
+	}
+	if err := h.iterate(keyPrefix, from, end, add); err != nil {
+		return nil, err
+	}
+	if interval == Day {
+		if err := h.iterate(dayKeyPrefix, from, end, add); err != nil {
+			return nil, err
+		}
+	}
+
+	records := make([]Record, 0, len(buckets))
+	for _, r := range buckets {
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Time.Equal(records[j].Time) {
+			return records[i].Time.Before(records[j].Time)
+		}
+		return records[i].Peer.String() < records[j].Peer.String()
+	})
+	return records, nil
+}
+
+// WriteCSV writes records as csv with a header row. Times are RFC 3339.
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "peer", "sentTraffic", "receivedTraffic", "sentSettlements", "receivedSettlements"}); err != nil {
+		return err
+	}
+	for _, r := range records {
+		err := cw.Write([]string{
+			r.Time.Format(time.RFC3339),
+			r.Peer.String(),
+			r.SentTraffic.String(),
+			r.ReceivedTraffic.String(),
+			r.SentSettlements.String(),
+			r.ReceivedSettlements.String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Close stops writing recorded traffic periodically and writes what is left.
+func (h *History) Close() error {
+	close(h.quit)
+	<-h.done
+	return h.Flush()
+}
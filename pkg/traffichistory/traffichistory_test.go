@@ -0,0 +1,300 @@
+package traffichistory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/traffichistory"
+	"github.com/gauss-project/aurorafs/pkg/boson"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/cheque"
+	"github.com/gauss-project/aurorafs/pkg/settlement/traffic/trafficprotocol"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+)
+
+func TestQuery(t *testing.T) {
+	store := mock.NewStateStore()
+	h := traffichistory.New(store, logging.New(io.Discard, 0))
+	peer1 := boson.MustParseHexAddress("ca1e")
+	peer2 := boson.MustParseHexAddress("be1e")
+
+	start := time.Date(2022, 5, 1, 22, 0, 0, 0, time.UTC)
+	now := start
+	h.SetNow(func() time.Time { return now })
+
+	h.AddSentTraffic(peer1, big.NewInt(10))
+	h.AddReceivedTraffic(peer2, big.NewInt(5))
+	now = start.Add(30 * time.Minute)
+	h.AddSentTraffic(peer1, big.NewInt(1))
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	now = start.Add(90 * time.Minute)
+	h.AddSentSettlement(peer1, big.NewInt(7))
+	now = start.Add(3 * time.Hour)
+	h.AddReceivedSettlement(peer1, big.NewInt(3))
+	h.AddSentTraffic(peer1, big.NewInt(0))
+
+	records, err := h.Query(start, start.Add(24*time.Hour), traffichistory.Hour, boson.ZeroAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4", len(records))
+	}
+	if r := records[0]; !r.Time.Equal(start) || !r.Peer.Equal(peer2) || r.ReceivedTraffic.Int64() != 5 {
+		t.Fatalf("got record %+v", r)
+	}
+	if r := records[1]; !r.Time.Equal(start) || !r.Peer.Equal(peer1) || r.SentTraffic.Int64() != 11 {
+		t.Fatalf("got record %+v", r)
+	}
+	if r := records[2]; !r.Time.Equal(start.Add(time.Hour)) || r.SentSettlements.Int64() != 7 {
+		t.Fatalf("got record %+v", r)
+	}
+
+	// days start at midnight, so the records fall into two days
+	records, err = h.Query(start, start.Add(24*time.Hour), traffichistory.Day, peer1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if r := records[0]; r.SentTraffic.Int64() != 11 || r.SentSettlements.Int64() != 7 || r.ReceivedTraffic.Sign() != 0 {
+		t.Fatalf("got record %+v", r)
+	}
+	if r := records[1]; !r.Time.Equal(time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC)) || r.ReceivedSettlements.Int64() != 3 {
+		t.Fatalf("got record %+v", r)
+	}
+
+	records, err = h.Query(start.Add(time.Hour), start.Add(2*time.Hour), traffichistory.Hour, boson.ZeroAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+
+	if _, err := h.Query(start, now, "week", boson.ZeroAddress); err != traffichistory.ErrInvalidInterval {
+		t.Fatalf("got error %v, want %v", err, traffichistory.ErrInvalidInterval)
+	}
+
+	var buf bytes.Buffer
+	if err := traffichistory.WriteCSV(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	want := "time,peer,sentTraffic,receivedTraffic,sentSettlements,receivedSettlements\n" +
+		"2022-05-01T23:00:00Z," + peer1.String() + ",0,0,7,0\n"
+	if buf.String() != want {
+		t.Fatalf("got csv %q, want %q", buf.String(), want)
+	}
+
+	// recorded traffic survives a restart
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	h = traffichistory.New(store, logging.New(io.Discard, 0))
+	defer h.Close()
+	records, err = h.Query(start, start.Add(24*time.Hour), traffichistory.Day, boson.ZeroAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	h := traffichistory.New(mock.NewStateStore(), logging.New(io.Discard, 0))
+	defer h.Close()
+	peer := boson.MustParseHexAddress("ca1e")
+
+	start := time.Date(2022, 5, 1, 22, 0, 0, 0, time.UTC)
+	now := start
+	h.SetNow(func() time.Time { return now })
+	h.AddSentTraffic(peer, big.NewInt(10))
+	now = start.Add(time.Hour)
+	h.AddSentTraffic(peer, big.NewInt(5))
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hourly buckets are merged into the day they fall into
+	now = start.Add(31 * 24 * time.Hour)
+	h.AddSentTraffic(peer, big.NewInt(1))
+	if err := h.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := h.Query(start, start.Add(2*time.Hour), traffichistory.Hour, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("got %d hourly records, want 0", len(records))
+	}
+	records, err = h.Query(start, now.Add(time.Hour), traffichistory.Day, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	for i, want := range []int64{15, 1} {
+		if got := records[i].SentTraffic.Int64(); got != want {
+			t.Fatalf("record %d: got sent traffic %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	for _, tc := range []struct {
+		lo, hi uint64
+		want   []string
+	}{
+		{lo: 7, hi: 7},
+		{lo: 100, hi: 200, want: []string{"000000000000000001"}},
+		{lo: 0, hi: 1000, want: []string{"00000000000000000"}},
+		{lo: 3600, hi: 7200, want: []string{
+			"000000000000000036", "000000000000000037", "000000000000000038", "000000000000000039",
+			"00000000000000004", "00000000000000005", "00000000000000006",
+			"000000000000000070", "000000000000000071",
+		}},
+	} {
+		got := traffichistory.RangePrefixes(tc.lo, tc.hi)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("range [%d, %d): got prefixes %v, want %v", tc.lo, tc.hi, got, tc.want)
+		}
+	}
+
+	// every number in the range has exactly one prefix, no other number has
+	for lo := uint64(0); lo < 120; lo += 7 {
+		for hi := lo; hi < 250; hi += 13 {
+			prefixes := traffichistory.RangePrefixes(lo, hi)
+			for n := uint64(0); n < 300; n++ {
+				s := fmt.Sprintf("%020d", n)
+				matches := 0
+				for _, p := range prefixes {
+					if strings.HasPrefix(s, p) {
+						matches++
+					}
+				}
+				if want := map[bool]int{true: 1, false: 0}[n >= lo && n < hi]; matches != want {
+					t.Fatalf("range [%d, %d): %d has %d prefixes, want %d", lo, hi, n, matches, want)
+				}
+			}
+		}
+	}
+}
+
+type settlementStub struct {
+	settlement.Interface
+	notify settlement.NotifyPaymentFunc
+}
+
+func (s *settlementStub) PutRetrieveTraffic(boson.Address, *big.Int) error { return nil }
+func (s *settlementStub) PutTransferTraffic(boson.Address, *big.Int) error { return nil }
+func (s *settlementStub) SetNotifyPaymentFunc(f settlement.NotifyPaymentFunc) {
+	s.notify = f
+}
+
+type trafficStub struct {
+	trafficprotocol.Traffic
+	mu   sync.Mutex
+	last *cheque.SignedCheque
+}
+
+func (t *trafficStub) LastReceivedCheque(boson.Address) (*cheque.SignedCheque, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		return nil, cheque.ErrNoCheque
+	}
+	return t.last, nil
+}
+
+func (t *trafficStub) ReceiveCheque(_ context.Context, _ boson.Address, c *cheque.SignedCheque) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last != nil && c.CumulativePayout.Cmp(t.last.CumulativePayout) <= 0 {
+		return errors.New("cheque not increasing")
+	}
+	t.last = c
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	h := traffichistory.New(mock.NewStateStore(), logging.New(io.Discard, 0))
+	defer h.Close()
+	peer := boson.MustParseHexAddress("ca1e")
+
+	stub := &settlementStub{}
+	s := h.Settlement(stub)
+	var notified *big.Int
+	s.SetNotifyPaymentFunc(func(_ boson.Address, amount *big.Int) error {
+		notified = amount
+		return nil
+	})
+	if err := s.PutRetrieveTraffic(peer, big.NewInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutTransferTraffic(peer, big.NewInt(6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.notify(peer, big.NewInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	if notified == nil || notified.Int64() != 4 {
+		t.Fatalf("got notified %v", notified)
+	}
+
+	tr := h.Traffic(&trafficStub{})
+	for _, payout := range []int64{100, 250} {
+		c := &cheque.SignedCheque{Cheque: cheque.Cheque{CumulativePayout: big.NewInt(payout)}}
+		if err := tr.ReceiveCheque(context.Background(), peer, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := h.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), traffichistory.Day, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	got := strings.Join([]string{r.SentTraffic.String(), r.ReceivedTraffic.String(), r.SentSettlements.String(), r.ReceivedSettlements.String()}, ",")
+	if got != "6,4,4,250" {
+		t.Fatalf("got counters %s, want 6,4,4,250", got)
+	}
+
+	// cheques received at once are counted once
+	other := boson.MustParseHexAddress("be1e")
+	tr = h.Traffic(&trafficStub{})
+	var wg sync.WaitGroup
+	for payout := int64(100); payout <= 1000; payout += 100 {
+		wg.Add(1)
+		go func(payout int64) {
+			defer wg.Done()
+			c := &cheque.SignedCheque{Cheque: cheque.Cheque{CumulativePayout: big.NewInt(payout)}}
+			_ = tr.ReceiveCheque(context.Background(), other, c)
+		}(payout)
+	}
+	wg.Wait()
+	records, err = h.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), traffichistory.Day, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ReceivedSettlements.Int64() != 1000 {
+		t.Fatalf("got records %+v, want received settlements 1000", records)
+	}
+}
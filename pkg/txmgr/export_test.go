@@ -0,0 +1,9 @@
+package txmgr
+
+import "time"
+
+func (m *Manager) SetNow(now func() time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = now
+}
@@ -0,0 +1,813 @@
+// Package txmgr sends the transactions of the node.
+//
+// The manager assigns nonces from a queue kept in the state store, so
+// transactions can be sent while earlier ones are pending, and prices them
+// by a configurable strategy: a fixed price, the price suggested by the
+// chain node or a percentile of the prices paid in recent blocks. Pending
+// transactions that are not mined in time are replaced by the same
+// transaction at a higher gas price, the ones the chain node lost are sent
+// again. Every transaction is kept with the
+// purpose it was sent for, until a week after it was mined or dropped.
+package txmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+const (
+	noncePrefix = "txmgr-nonce-"
+	txPrefix    = "txmgr-tx-"
+	hashPrefix  = "txmgr-hash-"
+
+	// checkInterval is how often pending transactions are checked.
+	checkInterval = 15 * time.Second
+	// resendAfter is how long a pending transaction the chain node does not
+	// know any more waits before it is sent again.
+	resendAfter = time.Minute
+	// receiptInterval is how often WaitForReceipt polls for the receipt.
+	receiptInterval = 3 * time.Second
+	// retention is how long transactions are kept once they are final.
+	retention = 7 * 24 * time.Hour
+
+	defaultPercentile  = 60
+	defaultBlocks      = 20
+	defaultBumpPercent = 20
+	// minBumpPercent is the smallest increase of the gas price chain nodes
+	// accept for a replacement.
+	minBumpPercent = 10
+)
+
+// Strategy is how transactions are priced.
+type Strategy string
+
+const (
+	// Fixed prices every transaction at the configured gas price.
+	Fixed Strategy = "fixed"
+	// Oracle prices transactions at the price suggested by the chain node.
+	Oracle Strategy = "oracle"
+	// Percentile prices transactions at a percentile of the gas prices
+	// paid in recent blocks.
+	Percentile Strategy = "percentile"
+)
+
+// ErrInvalidOptions is returned by New for inconsistent options.
+var ErrInvalidOptions = errors.New("txmgr: invalid options")
+
+// Status is the state of a sent transaction.
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusMined   Status = "mined"
+	StatusFailed  Status = "failed"
+	// StatusDropped is the state of a transaction whose nonce was used by
+	// a transaction not sent by the manager.
+	StatusDropped Status = "dropped"
+)
+
+// ValidStatus reports whether s is a known status.
+func ValidStatus(s Status) bool {
+	switch s {
+	case StatusPending, StatusMined, StatusFailed, StatusDropped:
+		return true
+	}
+	return false
+}
+
+// Options configure the pricing and the replacement of transactions.
+type Options struct {
+	Strategy Strategy
+	// GasPrice is the price of the fixed strategy.
+	GasPrice *big.Int
+	// Percentile and Blocks are the percentile of the gas prices and the
+	// number of recent blocks of the percentile strategy, 60 and 20 by
+	// default.
+	Percentile int
+	Blocks     int
+	// MaxGasPrice caps the price of every strategy and of replacements,
+	// nil or zero for no cap.
+	MaxGasPrice *big.Int
+	// ReplaceAfter is how long a transaction may be pending before it is
+	// replaced, zero disables replacement.
+	ReplaceAfter time.Duration
+	// BumpPercent is how much a replacement raises the gas price, 20 by
+	// default and at least 10.
+	BumpPercent int
+}
+
+// Backend is the part of the chain backend the manager uses.
+type Backend interface {
+	bind.ContractBackend
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+}
+
+// Record is a transaction sent by the manager. Hash is the hash of the
+// last transaction sent for the nonce, replaced transactions are listed in
+// Replaced.
+type Record struct {
+	Nonce       uint64          `json:"nonce"`
+	Hash        common.Hash     `json:"hash"`
+	Purpose     string          `json:"purpose"`
+	Subject     string          `json:"subject,omitempty"`
+	To          *common.Address `json:"to"`
+	Value       *big.Int        `json:"value"`
+	Data        hexutil.Bytes   `json:"data"`
+	GasLimit    uint64          `json:"gasLimit"`
+	GasPrice    *big.Int        `json:"gasPrice"`
+	Status      Status          `json:"status"`
+	Replaced    []common.Hash   `json:"replaced,omitempty"`
+	BlockNumber uint64          `json:"blockNumber,omitempty"`
+	Created     time.Time       `json:"created"`
+	Sent        time.Time       `json:"sent"`
+	// Finished is when the transaction was seen mined or dropped.
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func (r Record) hashes() []common.Hash {
+	return append([]common.Hash{r.Hash}, r.Replaced...)
+}
+
+// mined sets the state of r from the receipt of the transaction mined for
+// its nonce.
+func (r *Record) mined(receipt *types.Receipt, now time.Time) {
+	if receipt.TxHash != r.Hash {
+		// a replaced transaction was mined
+		replaced := []common.Hash{r.Hash}
+		for _, h := range r.Replaced {
+			if h != receipt.TxHash {
+				replaced = append(replaced, h)
+			}
+		}
+		r.Hash, r.Replaced = receipt.TxHash, replaced
+	}
+	r.BlockNumber = receipt.BlockNumber.Uint64()
+	r.Status = StatusMined
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		r.Status = StatusFailed
+	}
+	r.Finished = &now
+}
+
+type purposeKey struct{}
+
+type purpose struct {
+	purpose string
+	subject string
+}
+
+// WithPurpose returns ctx recording the transaction sent with it as sent
+// for purpose, e.g. registering a file, about subject, e.g. the file.
+func WithPurpose(ctx context.Context, p, subject string) context.Context {
+	return context.WithValue(ctx, purposeKey{}, purpose{purpose: p, subject: subject})
+}
+
+func purposeFrom(ctx context.Context) purpose {
+	if p, ok := ctx.Value(purposeKey{}).(purpose); ok {
+		return p
+	}
+	return purpose{purpose: "unknown"}
+}
+
+var _ chain.Transaction = (*Manager)(nil)
+
+// Manager sends the transactions of a signer. It implements
+// chain.Transaction.
+type Manager struct {
+	mu      sync.Mutex
+	logger  logging.Logger
+	backend Backend
+	signer  crypto.Signer
+	sender  common.Address
+	chainID *big.Int
+	store   storage.StateStorer
+	o       Options
+	now     func() time.Time
+	// blockPrices are the gas prices paid in the recent blocks by block
+	// number, so the percentile strategy only fetches new blocks.
+	priceMu     sync.Mutex
+	blockPrices map[uint64][]*big.Int
+	// reserved are the nonces of the transactions being sent.
+	reserved map[uint64]struct{}
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// New returns the manager of the transactions of signer and starts
+// checking the pending ones.
+func New(logger logging.Logger, backend Backend, signer crypto.Signer, chainID *big.Int, store storage.StateStorer, o Options) (*Manager, error) {
+	switch o.Strategy {
+	case "":
+		o.Strategy = Oracle
+	case Fixed:
+		if o.GasPrice == nil || o.GasPrice.Sign() <= 0 {
+			return nil, fmt.Errorf("%w: fixed strategy without gas price", ErrInvalidOptions)
+		}
+	case Oracle, Percentile:
+	default:
+		return nil, fmt.Errorf("%w: unknown strategy %q", ErrInvalidOptions, o.Strategy)
+	}
+	if o.Percentile == 0 {
+		o.Percentile = defaultPercentile
+	}
+	if o.Blocks == 0 {
+		o.Blocks = defaultBlocks
+	}
+	if o.BumpPercent == 0 {
+		o.BumpPercent = defaultBumpPercent
+	}
+	if o.Percentile < 0 || o.Percentile > 100 || o.Blocks < 0 || o.BumpPercent < minBumpPercent || o.ReplaceAfter < 0 {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidOptions, o)
+	}
+
+	sender, err := signer.EthereumAddress()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		logger:      logger,
+		backend:     backend,
+		signer:      signer,
+		sender:      sender,
+		chainID:     chainID,
+		store:       store,
+		o:           o,
+		now:         time.Now,
+		blockPrices: make(map[uint64][]*big.Int),
+		reserved:    make(map[uint64]struct{}),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go m.checkLoop()
+	return m, nil
+}
+
+func (m *Manager) nonceKey() string {
+	return fmt.Sprintf("%s%x", noncePrefix, m.sender)
+}
+
+func (m *Manager) txPrefix() string {
+	return fmt.Sprintf("%s%x-", txPrefix, m.sender)
+}
+
+func (m *Manager) txKey(nonce uint64) string {
+	return fmt.Sprintf("%s%020d", m.txPrefix(), nonce)
+}
+
+// hashKey is the key of the nonce of the transaction sent with hash.
+func (m *Manager) hashKey(hash common.Hash) string {
+	return fmt.Sprintf("%s%x-%x", hashPrefix, m.sender, hash)
+}
+
+// put saves r and indexes it by its hash.
+func (m *Manager) put(r Record) error {
+	if err := m.store.Put(m.hashKey(r.Hash), r.Nonce); err != nil {
+		return err
+	}
+	return m.store.Put(m.txKey(r.Nonce), r)
+}
+
+// remove deletes r and its index entries.
+func (m *Manager) remove(r Record) error {
+	for _, h := range r.hashes() {
+		if err := m.store.Delete(m.hashKey(h)); err != nil {
+			return err
+		}
+	}
+	return m.store.Delete(m.txKey(r.Nonce))
+}
+
+func (m *Manager) queuedNonce() (uint64, error) {
+	var queued uint64
+	if err := m.store.Get(m.nonceKey(), &queued); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return 0, err
+	}
+	return queued, nil
+}
+
+// nextNonce returns the nonce of the next transaction, which follows both
+// the transactions sent by the manager and the ones the chain node knows.
+// A nonce below them the chain node waits for is used again if no
+// transaction was sent with it, e.g. because sending it failed after later
+// nonces were reserved.
+func (m *Manager) nextNonce(ctx context.Context) (uint64, error) {
+	queued, err := m.queuedNonce()
+	if err != nil {
+		return 0, err
+	}
+	pending, err := m.backend.PendingNonceAt(ctx, m.sender)
+	if err != nil {
+		return 0, err
+	}
+	if pending >= queued {
+		return pending, nil
+	}
+	if _, ok := m.reserved[pending]; !ok {
+		var r Record
+		err := m.store.Get(m.txKey(pending), &r)
+		if errors.Is(err, storage.ErrNotFound) {
+			return pending, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return queued, nil
+}
+
+// NextNonce returns the nonce of the next transaction.
+func (m *Manager) NextNonce(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nextNonce(ctx)
+}
+
+// reserve returns the nonce of the next transaction, which is not used by
+// other transactions until it is released.
+func (m *Manager) reserve(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nonce, err := m.nextNonce(ctx)
+	if err != nil {
+		return 0, err
+	}
+	queued, err := m.queuedNonce()
+	if err != nil {
+		return 0, err
+	}
+	if nonce >= queued {
+		if err := m.store.Put(m.nonceKey(), nonce+1); err != nil {
+			return 0, err
+		}
+	}
+	m.reserved[nonce] = struct{}{}
+	return nonce, nil
+}
+
+// release releases nonce, which was reserved for a transaction that was not
+// sent. The next transaction takes it unless later nonces were reserved
+// meanwhile, then the first one finding the chain node waiting for it does.
+func (m *Manager) release(nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reserved, nonce)
+	queued, err := m.queuedNonce()
+	if err != nil {
+		return err
+	}
+	if queued == nonce+1 {
+		return m.store.Put(m.nonceKey(), nonce)
+	}
+	return nil
+}
+
+// sent records r, the transaction sent with a reserved nonce.
+func (m *Manager) sent(r Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reserved, r.Nonce)
+	return m.put(r)
+}
+
+// GasPrice returns the gas price of a new transaction by the strategy.
+func (m *Manager) GasPrice(ctx context.Context) (*big.Int, error) {
+	var (
+		price *big.Int
+		err   error
+	)
+	switch m.o.Strategy {
+	case Fixed:
+		price = new(big.Int).Set(m.o.GasPrice)
+	case Percentile:
+		price, err = m.percentileGasPrice(ctx)
+	default:
+		price, err = m.backend.SuggestGasPrice(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.capped(price), nil
+}
+
+func (m *Manager) capped(price *big.Int) *big.Int {
+	if m.o.MaxGasPrice != nil && m.o.MaxGasPrice.Sign() > 0 && price.Cmp(m.o.MaxGasPrice) > 0 {
+		return new(big.Int).Set(m.o.MaxGasPrice)
+	}
+	return price
+}
+
+// percentileGasPrice returns the percentile of the gas prices of the
+// transactions in the recent blocks, or the suggested price if there are
+// none. The prices of a block are fetched once, so pricing a transaction
+// usually only costs the request of the head.
+func (m *Manager) percentileGasPrice(ctx context.Context) (*big.Int, error) {
+	head, err := m.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m.priceMu.Lock()
+	defer m.priceMu.Unlock()
+	number := head.Number.Uint64()
+	var oldest uint64
+	if number+1 > uint64(m.o.Blocks) {
+		oldest = number + 1 - uint64(m.o.Blocks)
+	}
+	for n := range m.blockPrices {
+		if n < oldest || n > number {
+			delete(m.blockPrices, n)
+		}
+	}
+	var prices []*big.Int
+	for n := oldest; n <= number && m.o.Blocks > 0; n++ {
+		blockPrices, ok := m.blockPrices[n]
+		if !ok {
+			block, err := m.backend.BlockByNumber(ctx, new(big.Int).SetUint64(n))
+			if err != nil {
+				return nil, err
+			}
+			for _, tx := range block.Transactions() {
+				blockPrices = append(blockPrices, tx.GasPrice())
+			}
+			m.blockPrices[n] = blockPrices
+		}
+		prices = append(prices, blockPrices...)
+	}
+	if len(prices) == 0 {
+		return m.backend.SuggestGasPrice(ctx)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+	return new(big.Int).Set(prices[(len(prices)-1)*m.o.Percentile/100]), nil
+}
+
+func (m *Manager) sign(nonce uint64, to *common.Address, value *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) (*types.Transaction, error) {
+	return m.signer.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Value:    value,
+		Gas:      gasLimit,
+		GasPrice: gasPrice,
+		Data:     data,
+	}), m.chainID)
+}
+
+// SendTransaction prices, signs and sends the transaction of request with
+// the next nonce and records it with the purpose of ctx. Only the nonce is
+// reserved under the lock, so a slow chain node does not hold up other
+// transactions.
+func (m *Manager) SendTransaction(ctx context.Context, request *chain.TxRequest) (*types.Transaction, error) {
+	nonce, err := m.reserve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := m.send(ctx, nonce, request)
+	if err != nil {
+		if rerr := m.release(nonce); rerr != nil {
+			m.logger.Errorf("txmgr: release nonce %d: %v", nonce, rerr)
+		}
+		return nil, err
+	}
+	return tx, nil
+}
+
+// send prices, signs, sends and records the transaction of request with
+// nonce.
+func (m *Manager) send(ctx context.Context, nonce uint64, request *chain.TxRequest) (*types.Transaction, error) {
+	var err error
+	gasLimit := request.GasLimit
+	if gasLimit == 0 {
+		gasLimit, err = m.backend.EstimateGas(ctx, ethereum.CallMsg{
+			From:  m.sender,
+			To:    request.To,
+			Value: request.Value,
+			Data:  request.Data,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	gasPrice := request.GasPrice
+	if gasPrice == nil {
+		if gasPrice, err = m.GasPrice(ctx); err != nil {
+			return nil, err
+		}
+	}
+	value := request.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+
+	tx, err := m.sign(nonce, request.To, value, gasLimit, gasPrice, request.Data)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Tracef("txmgr: sending transaction %s with nonce %d", tx.Hash(), nonce)
+	if err := m.backend.SendTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	p := purposeFrom(ctx)
+	now := m.now()
+	r := Record{
+		Nonce:    nonce,
+		Hash:     tx.Hash(),
+		Purpose:  p.purpose,
+		Subject:  p.subject,
+		To:       request.To,
+		Value:    value,
+		Data:     request.Data,
+		GasLimit: gasLimit,
+		GasPrice: gasPrice,
+		Status:   StatusPending,
+		Created:  now,
+		Sent:     now,
+	}
+	if err := m.sent(r); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Send sends the transaction of request and returns its hash.
+func (m *Manager) Send(ctx context.Context, request *chain.TxRequest) (common.Hash, error) {
+	tx, err := m.SendTransaction(ctx, request)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+// Call simulates the transaction of request.
+func (m *Manager) Call(ctx context.Context, request *chain.TxRequest) ([]byte, error) {
+	return m.backend.CallContract(ctx, ethereum.CallMsg{
+		From:     m.sender,
+		To:       request.To,
+		Data:     request.Data,
+		GasPrice: request.GasPrice,
+		Gas:      request.GasLimit,
+		Value:    request.Value,
+	}, nil)
+}
+
+// Records returns the transactions with status, all of them if status is
+// empty, newest first.
+func (m *Manager) Records(status Status) ([]Record, error) {
+	var records []Record
+	err := m.store.Iterate(m.txPrefix(), func(_, v []byte) (bool, error) {
+		var r Record
+		if err := json.Unmarshal(v, &r); err != nil {
+			return true, err
+		}
+		if status == "" || r.Status == status {
+			records = append(records, r)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Nonce > records[j].Nonce })
+	return records, nil
+}
+
+// Record returns the transaction sent with hash, which may have been
+// replaced since, or storage.ErrNotFound.
+func (m *Manager) Record(hash common.Hash) (Record, error) {
+	var (
+		nonce uint64
+		r     Record
+	)
+	if err := m.store.Get(m.hashKey(hash), &nonce); err != nil {
+		return Record{}, err
+	}
+	err := m.store.Get(m.txKey(nonce), &r)
+	return r, err
+}
+
+// receipt returns the receipt of any of the transactions sent for the
+// nonce of r, nil if none is mined.
+func (m *Manager) receipt(ctx context.Context, r Record) (*types.Receipt, error) {
+	for _, h := range r.hashes() {
+		receipt, err := m.backend.TransactionReceipt(ctx, h)
+		if receipt != nil {
+			return receipt, nil
+		}
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// WaitForReceipt waits until the transaction with txHash or one replacing
+// it is mined or ctx is cancelled. The record of the transaction is updated
+// right away instead of on the next check.
+func (m *Manager) WaitForReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	for {
+		var (
+			receipt *types.Receipt
+			err     error
+		)
+		r, rerr := m.Record(txHash)
+		if rerr == nil {
+			receipt, err = m.receipt(ctx, r)
+		} else {
+			receipt, err = m.backend.TransactionReceipt(ctx, txHash)
+		}
+		if receipt != nil {
+			if rerr == nil && r.Status == StatusPending {
+				if err := m.setMined(txHash, receipt); err != nil {
+					m.logger.Errorf("txmgr: update transaction %s: %v", txHash, err)
+				}
+			}
+			return receipt, nil
+		}
+		if err != nil {
+			// some node implementations return an error if the transaction is not yet mined
+			m.logger.Tracef("txmgr: waiting for transaction %s to be mined: %v", txHash, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(receiptInterval):
+		}
+	}
+}
+
+// setMined updates the record of the transaction with txHash, which is
+// mined with receipt, unless a check did already.
+func (m *Manager) setMined(txHash common.Hash, receipt *types.Receipt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.Record(txHash)
+	if err != nil {
+		return err
+	}
+	if r.Status != StatusPending {
+		return nil
+	}
+	r.mined(receipt, m.now())
+	return m.put(r)
+}
+
+func (m *Manager) checkLoop() {
+	defer close(m.done)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), checkInterval)
+			if err := m.Check(ctx); err != nil {
+				m.logger.Errorf("txmgr: check pending transactions: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Check updates the state of the pending transactions, replaces the ones
+// pending for too long, sends again the ones the chain node lost and removes
+// the ones final for longer than the retention.
+func (m *Manager) Check(ctx context.Context) error {
+	records, err := m.Records("")
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	var pending []Record
+	for _, r := range records {
+		switch {
+		case r.Status == StatusPending:
+			pending = append(pending, r)
+		case r.Finished != nil && now.Sub(*r.Finished) >= retention:
+			if err := m.remove(r); err != nil {
+				return err
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	confirmed, err := m.backend.NonceAt(ctx, m.sender, nil)
+	if err != nil {
+		return err
+	}
+	// the chain node waits for the transaction with the pending nonce, if it
+	// is one of the manager the node lost it, e.g. dropped it from its pool,
+	// and the later transactions are stuck behind it
+	waiting, err := m.backend.PendingNonceAt(ctx, m.sender)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range pending {
+		receipt, err := m.receipt(ctx, r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case receipt != nil:
+			r.mined(receipt, now)
+		case r.Nonce < confirmed:
+			r.Status = StatusDropped
+			r.Finished = &now
+		case m.o.ReplaceAfter > 0 && now.Sub(r.Sent) >= m.o.ReplaceAfter:
+			if err := m.replace(ctx, &r); err != nil {
+				m.logger.Errorf("txmgr: replace transaction %s with nonce %d: %v", r.Hash, r.Nonce, err)
+				continue
+			}
+		case r.Nonce == waiting && now.Sub(r.Sent) >= resendAfter:
+			if err := m.resend(ctx, &r); err != nil {
+				m.logger.Errorf("txmgr: resend transaction %s with nonce %d: %v", r.Hash, r.Nonce, err)
+				continue
+			}
+		default:
+			continue
+		}
+		if err := m.put(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace sends the transaction of r again with a higher gas price.
+func (m *Manager) replace(ctx context.Context, r *Record) error {
+	price := new(big.Int).Mul(r.GasPrice, big.NewInt(int64(100+m.o.BumpPercent)))
+	price.Div(price, big.NewInt(100))
+	if current, err := m.GasPrice(ctx); err == nil && current.Cmp(price) > 0 {
+		price = current
+	}
+	price = m.capped(price)
+	if price.Cmp(r.GasPrice) <= 0 {
+		return fmt.Errorf("gas price %s at the maximum", r.GasPrice)
+	}
+
+	tx, err := m.sign(r.Nonce, r.To, r.Value, r.GasLimit, price, r.Data)
+	if err != nil {
+		return err
+	}
+	if err := m.backend.SendTransaction(ctx, tx); err != nil {
+		// the transaction was mined meanwhile, which the next check sees
+		if confirmed, nerr := m.backend.NonceAt(ctx, m.sender, nil); nerr == nil && confirmed > r.Nonce {
+			return nil
+		}
+		return err
+	}
+	m.logger.Infof("txmgr: replaced transaction %s with nonce %d by %s at gas price %s", r.Hash, r.Nonce, tx.Hash(), price)
+	r.Replaced = append(r.Replaced, r.Hash)
+	r.Hash = tx.Hash()
+	r.GasPrice = price
+	r.Sent = m.now()
+	return nil
+}
+
+// resend sends the transaction of r again at its gas price.
+func (m *Manager) resend(ctx context.Context, r *Record) error {
+	tx, err := m.sign(r.Nonce, r.To, r.Value, r.GasLimit, r.GasPrice, r.Data)
+	if err != nil {
+		return err
+	}
+	if err := m.backend.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	m.logger.Infof("txmgr: sent transaction %s with nonce %d again", tx.Hash(), r.Nonce)
+	if tx.Hash() != r.Hash {
+		r.Replaced = append(r.Replaced, r.Hash)
+		r.Hash = tx.Hash()
+	}
+	r.Sent = m.now()
+	return nil
+}
+
+// Close stops checking the pending transactions.
+func (m *Manager) Close() error {
+	close(m.quit)
+	<-m.done
+	return nil
+}
@@ -0,0 +1,434 @@
+package txmgr_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FavorLabs/favorX/pkg/txmgr"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gauss-project/aurorafs/pkg/crypto"
+	"github.com/gauss-project/aurorafs/pkg/logging"
+	"github.com/gauss-project/aurorafs/pkg/settlement/chain"
+	"github.com/gauss-project/aurorafs/pkg/statestore/mock"
+	"github.com/gauss-project/aurorafs/pkg/storage"
+)
+
+// backend records the sent transactions and mines the ones it is told to.
+type backend struct {
+	txmgr.Backend
+	mu        sync.Mutex
+	nonce     uint64
+	confirmed uint64
+	gasPrice  *big.Int
+	blocks    []*types.Block
+	requested int
+	sent      []*types.Transaction
+	mined     map[common.Hash]*types.Receipt
+	// fail is called before a transaction is sent, sending fails with the
+	// error it returns
+	fail func(tx *types.Transaction) error
+}
+
+func (b *backend) PendingNonceAt(context.Context, common.Address) (uint64, error) {
+	return b.nonce, nil
+}
+
+func (b *backend) NonceAt(context.Context, common.Address, *big.Int) (uint64, error) {
+	return b.confirmed, nil
+}
+
+func (b *backend) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return b.gasPrice, nil
+}
+
+func (b *backend) EstimateGas(context.Context, ethereum.CallMsg) (uint64, error) {
+	return 21000, nil
+}
+
+func (b *backend) SendTransaction(_ context.Context, tx *types.Transaction) error {
+	if b.fail != nil {
+		if err := b.fail(tx); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+func (b *backend) TransactionReceipt(_ context.Context, hash common.Hash) (*types.Receipt, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r, ok := b.mined[hash]; ok {
+		return r, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (b *backend) mine(hash common.Hash, status uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mined[hash] = &types.Receipt{TxHash: hash, Status: status, BlockNumber: big.NewInt(7)}
+}
+
+func (b *backend) HeaderByNumber(context.Context, *big.Int) (*types.Header, error) {
+	return b.blocks[len(b.blocks)-1].Header(), nil
+}
+
+func (b *backend) BlockByNumber(_ context.Context, n *big.Int) (*types.Block, error) {
+	b.requested++
+	return b.blocks[n.Int64()], nil
+}
+
+func newManager(t *testing.T, b *backend, o txmgr.Options) *txmgr.Manager {
+	t.Helper()
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := txmgr.New(logging.New(io.Discard, 0), b, crypto.NewDefaultSigner(key), big.NewInt(1), mock.NewStateStore(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestNew(t *testing.T) {
+	for _, o := range []txmgr.Options{
+		{Strategy: "cheapest"},
+		{Strategy: txmgr.Fixed},
+		{Percentile: 101},
+		{BumpPercent: 5},
+	} {
+		if _, err := txmgr.New(logging.New(io.Discard, 0), &backend{}, crypto.NewDefaultSigner(nil), big.NewInt(1), mock.NewStateStore(), o); !errors.Is(err, txmgr.ErrInvalidOptions) {
+			t.Fatalf("%+v: got error %v, want %v", o, err, txmgr.ErrInvalidOptions)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	b := &backend{nonce: 5, gasPrice: big.NewInt(10), mined: make(map[common.Hash]*types.Receipt)}
+	m := newManager(t, b, txmgr.Options{MaxGasPrice: big.NewInt(8)})
+	to := common.HexToAddress("0x7af1c")
+
+	ctx := txmgr.WithPurpose(context.Background(), "register", "ca1e")
+	first, err := m.SendTransaction(ctx, &chain.TxRequest{To: &to, Data: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pending transaction is not known to the chain node yet
+	second, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Nonce() != 5 || second.Nonce() != 6 {
+		t.Fatalf("got nonces %d and %d, want 5 and 6", first.Nonce(), second.Nonce())
+	}
+	if first.GasPrice().Int64() != 8 || first.Gas() != 21000 {
+		t.Fatalf("got gas price %v and gas %d", first.GasPrice(), first.Gas())
+	}
+
+	records, err := m.Records(txmgr.StatusPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Hash != second.Hash() || records[1].Purpose != "register" || records[1].Subject != "ca1e" || records[0].Purpose != "unknown" {
+		t.Fatalf("got records %+v", records)
+	}
+
+	b.mine(first.Hash(), types.ReceiptStatusSuccessful)
+	b.mine(second.Hash(), types.ReceiptStatusFailed)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := m.Record(first.Hash()); err != nil || r.Status != txmgr.StatusMined || r.BlockNumber != 7 {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+	if r, err := m.Record(second.Hash()); err != nil || r.Status != txmgr.StatusFailed {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+	if nonce, err := m.NextNonce(context.Background()); err != nil || nonce != 7 {
+		t.Fatalf("got nonce %d, error %v", nonce, err)
+	}
+	if _, err := m.Record(common.HexToHash("0x1")); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	b := &backend{gasPrice: big.NewInt(100), mined: make(map[common.Hash]*types.Receipt)}
+	m := newManager(t, b, txmgr.Options{})
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	to := common.HexToAddress("0x7af1c")
+
+	first, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// waiting for the receipt updates the record before the next check
+	b.mine(first.Hash(), types.ReceiptStatusSuccessful)
+	if _, err := m.WaitForReceipt(context.Background(), first.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := m.Record(first.Hash()); err != nil || r.Status != txmgr.StatusMined || r.Finished == nil {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+
+	// final transactions are kept for a week, pending ones until final
+	now = now.Add(7 * 24 * time.Hour)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Record(first.Hash()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	records, err := m.Records("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Hash != second.Hash() {
+		t.Fatalf("got records %+v", records)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	b := &backend{gasPrice: big.NewInt(100), mined: make(map[common.Hash]*types.Receipt)}
+	m := newManager(t, b, txmgr.Options{ReplaceAfter: time.Minute, MaxGasPrice: big.NewInt(130)})
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	to := common.HexToAddress("0x7af1c")
+
+	tx, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(context.Background()); err != nil || len(b.sent) != 1 {
+		t.Fatalf("got %d sent, error %v", len(b.sent), err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sent) != 2 || b.sent[1].Nonce() != tx.Nonce() || b.sent[1].GasPrice().Int64() != 120 {
+		t.Fatalf("got sent %v", b.sent)
+	}
+	r, err := m.Record(tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Hash != b.sent[1].Hash() || len(r.Replaced) != 1 || r.Replaced[0] != tx.Hash() {
+		t.Fatalf("got record %+v", r)
+	}
+
+	// the gas price is capped
+	now = now.Add(time.Minute)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sent) != 3 || b.sent[2].GasPrice().Int64() != 130 {
+		t.Fatalf("got sent %v", b.sent)
+	}
+	now = now.Add(time.Minute)
+	if err := m.Check(context.Background()); err != nil || len(b.sent) != 3 {
+		t.Fatalf("got %d sent, error %v", len(b.sent), err)
+	}
+
+	// the replaced transaction may still be mined
+	b.mine(tx.Hash(), types.ReceiptStatusSuccessful)
+	receipt, err := m.WaitForReceipt(context.Background(), b.sent[2].Hash())
+	if err != nil || receipt.TxHash != tx.Hash() {
+		t.Fatalf("got receipt %+v, error %v", receipt, err)
+	}
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := m.Record(b.sent[2].Hash()); err != nil || r.Status != txmgr.StatusMined || r.Hash != tx.Hash() || len(r.Replaced) != 2 {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	b := &backend{gasPrice: big.NewInt(100), mined: make(map[common.Hash]*types.Receipt)}
+	m := newManager(t, b, txmgr.Options{})
+	to := common.HexToAddress("0x7af1c")
+
+	tx, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.confirmed = 1
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := m.Record(tx.Hash()); err != nil || r.Status != txmgr.StatusDropped {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+}
+
+func TestNonceGap(t *testing.T) {
+	b := &backend{gasPrice: big.NewInt(100), mined: make(map[common.Hash]*types.Receipt)}
+	m := newManager(t, b, txmgr.Options{})
+	to := common.HexToAddress("0x7af1c")
+	errSend := errors.New("send failed")
+
+	// a failed transaction gives its nonce back
+	b.fail = func(*types.Transaction) error { return errSend }
+	if _, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to}); !errors.Is(err, errSend) {
+		t.Fatalf("got error %v, want %v", err, errSend)
+	}
+	b.fail = nil
+	if nonce, err := m.NextNonce(context.Background()); err != nil || nonce != 0 {
+		t.Fatalf("got nonce %d, error %v", nonce, err)
+	}
+
+	// the nonce is not locked while sending, a transaction sent meanwhile
+	// takes the next one and the failed one leaves a gap
+	var later *types.Transaction
+	b.fail = func(tx *types.Transaction) error {
+		if tx.Nonce() != 0 {
+			return nil
+		}
+		var err error
+		if later, err = m.SendTransaction(context.Background(), &chain.TxRequest{To: &to}); err != nil {
+			t.Fatal(err)
+		}
+		return errSend
+	}
+	if _, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to}); !errors.Is(err, errSend) {
+		t.Fatalf("got error %v, want %v", err, errSend)
+	}
+	b.fail = nil
+	if later == nil || later.Nonce() != 1 {
+		t.Fatalf("got later transaction %v", later)
+	}
+
+	// the chain node waits for the nonce of the gap, the next transaction
+	// fills it
+	tx, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Nonce() != 0 {
+		t.Fatalf("got nonce %d, want 0", tx.Nonce())
+	}
+	b.nonce = 2
+	if nonce, err := m.NextNonce(context.Background()); err != nil || nonce != 2 {
+		t.Fatalf("got nonce %d, error %v", nonce, err)
+	}
+}
+
+func TestResend(t *testing.T) {
+	b := &backend{gasPrice: big.NewInt(100), mined: make(map[common.Hash]*types.Receipt)}
+	m := newManager(t, b, txmgr.Options{})
+	now := time.Now()
+	m.SetNow(func() time.Time { return now })
+	to := common.HexToAddress("0x7af1c")
+
+	// the chain node knows the first transaction but lost the second, its
+	// pending nonce stays at the second
+	first, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.SendTransaction(context.Background(), &chain.TxRequest{To: &to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.nonce = 1
+	if err := m.Check(context.Background()); err != nil || len(b.sent) != 2 {
+		t.Fatalf("got %d sent, error %v", len(b.sent), err)
+	}
+
+	// without replacement the lost transaction is sent again as it was
+	now = now.Add(time.Minute)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.sent) != 3 || b.sent[2].Nonce() != second.Nonce() || b.sent[2].GasPrice().Cmp(second.GasPrice()) != 0 {
+		t.Fatalf("got sent %v", b.sent)
+	}
+	if r, err := m.Record(first.Hash()); err != nil || !r.Sent.Before(now) {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+	if r, err := m.Record(second.Hash()); err != nil || r.Status != txmgr.StatusPending || !r.Sent.Equal(now) {
+		t.Fatalf("got record %+v, error %v", r, err)
+	}
+}
+
+func TestGasPrice(t *testing.T) {
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	var blocks []*types.Block
+	for i := 0; i < 3; i++ {
+		var txs []*types.Transaction
+		for j := 1; j <= 4; j++ {
+			tx, err := signer.SignTx(types.NewTx(&types.LegacyTx{GasPrice: big.NewInt(int64(i*4 + j))}), big.NewInt(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			txs = append(txs, tx)
+		}
+		blocks = append(blocks, types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i))}).WithBody(txs, nil))
+	}
+	b := &backend{gasPrice: big.NewInt(100), blocks: blocks}
+
+	for _, tc := range []struct {
+		o    txmgr.Options
+		want int64
+	}{
+		{o: txmgr.Options{Strategy: txmgr.Fixed, GasPrice: big.NewInt(42)}, want: 42},
+		{o: txmgr.Options{Strategy: txmgr.Oracle}, want: 100},
+		{o: txmgr.Options{Strategy: txmgr.Oracle, MaxGasPrice: big.NewInt(50)}, want: 50},
+		// the prices 1 to 12 of the last three blocks
+		{o: txmgr.Options{Strategy: txmgr.Percentile}, want: 7},
+		{o: txmgr.Options{Strategy: txmgr.Percentile, Percentile: 100}, want: 12},
+		// the prices 5 to 12 of the last two blocks
+		{o: txmgr.Options{Strategy: txmgr.Percentile, Percentile: 50, Blocks: 2}, want: 8},
+	} {
+		price, err := newManager(t, b, tc.o).GasPrice(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if price.Int64() != tc.want {
+			t.Fatalf("%+v: got gas price %v, want %d", tc.o, price, tc.want)
+		}
+	}
+
+	// the prices of a block are requested once
+	m := newManager(t, b, txmgr.Options{Strategy: txmgr.Percentile, Percentile: 100, Blocks: 2})
+	b.requested = 0
+	for i := 0; i < 3; i++ {
+		if _, err := m.GasPrice(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.requested != 2 {
+		t.Fatalf("got %d block requests, want 2", b.requested)
+	}
+	tx, err := signer.SignTx(types.NewTx(&types.LegacyTx{GasPrice: big.NewInt(50)}), big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.blocks = append(b.blocks, types.NewBlockWithHeader(&types.Header{Number: big.NewInt(3)}).WithBody([]*types.Transaction{tx}, nil))
+	if price, err := m.GasPrice(context.Background()); err != nil || price.Int64() != 50 || b.requested != 3 {
+		t.Fatalf("got gas price %v, error %v after %d block requests", price, err, b.requested)
+	}
+}